@@ -1,8 +1,8 @@
 package main
 
 import (
+	"context"
 	"errors"
-	"fmt"
 	"net/http"
 	"strings"
 
@@ -53,7 +53,7 @@ func (app *application) CreateBankAccount(w http.ResponseWriter, r *http.Request
 	// Extract the actual authentication token from the header parts.
 	token := headerParts[1]
 
-	user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopeAuthentication, token)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
@@ -66,11 +66,16 @@ func (app *application) CreateBankAccount(w http.ResponseWriter, r *http.Request
 
 	user_details.User_id = int(user.ID)
 
-	//As a background task save these details on success to user_details table on DB
+	//As a background task save these details on success to user_details table on DB.
+	//The request will be long finished by then, so only its values are carried over.
+	//The save fills in defaults, so it gets its own copy rather than the one being
+	//written to the response.
+	ctx := context.WithoutCancel(r.Context())
+	saved := user_details
 	app.background(func() {
-		err := app.models.AccountModel.SaveCreatedAccountNo(&user_details)
+		err := app.models.AccountModel.SaveCreatedAccountNo(ctx, &saved)
 		if err != nil {
-			fmt.Println("Error from Saving Account No", err)
+			app.logger.Error("saving account number failed", "error", err, "user_id", user.ID)
 			return
 		}
 	})
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/ebitezion/backend-framework/internal/blob"
+	"github.com/ebitezion/backend-framework/internal/data"
+	"github.com/ebitezion/backend-framework/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Accepted document types for account upgrades, keyed by the sniffed content type
+// and mapped to the extension we store the file under.
+var upgradeDocumentTypes = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"application/pdf": ".pdf",
+}
+
+// Multipart form field names for the two documents an upgrade requires.
+const (
+	idDocumentField  = "idDocument"
+	utilityBillField = "utilityBill"
+)
+
+// errDocumentTooLarge and errDocumentType are returned by saveUpgradeDocument so the
+// handler can pick the matching error response.
+var (
+	errDocumentTooLarge = errors.New("document exceeds the maximum upload size")
+	errDocumentType     = errors.New("document must be a JPEG, PNG or PDF file")
+)
+
+// accountUpgradeHandler accepts a multipart KYC tier 3/4 upgrade request: the upgrade
+// details as form values, plus an ID document and a utility bill as files. The
+// documents are written to the blob store and the request is queued for review.
+func (app *application) accountUpgradeHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	// Cap the whole body at two documents plus some headroom for the form values.
+	maxBytes := app.config.Uploads.MaxBytes
+	r.Body = http.MaxBytesReader(w, r.Body, 2*maxBytes+1_048_576)
+	err := r.ParseMultipartForm(maxBytes)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			app.fileTooLargeResponse(w, r, errDocumentTooLarge.Error())
+			return
+		}
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	upgrade := &data.AccountUpgradeData{
+		UserID:        user.ID,
+		Title:         r.PostFormValue("title"),
+		FirstName:     r.PostFormValue("firstName"),
+		LastName:      r.PostFormValue("lastName"),
+		MiddleName:    r.PostFormValue("middleName"),
+		AccountNumber: r.PostFormValue("accountNumber"),
+		BVN:           r.PostFormValue("bvn"),
+		DOB:           r.PostFormValue("dob"),
+		PhoneNumber:   r.PostFormValue("phoneNumber"),
+		Email:         r.PostFormValue("email"),
+		MaidenName:    r.PostFormValue("maidenName"),
+		Nationality:   r.PostFormValue("nationality"),
+		Country:       r.PostFormValue("country"),
+		Address:       r.PostFormValue("address"),
+		IdType:        r.PostFormValue("idType"),
+	}
+
+	// The account being upgraded must belong to the caller.
+	accountNo, err := app.models.AccountModel.GetUserAccountNoByID(r.Context(), strconv.FormatInt(user.ID, 10))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.RecordNotFound(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if upgrade.AccountNumber != accountNo {
+		app.SendersAccountNoUnauthorizedResponse(w, r, "Account upgrade requested for another user's account")
+		return
+	}
+
+	// Save both documents. Either one failing aborts the request, and whatever was
+	// already written is removed again so we don't leave orphans in the store.
+	var saved []string
+	for _, field := range []string{idDocumentField, utilityBillField} {
+		file, header, err := r.FormFile(field)
+		if err != nil {
+			for _, key := range saved {
+				app.blobs.Delete(key)
+			}
+			v := validator.New()
+			v.AddError(field, "must be provided")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		key, err := app.saveUpgradeDocument(user.ID, field, file, header)
+		file.Close()
+		if err != nil {
+			for _, key := range saved {
+				app.blobs.Delete(key)
+			}
+			switch {
+			case errors.Is(err, errDocumentTooLarge):
+				app.fileTooLargeResponse(w, r, map[string]string{field: err.Error()})
+			case errors.Is(err, errDocumentType):
+				app.unsupportedMediaTypeResponse(w, r, map[string]string{field: err.Error()})
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		saved = append(saved, key)
+	}
+	upgrade.Document, upgrade.UtilityBill = saved[0], saved[1]
+
+	v := validator.New()
+	if data.ValidateAccountUpgradeData(v, upgrade); !v.Valid() {
+		for _, key := range saved {
+			app.blobs.Delete(key)
+		}
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.AccountModel.NewAccountUpgrade(r.Context(), upgrade)
+	if err != nil {
+		for _, key := range saved {
+			app.blobs.Delete(key)
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := app.SuccessFormater(upgrade, "Account upgrade submitted for review")
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// saveUpgradeDocument validates the size and content type of an uploaded document and
+// writes it to the blob store, returning the key it was stored under. The content type
+// is sniffed from the file contents rather than trusted from the client.
+func (app *application) saveUpgradeDocument(userID int64, field string, file multipart.File, header *multipart.FileHeader) (string, error) {
+	if header.Size > app.config.Uploads.MaxBytes {
+		return "", errDocumentTooLarge
+	}
+
+	sniff := make([]byte, 512)
+	n, err := io.ReadFull(file, sniff)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	ext, ok := upgradeDocumentTypes[http.DetectContentType(sniff[:n])]
+	if !ok {
+		return "", errDocumentType
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+
+	// Prefix the file name with random bytes so keys can't be guessed and a
+	// resubmission never overwrites the documents of an earlier request.
+	random := make([]byte, 8)
+	_, err = rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+	key := fmt.Sprintf("upgrades/%d/%s-%s%s", userID, hex.EncodeToString(random), field, ext)
+
+	err = app.blobs.Put(key, io.LimitReader(file, app.config.Uploads.MaxBytes))
+	if err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// listAccountUpgradesHandler returns the review queue. By default only pending
+// requests are listed; ?status=approved or ?status=rejected shows reviewed ones.
+func (app *application) listAccountUpgradesHandler(w http.ResponseWriter, r *http.Request) {
+	status := app.readString(r.URL.Query(), "status", data.Pending)
+
+	v := validator.New()
+	v.Check(validator.In(status, data.Pending, data.Approved, data.Rejected), "status", "must be one of pending, approved or rejected")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	upgrades, err := app.models.AccountModel.GetAccountUpgradesByStatus(r.Context(), status)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := app.SuccessFormater(upgrades, "Success")
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showAccountUpgradeDocumentHandler streams one of the documents attached to an
+// upgrade request so that an operator can inspect it before reviewing.
+func (app *application) showAccountUpgradeDocumentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	upgrade, err := app.models.AccountModel.GetAccountUpgrade(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var key string
+	switch httprouter.ParamsFromContext(r.Context()).ByName("document") {
+	case idDocumentField:
+		key = upgrade.Document
+	case utilityBillField:
+		key = upgrade.UtilityBill
+	default:
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	document, err := app.blobs.Get(key)
+	if err != nil {
+		switch {
+		case errors.Is(err, blob.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer document.Close()
+
+	w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(key)))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", path.Base(key)))
+	_, err = io.Copy(w, document)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// reviewAccountUpgradeHandler approves or rejects a pending upgrade request. Approving
+// raises the user's KYC level and marks their account as upgraded, together with the
+// review itself.
+func (app *application) reviewAccountUpgradeHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Status string `json:"status"`
+		Note   string `json:"note"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(validator.In(input.Status, data.Approved, data.Rejected), "status", "must be either approved or rejected")
+	if input.Status == data.Rejected {
+		v.Check(input.Note != "", "note", "must be provided when rejecting an upgrade")
+	}
+	v.Check(len(input.Note) <= 500, "note", "must not be more than 500 bytes long")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	upgrade, err := app.models.AccountModel.GetAccountUpgrade(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	reviewer := app.contextGetUser(r)
+	err = app.models.AccountModel.ReviewAccountUpgrade(r.Context(), upgrade, input.Status, reviewer.ID, input.Note)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := app.SuccessFormater(upgrade, "Success")
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ebitezion/backend-framework/internal/data"
+	thirdparty "github.com/ebitezion/backend-framework/internal/third_party"
+	"github.com/ebitezion/backend-framework/internal/validator"
+)
+
+// bankList caches the interbank bank list, which rarely changes, so that showing it
+// doesn't cost an upstream call every time. If the list can't be refreshed, the
+// stale copy is served instead.
+type bankList struct {
+	ttl   time.Duration
+	fetch func(ctx context.Context) ([]thirdparty.Bank, error)
+
+	mu      sync.Mutex
+	banks   []thirdparty.Bank
+	expires time.Time
+}
+
+func newBankList(ttl time.Duration) *bankList {
+	return &bankList{ttl: ttl, fetch: thirdparty.GetBanks}
+}
+
+// get returns the bank list, fetching it if the cached copy has expired. Callers
+// must not modify the result.
+func (l *bankList) get(ctx context.Context) ([]thirdparty.Bank, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.banks != nil && time.Now().Before(l.expires) {
+		return l.banks, nil
+	}
+
+	banks, err := l.fetch(ctx)
+	if err != nil {
+		if l.banks != nil {
+			return l.banks, nil
+		}
+		return nil, err
+	}
+	l.banks = banks
+	l.expires = time.Now().Add(l.ttl)
+	return banks, nil
+}
+
+// lookup returns the bank with the given code.
+func (l *bankList) lookup(ctx context.Context, code string) (thirdparty.Bank, bool, error) {
+	banks, err := l.get(ctx)
+	if err != nil {
+		return thirdparty.Bank{}, false, err
+	}
+	for _, bank := range banks {
+		if bank.Code == code {
+			return bank, true, nil
+		}
+	}
+	return thirdparty.Bank{}, false, nil
+}
+
+// listBanksHandler returns the banks that money can be sent to.
+func (app *application) listBanksHandler(w http.ResponseWriter, r *http.Request) {
+	banks, err := app.banks.get(r.Context())
+	if err != nil {
+		app.logError(r, err)
+		if !app.upstreamErrorResponse(w, r, err) {
+			app.FailedApiResponse(w, r, "the bank list is not available at the moment")
+		}
+		return
+	}
+
+	env := app.SuccessFormater(banks, "Success")
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// errUnknownBank is returned by enquireName for a bank code that isn't on the bank
+// list.
+var errUnknownBank = errors.New("unknown bank")
+
+// nameEnquiryHandler confirms the name on a beneficiary account before money is sent
+// to it. The result is kept for a short time under a reference that the transfer
+// then quotes.
+func (app *application) nameEnquiryHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input data.NameEnquiryRequest
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateNameEnquiryRequest(v, &input); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	enquiry, err := app.enquireName(r.Context(), user.ID, input.AccountNumber, input.BankCode)
+	if err != nil {
+		app.nameEnquiryErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.NameEnquiries.Insert(r.Context(), enquiry)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := app.SuccessFormater(enquiry, "Success")
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// enquireName confirms the name on an account for the user, without storing the
+// enquiry. Our own accounts (an empty bank code, or our institution code) are looked
+// up locally; anything else goes out as an interbank name enquiry. An account that
+// doesn't exist is reported as data.ErrRecordNotFound.
+func (app *application) enquireName(ctx context.Context, userID int64, accountNumber, bankCode string) (*data.NameEnquiry, error) {
+	ref, err := data.NewNameEnquiryRef()
+	if err != nil {
+		return nil, err
+	}
+	enquiry := &data.NameEnquiry{
+		Ref:           ref,
+		UserID:        userID,
+		AccountNumber: accountNumber,
+		BankCode:      bankCode,
+		ExpiresAt:     time.Now().Add(app.config.Interbank.NameEnquiryTTL),
+	}
+
+	if bankCode == "" || bankCode == app.config.Interbank.InstitutionCode {
+		holder, err := app.models.AccountModel.GetAccountHolder(ctx, accountNumber)
+		if err != nil {
+			return nil, err
+		}
+		enquiry.Internal = true
+		enquiry.BankCode = app.config.Interbank.InstitutionCode
+		enquiry.BankName = app.config.Interbank.InstitutionName
+		enquiry.AccountName = holder.Name
+		enquiry.KYCLevel = kycLevel(holder.KYCLevel)
+		return enquiry, nil
+	}
+
+	bank, ok, err := app.banks.lookup(ctx, bankCode)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errUnknownBank
+	}
+	resolved, err := thirdparty.NameEnquiry(ctx, &thirdparty.NameEnquiryRequest{
+		AccountNumber:              accountNumber,
+		DestinationInstitutionCode: bankCode,
+		ChannelCode:                thirdparty.ChannelMobile,
+	})
+	if err != nil {
+		var apiErr *thirdparty.APIError
+		if errors.As(err, &apiErr) && apiErr.Declined() {
+			return nil, data.ErrRecordNotFound
+		}
+		return nil, err
+	}
+	enquiry.BankName = bank.Name
+	enquiry.AccountName = resolved.AccountName
+	enquiry.BVN = resolved.BankVerificationNumber
+	enquiry.KYCLevel = resolved.KYCLevel
+	enquiry.SessionID = resolved.SessionID
+	return enquiry, nil
+}
+
+// nameEnquiryError turns an error from enquireName into the paymentError a payment
+// pipeline returns for it. Upstream errors are left for paymentErrorResponse.
+func (app *application) nameEnquiryError(err error) error {
+	var apiErr *thirdparty.APIError
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		return errBeneficiaryNotFound
+	case errors.Is(err, errUnknownBank):
+		return paymentValidationError("bankCode", "is not a known bank")
+	case errors.As(err, &apiErr) && !apiErr.Timeout() && !apiErr.Unreachable():
+		app.logger.Error(err.Error())
+		return &paymentError{http.StatusUnauthorized, "Request Failed", FailedApiResponse, "the account could not be verified at the moment"}
+	default:
+		return err
+	}
+}
+
+// nameEnquiryErrorResponse sends the response for an error from enquireName.
+func (app *application) nameEnquiryErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.paymentErrorResponse(w, r, app.nameEnquiryError(err))
+}
+
+// kycLevel formats a user's KYC level the way the interbank network reports it.
+func kycLevel(level int) string {
+	return strconv.Itoa(level)
+}
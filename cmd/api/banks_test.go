@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/ebitezion/backend-framework/internal/data"
+	"github.com/ebitezion/backend-framework/internal/mock"
+	thirdparty "github.com/ebitezion/backend-framework/internal/third_party"
+)
+
+func TestListBanks(t *testing.T) {
+	ts := newTestServer(t)
+	token := newCustomer(t, ts, "ada@example.com", "0123456789", 0)
+
+	resp := ts.Do(t, http.MethodGet, "/v1/banks", token, nil)
+	if resp.Status != http.StatusOK {
+		t.Fatalf("got %d %s", resp.Status, resp.Body)
+	}
+	var banks []thirdparty.Bank
+	resp.Decode(t, &banks)
+	if len(banks) != len(mock.Banks) || banks[0].Code != mock.Banks[0].Code {
+		t.Errorf("got banks %+v", banks)
+	}
+
+	// The list is cached, so the provider failing doesn't matter.
+	ts.provider.Script(mock.Fault{Status: http.StatusServiceUnavailable})
+	if resp := ts.Do(t, http.MethodGet, "/v1/banks", token, nil); resp.Status != http.StatusOK {
+		t.Errorf("cached: got %d %s", resp.Status, resp.Body)
+	}
+
+	if resp := ts.Do(t, http.MethodGet, "/v1/banks", "", nil); resp.Status != http.StatusUnauthorized {
+		t.Errorf("without token: got %d %s", resp.Status, resp.Body)
+	}
+}
+
+func TestNameEnquiry(t *testing.T) {
+	ts := newTestServer(t)
+	token := newCustomer(t, ts, "ada@example.com", "0123456789", 0)
+	newCustomer(t, ts, "eve@example.com", "9876543210", 0)
+
+	tests := []struct {
+		name     string
+		request  data.NameEnquiryRequest
+		status   int
+		code     string
+		internal bool
+		account  string
+	}{
+		{"interbank", data.NameEnquiryRequest{AccountNumber: "1234567890", BankCode: mock.Banks[0].Code}, http.StatusCreated, Success.Code, false, "MOCK BENEFICIARY 7890"},
+		{"internal", data.NameEnquiryRequest{AccountNumber: "9876543210"}, http.StatusCreated, Success.Code, true, "Test User"},
+		{"internal by code", data.NameEnquiryRequest{AccountNumber: "9876543210", BankCode: "999999"}, http.StatusCreated, Success.Code, true, "Test User"},
+		{"unknown internal account", data.NameEnquiryRequest{AccountNumber: "5555555555"}, http.StatusNotFound, RecordNotFound.Code, false, ""},
+		{"unknown bank", data.NameEnquiryRequest{AccountNumber: "1234567890", BankCode: "123456"}, http.StatusUnprocessableEntity, ValidationError.Code, false, ""},
+		{"invalid", data.NameEnquiryRequest{AccountNumber: "123"}, http.StatusUnprocessableEntity, ValidationError.Code, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := ts.Do(t, http.MethodPost, "/v1/accounts/name-enquiry", token, tt.request)
+			if resp.Status != tt.status || resp.StatusCode != tt.code {
+				t.Fatalf("got %d %s", resp.Status, resp.Body)
+			}
+			if tt.status != http.StatusCreated {
+				return
+			}
+
+			var enquiry data.NameEnquiry
+			resp.Decode(t, &enquiry)
+			if enquiry.Internal != tt.internal || enquiry.AccountName != tt.account {
+				t.Errorf("got %+v", enquiry)
+			}
+
+			// The enquiry can be quoted by the user who made it.
+			user, err := ts.models.Users.GetForToken(context.Background(), data.ScopeAuthentication, token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stored, err := ts.models.NameEnquiries.Get(context.Background(), enquiry.Ref, user.ID)
+			if err != nil || stored.AccountName != tt.account {
+				t.Errorf("stored: got %+v, %v", stored, err)
+			}
+		})
+	}
+}
+
+func TestNameEnquiryInternalIsLocal(t *testing.T) {
+	ts := newTestServer(t)
+	token := newCustomer(t, ts, "ada@example.com", "0123456789", 0)
+	newCustomer(t, ts, "eve@example.com", "9876543210", 0)
+
+	// Our own accounts never reach the provider, so its failing doesn't matter.
+	down := mock.Fault{Status: http.StatusServiceUnavailable}
+	ts.provider.Script(down, down, down)
+	resp := ts.Do(t, http.MethodPost, "/v1/accounts/name-enquiry", token, data.NameEnquiryRequest{AccountNumber: "9876543210"})
+	if resp.Status != http.StatusCreated {
+		t.Fatalf("got %d %s", resp.Status, resp.Body)
+	}
+
+	// An interbank enquiry does, and reports the outage.
+	resp = ts.Do(t, http.MethodPost, "/v1/accounts/name-enquiry", token, data.NameEnquiryRequest{AccountNumber: "1234567890", BankCode: mock.Banks[0].Code})
+	if resp.Status == http.StatusCreated {
+		t.Fatalf("got %d %s", resp.Status, resp.Body)
+	}
+}
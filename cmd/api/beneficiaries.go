@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ebitezion/backend-framework/internal/data"
+	"github.com/ebitezion/backend-framework/internal/metrics"
+	"github.com/ebitezion/backend-framework/internal/notify"
+	"github.com/ebitezion/backend-framework/internal/validator"
+)
+
+// createBeneficiaryHandler saves a recipient for the caller. The name on the account
+// is confirmed first, in the same way as a name enquiry or a tag lookup, and the
+// saved beneficiary is held to the cooling-off limit for beneficiaries.cooling_off.
+func (app *application) createBeneficiaryHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input data.BeneficiaryRequest
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	input.Tag = data.NormalizeTransferTag(input.Tag)
+
+	v := validator.New()
+	if data.ValidateBeneficiaryRequest(v, &input); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ownAccountNumber, err := app.models.AccountModel.GetUserAccountNoByID(r.Context(), strconv.FormatInt(user.ID, 10))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.RecordNotFound(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	beneficiary := &data.Beneficiary{
+		UserID:          user.ID,
+		Type:            input.Type,
+		Nickname:        input.Nickname,
+		CoolingOffUntil: time.Now().Add(app.config.Beneficiaries.CoolingOff),
+	}
+	field := "accountNumber"
+	if input.Type == data.BeneficiaryTag {
+		field = "tag"
+		tag, err := app.models.TransferTags.GetByTag(r.Context(), input.Tag)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.beneficiaryNotFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		if tag.AccountNumber == ownAccountNumber {
+			v.AddError("tag", "must not be your own tag")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		beneficiary.Tag = tag.Tag
+		beneficiary.TagAccountNumber = tag.AccountNumber
+		beneficiary.AccountName = notify.MaskName(tag.AccountName)
+		beneficiary.BankCode = app.config.Interbank.InstitutionCode
+		beneficiary.BankName = app.config.Interbank.InstitutionName
+	} else {
+		enquiry, err := app.enquireName(r.Context(), user.ID, input.AccountNumber, input.BankCode)
+		if err != nil {
+			app.nameEnquiryErrorResponse(w, r, err)
+			return
+		}
+		if enquiry.AccountNumber == ownAccountNumber && enquiry.Internal {
+			v.AddError("accountNumber", "must not be your own account")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		// An interbank beneficiary given our own bank code is one of our accounts.
+		if enquiry.Internal {
+			beneficiary.Type = data.BeneficiaryInternal
+		}
+		beneficiary.AccountNumber = enquiry.AccountNumber
+		beneficiary.AccountName = enquiry.AccountName
+		beneficiary.BankCode = enquiry.BankCode
+		beneficiary.BankName = enquiry.BankName
+	}
+
+	err = app.models.Beneficiaries.Insert(r.Context(), beneficiary)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateBeneficiary):
+			v.AddError(field, "is already a saved beneficiary")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := app.SuccessFormater(beneficiary, "Success")
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listBeneficiariesHandler returns the caller's beneficiaries, the most used first.
+func (app *application) listBeneficiariesHandler(w http.ResponseWriter, r *http.Request) {
+	beneficiaries, err := app.models.Beneficiaries.GetAllForUser(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := app.SuccessFormater(beneficiaries, "Success")
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// ownBeneficiary loads the caller's beneficiary named by the :id parameter, writing a
+// 404 if it doesn't exist or belongs to another user.
+func (app *application) ownBeneficiary(w http.ResponseWriter, r *http.Request) (*data.Beneficiary, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	beneficiary, err := app.models.Beneficiaries.Get(r.Context(), id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return beneficiary, true
+}
+
+// showBeneficiaryHandler returns one of the caller's beneficiaries.
+func (app *application) showBeneficiaryHandler(w http.ResponseWriter, r *http.Request) {
+	beneficiary, ok := app.ownBeneficiary(w, r)
+	if !ok {
+		return
+	}
+
+	env := app.SuccessFormater(beneficiary, "Success")
+	err := app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateBeneficiaryHandler changes the nickname of one of the caller's beneficiaries.
+// The recipient itself can't be changed.
+func (app *application) updateBeneficiaryHandler(w http.ResponseWriter, r *http.Request) {
+	beneficiary, ok := app.ownBeneficiary(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Nickname *string `json:"nickname"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.Nickname != nil {
+		beneficiary.Nickname = *input.Nickname
+	}
+
+	v := validator.New()
+	if data.ValidateBeneficiaryNickname(v, beneficiary.Nickname); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Beneficiaries.UpdateNickname(r.Context(), beneficiary)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := app.SuccessFormater(beneficiary, "Success")
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteBeneficiaryHandler removes one of the caller's beneficiaries.
+func (app *application) deleteBeneficiaryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Beneficiaries.Delete(r.Context(), id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := app.SuccessFormater(nil, "Beneficiary deleted")
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// payableBeneficiary loads the user's beneficiary for a payment of amount. It
+// returns a paymentError if the beneficiary isn't one of theirs, isn't one of the
+// given types, or is still cooling off and the amount alone is over the cooling-off
+// limit. What has already been paid to it is added in when the payment is debited.
+func (app *application) payableBeneficiary(ctx context.Context, userID, id int64, amount int, types ...string) (*data.Beneficiary, error) {
+	beneficiary, err := app.models.Beneficiaries.Get(ctx, id, userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, paymentValidationError("beneficiary_id", "is not one of your beneficiaries")
+		}
+		return nil, err
+	}
+	if !validator.In(beneficiary.Type, types...) {
+		return nil, paymentValidationError("beneficiary_id", "is a "+beneficiary.Type+" beneficiary, which this transfer can't pay")
+	}
+	if beneficiary.CoolingOff && int64(amount) > app.config.Beneficiaries.CoolingOffLimit {
+		metrics.LimitRejections.WithLabelValues("beneficiary_cooling_off").Inc()
+		return nil, errBeneficiaryCoolingOff
+	}
+	return beneficiary, nil
+}
+
+// recordBeneficiaryUse counts a payment to a beneficiary, if the payment was made to
+// one. The payment has already gone through, so a failure is only logged.
+func (app *application) recordBeneficiaryUse(ctx context.Context, beneficiary *data.Beneficiary) {
+	if beneficiary == nil {
+		return
+	}
+	err := app.models.Beneficiaries.RecordUse(context.WithoutCancel(ctx), beneficiary.ID)
+	if err != nil {
+		app.logger.Error(err.Error(), "beneficiary_id", beneficiary.ID)
+	}
+}
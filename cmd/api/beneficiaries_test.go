@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ebitezion/backend-framework/internal/data"
+	"github.com/ebitezion/backend-framework/internal/mock"
+)
+
+// saveBeneficiary saves a beneficiary and returns it.
+func saveBeneficiary(t *testing.T, ts *testServer, token string, request data.BeneficiaryRequest) *data.Beneficiary {
+	t.Helper()
+	resp := ts.Do(t, http.MethodPost, "/v1/beneficiaries", token, request)
+	if resp.Status != http.StatusCreated {
+		t.Fatalf("save beneficiary: got %d %s", resp.Status, resp.Body)
+	}
+	var beneficiary data.Beneficiary
+	resp.Decode(t, &beneficiary)
+	return &beneficiary
+}
+
+func TestBeneficiaries(t *testing.T) {
+	ts := newTestServer(t)
+	ts.app.config.Beneficiaries.CoolingOff = time.Hour
+	token := newCustomer(t, ts, "ada@example.com", "0123456789", 1000)
+	other := newCustomer(t, ts, "eve@example.com", "9876543210", 0)
+	if resp := ts.Do(t, http.MethodPut, "/v1/tags", other, data.TransferTagRequest{Tag: "eve"}); resp.Status != http.StatusOK {
+		t.Fatalf("claim tag: got %d %s", resp.Status, resp.Body)
+	}
+
+	internal := saveBeneficiary(t, ts, token, data.BeneficiaryRequest{Type: data.BeneficiaryInternal, AccountNumber: "9876543210", Nickname: "Eve"})
+	if internal.AccountName != "Test User" || internal.BankCode != "999999" || !internal.CoolingOff {
+		t.Errorf("internal: got %+v", internal)
+	}
+	interbank := saveBeneficiary(t, ts, token, data.BeneficiaryRequest{Type: data.BeneficiaryInterbank, AccountNumber: "1234567890", BankCode: mock.Banks[0].Code})
+	if interbank.AccountName != "MOCK BENEFICIARY 7890" || interbank.BankName != mock.Banks[0].Name {
+		t.Errorf("interbank: got %+v", interbank)
+	}
+	tag := saveBeneficiary(t, ts, token, data.BeneficiaryRequest{Type: data.BeneficiaryTag, Tag: "@Eve"})
+	if tag.Tag != "eve" || tag.AccountName != "Test U***" || tag.AccountNumber != "" {
+		t.Errorf("tag: got %+v", tag)
+	}
+
+	tests := []struct {
+		name    string
+		request data.BeneficiaryRequest
+		status  int
+		code    string
+	}{
+		{"duplicate", data.BeneficiaryRequest{Type: data.BeneficiaryInternal, AccountNumber: "9876543210"}, http.StatusUnprocessableEntity, ValidationError.Code},
+		{"own account", data.BeneficiaryRequest{Type: data.BeneficiaryInternal, AccountNumber: "0123456789"}, http.StatusUnprocessableEntity, ValidationError.Code},
+		{"unknown account", data.BeneficiaryRequest{Type: data.BeneficiaryInternal, AccountNumber: "5555555555"}, http.StatusNotFound, RecordNotFound.Code},
+		{"unknown tag", data.BeneficiaryRequest{Type: data.BeneficiaryTag, Tag: "nobody"}, http.StatusNotFound, RecordNotFound.Code},
+		{"tag with account", data.BeneficiaryRequest{Type: data.BeneficiaryTag, Tag: "eve", AccountNumber: "9876543210"}, http.StatusUnprocessableEntity, ValidationError.Code},
+		{"unknown type", data.BeneficiaryRequest{Type: "card", AccountNumber: "9876543210"}, http.StatusUnprocessableEntity, ValidationError.Code},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := ts.Do(t, http.MethodPost, "/v1/beneficiaries", token, tt.request)
+			if resp.Status != tt.status || resp.StatusCode != tt.code {
+				t.Fatalf("got %d %s", resp.Status, resp.Body)
+			}
+		})
+	}
+
+	path := fmt.Sprintf("/v1/beneficiaries/%d", tag.ID)
+	resp := ts.Do(t, http.MethodPatch, path, token, map[string]string{"nickname": "Evie"})
+	var updated data.Beneficiary
+	resp.Decode(t, &updated)
+	if resp.Status != http.StatusOK || updated.Nickname != "Evie" || updated.Tag != "eve" {
+		t.Errorf("update: got %d %s", resp.Status, resp.Body)
+	}
+	// Another user's beneficiaries are out of sight.
+	if resp := ts.Do(t, http.MethodGet, path, other, nil); resp.Status != http.StatusNotFound {
+		t.Errorf("show as another user: got %d %s", resp.Status, resp.Body)
+	}
+	if resp := ts.Do(t, http.MethodDelete, path, other, nil); resp.Status != http.StatusNotFound {
+		t.Errorf("delete as another user: got %d %s", resp.Status, resp.Body)
+	}
+	if resp := ts.Do(t, http.MethodDelete, path, token, nil); resp.Status != http.StatusOK {
+		t.Errorf("delete: got %d %s", resp.Status, resp.Body)
+	}
+	if resp := ts.Do(t, http.MethodGet, path, token, nil); resp.Status != http.StatusNotFound {
+		t.Errorf("show deleted: got %d %s", resp.Status, resp.Body)
+	}
+
+	var list []data.Beneficiary
+	ts.Do(t, http.MethodGet, "/v1/beneficiaries", token, nil).Decode(t, &list)
+	if len(list) != 2 || list[0].ID != internal.ID || list[1].ID != interbank.ID {
+		t.Errorf("list: got %+v", list)
+	}
+}
+
+func TestPayBeneficiary(t *testing.T) {
+	ts := newTestServer(t)
+	ts.app.config.Beneficiaries.CoolingOff = time.Hour
+	ts.app.config.Beneficiaries.CoolingOffLimit = 500
+	token := newCustomer(t, ts, "ada@example.com", "0123456789", 100_000)
+	recipient := newCustomer(t, ts, "eve@example.com", "9876543210", 0)
+	setPIN(t, ts, token, "1234")
+
+	internal := saveBeneficiary(t, ts, token, data.BeneficiaryRequest{Type: data.BeneficiaryInternal, AccountNumber: "9876543210"})
+	interbank := saveBeneficiary(t, ts, token, data.BeneficiaryRequest{Type: data.BeneficiaryInterbank, AccountNumber: "1234567890", BankCode: mock.Banks[0].Code})
+
+	payInternal := func(reference string, id int64, amount int) (int, string) {
+		resp := ts.Do(t, http.MethodPost, "/v1/transfers/internal", token, data.InternalTransferRequest{SendersAccountNo: "0123456789", BeneficiaryID: id, Amount: amount, PIN: "1234", Reference: reference})
+		return resp.Status, resp.StatusCode
+	}
+	payInterbank := func(reference string, id int64, amount int) (int, string) {
+		resp := ts.Do(t, http.MethodPost, "/v1/transfers/interbank", token, data.InterbankTransferRequest{BeneficiaryID: id, Amount: amount, Reference: reference})
+		return resp.Status, resp.StatusCode
+	}
+
+	tests := []struct {
+		name   string
+		pay    func(string, int64, int) (int, string)
+		id     int64
+		amount int
+		status int
+		code   string
+	}{
+		{"internal", payInternal, internal.ID, 500, http.StatusCreated, Success.Code},
+		{"internal over cooling-off limit", payInternal, internal.ID, 501, http.StatusForbidden, BeneficiaryCoolingOff.Code},
+		{"internal cooling-off limit used up", payInternal, internal.ID, 1, http.StatusForbidden, BeneficiaryCoolingOff.Code},
+		{"interbank", payInterbank, interbank.ID, 500, http.StatusCreated, Success.Code},
+		{"interbank over cooling-off limit", payInterbank, interbank.ID, 501, http.StatusForbidden, BeneficiaryCoolingOff.Code},
+		{"interbank cooling-off limit used up", payInterbank, interbank.ID, 1, http.StatusForbidden, BeneficiaryCoolingOff.Code},
+		{"interbank beneficiary internally", payInternal, interbank.ID, 1, http.StatusUnprocessableEntity, ValidationError.Code},
+		{"internal beneficiary interbank", payInterbank, internal.ID, 1, http.StatusUnprocessableEntity, ValidationError.Code},
+		{"unknown beneficiary", payInternal, 99, 1, http.StatusUnprocessableEntity, ValidationError.Code},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, code := tt.pay(fmt.Sprintf("ref-%d", i), tt.id, tt.amount); status != tt.status || code != tt.code {
+				t.Fatalf("got %d %s", status, code)
+			}
+		})
+	}
+	if got := balance(t, ts, recipient); got != "500.00" {
+		t.Errorf("recipient: got balance %s, want 500.00", got)
+	}
+
+	// Each beneficiary was paid once.
+	for _, id := range []int64{internal.ID, interbank.ID} {
+		var beneficiary data.Beneficiary
+		ts.Do(t, http.MethodGet, fmt.Sprintf("/v1/beneficiaries/%d", id), token, nil).Decode(t, &beneficiary)
+		if beneficiary.UsageCount != 1 || beneficiary.LastUsedAt == nil {
+			t.Errorf("beneficiary %d: got %+v", id, beneficiary)
+		}
+	}
+
+	// Without a cooling-off period only the usual limits apply.
+	ts.app.config.Beneficiaries.CoolingOff = 0
+	if resp := ts.Do(t, http.MethodPut, "/v1/tags", recipient, data.TransferTagRequest{Tag: "eve"}); resp.Status != http.StatusOK {
+		t.Fatalf("claim tag: got %d %s", resp.Status, resp.Body)
+	}
+	tag := saveBeneficiary(t, ts, token, data.BeneficiaryRequest{Type: data.BeneficiaryTag, Tag: "eve"})
+	if status, code := payInternal("ref-tag", tag.ID, 1000); status != http.StatusCreated || tag.CoolingOff {
+		t.Fatalf("tag: got %d %s, %+v", status, code, tag)
+	}
+	if got := balance(t, ts, recipient); got != "1500.00" {
+		t.Errorf("recipient: got balance %s, want 1500.00", got)
+	}
+
+	// Once the tag has moved to someone else, the beneficiary isn't paid.
+	ts.app.config.Tags.ChangeCooldown = 0
+	if resp := ts.Do(t, http.MethodPut, "/v1/tags", recipient, data.TransferTagRequest{Tag: "eve_2"}); resp.Status != http.StatusOK {
+		t.Fatalf("change tag: got %d %s", resp.Status, resp.Body)
+	}
+	other := newCustomer(t, ts, "mallory@example.com", "5555555555", 0)
+	if resp := ts.Do(t, http.MethodPut, "/v1/tags", other, data.TransferTagRequest{Tag: "eve"}); resp.Status != http.StatusOK {
+		t.Fatalf("take tag: got %d %s", resp.Status, resp.Body)
+	}
+	if status, code := payInternal("ref-moved", tag.ID, 1000); status != http.StatusConflict || code != BeneficiaryTagMoved.Code {
+		t.Fatalf("moved tag: got %d %s", status, code)
+	}
+	if got := balance(t, ts, other); got != "0.00" {
+		t.Errorf("new tag holder: got balance %s, want 0.00", got)
+	}
+}
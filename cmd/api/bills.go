@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/ebitezion/backend-framework/internal/billers"
+	"github.com/ebitezion/backend-framework/internal/data"
+	"github.com/ebitezion/backend-framework/internal/metrics"
+	thirdparty "github.com/ebitezion/backend-framework/internal/third_party"
+	"github.com/ebitezion/backend-framework/internal/validator"
+	"github.com/shopspring/decimal"
+)
+
+// listBillersHandler returns the billers that can be paid, optionally only those in
+// the category given by the category query parameter.
+func (app *application) listBillersHandler(w http.ResponseWriter, r *http.Request) {
+	category := app.readString(r.URL.Query(), "category", "")
+
+	v := validator.New()
+	if v.Check(category == "" || validator.In(category, billers.Categories...), "category", "must be one of the biller categories"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	list, err := app.billers.Billers(r.Context(), category)
+	if err != nil {
+		app.logError(r, err)
+		if !app.upstreamErrorResponse(w, r, err) {
+			app.FailedApiResponse(w, r, "the biller list is not available at the moment")
+		}
+		return
+	}
+
+	env := app.SuccessFormater(list, "Success")
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// validateBillCustomerHandler asks a biller for the name of one of its customers, so
+// that the payer can check they are paying the right meter, smartcard or phone.
+func (app *application) validateBillCustomerHandler(w http.ResponseWriter, r *http.Request) {
+	var input data.BillCustomerRequest
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateBillCustomerRequest(v, &input); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	biller, provider, err := app.billers.Biller(r.Context(), input.BillerID)
+	if err != nil {
+		app.paymentErrorResponse(w, r, billerError(err))
+		return
+	}
+	customer, err := provider.ValidateCustomer(r.Context(), biller.ID, input.CustomerID)
+	if err != nil {
+		app.paymentErrorResponse(w, r, billerError(err))
+		return
+	}
+
+	env := app.SuccessFormater(customer, "Success")
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// payBillHandler pays one of a biller's products for a customer from the caller's
+// account, authorised with their transaction PIN. The payment goes through payBill,
+// and one whose outcome the biller hasn't given yet is accepted as pending.
+func (app *application) payBillHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input data.BillPaymentRequest
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateBillPaymentRequest(v, &input); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	userDetail, err := app.models.Users.GetUserDetailsByUserID(r.Context(), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.RecordNotFound(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if userDetail.PIN == "" {
+		app.TransactionPINNotSet(w, r, data.ErrTransactionPINNotSet)
+		return
+	}
+	if !VerifyPIN(userDetail.PIN, input.PIN) {
+		app.invalidTransferPINResponse(w, r)
+		return
+	}
+
+	payment, err := app.payBill(r.Context(), userDetail, &input)
+	if err != nil {
+		app.paymentErrorResponse(w, r, err)
+		return
+	}
+
+	status := http.StatusCreated
+	if payment.Status == data.Pending {
+		status = http.StatusAccepted
+	}
+	env := app.SuccessFormater(payment, "Success")
+	err = app.writeJSON(w, status, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listBillPaymentsHandler returns the caller's bill payments, the latest first.
+func (app *application) listBillPaymentsHandler(w http.ResponseWriter, r *http.Request) {
+	payments, err := app.models.BillPayments.GetAllForUser(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := app.SuccessFormater(payments, "Success")
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showBillPaymentHandler returns one of the caller's bill payments by reference, so
+// that a pending payment can be followed up.
+func (app *application) showBillPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	reference := httprouter.ParamsFromContext(r.Context()).ByName("reference")
+
+	payment, err := app.models.BillPayments.Get(r.Context(), reference)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if payment.UserID != app.contextGetUser(r).ID {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	env := app.SuccessFormater(payment, "Success")
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// billerError turns an error from the biller catalogue or a provider into the
+// paymentError for it, if the payer can act on it.
+func billerError(err error) error {
+	switch {
+	case errors.Is(err, billers.ErrUnknownBiller):
+		return paymentValidationError("biller_id", "is not a known biller")
+	case errors.Is(err, billers.ErrCustomerNotFound):
+		return &paymentError{http.StatusNotFound, "the biller has no customer with this ID", RecordNotFound, ""}
+	default:
+		return err
+	}
+}
+
+// billDeclinedError is returned when a biller turns a payment down. The payer has
+// already been refunded.
+func billDeclinedError(payment interface{}) error {
+	message := "the payment was declined by the biller and the amount has been refunded"
+	return &paymentError{http.StatusUnprocessableEntity, message, BillPaymentDeclined, payment}
+}
+
+// payBill is the payment pipeline for a bill paid from the account in userDetail.
+// The caller must already have authorised the payment. The customer is confirmed with
+// the biller and the payment checked against the payer's bills limits; then, as for
+// an interbank transfer, the amount is debited into suspense, the payment is sent
+// through the biller's provider and settled by the answer: completed, or failed and
+// refunded, in which case billDeclinedError is returned. If the answer doesn't settle
+// it the payment is returned pending and left to the status requery queued with the
+// debit.
+func (app *application) payBill(ctx context.Context, userDetail *data.UserDetailsForLimits, input *data.BillPaymentRequest) (*data.BillPayment, error) {
+	userID, _ := strconv.ParseInt(userDetail.UserID, 10, 64)
+
+	biller, provider, err := app.billers.Biller(ctx, input.BillerID)
+	if err != nil {
+		return nil, billerError(err)
+	}
+	product, ok := biller.Product(input.ProductCode)
+	if !ok {
+		return nil, paymentValidationError("product_code", "is not one of the biller's products")
+	}
+	switch {
+	case product.Amount > 0 && input.Amount == 0:
+		input.Amount = int(product.Amount)
+	case product.Amount > 0 && int64(input.Amount) != product.Amount:
+		return nil, paymentValidationError("amount", fmt.Sprintf("must be %d for this product", product.Amount))
+	case input.Amount <= 0:
+		return nil, paymentValidationError("amount", "must be provided")
+	}
+
+	customer, err := provider.ValidateCustomer(ctx, biller.ID, input.CustomerID)
+	if err != nil {
+		return nil, billerError(err)
+	}
+
+	err = app.checkLimits(userDetail, data.ChannelBills, input.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	amount := decimal.NewFromInt(int64(input.Amount))
+	// Fail fast on insufficient funds. Debit re-checks under a row lock.
+	balance, err := decimal.NewFromString(userDetail.Balance)
+	if err == nil && balance.LessThan(amount) {
+		return nil, data.ErrInsufficientFunds
+	}
+
+	reference, err := data.NewBillReference()
+	if err != nil {
+		return nil, err
+	}
+	payment := &data.BillPayment{
+		Reference:     reference,
+		RequestID:     input.Reference,
+		UserID:        userID,
+		AccountNumber: userDetail.AccountNumber,
+		Provider:      provider.Name(),
+		BillerID:      biller.ID,
+		BillerName:    biller.Name,
+		Category:      biller.Category,
+		ProductCode:   product.Code,
+		CustomerID:    customer.CustomerID,
+		CustomerName:  customer.Name,
+		Amount:        float64(input.Amount),
+		Narration:     fmt.Sprintf("%s payment for %s", biller.Name, customer.CustomerID),
+	}
+
+	err = app.models.BillPayments.Debit(ctx, payment, app.config.Bills.RequeryDelay)
+	if err != nil {
+		return nil, limitError(data.ChannelBills, err)
+	}
+
+	// The money has left the payer's balance, so from here on the payment is seen
+	// through even if the client goes away.
+	ctx = context.WithoutCancel(ctx)
+	receipt, err := provider.Pay(ctx, &billers.Payment{
+		Reference:   reference,
+		BillerID:    biller.ID,
+		ProductCode: product.Code,
+		CustomerID:  customer.CustomerID,
+		Amount:      int64(input.Amount),
+	})
+
+	switch {
+	case err == nil:
+		settled, settleErr := app.settleBillPayment(ctx, reference, data.Completed, receipt)
+		if settleErr != nil {
+			// The requery will settle it.
+			app.logger.Error(settleErr.Error(), "reference", reference)
+		} else {
+			payment = settled
+		}
+	case errors.Is(err, billers.ErrDeclined):
+		settled, settleErr := app.settleBillPayment(ctx, reference, data.Failed, nil)
+		if settleErr != nil {
+			app.logger.Error(settleErr.Error(), "reference", reference)
+			return nil, settleErr
+		}
+		var apiErr *thirdparty.APIError
+		if errors.As(err, &apiErr) && apiErr.Unreachable() {
+			return nil, err
+		}
+		app.logger.Error(err.Error(), "reference", reference)
+		return nil, billDeclinedError(settled)
+	default:
+		app.logger.Error(err.Error(), "reference", reference)
+	}
+	return payment, nil
+}
+
+// settleBillPayment settles a pending bill payment and counts the outcome. The
+// receipt is only used when completing it.
+func (app *application) settleBillPayment(ctx context.Context, reference, status string, receipt *billers.Receipt) (*data.BillPayment, error) {
+	if receipt == nil {
+		receipt = &billers.Receipt{}
+	}
+	payment, changed, err := app.models.BillPayments.Settle(ctx, reference, status, receipt.BillerReference, receipt.Token)
+	if err != nil {
+		return nil, err
+	}
+	if changed {
+		metrics.Transactions.WithLabelValues(string(data.Debit), payment.Status).Inc()
+	}
+	return payment, nil
+}
+
+// requeryBillPayment is the outbox handler for the status requery queued with every
+// bill payment. It works as requeryInterbankTransfer does, asking the provider the
+// payment was made through: a payment it reports as made is completed, and one it
+// reports as not made is failed and refunded. Anything else is returned as an error
+// so that the outbox asks again later.
+func (app *application) requeryBillPayment(ctx context.Context, msg *data.OutboxMessage) error {
+	var ref data.TransactionReference
+	err := json.Unmarshal(msg.Payload, &ref)
+	if err != nil {
+		return err
+	}
+
+	payment, err := app.models.BillPayments.Get(ctx, ref.Reference)
+	if err != nil {
+		return err
+	}
+	if payment.Status != data.Pending {
+		return nil
+	}
+
+	provider, err := app.billers.Provider(payment.Provider)
+	if err != nil {
+		return err
+	}
+	receipt, err := provider.Status(ctx, payment.Reference)
+	switch {
+	case err == nil:
+		_, err = app.settleBillPayment(ctx, payment.Reference, data.Completed, receipt)
+	case errors.Is(err, billers.ErrDeclined):
+		_, err = app.settleBillPayment(ctx, payment.Reference, data.Failed, nil)
+	}
+	return err
+}
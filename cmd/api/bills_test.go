@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/ebitezion/backend-framework/internal/billers"
+	"github.com/ebitezion/backend-framework/internal/data"
+	"github.com/ebitezion/backend-framework/internal/mock"
+)
+
+// requeryBill runs the status requery of a bill payment, as the outbox would.
+func requeryBill(t *testing.T, ts *testServer, reference string) error {
+	t.Helper()
+	msg, err := data.NewOutboxMessage(data.OutboxBillRequery, data.TransactionReference{Reference: reference})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts.app.requeryBillPayment(context.Background(), msg)
+}
+
+func TestBillers(t *testing.T) {
+	ts := newTestServer(t)
+	token := newCustomer(t, ts, "ada@example.com", "0123456789", 0)
+
+	resp := ts.Do(t, http.MethodGet, "/v1/billers", token, nil)
+	var all []billers.Biller
+	resp.Decode(t, &all)
+	if resp.Status != http.StatusOK || len(all) != len(mock.Billers) {
+		t.Fatalf("got %d %s", resp.Status, resp.Body)
+	}
+
+	resp = ts.Do(t, http.MethodGet, "/v1/billers?category=tv", token, nil)
+	var tv []billers.Biller
+	resp.Decode(t, &tv)
+	if len(tv) != 1 || tv[0].ID != "dstv" || len(tv[0].Products) != 2 || tv[0].Products[0].Amount != 12500 {
+		t.Errorf("got %+v", tv)
+	}
+	if resp := ts.Do(t, http.MethodGet, "/v1/billers?category=gas", token, nil); resp.Status != http.StatusUnprocessableEntity {
+		t.Errorf("unknown category: got %d %s", resp.Status, resp.Body)
+	}
+
+	resp = ts.Do(t, http.MethodPost, "/v1/billers/customers", token, data.BillCustomerRequest{BillerID: "ikedc-prepaid", CustomerID: "45012345678"})
+	var customer billers.Customer
+	resp.Decode(t, &customer)
+	if resp.Status != http.StatusOK || customer.Name != "MOCK CUSTOMER 5678" {
+		t.Errorf("got %d %s", resp.Status, resp.Body)
+	}
+
+	tests := []struct {
+		name    string
+		request data.BillCustomerRequest
+		status  int
+		code    string
+	}{
+		{"unknown biller", data.BillCustomerRequest{BillerID: "nepa", CustomerID: "45012345678"}, http.StatusUnprocessableEntity, ValidationError.Code},
+		{"unknown customer", data.BillCustomerRequest{BillerID: "dstv", CustomerID: "0000000000"}, http.StatusNotFound, RecordNotFound.Code},
+		{"invalid", data.BillCustomerRequest{BillerID: "dstv"}, http.StatusUnprocessableEntity, ValidationError.Code},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := ts.Do(t, http.MethodPost, "/v1/billers/customers", token, tt.request)
+			if resp.Status != tt.status || resp.StatusCode != tt.code {
+				t.Fatalf("got %d %s", resp.Status, resp.Body)
+			}
+		})
+	}
+}
+
+func TestPayBill(t *testing.T) {
+	ts := newTestServer(t)
+	token := newCustomer(t, ts, "ada@example.com", "0123456789", 300_000)
+	setPIN(t, ts, token, "1234")
+
+	resp := ts.Do(t, http.MethodPost, "/v1/bills", token, data.BillPaymentRequest{BillerID: "ikedc-prepaid", ProductCode: "prepaid", CustomerID: "45012345678", Amount: 5000, PIN: "1234", Reference: "ref-1"})
+	if resp.Status != http.StatusCreated {
+		t.Fatalf("got %d %s", resp.Status, resp.Body)
+	}
+	var payment data.BillPayment
+	resp.Decode(t, &payment)
+	if payment.Status != data.Completed || payment.Token == "" || payment.BillerReference == "" || payment.CustomerName != "MOCK CUSTOMER 5678" || payment.Category != billers.CategoryElectricity {
+		t.Errorf("got %+v", payment)
+	}
+	if got := balance(t, ts, token); got != "295000.00" {
+		t.Errorf("got balance %s, want 295000.00", got)
+	}
+
+	// The biller got the payment under our reference.
+	paid, ok := ts.provider.BillPayment(payment.Reference)
+	if !ok || paid.Amount != "5000.00" || paid.Token != payment.Token {
+		t.Errorf("provider has %+v, %v", paid, ok)
+	}
+
+	// The requery queued with the debit finds nothing to do.
+	if err := requeryBill(t, ts, payment.Reference); err != nil {
+		t.Error(err)
+	}
+
+	// A product with a fixed price is charged it.
+	resp = ts.Do(t, http.MethodPost, "/v1/bills", token, data.BillPaymentRequest{BillerID: "dstv", ProductCode: "compact", CustomerID: "7012345678", PIN: "1234", Reference: "ref-2"})
+	var fixed data.BillPayment
+	resp.Decode(t, &fixed)
+	if resp.Status != http.StatusCreated || fixed.Amount != 12500 || fixed.Token != "" {
+		t.Errorf("fixed price: got %d %s", resp.Status, resp.Body)
+	}
+
+	tests := []struct {
+		name    string
+		request data.BillPaymentRequest
+		status  int
+		code    string
+	}{
+		{"wrong price", data.BillPaymentRequest{BillerID: "dstv", ProductCode: "compact", CustomerID: "7012345678", Amount: 100, PIN: "1234", Reference: "ref-3"}, http.StatusUnprocessableEntity, ValidationError.Code},
+		{"no amount", data.BillPaymentRequest{BillerID: "mtn-airtime", ProductCode: "airtime", CustomerID: "08031234567", PIN: "1234", Reference: "ref-4"}, http.StatusUnprocessableEntity, ValidationError.Code},
+		{"unknown product", data.BillPaymentRequest{BillerID: "dstv", ProductCode: "family", CustomerID: "7012345678", PIN: "1234", Reference: "ref-5"}, http.StatusUnprocessableEntity, ValidationError.Code},
+		{"unknown customer", data.BillPaymentRequest{BillerID: "dstv", ProductCode: "compact", CustomerID: "0000000000", PIN: "1234", Reference: "ref-6"}, http.StatusNotFound, RecordNotFound.Code},
+		{"wrong PIN", data.BillPaymentRequest{BillerID: "mtn-airtime", ProductCode: "airtime", CustomerID: "08031234567", Amount: 100, PIN: "4321", Reference: "ref-7"}, http.StatusForbidden, InvalidTransferPIN.Code},
+		{"over single limit", data.BillPaymentRequest{BillerID: "mtn-airtime", ProductCode: "airtime", CustomerID: "08031234567", Amount: 100_001, PIN: "1234", Reference: "ref-8"}, http.StatusForbidden, BillSingleLimitExceeded.Code},
+		{"at single limit", data.BillPaymentRequest{BillerID: "mtn-airtime", ProductCode: "airtime", CustomerID: "08031234567", Amount: 100_000, PIN: "1234", Reference: "ref-9"}, http.StatusCreated, Success.Code},
+		{"over daily limit", data.BillPaymentRequest{BillerID: "mtn-airtime", ProductCode: "airtime", CustomerID: "08031234567", Amount: 82_501, PIN: "1234", Reference: "ref-10"}, http.StatusForbidden, BillDailyLimitExceeded.Code},
+		{"invalid", data.BillPaymentRequest{BillerID: "dstv"}, http.StatusUnprocessableEntity, ValidationError.Code},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := ts.Do(t, http.MethodPost, "/v1/bills", token, tt.request)
+			if resp.Status != tt.status || resp.StatusCode != tt.code {
+				t.Fatalf("got %d %s", resp.Status, resp.Body)
+			}
+		})
+	}
+
+	// Bills count against the bills limits only.
+	details, err := ts.models.Users.GetUserDetailsFromToken(context.Background(), data.ScopeAuthentication, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.Counter.Bills != 117_500 || details.Counter.Transfers != 0 || details.Counter.USSD != 0 {
+		t.Errorf("got counts %+v", details.Counter)
+	}
+
+	resp = ts.Do(t, http.MethodGet, "/v1/bills", token, nil)
+	var history []data.BillPayment
+	resp.Decode(t, &history)
+	if len(history) != 3 || history[0].RequestID != "ref-9" || history[2].RequestID != "ref-1" {
+		t.Errorf("got history %+v", history)
+	}
+
+	other := newCustomer(t, ts, "eve@example.com", "9876543210", 0)
+	if resp := ts.Do(t, http.MethodGet, "/v1/bills/"+history[0].Reference, other, nil); resp.Status != http.StatusNotFound {
+		t.Errorf("another user's payment: got %d %s", resp.Status, resp.Body)
+	}
+}
+
+func TestPayBillDeclined(t *testing.T) {
+	ts := newTestServer(t)
+	token := newCustomer(t, ts, "ada@example.com", "0123456789", 1000)
+	setPIN(t, ts, token, "1234")
+	ts.Do(t, http.MethodGet, "/v1/billers", token, nil)
+
+	// The customer is validated, then the payment is turned down.
+	ts.provider.Script(mock.Fault{}, mock.Fault{ResponseCode: "51"})
+	resp := ts.Do(t, http.MethodPost, "/v1/bills", token, data.BillPaymentRequest{BillerID: "mtn-airtime", ProductCode: "airtime", CustomerID: "08031234567", Amount: 500, PIN: "1234", Reference: "ref-1"})
+	if resp.Status != http.StatusUnprocessableEntity || resp.StatusCode != BillPaymentDeclined.Code {
+		t.Fatalf("got %d %s", resp.Status, resp.Body)
+	}
+
+	// The payer has their money back, and the debit is on record as failed.
+	if got := balance(t, ts, token); got != "1000.00" {
+		t.Errorf("got balance %s, want 1000.00", got)
+	}
+	history, err := ts.models.Transactions.GetAccountHistory(context.Background(), "0123456789", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Source != data.SourceBills || history[0].Status != data.Failed {
+		t.Errorf("got history %+v", history)
+	}
+}
+
+func TestPayBillPending(t *testing.T) {
+	tests := []struct {
+		name    string
+		fault   mock.Fault
+		status  string
+		balance string
+	}{
+		// The payment went through but the answer was lost.
+		{"carried out", mock.Fault{Malformed: true}, data.Completed, "0.00"},
+		// The biller failed before it got to the payment.
+		{"not carried out", mock.Fault{Status: http.StatusServiceUnavailable}, data.Failed, "1000.00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			token := newCustomer(t, ts, "ada@example.com", "0123456789", 1000)
+			setPIN(t, ts, token, "1234")
+			ts.Do(t, http.MethodGet, "/v1/billers", token, nil)
+
+			ts.provider.Script(mock.Fault{}, tt.fault)
+			resp := ts.Do(t, http.MethodPost, "/v1/bills", token, data.BillPaymentRequest{BillerID: "mtn-data", ProductCode: "1gb-30d", CustomerID: "08031234567", PIN: "1234", Reference: "ref-1"})
+			if resp.Status != http.StatusAccepted {
+				t.Fatalf("got %d %s", resp.Status, resp.Body)
+			}
+			var payment data.BillPayment
+			resp.Decode(t, &payment)
+			if payment.Status != data.Pending {
+				t.Errorf("got %+v", payment)
+			}
+			if got := balance(t, ts, token); got != "0.00" {
+				t.Errorf("pending: got balance %s, want 0.00", got)
+			}
+
+			// While the biller can't say, the payment stays pending.
+			unavailable := mock.Fault{Status: http.StatusServiceUnavailable}
+			ts.provider.Script(unavailable, unavailable, unavailable)
+			if err := requeryBill(t, ts, payment.Reference); err == nil {
+				t.Error("requery with the biller down: got no error")
+			}
+
+			if err := requeryBill(t, ts, payment.Reference); err != nil {
+				t.Fatal(err)
+			}
+			resp = ts.Do(t, http.MethodGet, "/v1/bills/"+payment.Reference, token, nil)
+			resp.Decode(t, &payment)
+			if payment.Status != tt.status {
+				t.Errorf("got %+v; want %s", payment, tt.status)
+			}
+			if got := balance(t, ts, token); got != tt.balance {
+				t.Errorf("got balance %s, want %s", got, tt.balance)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/ebitezion/backend-framework/internal/data"
+	"github.com/ebitezion/backend-framework/internal/validator"
+	"github.com/ebitezion/backend-framework/internal/webhook"
+)
+
+// callbackTolerance is how far a callback's timestamp may be from our clock, either
+// way. A callback can therefore pass the check for up to twice this long after it
+// arrives, and that is how long its nonce is remembered for replay protection.
+const callbackTolerance = 5 * time.Minute
+
+// paymentCallbackHandler receives asynchronous settlement notices from the payment
+// provider. The provider signs "<timestamp>.<body>" with the shared callback secret
+// (see the webhook package) and includes a unique nonce in the body. Every callback is
+// recorded in provider_callbacks along with what we did with it. Anyone can reach
+// this endpoint, so for a callback that fails the signature check only a hash of the
+// body is kept, and the headers are cut to the size of their columns.
+//
+// Callbacks that were already applied, or that leave the transaction unchanged, are
+// acknowledged with 200 so that the provider stops retrying them. A callback for an
+// internal or interbank transfer or a bill payment is refused with 409: those are
+// settled from their own networks' answers.
+func (app *application) paymentCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if app.config.Provider.CallbackSecret == "" {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1_048_576))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	callback := &data.ProviderCallback{
+		Signature: r.Header.Get(webhook.HeaderSignature),
+		Timestamp: r.Header.Get(webhook.HeaderTimestamp),
+		Payload:   string(body),
+	}
+	// The audit record is written even if the provider hangs up on us first.
+	defer func() {
+		err := app.models.Callbacks.Insert(context.WithoutCancel(r.Context()), callback)
+		if err != nil {
+			app.logError(r, err)
+		}
+	}()
+
+	err = webhook.Verify(app.config.Provider.CallbackSecret, callback.Signature, callback.Timestamp, body, callbackTolerance, time.Now())
+	if err != nil {
+		callback.Outcome = data.CallbackInvalidSignature
+		callback.Error = err.Error()
+		callback.Signature = truncate(callback.Signature, 255)
+		callback.Timestamp = truncate(callback.Timestamp, 20)
+		sum := sha256.Sum256(body)
+		callback.Payload = "sha256:" + hex.EncodeToString(sum[:])
+		app.invalidKey(w, r)
+		return
+	}
+
+	var input struct {
+		Nonce     string `json:"nonce"`
+		Reference string `json:"reference"`
+		Status    string `json:"status"`
+	}
+	err = json.Unmarshal(body, &input)
+	if err != nil {
+		callback.Outcome = data.CallbackRejected
+		callback.Error = err.Error()
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	callback.Nonce = input.Nonce
+	callback.Reference = input.Reference
+	callback.Status = input.Status
+
+	v := validator.New()
+	v.Check(input.Nonce != "", "nonce", "must be provided")
+	v.Check(len(input.Nonce) <= 100, "nonce", "must not be more than 100 bytes long")
+	v.Check(input.Reference != "", "reference", "must be provided")
+	v.Check(validator.In(input.Status, data.Completed, data.Failed), "status", "must be either completed or failed")
+	if !v.Valid() {
+		callback.Outcome = data.CallbackRejected
+		callback.Error = "failed validation"
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	transaction, changed, err := app.models.Transactions.SettleTransaction(r.Context(), input.Nonce, input.Reference, input.Status)
+	if err != nil {
+		callback.Error = err.Error()
+		switch {
+		case errors.Is(err, data.ErrDuplicateCallback):
+			callback.Outcome = data.CallbackDuplicate
+			app.callbackAcknowledged(w, r, callback.Outcome)
+		case errors.Is(err, data.ErrRecordNotFound):
+			callback.Outcome = data.CallbackRejected
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrInvalidTransition):
+			callback.Outcome = data.CallbackRejected
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrInsufficientFunds):
+			// The credit has been spent, so it needs someone to recover it by hand.
+			callback.Outcome = data.CallbackRejected
+			app.logger.Error("provider failed a credit the account can no longer cover", "reference", input.Reference, "request_id", app.contextGetRequestID(r))
+			app.editConflictResponse(w, r)
+		default:
+			callback.Outcome = data.CallbackError
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	callback.Outcome = data.CallbackUnchanged
+	if changed {
+		callback.Outcome = data.CallbackApplied
+		app.logger.Info("transaction settled by provider callback", "reference", transaction.InternalReference, "status", transaction.Status, "request_id", app.contextGetRequestID(r))
+	}
+	app.callbackAcknowledged(w, r, callback.Outcome)
+}
+
+// callbackAcknowledged tells the provider the callback needs no further retries.
+func (app *application) callbackAcknowledged(w http.ResponseWriter, r *http.Request, outcome string) {
+	env := app.SuccessFormater(map[string]string{"outcome": outcome}, "Success")
+	err := app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// truncate cuts s to at most n bytes.
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// pruneCallbackNonces deletes the nonces of callbacks that could no longer pass the
+// timestamp check, every callbackTolerance until ctx is cancelled.
+func (app *application) pruneCallbackNonces(ctx context.Context) {
+	ticker := time.NewTicker(callbackTolerance)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		n, err := app.models.Callbacks.PruneNonces(ctx, time.Now().Add(-2*callbackTolerance))
+		if err != nil && ctx.Err() == nil {
+			app.logger.Error("pruning callback nonces failed", "error", err)
+			continue
+		}
+		if n > 0 {
+			app.logger.Info("pruned callback nonces", "count", n)
+		}
+	}
+}
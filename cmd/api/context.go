@@ -15,6 +15,9 @@ type contextKey string
 // in the request context.
 const userContextKey = contextKey("user")
 
+// requestIDContextKey is the key for the request ID set by the requestID() middleware.
+const requestIDContextKey = contextKey("request_id")
+
 // The contextSetUser() method returns a new copy of the request with the provided
 // User struct added to the context. Note that we use our userContextKey constant as the
 // key.
@@ -35,3 +38,17 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+// The contextSetRequestID() method returns a new copy of the request with the request
+// ID added to the context.
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// The contextGetRequestID() method returns the request ID, or the empty string for a
+// request that didn't pass through the requestID() middleware.
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/ebitezion/backend-framework/internal/data"
+	thirdparty "github.com/ebitezion/backend-framework/internal/third_party"
 )
 
 // ErrorCode represents an error code with its corresponding meaning.
@@ -101,11 +107,32 @@ var (
 	TransferDailyLimitExceeded  = ErrorCode{"111", "Transfer amount exceeds daily limit"}
 	InvalidTransferPIN          = ErrorCode{"112", "The transfer PIN is invalid"}
 	UnauthorizedAccountNo       = ErrorCode{"113", "The Senders AccountNo is not authorized"}
+	InsufficientFunds           = ErrorCode{"114", "Insufficient funds"}
+	TransferDeclined            = ErrorCode{"115", "The transfer was declined"}
+	TransferTagCooldown         = ErrorCode{"116", "The transfer tag was changed too recently"}
+	BeneficiaryCoolingOff       = ErrorCode{"117", "Beneficiary cooling-off limit exceeded"}
+	BillSingleLimitExceeded     = ErrorCode{"118", "Bill amount exceeds single limit"}
+	BillDailyLimitExceeded      = ErrorCode{"119", "Bill amount exceeds daily limit"}
+	USSDSingleLimitExceeded     = ErrorCode{"120", "USSD amount exceeds single limit"}
+	USSDDailyLimitExceeded      = ErrorCode{"121", "USSD amount exceeds daily limit"}
+	BillPaymentDeclined         = ErrorCode{"122", "The bill payment was declined"}
+	BeneficiaryTagMoved         = ErrorCode{"123", "The beneficiary's tag belongs to another account"}
 )
 
-// The logError() method is a generic helper for logging an error message.
+// The logError() method is a generic helper for logging an error message along with
+// the request it happened in.
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.Println(err)
+	var userID int64
+	if user, ok := r.Context().Value(userContextKey).(*data.User); ok {
+		userID = user.ID
+	}
+
+	app.logger.Error(err.Error(),
+		"method", r.Method,
+		"uri", r.URL.RequestURI(),
+		"user_id", userID,
+		"request_id", app.contextGetRequestID(r),
+	)
 }
 
 // The errorResponse() method is a generic helper for sending JSON-formatted error
@@ -274,6 +301,110 @@ func (app *application) FailedTransferResponse(w http.ResponseWriter, r *http.Re
 	app.errorResponse(w, r, http.StatusUnauthorized, err, FailedApiResponse, err)
 }
 
+// The upstreamErrorResponse() method handles a third-party call that got no answer:
+// a timeout gets a 504 Gateway Timeout and anything else (a failed connection, or an
+// upstream whose circuit is open) a 502 Bad Gateway. It returns false without
+// writing anything if the upstream did answer, so that the caller can respond in
+// terms of its own operation.
+func (app *application) upstreamErrorResponse(w http.ResponseWriter, r *http.Request, err error) bool {
+	var apiErr *thirdparty.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch {
+	case apiErr.Timeout():
+		message := "the upstream service took too long to respond"
+		app.errorResponse(w, r, http.StatusGatewayTimeout, message, GatewayTimeout, "")
+	case apiErr.Unreachable():
+		message := "the upstream service could not be reached"
+		app.errorResponse(w, r, http.StatusBadGateway, message, NetworkError, "")
+	default:
+		return false
+	}
+	return true
+}
+
+// beneficiaryNotFoundResponse is sent when a name enquiry finds no account with the
+// given number at the given bank.
+func (app *application) beneficiaryNotFoundResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the beneficiary account could not be found"
+	app.errorResponse(w, r, http.StatusNotFound, message, RecordNotFound, "")
+}
+
+// paymentError is a payment refused for a reason the payer can act on, such as a
+// limit it would break. The payment pipelines, which are shared with scheduled
+// payments and so don't write responses themselves, return it carrying the error
+// response the API sends for it.
+type paymentError struct {
+	status   int
+	message  interface{}
+	code     ErrorCode
+	specific interface{}
+}
+
+func (e *paymentError) Error() string {
+	if errs, ok := e.specific.(map[string]string); ok {
+		fields := make([]string, 0, len(errs))
+		for field, message := range errs {
+			fields = append(fields, field+" "+message)
+		}
+		sort.Strings(fields)
+		return strings.Join(fields, "; ")
+	}
+	if message, ok := e.message.(string); ok && message != "" {
+		return message
+	}
+	return e.code.Value
+}
+
+// paymentValidationError is the paymentError for a payment that names something it
+// can't use, sent as a failed validation of field.
+func paymentValidationError(field, message string) error {
+	return &paymentError{http.StatusUnprocessableEntity, "", ValidationError, map[string]string{field: message}}
+}
+
+var (
+	// errBeneficiaryNotFound is returned when a payment's beneficiary account can't
+	// be found.
+	errBeneficiaryNotFound = &paymentError{http.StatusNotFound, "the beneficiary account could not be found", RecordNotFound, ""}
+	// errBeneficiaryCoolingOff is returned when a payment to a newly saved
+	// beneficiary is larger than the cooling-off limit allows.
+	errBeneficiaryCoolingOff = &paymentError{http.StatusForbidden, "the beneficiary was saved too recently to be paid this much yet", BeneficiaryCoolingOff, ""}
+	// errBeneficiaryTagMoved is returned when the tag of a tag beneficiary has moved
+	// to another account since the beneficiary was saved.
+	errBeneficiaryTagMoved = &paymentError{http.StatusConflict, "the beneficiary's tag now belongs to a different account; remove it and save it again to pay its new holder", BeneficiaryTagMoved, ""}
+)
+
+// transferDeclinedError is returned when the destination bank turns a transfer down.
+// The sender has already been refunded.
+func transferDeclinedError(transfer interface{}) error {
+	message := "the transfer was declined by the destination bank and the amount has been refunded"
+	return &paymentError{http.StatusUnprocessableEntity, message, TransferDeclined, transfer}
+}
+
+// paymentErrorResponse sends the response for an error from a payment pipeline. An
+// upstream that couldn't carry the payment out is reported as upstreamErrorResponse
+// does, or else as a failed transfer.
+func (app *application) paymentErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	var paymentErr *paymentError
+	var apiErr *thirdparty.APIError
+	switch {
+	case errors.As(err, &paymentErr):
+		app.errorResponse(w, r, paymentErr.status, paymentErr.message, paymentErr.code, paymentErr.specific)
+	case errors.Is(err, data.ErrInsufficientFunds):
+		app.insufficientFundsResponse(w, r)
+	case errors.Is(err, data.ErrDuplicateTransaction):
+		app.duplicateTransactionResponse(w, r)
+	case errors.As(err, &apiErr):
+		app.logError(r, err)
+		if !app.upstreamErrorResponse(w, r, err) {
+			app.FailedTransferResponse(w, r, err.Error())
+		}
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 // Different Source Login
 func (app *application) DifferentSourceResponse(w http.ResponseWriter, r *http.Request, err interface{}) {
 	message := "Source Error: This login details is from source-Spectrumpay, device validation is required to continue"
@@ -285,6 +416,29 @@ func (app *application) SendersAccountNoUnauthorizedResponse(w http.ResponseWrit
 	app.errorResponse(w, r, http.StatusUnauthorized, message, UnauthorizedAccountNo, err)
 }
 
+// invalidTransferPINResponse is sent when the transaction PIN a transfer was
+// authorised with doesn't match the user's.
+func (app *application) invalidTransferPINResponse(w http.ResponseWriter, r *http.Request) {
+	app.errorResponse(w, r, http.StatusForbidden, InvalidTransferPIN.Value, InvalidTransferPIN, "")
+}
+
+// transferTagCooldownResponse is sent when a user tries to change their transfer tag
+// again before the cooldown is up.
+func (app *application) transferTagCooldownResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your transfer tag was changed too recently to be changed again yet"
+	app.errorResponse(w, r, http.StatusForbidden, message, TransferTagCooldown, "")
+}
+
+func (app *application) insufficientFundsResponse(w http.ResponseWriter, r *http.Request) {
+	message := "The account balance is not sufficient for this transaction"
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, message, InsufficientFunds, "")
+}
+
+func (app *application) duplicateTransactionResponse(w http.ResponseWriter, r *http.Request) {
+	message := "A transaction with this reference already exists"
+	app.errorResponse(w, r, http.StatusConflict, message, DuplicateEntry, "")
+}
+
 // KYC Errors
 
 // AddressNotVerifiedResponse Address Not Verified Error Response
@@ -309,3 +463,18 @@ func (app *application) ErrExistingAccountHolderResponse(w http.ResponseWriter,
 	message := "Existing account number Has not be validated, submit OTP to validate."
 	app.errorResponse(w, r, http.StatusUnauthorized, message, ErrExistingAccountHolderResponse, "")
 }
+
+// Document uploads
+
+// unsupportedMediaTypeResponse is sent when an uploaded document is not one of the
+// accepted file types.
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, err interface{}) {
+	message := "The uploaded document type is not supported"
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message, UnsupportedMediaType, err)
+}
+
+// fileTooLargeResponse is sent when an uploaded document exceeds the configured size.
+func (app *application) fileTooLargeResponse(w http.ResponseWriter, r *http.Request, err interface{}) {
+	message := "The uploaded document is too large"
+	app.errorResponse(w, r, http.StatusRequestEntityTooLarge, message, BandwidthLimitExceeded, err)
+}
@@ -1,17 +1,32 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"time"
+
+	"github.com/ebitezion/backend-framework/internal/health"
+	"github.com/ebitezion/backend-framework/internal/migrate"
+	thirdparty "github.com/ebitezion/backend-framework/internal/third_party"
+	"github.com/ebitezion/backend-framework/migrations"
 )
 
 // Declare a handler which writes a plain-text response which information about
 // the application status, operating environment, and version.
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
+	// Report "draining" once a shutdown has started, so that load balancers stop
+	// sending new traffic while in-flight requests complete.
+	status := "available"
+	if app.draining.Load() {
+		status = "draining"
+	}
+
 	// Declare an envelope map containing the data for the response.
 	env := envelope{
-		"status": "available",
+		"status": status,
 		"system_info": map[string]string{
-			"environment": app.config.env,
+			"environment": app.config.Env,
 			"version":     version,
 		},
 	}
@@ -21,3 +36,79 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// livenessHandler tells the orchestrator that the process is up and serving. It
+// checks nothing else: a database outage should take the pod out of rotation (see
+// readinessHandler), not get it restarted.
+func (app *application) livenessHandler(w http.ResponseWriter, r *http.Request) {
+	status := "alive"
+	if app.draining.Load() {
+		status = "draining"
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"status": status, "version": version}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readinessHandler runs the dependency checks and answers 503, with the status of
+// each component, if any of them failed or a shutdown is under way.
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	report := app.health.Run(r.Context())
+	if app.draining.Load() {
+		report.Status = "draining"
+	}
+
+	status := http.StatusOK
+	if !report.Ready() {
+		status = http.StatusServiceUnavailable
+	}
+
+	err := app.writeJSON(w, status, envelope{"status": report.Status, "components": report.Components}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// newHealthChecker registers the readiness checks. The SMTP server and the payment
+// provider are external, so their results are cached rather than hit on every probe.
+func (app *application) newHealthChecker() *health.Checker {
+	checker := health.New(2 * time.Second)
+
+	checker.Add("database", func(ctx context.Context) error {
+		return app.db.PingContext(ctx)
+	})
+
+	// The schema this build expects is the newest migration embedded in it. The
+	// driver was checked at startup, and Load only fails on malformed file names,
+	// which the migrate package tests catch.
+	fsys, err := migrations.FS(app.config.DB.Driver)
+	if err != nil {
+		panic(err)
+	}
+	expected, err := migrate.Latest(fsys)
+	if err != nil {
+		panic(err)
+	}
+	checker.Add("migrations", func(ctx context.Context) error {
+		current, err := migrate.Version(ctx, app.db)
+		if err != nil {
+			return err
+		}
+		if current != expected {
+			return fmt.Errorf("schema at version %d, expected %d", current, expected)
+		}
+		return nil
+	})
+
+	checker.Add("smtp", health.Cached(app.mailer.Ping, 30*time.Second))
+
+	if app.config.Provider.URL != "" {
+		checker.Add("provider", health.Cached(func(ctx context.Context) error {
+			return thirdparty.Ping(ctx, app.config.Provider.URL)
+		}, 30*time.Second))
+	}
+
+	return checker
+}
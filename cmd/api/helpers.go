@@ -11,6 +11,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/ebitezion/backend-framework/internal/metrics"
 	"github.com/ebitezion/backend-framework/internal/validator"
 	"github.com/julienschmidt/httprouter"
 	"golang.org/x/crypto/bcrypt"
@@ -244,12 +245,15 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 
 // The background() helper accepts an arbitrary function as a parameter.
 func (app *application) background(fn func()) {
+	// Track the goroutine so that shutdown can wait for it to finish.
+	app.wg.Add(1)
 	// Launch a background goroutine.
 	go func() {
+		defer app.wg.Done()
 		// Recover any panic.
 		defer func() {
 			if err := recover(); err != nil {
-				app.logger.Println(fmt.Errorf("%s", err), nil)
+				app.logger.Error(fmt.Sprintf("%s", err), "source", "background")
 			}
 		}()
 		// Execute the arbitrary function that we passed as the parameter.
@@ -270,10 +274,15 @@ func EncryptPIN(pin string) (string, error) {
 	return string(hashedPin), nil
 }
 
-// VerifyPIN verifies a PIN against its hashed value.
+// VerifyPIN verifies a PIN against its hashed value. Mismatches are counted in the
+// pin_failures_total metric.
 func VerifyPIN(hashedPin, pin string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hashedPin), []byte(pin))
-	return err == nil
+	if err != nil {
+		metrics.PINFailures.Inc()
+		return false
+	}
+	return true
 }
 
 // SuccessFormater formats that json for successesful calls to apis
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/ebitezion/backend-framework/internal/data"
+	"github.com/ebitezion/backend-framework/internal/metrics"
+	thirdparty "github.com/ebitezion/backend-framework/internal/third_party"
+	"github.com/ebitezion/backend-framework/internal/validator"
+	"github.com/shopspring/decimal"
+)
+
+// interbankTransferHandler sends money to an account at another bank, which the
+// sender must have confirmed with a name enquiry or saved as a beneficiary. The
+// transfer goes through interbankTransfer, and one whose outcome the network hasn't
+// settled yet is accepted as pending.
+func (app *application) interbankTransferHandler(w http.ResponseWriter, r *http.Request) {
+	token := app.GetBearerToken(w, r)
+	if token == "" {
+		return
+	}
+
+	var input data.InterbankTransferRequest
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateInterbankTransferRequest(v, &input); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	userDetail, err := app.models.Users.GetUserDetailsFromToken(r.Context(), data.ScopeAuthentication, token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.RecordNotFound(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	transfer, err := app.interbankTransfer(r.Context(), userDetail, &input)
+	if err != nil {
+		app.paymentErrorResponse(w, r, err)
+		return
+	}
+
+	status := http.StatusCreated
+	if transfer.Status == data.Pending {
+		status = http.StatusAccepted
+	}
+	env := app.SuccessFormater(transfer, "Success")
+	err = app.writeJSON(w, status, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// interbankTransfer is the payment pipeline for a transfer from the account in
+// userDetail to an account at another bank, shared by the transfer endpoint and
+// scheduled payments. The amount is debited into suspense and the fee into fee
+// income first; the transfer is then sent to the network and settled by the answer:
+// completed, or failed and refunded, in which case transferDeclinedError is returned.
+// If the answer doesn't settle it (the call timed out, say) the transfer is returned
+// pending and left to the status requery queued with the debit.
+func (app *application) interbankTransfer(ctx context.Context, userDetail *data.UserDetailsForLimits, input *data.InterbankTransferRequest) (*data.InterbankTransfer, error) {
+	userID, _ := strconv.ParseInt(userDetail.UserID, 10, 64)
+
+	var enquiry *data.NameEnquiry
+	var beneficiary *data.Beneficiary
+	var err error
+	if input.BeneficiaryID != 0 {
+		beneficiary, err = app.payableBeneficiary(ctx, userID, input.BeneficiaryID, input.Amount, data.BeneficiaryInterbank)
+		if err != nil {
+			return nil, err
+		}
+		// The network wants the session of a current name enquiry, so a saved
+		// beneficiary is confirmed again.
+		enquiry, err = app.enquireName(ctx, userID, beneficiary.AccountNumber, beneficiary.BankCode)
+		if err == nil {
+			err = app.models.NameEnquiries.Insert(ctx, enquiry)
+		}
+		if err != nil {
+			return nil, app.nameEnquiryError(err)
+		}
+	} else {
+		enquiry, err = app.models.NameEnquiries.Get(ctx, input.NameEnquiryRef, userID)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return nil, paymentValidationError("nameEnquiryRef", "is unknown or has expired")
+			}
+			return nil, err
+		}
+	}
+	if enquiry.Internal {
+		return nil, paymentValidationError("nameEnquiryRef", "is for one of our own accounts")
+	}
+
+	originator, err := app.models.AccountModel.GetAccountHolder(ctx, userDetail.AccountNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.checkLimits(userDetail, data.ChannelTransfers, input.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	amount := decimal.NewFromInt(int64(input.Amount))
+	fee := data.InterbankFee(amount)
+	// Fail fast on insufficient funds. Debit re-checks under a row lock.
+	balance, err := decimal.NewFromString(userDetail.Balance)
+	if err == nil && balance.LessThan(amount.Add(fee)) {
+		return nil, data.ErrInsufficientFunds
+	}
+
+	reference, err := data.NewInterbankReference()
+	if err != nil {
+		return nil, err
+	}
+	feeValue, _ := fee.Float64()
+	transfer := &data.InterbankTransfer{
+		Reference:                reference,
+		RequestID:                input.Reference,
+		UserID:                   userID,
+		AccountNumber:            userDetail.AccountNumber,
+		NameEnquiryRef:           enquiry.Ref,
+		BankCode:                 enquiry.BankCode,
+		BankName:                 enquiry.BankName,
+		BeneficiaryAccountNumber: enquiry.AccountNumber,
+		BeneficiaryAccountName:   enquiry.AccountName,
+		Amount:                   float64(input.Amount),
+		Fee:                      feeValue,
+		Narration:                input.Narration,
+	}
+	if beneficiary != nil {
+		transfer.BeneficiaryID = beneficiary.ID
+		transfer.CoolingOffLimit = app.config.Beneficiaries.CoolingOffLimit
+	}
+
+	err = app.models.InterbankTransfers.Debit(ctx, transfer, app.config.Interbank.RequeryDelay)
+	if err != nil {
+		return nil, limitError(data.ChannelTransfers, err)
+	}
+
+	// The money has left the sender's balance, so from here on the transfer is seen
+	// through even if the client goes away.
+	ctx = context.WithoutCancel(ctx)
+	sent, err := thirdparty.FundsTransferCredit(ctx, &thirdparty.FundsTransferCreditRequest{
+		NameEnquiryRef:                    enquiry.SessionID,
+		DestinationInstitutionCode:        enquiry.BankCode,
+		ChannelCode:                       thirdparty.ChannelMobile,
+		BeneficiaryAccountName:            enquiry.AccountName,
+		BeneficiaryAccountNumber:          enquiry.AccountNumber,
+		BeneficiaryBankVerificationNumber: enquiry.BVN,
+		BeneficiaryKYCLevel:               enquiry.KYCLevel,
+		OriginatorAccountName:             originator.Name,
+		OriginatorAccountNumber:           originator.AccountNumber,
+		OriginatorBankVerificationNumber:  originator.BVN,
+		OriginatorKYCLevel:                kycLevel(originator.KYCLevel),
+		Narration:                         input.Narration,
+		PaymentReference:                  reference,
+		Amount:                            amount.StringFixed(2),
+	})
+
+	var apiErr *thirdparty.APIError
+	switch {
+	case err == nil:
+		settled, settleErr := app.settleInterbankTransfer(ctx, reference, data.Completed, sent.SessionID)
+		if settleErr != nil {
+			// The requery will settle it.
+			app.logger.Error(settleErr.Error(), "reference", reference)
+		} else {
+			transfer = settled
+		}
+	case errors.As(err, &apiErr) && apiErr.Indeterminate():
+		app.logger.Error(err.Error(), "reference", reference)
+	default:
+		settled, settleErr := app.settleInterbankTransfer(ctx, reference, data.Failed, "")
+		if settleErr != nil {
+			app.logger.Error(err.Error(), "reference", reference)
+			return nil, settleErr
+		}
+		if apiErr != nil && apiErr.Unreachable() {
+			return nil, err
+		}
+		app.logger.Error(err.Error(), "reference", reference)
+		return nil, transferDeclinedError(settled)
+	}
+
+	app.recordBeneficiaryUse(ctx, beneficiary)
+	return transfer, nil
+}
+
+// settleInterbankTransfer settles a pending transfer and counts the outcome.
+func (app *application) settleInterbankTransfer(ctx context.Context, reference, status, sessionID string) (*data.InterbankTransfer, error) {
+	transfer, changed, err := app.models.InterbankTransfers.Settle(ctx, reference, status, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if changed {
+		metrics.Transactions.WithLabelValues(string(data.Debit), transfer.Status).Inc()
+	}
+	return transfer, nil
+}
+
+// requeryInterbankTransfer is the outbox handler for the status requery queued with
+// every interbank transfer. A transfer that has been settled in the meantime is left
+// alone. Otherwise the network is asked for the outcome: a transfer it reports as
+// successful is completed, and one it reports as failed or can't find is failed and
+// refunded. An answer that still leaves the outcome open is returned as an error,
+// so that the outbox asks again later; a transfer that is still pending when the
+// outbox gives up needs a human.
+func (app *application) requeryInterbankTransfer(ctx context.Context, msg *data.OutboxMessage) error {
+	var ref data.TransactionReference
+	err := json.Unmarshal(msg.Payload, &ref)
+	if err != nil {
+		return err
+	}
+
+	transfer, err := app.models.InterbankTransfers.Get(ctx, ref.Reference)
+	if err != nil {
+		return err
+	}
+	if transfer.Status != data.Pending {
+		return nil
+	}
+
+	status, err := thirdparty.TransactionStatusQuery(ctx, transfer.Reference)
+	var apiErr *thirdparty.APIError
+	switch {
+	case err == nil:
+		_, err = app.settleInterbankTransfer(ctx, transfer.Reference, data.Completed, status.SessionID)
+	case errors.As(err, &apiErr) && apiErr.Declined() && !apiErr.Indeterminate():
+		_, err = app.settleInterbankTransfer(ctx, transfer.Reference, data.Failed, "")
+	}
+	return err
+}
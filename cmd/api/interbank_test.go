@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/ebitezion/backend-framework/internal/data"
+	"github.com/ebitezion/backend-framework/internal/mock"
+)
+
+// confirmBeneficiary makes a name enquiry and returns its reference.
+func confirmBeneficiary(t *testing.T, ts *testServer, token string, request data.NameEnquiryRequest) string {
+	t.Helper()
+	resp := ts.Do(t, http.MethodPost, "/v1/accounts/name-enquiry", token, request)
+	if resp.Status != http.StatusCreated {
+		t.Fatalf("name enquiry: got %d %s", resp.Status, resp.Body)
+	}
+	var enquiry data.NameEnquiry
+	resp.Decode(t, &enquiry)
+	return enquiry.Ref
+}
+
+// balance returns the balance of the account the token's user holds.
+func balance(t *testing.T, ts *testServer, token string) string {
+	t.Helper()
+	details, err := ts.models.Users.GetUserDetailsFromToken(context.Background(), data.ScopeAuthentication, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return details.Balance
+}
+
+// requery runs the status requery of a transfer, as the outbox would.
+func requery(t *testing.T, ts *testServer, reference string) error {
+	t.Helper()
+	msg, err := data.NewOutboxMessage(data.OutboxInterbankRequery, data.TransactionReference{Reference: reference})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts.app.requeryInterbankTransfer(context.Background(), msg)
+}
+
+func TestInterbankTransfer(t *testing.T) {
+	ts := newTestServer(t)
+	token := newCustomer(t, ts, "ada@example.com", "0123456789", 300_000)
+	newCustomer(t, ts, "eve@example.com", "9876543210", 0)
+	beneficiary := data.NameEnquiryRequest{AccountNumber: "1234567890", BankCode: mock.Banks[0].Code}
+	ref := confirmBeneficiary(t, ts, token, beneficiary)
+
+	resp := ts.Do(t, http.MethodPost, "/v1/transfers/interbank", token, data.InterbankTransferRequest{NameEnquiryRef: ref, Amount: 10_000, Narration: "rent", Reference: "ref-1"})
+	if resp.Status != http.StatusCreated {
+		t.Fatalf("got %d %s", resp.Status, resp.Body)
+	}
+	var transfer data.InterbankTransfer
+	resp.Decode(t, &transfer)
+	if transfer.Status != data.Completed || transfer.Fee != 26.88 || transfer.SessionID == "" || transfer.BeneficiaryAccountName != "MOCK BENEFICIARY 7890" {
+		t.Errorf("got %+v", transfer)
+	}
+	if got := balance(t, ts, token); got != "289973.12" {
+		t.Errorf("got balance %s, want 289973.12", got)
+	}
+
+	// The network got the transfer under our reference.
+	sent, ok := ts.provider.Transfer(transfer.Reference)
+	if !ok || sent.Amount != "10000.00" || sent.BeneficiaryAccountNumber != beneficiary.AccountNumber {
+		t.Errorf("provider has %+v, %v", sent, ok)
+	}
+
+	// The requery queued with the debit finds nothing to do.
+	if err := requery(t, ts, transfer.Reference); err != nil {
+		t.Error(err)
+	}
+	stored, err := ts.models.InterbankTransfers.Get(context.Background(), transfer.Reference)
+	if err != nil || stored.Status != data.Completed {
+		t.Errorf("got %+v, %v", stored, err)
+	}
+
+	internal := confirmBeneficiary(t, ts, token, data.NameEnquiryRequest{AccountNumber: "9876543210"})
+	tests := []struct {
+		name    string
+		request data.InterbankTransferRequest
+		status  int
+		code    string
+	}{
+		{"unknown enquiry", data.InterbankTransferRequest{NameEnquiryRef: "NE0", Amount: 100, Reference: "ref-2"}, http.StatusUnprocessableEntity, ValidationError.Code},
+		{"internal enquiry", data.InterbankTransferRequest{NameEnquiryRef: internal, Amount: 100, Reference: "ref-3"}, http.StatusUnprocessableEntity, ValidationError.Code},
+		{"over single limit", data.InterbankTransferRequest{NameEnquiryRef: ref, Amount: 200_001, Reference: "ref-4"}, http.StatusForbidden, TransferSingleLimitExceeded.Code},
+		{"at single limit", data.InterbankTransferRequest{NameEnquiryRef: ref, Amount: 200_000, Reference: "ref-5"}, http.StatusCreated, Success.Code},
+		{"insufficient funds", data.InterbankTransferRequest{NameEnquiryRef: ref, Amount: 89_973, Reference: "ref-6"}, http.StatusUnprocessableEntity, InsufficientFunds.Code},
+		{"invalid", data.InterbankTransferRequest{Amount: 0}, http.StatusUnprocessableEntity, ValidationError.Code},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := ts.Do(t, http.MethodPost, "/v1/transfers/interbank", token, tt.request)
+			if resp.Status != tt.status || resp.StatusCode != tt.code {
+				t.Fatalf("got %d %s", resp.Status, resp.Body)
+			}
+		})
+	}
+}
+
+func TestInterbankTransferDeclined(t *testing.T) {
+	ts := newTestServer(t)
+	token := newCustomer(t, ts, "ada@example.com", "0123456789", 1000)
+	ref := confirmBeneficiary(t, ts, token, data.NameEnquiryRequest{AccountNumber: "1234567890", BankCode: mock.Banks[0].Code})
+
+	// Dormant account.
+	ts.provider.Script(mock.Fault{ResponseCode: "69"})
+	resp := ts.Do(t, http.MethodPost, "/v1/transfers/interbank", token, data.InterbankTransferRequest{NameEnquiryRef: ref, Amount: 500, Reference: "ref-1"})
+	if resp.Status != http.StatusUnprocessableEntity || resp.StatusCode != TransferDeclined.Code {
+		t.Fatalf("got %d %s", resp.Status, resp.Body)
+	}
+
+	// The sender has their money back, fee included, and the debit is on record as
+	// failed.
+	if got := balance(t, ts, token); got != "1000.00" {
+		t.Errorf("got balance %s, want 1000.00", got)
+	}
+	history, err := ts.models.Transactions.GetAccountHistory(context.Background(), "0123456789", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Source != data.SourceInterbank || history[0].Status != data.Failed {
+		t.Errorf("got history %+v", history)
+	}
+}
+
+func TestInterbankTransferPending(t *testing.T) {
+	tests := []struct {
+		name    string
+		fault   mock.Fault
+		status  string
+		balance string
+	}{
+		// The transfer went through but the answer was lost.
+		{"carried out", mock.Fault{Malformed: true}, data.Completed, "489.25"},
+		// The network failed before it got to the transfer.
+		{"not carried out", mock.Fault{Status: http.StatusServiceUnavailable}, data.Failed, "1000.00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			token := newCustomer(t, ts, "ada@example.com", "0123456789", 1000)
+			ref := confirmBeneficiary(t, ts, token, data.NameEnquiryRequest{AccountNumber: "1234567890", BankCode: mock.Banks[0].Code})
+
+			ts.provider.Script(tt.fault)
+			resp := ts.Do(t, http.MethodPost, "/v1/transfers/interbank", token, data.InterbankTransferRequest{NameEnquiryRef: ref, Amount: 500, Reference: "ref-1"})
+			if resp.Status != http.StatusAccepted {
+				t.Fatalf("got %d %s", resp.Status, resp.Body)
+			}
+			var transfer data.InterbankTransfer
+			resp.Decode(t, &transfer)
+			if transfer.Status != data.Pending {
+				t.Errorf("got %+v", transfer)
+			}
+			if got := balance(t, ts, token); got != "489.25" {
+				t.Errorf("pending: got balance %s, want 489.25", got)
+			}
+
+			// While the network can't say, the transfer stays pending.
+			unavailable := mock.Fault{Status: http.StatusServiceUnavailable}
+			ts.provider.Script(unavailable, unavailable, unavailable)
+			if err := requery(t, ts, transfer.Reference); err == nil {
+				t.Error("requery with the network down: got no error")
+			}
+
+			if err := requery(t, ts, transfer.Reference); err != nil {
+				t.Fatal(err)
+			}
+			stored, err := ts.models.InterbankTransfers.Get(context.Background(), transfer.Reference)
+			if err != nil || stored.Status != tt.status {
+				t.Errorf("got %+v, %v; want %s", stored, err, tt.status)
+			}
+			if got := balance(t, ts, token); got != tt.balance {
+				t.Errorf("got balance %s, want %s", got, tt.balance)
+			}
+		})
+	}
+}
@@ -3,109 +3,134 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
-	"net/http"
+	"log/slog"
 	"os"
-	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/ebitezion/backend-framework/internal/billers"
+	"github.com/ebitezion/backend-framework/internal/blob"
+	"github.com/ebitezion/backend-framework/internal/config"
 	"github.com/ebitezion/backend-framework/internal/data"
+	"github.com/ebitezion/backend-framework/internal/health"
+	"github.com/ebitezion/backend-framework/internal/logging"
 	"github.com/ebitezion/backend-framework/internal/mailer"
+	"github.com/ebitezion/backend-framework/internal/metrics"
+	"github.com/ebitezion/backend-framework/internal/migrate"
+	"github.com/ebitezion/backend-framework/internal/mock"
+	"github.com/ebitezion/backend-framework/internal/notify"
+	thirdparty "github.com/ebitezion/backend-framework/internal/third_party"
+	"github.com/ebitezion/backend-framework/internal/webhook"
+	"github.com/ebitezion/backend-framework/migrations"
 
 	"github.com/joho/godotenv"
 
-	//_ "github.com/lib/pq"
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
 )
 
 // Application version number. Later on this will be generated at build time.
 const version = "1.0.0"
 
-// Define a config struct to hold all the configuration settings for the app.
-type config struct {
-	port int
-	env  string
-	db   struct {
-		dsn          string
-		maxOpenConns int
-		maxIdleConns int
-		maxIdleTime  string
-	}
-
-	smtp struct {
-		host     string
-		port     int
-		username string
-		password string
-		sender   string
-	}
-}
-
 // Define an application struct to hold the dependencies for HTTP handlers,
 // helpers, and middleware.
 type application struct {
-	config config
-	logger *log.Logger
-	models data.Models
-	mailer mailer.Mailer
+	config   config.Config
+	logger   *slog.Logger
+	db       *sql.DB
+	models   data.Models
+	mailer   mailer.Mailer
+	blobs    blob.Store
+	notifier *notify.Notifier
+	webhooks *webhook.Sender
+	health   *health.Checker
+	banks    *bankList
+	billers  *billers.Catalogue
+	wg       sync.WaitGroup
+	draining atomic.Bool
 }
 
 func main() {
+	// Log at info level until the flags have been parsed.
+	logger := logging.New(os.Stdout, slog.LevelInfo)
+
 	err := godotenv.Load()
 	if err != nil {
-		log.Println("Environment Loading Error", err)
+		logger.Warn("environment loading error", "error", err)
+	}
+
+	// Load the configuration from the file named by -config (if any), then the
+	// environment, then the command-line flags, and refuse to start with any of it
+	// invalid.
+	cfg, printConfig, err := config.Load(os.Args[0], os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
 	}
-	//Variables, environment and stuffs
-	var cfg config
-	PORT, err := strconv.ParseInt(os.Getenv("PORT"), 10, 32)
 	if err != nil {
-		log.Println("String to Int Conversion Error", err)
+		logger.Error(err.Error())
+		os.Exit(2)
+	}
+	if printConfig {
+		err = cfg.Print(os.Stdout)
+		if err == nil {
+			err = cfg.Validate()
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
 	}
-	maxOpenConns, err := strconv.ParseInt(os.Getenv("DATABASE_MAX_OPEN_CONNS"), 10, 32)
+	err = cfg.Validate()
 	if err != nil {
-		log.Println("String to Int Conversion Error", err)
+		logger.Error(err.Error())
+		os.Exit(1)
 	}
 
-	maxIdleConns, err := strconv.ParseInt(os.Getenv("DATABASE_MAX_IDLE_CONNS"), 10, 32)
+	// Initialize a new JSON logger which writes redacted records at or above the
+	// configured level to the standard output stream. It also becomes the default
+	// logger, so anything written with the standard log package is formatted (and
+	// redacted) the same way.
+	level, err := logging.ParseLevel(cfg.LogLevel)
 	if err != nil {
-		log.Println("String to Int Conversion Error", err)
+		logger.Error(err.Error())
+		os.Exit(1)
 	}
+	logger = logging.New(os.Stdout, level)
+	slog.SetDefault(logger)
 
-	maxIdleTime, err := strconv.ParseInt(os.Getenv("DATABASE_MAX_IDLE_TIME"), 10, 32)
+	dialect, err := data.ParseDialect(cfg.DB.Driver)
 	if err != nil {
-		log.Println("String to Int Conversion Error", err)
+		logger.Error(err.Error())
+		os.Exit(1)
 	}
 
-	// Read the values of the command-line flags into the config struct.
-	flag.IntVar(&cfg.port, "port", int(PORT), "API server port")
-	flag.StringVar(&cfg.env, "env", os.Getenv("ENV"), "Environment (development|staging|production)")
-	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("DATABASE_DSN"), "MySQL DSN")
-	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", int(maxOpenConns), "MySQL max open connections")
-	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", int(maxIdleConns), "MySQL max idle connections")
-	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", fmt.Sprintf("%d%v", maxIdleTime, "m"), "MySQL max connection idle time")
-
-	// Read the SMTP server configuration settings into the config struct, using the
-	// Mailtrap settings as the default values.
-	flag.StringVar(&cfg.smtp.host, "smtp-host", os.Getenv("MAIL_HOST"), "SMTP host")
-	flag.IntVar(&cfg.smtp.port, "smtp-port", 465, "SMTP port")
-	flag.StringVar(&cfg.smtp.username, "smtp-username", os.Getenv("MAIL_USERNAME"), "SMTP username")
-	flag.StringVar(&cfg.smtp.password, "smtp-password", os.Getenv("MAIL_PASSWORD"), "SMTP password")
-	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Spectrum Extra App <notification@spectrummfb.com>", "SMTP sender")
-
-	flag.Parse()
-
-	// Initialize a new logger which writes messages to the standard output stream,
-	// prefixed with the current date and time.
-	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	// In development the mock provider runs in-process and stands in for any
+	// third-party endpoint that isn't configured.
+	upstreams := cfg.ThirdParty
+	if cfg.Env == "development" {
+		provider := mock.Start()
+		defer provider.Close()
+		if cfg.Provider.URL == "" {
+			cfg.Provider.URL = provider.URL
+			logger.Info("using mock payment provider", "url", cfg.Provider.URL)
+		}
+		upstreams = upstreams.WithMockDefaults(provider.URL)
+	}
+	thirdparty.Configure(upstreams)
+	logger.Info("third-party endpoints configured", "upstreams", upstreams)
 
 	// Call the openDB() helper function (see below) to create the connection pool,
 	// passing in the config struct. If this returns an error, we log it and exit the
 	// application immediately.
 	db, err := openDB(cfg)
 	if err != nil {
-		logger.Fatal(err)
+		logger.Error(err.Error())
+		os.Exit(1)
 	}
 
 	// Defer a call to db.Close() so that the connection pool is closed before the
@@ -114,61 +139,107 @@ func main() {
 
 	// Also log a message to say that the connection pool has been successfully
 	// established.
-	logger.Printf("database connection pool established")
+	logger.Info("database connection pool established")
+	metrics.RegisterDB(db, cfg.DB.Driver)
+
+	// Bring the schema up to date before anything touches it. Instances started
+	// together wait on each other rather than racing to apply the same migration.
+	if cfg.DB.Migrate {
+		fsys, err := migrations.FS(cfg.DB.Driver)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		version, err := migrate.Up(context.Background(), db, dialect, fsys, logger)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		logger.Info("database schema up to date", "version", version)
+	}
+
+	// Open the blob store used for uploaded documents.
+	blobs, err := blob.NewLocalStore(cfg.Uploads.Dir)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	mail := mailer.New(cfg.SMTP.Host, cfg.SMTP.Port, cfg.SMTP.Username, cfg.SMTP.Password, cfg.SMTP.Sender)
+
+	// Assemble the notification channels that have been configured. Email is always
+	// available and is the fallback for mandatory notifications.
+	channels := []notify.Channel{notify.NewEmailChannel(mail)}
+	if cfg.Notify.SMSURL != "" {
+		channels = append(channels, notify.NewSMSChannel(cfg.Notify.SMSURL, cfg.Notify.SMSAPIKey, cfg.Notify.SMSSender))
+	}
+	if cfg.Notify.PushURL != "" {
+		channels = append(channels, notify.NewPushChannel(cfg.Notify.PushURL, cfg.Notify.PushKey))
+	}
+	switch cfg.Notify.LogFile {
+	case "":
+	case "-":
+		channels = append(channels, notify.NewLogChannel(os.Stdout))
+	default:
+		f, err := os.OpenFile(cfg.Notify.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		defer f.Close()
+		channels = append(channels, notify.NewLogChannel(f))
+	}
 
 	// Declare an instance of the application struct, containing the config struct and
 	// the logger.
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
-	}
-
-	// Declare a HTTP server with some sensible timeout settings, which listens on the
-	// port provided in the config struct and uses the servemux we created as the handler.
-	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", cfg.port),
-		Handler:      app.routes(),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
-	}
-
-	// Start the HTTP server.
-	logger.Printf("starting %s server on %s", cfg.env, srv.Addr)
-	err = srv.ListenAndServe()
-	logger.Fatal(err)
+		config:   cfg,
+		logger:   logger,
+		db:       db,
+		models:   data.NewModels(db, dialect, cfg.DB.QueryTimeout),
+		mailer:   mail,
+		blobs:    blobs,
+		notifier: notify.New(notify.ChannelEmail, channels...),
+		webhooks: webhook.NewSender(10*time.Second, cfg.Env == "development"),
+		banks:    newBankList(cfg.Interbank.BankListTTL),
+		billers:  billers.NewCatalogue(cfg.Bills.CatalogueTTL, billers.Upstream{}),
+	}
+
+	app.health = app.newHealthChecker()
+
+	// Start the server and block until it has shut down.
+	err = app.serve()
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
 }
 
 // The openDB() function returns a sql.DB connection pool.
-func openDB(cfg config) (*sql.DB, error) {
+func openDB(cfg config.Config) (*sql.DB, error) {
+	// Pin the session time zone, so that NOW() agrees with the times we write.
+	dsn, err := data.Dialect(cfg.DB.Driver).SessionDSN(cfg.DB.DSN)
+	if err != nil {
+		return nil, err
+	}
+
 	// Use sql.Open() to create an empty connection pool, using the DSN from the config
 	// struct.
-	db, err := sql.Open("mysql", cfg.db.dsn)
+	db, err := sql.Open(cfg.DB.Driver, dsn)
 	if err != nil {
-		fmt.Println("Connection to DB cannot be established...")
 		return nil, err
 	}
 
 	// Set the maximum number of open (in-use + idle) connections in the pool. Note that
 	// passing a value less than or equal to 0 will mean there is no limit.
-	db.SetMaxOpenConns(cfg.db.maxOpenConns)
+	db.SetMaxOpenConns(cfg.DB.MaxOpenConns)
 
 	// Set the maximum number of idle connections in the pool. Again, passing a value
 	// less than or equal to 0 will mean there is no limit.
-	db.SetMaxIdleConns(cfg.db.maxIdleConns)
-
-	// Use the time.ParseDuration() function to conver the idle timeout duration string
-	// to a time.Duration type.
-	duration, err := time.ParseDuration(cfg.db.maxIdleTime)
-	if err != nil {
-		fmt.Println("Connection to DB failed due to parse duration...")
-		return nil, err
-	}
+	db.SetMaxIdleConns(cfg.DB.MaxIdleConns)
 
 	// Set the maximum idle timeout.
-	db.SetConnMaxIdleTime(duration)
+	db.SetConnMaxIdleTime(cfg.DB.MaxIdleTime)
 
 	// Create a context with a 5-second timeout deadline.
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ebitezion/backend-framework/internal/apitest"
+	"github.com/ebitezion/backend-framework/internal/billers"
+	"github.com/ebitezion/backend-framework/internal/blob"
+	"github.com/ebitezion/backend-framework/internal/config"
+	"github.com/ebitezion/backend-framework/internal/data"
+	"github.com/ebitezion/backend-framework/internal/data/memstore"
+	"github.com/ebitezion/backend-framework/internal/mailer"
+	"github.com/ebitezion/backend-framework/internal/mock"
+	thirdparty "github.com/ebitezion/backend-framework/internal/third_party"
+	"github.com/ebitezion/backend-framework/internal/webhook"
+)
+
+// testServer is the API served over HTTP from an in-memory store, talking to a fake
+// payment provider.
+type testServer struct {
+	*apitest.Client
+	app      *application
+	models   data.Models
+	provider *mock.Server
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	provider := apitest.NewProvider(t)
+	blobs, err := blob.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg config.Config
+	cfg.Env = "testing"
+	cfg.Provider.URL = provider.URL
+	cfg.Provider.RequeryDelay = time.Minute
+	cfg.Uploads.MaxBytes = 5_242_880
+	cfg.Interbank.InstitutionCode = "999999"
+	cfg.Interbank.InstitutionName = "Test Bank"
+	cfg.Interbank.BankListTTL = time.Hour
+	cfg.Interbank.NameEnquiryTTL = 10 * time.Minute
+	cfg.Interbank.RequeryDelay = time.Minute
+	cfg.Bills.CatalogueTTL = time.Hour
+	cfg.Bills.RequeryDelay = time.Minute
+	cfg.USSD.MaxPINAttempts = 3
+
+	// The interbank endpoints are configured package-wide, so point them at this
+	// test's provider.
+	thirdparty.Configure(thirdparty.Config{}.WithMockDefaults(provider.URL))
+
+	models := memstore.New().Models()
+	app := &application{
+		config:   cfg,
+		logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+		models:   models,
+		mailer:   mailer.New("localhost", 25, "", "", "test@example.com"),
+		blobs:    blobs,
+		webhooks: webhook.NewSender(time.Second, false),
+		banks:    newBankList(cfg.Interbank.BankListTTL),
+		billers:  billers.NewCatalogue(cfg.Bills.CatalogueTTL, billers.Upstream{}),
+	}
+
+	ts := httptest.NewServer(app.routes())
+	t.Cleanup(func() {
+		ts.Close()
+		app.wg.Wait()
+	})
+
+	return &testServer{
+		Client:   apitest.NewClient(ts.URL),
+		app:      app,
+		models:   models,
+		provider: provider,
+	}
+}
+
+// wait blocks until the background tasks started by earlier requests have finished.
+func (ts *testServer) wait() {
+	ts.app.wg.Wait()
+}
@@ -1,14 +1,96 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"net/http"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/ebitezion/backend-framework/internal/data"
+	"github.com/ebitezion/backend-framework/internal/metrics"
 	"github.com/ebitezion/backend-framework/internal/validator"
+	"github.com/julienschmidt/httprouter"
 )
 
+// rxRequestID limits the request IDs we accept from clients and proxies to something
+// that is safe to echo back and to write into logs.
+var rxRequestID = regexp.MustCompile(`^[A-Za-z0-9._\-]{1,64}$`)
+
+// The requestID() middleware tags every request with an ID, which is added to the
+// request context (for logging) and returned in the X-Request-ID header. An ID set
+// by an upstream proxy is kept so that requests can be traced across services.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !rxRequestID.MatchString(id) {
+			b := make([]byte, 16)
+			_, err := rand.Read(b)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, app.contextSetRequestID(r, id))
+	})
+}
+
+// statusRecorder remembers the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// The metrics() middleware records the count and latency of every request, labelled
+// with the route pattern (e.g. /v1/webhooks/:id) rather than the raw path so that
+// IDs don't explode the number of series. Requests that match no route share the
+// "unmatched" label.
+func (app *application) metrics(router *httprouter.Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rec, r)
+
+		metrics.ObserveHTTP(r.Method, routePattern(router, r), rec.status, time.Since(start))
+	})
+}
+
+// routePattern rebuilds the pattern a request was routed by, by putting the
+// parameter names back in place of their values.
+func routePattern(router *httprouter.Router, r *http.Request) string {
+	handle, params, _ := router.Lookup(r.Method, r.URL.Path)
+	if handle == nil {
+		return "unmatched"
+	}
+
+	segments := strings.Split(r.URL.Path, "/")
+	for _, param := range params {
+		for i, segment := range segments {
+			if segment == param.Value {
+				segments[i] = ":" + param.Key
+				break
+			}
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Add the "Vary: Authorization" header to the response. This indicates to any
@@ -52,7 +134,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// again calling the invalidAuthenticationTokenResponse() helper if no
 		// matching record was found. IMPORTANT: Notice that we are using
 		// ScopeAuthentication as the first parameter here.
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		user, err := app.models.Users.GetForToken(r.Context(), data.ScopeAuthentication, token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -83,66 +165,44 @@ func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.Han
 	})
 }
 
-// // Checks that a user is both authenticated and activated.
-// func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
-// 	// Rather than returning this http.HandlerFunc we assign it to the variable fn.
-// 	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-// 		user := app.contextGetUser(r)
-// 		// Check that a user is activated.
-// 		if !user.Activated {
-// 			app.inactiveAccountResponse(w, r)
-// 			return
-// 		}
-// 		next.ServeHTTP(w, r)
-// 	})
-// 	// Wrap fn with the requireAuthenticatedUser() middleware before returning it.
-// 	return app.requireAuthenticatedUser(fn)
-// }
+// Checks that a user is both authenticated and activated.
+func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
+	// Rather than returning this http.HandlerFunc we assign it to the variable fn.
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+		// Check that a user is activated.
+		if !user.Activated.Bool {
+			app.inactiveAccountResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+	// Wrap fn with the requireAuthenticatedUser() middleware before returning it.
+	return app.requireAuthenticatedUser(fn)
+}
 
 // Note that the first parameter for the middleware function is the permission code that
 // we require the user to have.
-// func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
-// 	fn := func(w http.ResponseWriter, r *http.Request) {
-// 		// Retrieve the user from the request context.
-// 		user := app.contextGetUser(r)
-// 		// Get the slice of permissions for the user.
-// 		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
-// 		if err != nil {
-// 			app.serverErrorResponse(w, r, err)
-// 			return
-// 		}
-// 		// Check if the slice includes the required permission. If it doesn't, then
-// 		// return a 403 Forbidden response.
-// 		if !permissions.Include(code) {
-// 			app.notPermittedResponse(w, r)
-// 			return
-// 		}
-// 		// Otherwise they have the required permission so we call the next handler in
-// 		// the chain.
-// 		next.ServeHTTP(w, r)
-// 	}
-// 	// Wrap this with the requireActivatedUser() middleware before returning it. This should check if user is permitted.
-// 	return app.requireActivatedUser(fn)
-// }
-
-// func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
-// 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-// 		// Use the contextGetUser() helper that we made earlier to retrieve the user
-// 		// information from the request context.
-// 		user := app.contextGetUser(r)
-// 		// If the user is anonymous, then call the authenticationRequiredResponse() to
-// 		// inform the client that they should authenticate before trying again.
-// 		if user.IsAnonymous() {
-// 			app.authenticationRequiredResponse(w, r)
-// 			return
-// 		}
-// 		// If the user is not activated, use the inactiveAccountResponse() helper to
-// 		// inform them that they need to activate their account.
-// 		if !user.Activated {
-// 			app.inactiveAccountResponse(w, r)
-// 			return
-// 		}
-// 		// Call the next handler in the chain.
-// 		next.ServeHTTP(w, r)
-// 	})
-// }
+func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		// Retrieve the user from the request context.
+		user := app.contextGetUser(r)
+		// Get the slice of permissions for the user.
+		permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		// Check if the slice includes the required permission. If it doesn't, then
+		// return a 403 Forbidden response.
+		if !permissions.Include(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+		// Otherwise they have the required permission so we call the next handler in
+		// the chain.
+		next.ServeHTTP(w, r)
+	}
+	// Wrap this with the requireActivatedUser() middleware before returning it. This should check if user is permitted.
+	return app.requireActivatedUser(fn)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ebitezion/backend-framework/internal/data"
+	"github.com/ebitezion/backend-framework/internal/notify"
+	"github.com/ebitezion/backend-framework/internal/validator"
+	"github.com/shopspring/decimal"
+)
+
+// transactionAlertMessages builds the outbox messages that notify the account holder
+// about a committed debit or credit, one per channel they have enabled. Alerts are
+// mandatory, so they still go out by email if the user has switched everything off.
+func (app *application) transactionAlertMessages(ctx context.Context, transaction *data.Transaction) ([]*data.OutboxMessage, error) {
+	user, err := app.models.Users.GetUserByUserID(ctx, strconv.FormatUint(transaction.UserID, 10))
+	if err != nil {
+		return nil, err
+	}
+	prefs, err := app.models.Notifications.GetForUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	recipient := notify.Recipient{
+		UserID:      user.ID,
+		Name:        user.Name,
+		Email:       user.Email,
+		PhoneNumber: user.PhoneNumber,
+		PushToken:   prefs.PushToken,
+	}
+
+	alert := notify.TransactionAlert{
+		Name:          user.Name,
+		AccountNumber: notify.MaskAccountNumber(transaction.AccountNumber),
+		Type:          transaction.Type,
+		Amount:        decimal.NewFromFloat(transaction.Amount).StringFixed(2),
+		Narration:     transaction.Narration,
+		Reference:     transaction.InternalReference,
+		Date:          time.Now().Format("02-Jan-2006 15:04"),
+	}
+	if transaction.CreatedAt != nil {
+		alert.Date = *transaction.CreatedAt
+	}
+	if transaction.BalanceAfter != nil {
+		alert.Balance = decimal.NewFromFloat(*transaction.BalanceAfter).StringFixed(2)
+	}
+	alertJSON, err := json.Marshal(alert)
+	if err != nil {
+		return nil, err
+	}
+
+	templateName := notify.CreditAlert
+	if transaction.Type == string(data.Debit) {
+		templateName = notify.DebitAlert
+	}
+
+	var messages []*data.OutboxMessage
+	for _, channel := range app.notifier.Targets(recipient, notificationPreferences(prefs), true) {
+		msg, err := data.NewOutboxMessage(data.OutboxNotification, notificationMessage{
+			Channel:   channel,
+			Recipient: recipient,
+			Template:  templateName,
+			Data:      alertJSON,
+		})
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// notificationPreferences converts the stored preferences into the channel map the
+// notifier works with. The log sink is always on; it only exists in development.
+func notificationPreferences(prefs *data.NotificationPreferences) notify.Preferences {
+	return notify.Preferences{
+		notify.ChannelEmail: prefs.Email,
+		notify.ChannelSMS:   prefs.SMS,
+		notify.ChannelPush:  prefs.Push,
+	}
+}
+
+// showNotificationPreferencesHandler returns the caller's notification preferences.
+func (app *application) showNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	prefs, err := app.models.Notifications.GetForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := app.SuccessFormater(prefs, "Success")
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateNotificationPreferencesHandler switches channels on or off for the caller
+// and registers the device token used for push notifications. Fields left out of
+// the request body keep their current value.
+func (app *application) updateNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	prefs, err := app.models.Notifications.GetForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		Email     *bool   `json:"email"`
+		SMS       *bool   `json:"sms"`
+		Push      *bool   `json:"push"`
+		PushToken *string `json:"push_token"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Email != nil {
+		prefs.Email = *input.Email
+	}
+	if input.SMS != nil {
+		prefs.SMS = *input.SMS
+	}
+	if input.Push != nil {
+		prefs.Push = *input.Push
+	}
+	if input.PushToken != nil {
+		prefs.PushToken = *input.PushToken
+	}
+
+	v := validator.New()
+	v.Check(len(prefs.PushToken) <= 255, "push_token", "must not be more than 255 bytes long")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Notifications.Upsert(r.Context(), prefs)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := app.SuccessFormater(prefs, "Success")
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
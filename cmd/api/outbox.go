@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/ebitezion/backend-framework/internal/data"
+	"github.com/ebitezion/backend-framework/internal/notify"
+	"github.com/ebitezion/backend-framework/internal/outbox"
+)
+
+// emailMessage is the outbox payload for a templated email.
+type emailMessage struct {
+	Recipient string                 `json:"recipient"`
+	Template  string                 `json:"template"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// notificationMessage is the outbox payload for a notification on a single channel.
+// Each channel gets its own message so that a failing SMS gateway is retried without
+// sending the email a second time.
+type notificationMessage struct {
+	Channel   string           `json:"channel"`
+	Recipient notify.Recipient `json:"recipient"`
+	Template  string           `json:"template"`
+	Data      json.RawMessage  `json:"data"`
+}
+
+// newOutboxWorker returns the outbox worker with a handler registered for every kind
+// of message the application writes to the outbox.
+func (app *application) newOutboxWorker() *outbox.Worker {
+	worker := outbox.New(app.models.Outbox, outbox.Config{
+		Workers:      app.config.Outbox.Workers,
+		PollInterval: app.config.Outbox.PollInterval,
+		Lease:        time.Minute,
+		MaxAttempts:  app.config.Outbox.MaxAttempts,
+		BaseBackoff:  10 * time.Second,
+		MaxBackoff:   time.Hour,
+	}, app.logger)
+
+	worker.Handle(data.OutboxEmail, app.deliverEmail)
+	worker.Handle(data.OutboxNotification, app.deliverNotification)
+	worker.Handle(data.OutboxTransactionAlert, app.expandTransactionAlert)
+	worker.Handle(data.OutboxWebhookEvent, app.expandWebhookEvent)
+	worker.Handle(data.OutboxWebhookDelivery, app.deliverWebhook)
+	worker.Handle(data.OutboxInterbankRequery, app.requeryInterbankTransfer)
+	worker.Handle(data.OutboxBillRequery, app.requeryBillPayment)
+	worker.Handle(data.OutboxPaymentRequery, app.requeryPayment)
+	worker.Handle(data.OutboxInternalReconcile, app.reconcileInternalTransfer)
+	return worker
+}
+
+// enqueueEmail writes an email to the outbox instead of sending it from a goroutine,
+// so it survives a restart and is retried if the SMTP server is unavailable.
+func (app *application) enqueueEmail(ctx context.Context, recipient, templateFile string, payload map[string]interface{}) error {
+	msg, err := data.NewOutboxMessage(data.OutboxEmail, emailMessage{
+		Recipient: recipient,
+		Template:  templateFile,
+		Data:      payload,
+	})
+	if err != nil {
+		return err
+	}
+	return app.models.Outbox.Insert(ctx, msg)
+}
+
+// deliverEmail is the outbox handler for emails.
+func (app *application) deliverEmail(ctx context.Context, msg *data.OutboxMessage) error {
+	var email emailMessage
+	err := json.Unmarshal(msg.Payload, &email)
+	if err != nil {
+		return err
+	}
+	return app.mailer.Send(email.Recipient, email.Template, email.Data)
+}
+
+// deliverNotification is the outbox handler for a notification on a single channel.
+func (app *application) deliverNotification(ctx context.Context, msg *data.OutboxMessage) error {
+	var notification notificationMessage
+	err := json.Unmarshal(msg.Payload, &notification)
+	if err != nil {
+		return err
+	}
+
+	var payload map[string]interface{}
+	err = json.Unmarshal(notification.Data, &payload)
+	if err != nil {
+		return err
+	}
+	return app.notifier.SendVia(notification.Channel, notification.Recipient, notification.Template, payload)
+}
+
+// expandTransactionAlert is the outbox handler for a committed transaction. It queues
+// one notification message for every channel the alert should go out on.
+func (app *application) expandTransactionAlert(ctx context.Context, msg *data.OutboxMessage) error {
+	var ref data.TransactionReference
+	err := json.Unmarshal(msg.Payload, &ref)
+	if err != nil {
+		return err
+	}
+
+	transaction, err := app.models.Transactions.GetTransactionByReference(ctx, ref.Reference)
+	if err != nil {
+		return err
+	}
+
+	messages, err := app.transactionAlertMessages(ctx, transaction)
+	if err != nil {
+		return err
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	return app.models.Outbox.Insert(ctx, messages...)
+}
@@ -3,10 +3,11 @@ package main
 import (
 	"net/http"
 
+	"github.com/ebitezion/backend-framework/internal/metrics"
 	"github.com/julienschmidt/httprouter"
 )
 
-func (app *application) routes() *httprouter.Router {
+func (app *application) routes() http.Handler {
 	// Initialize a new httprouter router instance.
 	router := httprouter.New()
 	// Convert the notFoundResponse() helper to a http.Handler using the
@@ -22,6 +23,8 @@ func (app *application) routes() *httprouter.Router {
 	// endpoints using the HandlerFunc() method.
 	//router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.requirePermission("account:read",app.healthcheckHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/healthcheck/live", app.livenessHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/healthcheck/ready", app.readinessHandler)
 
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/accounts", app.CreateBankAccount)
@@ -31,5 +34,82 @@ func (app *application) routes() *httprouter.Router {
 	//authorize our API with this
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
 
-	return router
+	// Single-account debit and credit payments.
+	router.HandlerFunc(http.MethodPost, "/v1/transactions", app.requireActivatedUser(app.PaymentInitiation))
+
+	// Interbank bank list and beneficiary name enquiry.
+	router.HandlerFunc(http.MethodGet, "/v1/banks", app.requireAuthenticatedUser(app.listBanksHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/accounts/name-enquiry", app.requireActivatedUser(app.nameEnquiryHandler))
+
+	// Transfer tags, which customers can be paid by instead of an account number.
+	router.HandlerFunc(http.MethodPut, "/v1/tags", app.requireActivatedUser(app.claimTransferTagHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/tags/:tag", app.requireActivatedUser(app.showTransferTagHandler))
+
+	// Saved beneficiaries, which transfers can pay by ID.
+	router.HandlerFunc(http.MethodGet, "/v1/beneficiaries", app.requireActivatedUser(app.listBeneficiariesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/beneficiaries", app.requireActivatedUser(app.createBeneficiaryHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/beneficiaries/:id", app.requireActivatedUser(app.showBeneficiaryHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/beneficiaries/:id", app.requireActivatedUser(app.updateBeneficiaryHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/beneficiaries/:id", app.requireActivatedUser(app.deleteBeneficiaryHandler))
+
+	// Transfers between two of our own accounts, by account number, transfer tag or
+	// saved beneficiary.
+	router.HandlerFunc(http.MethodPost, "/v1/transfers/internal", app.requireActivatedUser(app.internalTransferHandler))
+
+	// Transfers to accounts at other banks, to a confirmed name enquiry or a saved
+	// beneficiary.
+	router.HandlerFunc(http.MethodPost, "/v1/transfers/interbank", app.requireActivatedUser(app.interbankTransferHandler))
+
+	// Scheduled and recurring payments to saved beneficiaries, and their run history.
+	router.HandlerFunc(http.MethodGet, "/v1/schedules", app.requireActivatedUser(app.listSchedulesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/schedules", app.requireActivatedUser(app.createScheduleHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/schedules/:id", app.requireActivatedUser(app.showScheduleHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/schedules/:id", app.requireActivatedUser(app.cancelScheduleHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/schedules/:id/runs", app.requireActivatedUser(app.listScheduleRunsHandler))
+
+	// Bill payments: the biller catalogue, customer validation and payments.
+	router.HandlerFunc(http.MethodGet, "/v1/billers", app.requireAuthenticatedUser(app.listBillersHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/billers/customers", app.requireActivatedUser(app.validateBillCustomerHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/bills", app.requireActivatedUser(app.listBillPaymentsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/bills", app.requireActivatedUser(app.payBillHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/bills/:reference", app.requireActivatedUser(app.showBillPaymentHandler))
+
+	// Per-user notification channel preferences.
+	router.HandlerFunc(http.MethodGet, "/v1/users/notifications", app.requireAuthenticatedUser(app.showNotificationPreferencesHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/notifications", app.requireAuthenticatedUser(app.updateNotificationPreferencesHandler))
+
+	// Account upgrade (KYC tier 3/4) submission and the ops review queue.
+	router.HandlerFunc(http.MethodPost, "/v1/accounts/upgrade", app.requireActivatedUser(app.accountUpgradeHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/accounts/upgrades", app.requirePermission("kyc:review", app.listAccountUpgradesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/accounts/upgrades/:id/documents/:document", app.requirePermission("kyc:review", app.showAccountUpgradeDocumentHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/accounts/upgrades/:id", app.requirePermission("kyc:review", app.reviewAccountUpgradeHandler))
+
+	// Settlement callbacks from the payment provider, authenticated by signature.
+	router.HandlerFunc(http.MethodPost, "/v1/callbacks/payments", app.paymentCallbackHandler)
+
+	// USSD sessions from the USSD gateway, authenticated by the gateway's key.
+	router.HandlerFunc(http.MethodPost, "/v1/ussd", app.ussdHandler)
+
+	// Outbound webhooks for API clients: endpoint registration, delivery logs and
+	// replay of failed deliveries.
+	router.HandlerFunc(http.MethodPost, "/v1/webhooks", app.requirePermission("webhooks:manage", app.createWebhookEndpointHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks", app.requirePermission("webhooks:manage", app.listWebhookEndpointsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/webhooks/:id", app.requirePermission("webhooks:manage", app.deleteWebhookEndpointHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id/deliveries", app.requirePermission("webhooks:manage", app.listWebhookDeliveriesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/webhooks/:id/deliveries/:delivery/replay", app.requirePermission("webhooks:manage", app.replayWebhookDeliveryHandler))
+
+	// Wrap the router with the authenticate() middleware so that the user for the
+	// request is available to every handler, and tag every request with an ID first
+	// so that even authentication failures can be traced. The metrics() middleware
+	// goes outermost so that it sees the final status of every request.
+	return app.metrics(router, app.requestID(app.authenticate(router)))
+}
+
+// adminRoutes returns the handler for the admin listener, which is bound to a separate
+// (by default loopback-only) address so that operational endpoints are never exposed
+// alongside the public API.
+func (app *application) adminRoutes() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	return mux
 }
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ebitezion/backend-framework/internal/data"
+	"github.com/ebitezion/backend-framework/internal/schedule"
+	"github.com/ebitezion/backend-framework/internal/validator"
+)
+
+// createScheduleHandler sets up a scheduled payment to one of the caller's
+// beneficiaries, once or on a recurring rule. The caller's transaction PIN authorises
+// every run in advance; the runs themselves are made by the scheduler.
+func (app *application) createScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input data.ScheduleRequest
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateScheduleRequest(v, &input); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Times are kept to the second, in UTC.
+	startAt := input.StartAt.UTC().Truncate(time.Second)
+	var endAt *time.Time
+	if input.EndAt != nil {
+		t := input.EndAt.UTC().Truncate(time.Second)
+		endAt = &t
+	}
+	rule, err := schedule.ParseRule(input.Frequency, input.Cron)
+	if err != nil {
+		v.AddError("cron", err.Error())
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	first, ok := rule.Next(startAt, time.Time{})
+	if !ok || (endAt != nil && first.After(*endAt)) {
+		v.AddError("start_at", "no payment falls between start_at and end_at")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	userDetail, err := app.models.Users.GetUserDetailsByUserID(r.Context(), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.RecordNotFound(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if userDetail.PIN == "" {
+		app.TransactionPINNotSet(w, r, data.ErrTransactionPINNotSet)
+		return
+	}
+	if !VerifyPIN(userDetail.PIN, input.PIN) {
+		app.invalidTransferPINResponse(w, r)
+		return
+	}
+
+	beneficiary, err := app.models.Beneficiaries.Get(r.Context(), input.BeneficiaryID, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("beneficiary_id", "is not one of your beneficiaries")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	s := &data.Schedule{
+		UserID:        user.ID,
+		BeneficiaryID: beneficiary.ID,
+		Type:          beneficiary.Type,
+		Amount:        input.Amount,
+		Narration:     input.Narration,
+		Frequency:     input.Frequency,
+		Cron:          input.Cron,
+		StartAt:       startAt,
+		EndAt:         endAt,
+		NextRunAt:     &first,
+		Status:        data.ScheduleActive,
+	}
+	err = app.models.Schedules.Insert(r.Context(), s)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := app.SuccessFormater(s, "Success")
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listSchedulesHandler returns the caller's scheduled payments, the newest first.
+func (app *application) listSchedulesHandler(w http.ResponseWriter, r *http.Request) {
+	schedules, err := app.models.Schedules.GetAllForUser(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := app.SuccessFormater(schedules, "Success")
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// ownSchedule loads the caller's schedule named by the :id parameter, writing a 404
+// if it doesn't exist or belongs to another user.
+func (app *application) ownSchedule(w http.ResponseWriter, r *http.Request) (*data.Schedule, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	s, err := app.models.Schedules.Get(r.Context(), id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return s, true
+}
+
+// showScheduleHandler returns one of the caller's scheduled payments.
+func (app *application) showScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.ownSchedule(w, r)
+	if !ok {
+		return
+	}
+
+	env := app.SuccessFormater(s, "Success")
+	err := app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// cancelScheduleHandler stops one of the caller's active scheduled payments. Its run
+// history is kept.
+func (app *application) cancelScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.ownSchedule(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Schedules.Cancel(r.Context(), s)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.failedValidationResponse(w, r, map[string]string{"status": "is already " + s.Status})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := app.SuccessFormater(s, "Schedule cancelled")
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listScheduleRunsHandler returns the run history of one of the caller's scheduled
+// payments, the latest first.
+func (app *application) listScheduleRunsHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.ownSchedule(w, r)
+	if !ok {
+		return
+	}
+
+	runs, err := app.models.Schedules.GetRuns(r.Context(), s.ID, s.UserID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := app.SuccessFormater(runs, "Success")
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// newScheduler returns the scheduler that makes scheduled payments.
+func (app *application) newScheduler() *schedule.Scheduler {
+	return schedule.New(app.models.Schedules, app.runScheduledPayment, schedule.Config{
+		BatchSize:    app.config.Schedules.BatchSize,
+		PollInterval: app.config.Schedules.PollInterval,
+		Lease:        app.config.Schedules.Lease,
+		RunTimeout:   app.config.Schedules.RunTimeout,
+		RetryDelay:   app.config.Schedules.RetryDelay,
+		MaxRetries:   app.config.Schedules.MaxRetries,
+	}, app.logger)
+}
+
+// runScheduledPayment is the scheduler's handler. It pays the schedule's beneficiary
+// through the same pipeline as a transfer the user makes themselves, limits and
+// cooling-off included; the PIN given when the schedule was set up stands in for
+// theirs. If an earlier run already made the payment under reference, its outcome is
+// returned instead.
+func (app *application) runScheduledPayment(ctx context.Context, s *data.Schedule, reference string) (string, error) {
+	userDetail, err := app.models.Users.GetUserDetailsByUserID(ctx, s.UserID)
+	if err != nil {
+		return "", err
+	}
+
+	var transferReference string
+	switch s.Type {
+	case data.BeneficiaryInterbank:
+		var transfer *data.InterbankTransfer
+		transfer, err = app.interbankTransfer(ctx, userDetail, &data.InterbankTransferRequest{
+			BeneficiaryID: s.BeneficiaryID,
+			Amount:        s.Amount,
+			Narration:     s.Narration,
+			Reference:     reference,
+		})
+		if err == nil {
+			transferReference = transfer.Reference
+		}
+	default:
+		var transfer *data.InternalTransfer
+		transfer, err = app.internalTransfer(ctx, userDetail, data.ChannelTransfers, &data.InternalTransferRequest{
+			SendersAccountNo: userDetail.AccountNumber,
+			BeneficiaryID:    s.BeneficiaryID,
+			Amount:           s.Amount,
+			Narration:        s.Narration,
+			Reference:        reference,
+		})
+		if err == nil {
+			transferReference = transfer.Reference
+		}
+	}
+	if errors.Is(err, data.ErrDuplicateTransaction) {
+		return app.earlierScheduledPayment(ctx, userDetail.AccountNumber, reference)
+	}
+	return transferReference, err
+}
+
+// earlierScheduledPayment returns the outcome of the payment that an earlier run made
+// under reference but didn't live to record, because its lease ran out, say. A
+// payment that has failed is returned as an error; one that is still pending is
+// treated as paid, as it is when a run makes it.
+func (app *application) earlierScheduledPayment(ctx context.Context, accountNumber, reference string) (string, error) {
+	debit, err := app.models.Transactions.GetDebitByRequestID(ctx, accountNumber, reference)
+	if err != nil {
+		return "", err
+	}
+	if debit.Status == data.Failed {
+		return "", fmt.Errorf("an earlier run of %s failed", reference)
+	}
+	return debit.TransferReference(), nil
+}
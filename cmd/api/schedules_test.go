@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ebitezion/backend-framework/internal/data"
+	"github.com/ebitezion/backend-framework/internal/mock"
+	"github.com/ebitezion/backend-framework/internal/schedule"
+)
+
+// dueNow is a schedule store which hands out one schedule as due, whenever its next
+// run actually is.
+type dueNow struct {
+	data.ScheduleRepository
+	schedule *data.Schedule
+}
+
+func (s dueNow) ClaimDue(context.Context, int, time.Duration) ([]*data.Schedule, error) {
+	return []*data.Schedule{s.schedule}, nil
+}
+
+// runSchedule makes the next run of one of the user's schedules straight away.
+func runSchedule(t *testing.T, ts *testServer, token string, id int64) {
+	t.Helper()
+	user, err := ts.models.Users.GetForToken(context.Background(), data.ScopeAuthentication, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := ts.models.Schedules.Get(context.Background(), id, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// With ctx already cancelled the scheduler stops after a single batch.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	schedule.New(dueNow{ts.models.Schedules, s}, ts.app.runScheduledPayment, schedule.Config{
+		BatchSize:    1,
+		PollInterval: time.Hour,
+		Lease:        time.Minute,
+		RunTimeout:   time.Minute,
+		RetryDelay:   ts.app.config.Schedules.RetryDelay,
+		MaxRetries:   ts.app.config.Schedules.MaxRetries,
+	}, ts.app.logger).Run(ctx)
+}
+
+// createSchedule sets up a schedule and returns it.
+func createSchedule(t *testing.T, ts *testServer, token string, request data.ScheduleRequest) *data.Schedule {
+	t.Helper()
+	resp := ts.Do(t, http.MethodPost, "/v1/schedules", token, request)
+	if resp.Status != http.StatusCreated {
+		t.Fatalf("create schedule: got %d %s", resp.Status, resp.Body)
+	}
+	var s data.Schedule
+	resp.Decode(t, &s)
+	return &s
+}
+
+func TestSchedules(t *testing.T) {
+	ts := newTestServer(t)
+	token := newCustomer(t, ts, "ada@example.com", "0123456789", 1000)
+	other := newCustomer(t, ts, "eve@example.com", "9876543210", 0)
+	setPIN(t, ts, token, "1234")
+	beneficiary := saveBeneficiary(t, ts, token, data.BeneficiaryRequest{Type: data.BeneficiaryInternal, AccountNumber: "9876543210"})
+
+	start := time.Now().Add(time.Hour)
+	monthly := createSchedule(t, ts, token, data.ScheduleRequest{BeneficiaryID: beneficiary.ID, Amount: 300, Narration: "rent", Frequency: data.FrequencyMonthly, StartAt: start, PIN: "1234"})
+	if want := start.UTC().Truncate(time.Second); !monthly.StartAt.Equal(want) || monthly.NextRunAt == nil || !monthly.NextRunAt.Equal(want) ||
+		monthly.Type != data.BeneficiaryInternal || monthly.Status != data.ScheduleActive {
+		t.Errorf("create: got %+v", monthly)
+	}
+
+	past := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name    string
+		request data.ScheduleRequest
+		status  int
+		code    string
+	}{
+		{"in the past", data.ScheduleRequest{BeneficiaryID: beneficiary.ID, Amount: 1, Frequency: data.FrequencyOnce, StartAt: past, PIN: "1234"}, http.StatusUnprocessableEntity, ValidationError.Code},
+		{"unknown frequency", data.ScheduleRequest{BeneficiaryID: beneficiary.ID, Amount: 1, Frequency: "hourly", StartAt: start, PIN: "1234"}, http.StatusUnprocessableEntity, ValidationError.Code},
+		{"cron without rule", data.ScheduleRequest{BeneficiaryID: beneficiary.ID, Amount: 1, Frequency: data.FrequencyCron, StartAt: start, PIN: "1234"}, http.StatusUnprocessableEntity, ValidationError.Code},
+		{"invalid cron", data.ScheduleRequest{BeneficiaryID: beneficiary.ID, Amount: 1, Frequency: data.FrequencyCron, Cron: "61 * * * *", StartAt: start, PIN: "1234"}, http.StatusUnprocessableEntity, ValidationError.Code},
+		{"end before start", data.ScheduleRequest{BeneficiaryID: beneficiary.ID, Amount: 1, Frequency: data.FrequencyDaily, StartAt: start, EndAt: &past, PIN: "1234"}, http.StatusUnprocessableEntity, ValidationError.Code},
+		{"unknown beneficiary", data.ScheduleRequest{BeneficiaryID: 99, Amount: 1, Frequency: data.FrequencyOnce, StartAt: start, PIN: "1234"}, http.StatusUnprocessableEntity, ValidationError.Code},
+		{"wrong PIN", data.ScheduleRequest{BeneficiaryID: beneficiary.ID, Amount: 1, Frequency: data.FrequencyOnce, StartAt: start, PIN: "4321"}, http.StatusForbidden, InvalidTransferPIN.Code},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := ts.Do(t, http.MethodPost, "/v1/schedules", token, tt.request)
+			if resp.Status != tt.status || resp.StatusCode != tt.code {
+				t.Fatalf("got %d %s", resp.Status, resp.Body)
+			}
+		})
+	}
+
+	path := fmt.Sprintf("/v1/schedules/%d", monthly.ID)
+	if resp := ts.Do(t, http.MethodGet, path, other, nil); resp.Status != http.StatusNotFound {
+		t.Errorf("show as another user: got %d %s", resp.Status, resp.Body)
+	}
+	if resp := ts.Do(t, http.MethodDelete, path, token, nil); resp.Status != http.StatusOK {
+		t.Fatalf("cancel: got %d %s", resp.Status, resp.Body)
+	}
+	var cancelled data.Schedule
+	ts.Do(t, http.MethodGet, path, token, nil).Decode(t, &cancelled)
+	if cancelled.Status != data.ScheduleCancelled || cancelled.NextRunAt != nil {
+		t.Errorf("cancelled: got %+v", cancelled)
+	}
+	if resp := ts.Do(t, http.MethodDelete, path, token, nil); resp.Status != http.StatusUnprocessableEntity {
+		t.Errorf("cancel twice: got %d %s", resp.Status, resp.Body)
+	}
+
+	var list []data.Schedule
+	ts.Do(t, http.MethodGet, "/v1/schedules", token, nil).Decode(t, &list)
+	if len(list) != 1 || list[0].ID != monthly.ID {
+		t.Errorf("list: got %+v", list)
+	}
+}
+
+func TestScheduleRuns(t *testing.T) {
+	ts := newTestServer(t)
+	ts.app.config.Schedules.RetryDelay = time.Hour
+	ts.app.config.Schedules.MaxRetries = 1
+	token := newCustomer(t, ts, "ada@example.com", "0123456789", 1000)
+	recipient := newCustomer(t, ts, "eve@example.com", "9876543210", 0)
+	setPIN(t, ts, token, "1234")
+	internal := saveBeneficiary(t, ts, token, data.BeneficiaryRequest{Type: data.BeneficiaryInternal, AccountNumber: "9876543210"})
+	interbank := saveBeneficiary(t, ts, token, data.BeneficiaryRequest{Type: data.BeneficiaryInterbank, AccountNumber: "1234567890", BankCode: mock.Banks[0].Code})
+
+	runs := func(id int64) []data.ScheduleRun {
+		var runs []data.ScheduleRun
+		ts.Do(t, http.MethodGet, fmt.Sprintf("/v1/schedules/%d/runs", id), token, nil).Decode(t, &runs)
+		return runs
+	}
+	show := func(id int64) *data.Schedule {
+		var s data.Schedule
+		ts.Do(t, http.MethodGet, fmt.Sprintf("/v1/schedules/%d", id), token, nil).Decode(t, &s)
+		return &s
+	}
+
+	// A standing order is paid and moves on to the same day next month.
+	start := time.Now().Add(time.Hour)
+	monthly := createSchedule(t, ts, token, data.ScheduleRequest{BeneficiaryID: internal.ID, Amount: 300, Narration: "rent", Frequency: data.FrequencyMonthly, StartAt: start, PIN: "1234"})
+	runSchedule(t, ts, token, monthly.ID)
+	if got := balance(t, ts, recipient); got != "300.00" {
+		t.Errorf("recipient: got balance %s, want 300.00", got)
+	}
+	if s := show(monthly.ID); s.Occurrence != 1 || s.Status != data.ScheduleActive || s.NextRunAt == nil || !s.NextRunAt.Equal(monthly.StartAt.AddDate(0, 1, 0)) {
+		t.Errorf("monthly: got %+v", s)
+	}
+	if got := runs(monthly.ID); len(got) != 1 || got[0].Status != data.RunPaid || got[0].TransferReference == "" || got[0].Reference != fmt.Sprintf("SCH%d-1-1", monthly.ID) {
+		t.Errorf("monthly runs: got %+v", got)
+	}
+
+	// A run repeated after its lease ran out returns what the first one did rather
+	// than paying again, failure included.
+	ctx := context.Background()
+	user, err := ts.models.Users.GetForToken(ctx, data.ScopeAuthentication, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repeated, err := ts.models.Schedules.Get(ctx, monthly.ID, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := runs(monthly.ID)[0]
+	if reference, err := ts.app.runScheduledPayment(ctx, repeated, first.Reference); err != nil || reference != first.TransferReference {
+		t.Errorf("repeated run: got %q, %v; want %q", reference, err, first.TransferReference)
+	}
+	if got := balance(t, ts, recipient); got != "300.00" {
+		t.Errorf("recipient: got balance %s, want 300.00", got)
+	}
+	ts.provider.Script(mock.Fault{Status: http.StatusBadRequest})
+	if _, err := ts.app.runScheduledPayment(ctx, repeated, "declined"); err == nil {
+		t.Fatal("declined run: got no error")
+	}
+	if _, err := ts.app.runScheduledPayment(ctx, repeated, "declined"); err == nil || err.Error() != "an earlier run of declined failed" {
+		t.Errorf("repeated declined run: got %v", err)
+	}
+
+	// A one-off interbank payment is completed once it has run.
+	once := createSchedule(t, ts, token, data.ScheduleRequest{BeneficiaryID: interbank.ID, Amount: 200, Frequency: data.FrequencyOnce, StartAt: start, PIN: "1234"})
+	runSchedule(t, ts, token, once.ID)
+	if s := show(once.ID); s.Status != data.ScheduleCompleted || s.NextRunAt != nil {
+		t.Errorf("once: got %+v", s)
+	}
+	if got := runs(once.ID); len(got) != 1 || got[0].Status != data.RunPaid {
+		t.Errorf("once runs: got %+v", got)
+	}
+
+	// A payment the balance can't cover is retried, then given up.
+	large := createSchedule(t, ts, token, data.ScheduleRequest{BeneficiaryID: internal.ID, Amount: 5000, Frequency: data.FrequencyWeekly, StartAt: start, PIN: "1234"})
+	runSchedule(t, ts, token, large.ID)
+	if s := show(large.ID); s.Retries != 1 || s.Occurrence != 0 || s.NextRunAt.Sub(time.Now()) < 59*time.Minute {
+		t.Errorf("retrying: got %+v", s)
+	}
+	runSchedule(t, ts, token, large.ID)
+	if s := show(large.ID); s.Retries != 0 || s.Occurrence != 1 || !s.NextRunAt.Equal(large.StartAt.AddDate(0, 0, 7)) {
+		t.Errorf("given up: got %+v", s)
+	}
+	got := runs(large.ID)
+	if len(got) != 2 || got[0].Status != data.RunFailed || got[0].Attempt != 2 || got[1].Status != data.RunRetrying || got[1].Error == "" {
+		t.Errorf("large runs: got %+v", got)
+	}
+	if got := balance(t, ts, recipient); got != "300.00" {
+		t.Errorf("recipient: got balance %s, want 300.00", got)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// serve starts the HTTP server, the outbox worker, the scheduler and the callback
+// nonce pruner, and blocks until the server has been shut down gracefully in
+// response to SIGINT or SIGTERM.
+func (app *application) serve() error {
+	// Declare a HTTP server with some sensible timeout settings, which listens on the
+	// port provided in the config struct and uses the servemux we created as the handler.
+	srv := &http.Server{
+		Addr:         fmt.Sprintf(":%d", app.config.Port),
+		Handler:      app.routes(),
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+	}
+
+	// Start the outbox worker, the scheduler and the nonce pruner. They are tracked by the same
+	// WaitGroup as the other background tasks, and stop picking up new work once ctx
+	// is cancelled.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app.wg.Add(3)
+	go func() {
+		defer app.wg.Done()
+		app.newOutboxWorker().Run(ctx)
+	}()
+	go func() {
+		defer app.wg.Done()
+		app.newScheduler().Run(ctx)
+	}()
+	go func() {
+		defer app.wg.Done()
+		app.pruneCallbackNonces(ctx)
+	}()
+
+	// The admin server runs alongside the API server and is shut down with it.
+	var adminSrv *http.Server
+	if app.config.Admin.Addr != "" {
+		adminSrv = &http.Server{
+			Addr:         app.config.Admin.Addr,
+			Handler:      app.adminRoutes(),
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 10 * time.Second,
+			ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+		}
+		go func() {
+			app.logger.Info("starting admin server", "addr", adminSrv.Addr)
+			err := adminSrv.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.Error("admin server stopped", "error", err)
+			}
+		}()
+	}
+
+	// The shutdownError channel receives any error returned by the graceful
+	// Shutdown() in the goroutine below.
+	shutdownError := make(chan error)
+
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		s := <-quit
+
+		app.logger.Info("shutting down server", "signal", s.String())
+		app.draining.Store(true)
+		time.Sleep(app.config.Shutdown.DrainDelay)
+
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), app.config.Shutdown.Timeout)
+		defer shutdownCancel()
+
+		// Stop accepting new requests and wait for in-flight ones to complete.
+		if adminSrv != nil {
+			err := adminSrv.Shutdown(shutdownCtx)
+			if err != nil {
+				app.logger.Error("admin server shutdown", "error", err)
+			}
+		}
+		err := srv.Shutdown(shutdownCtx)
+		if err != nil {
+			shutdownError <- err
+			return
+		}
+
+		// Stop the outbox worker and the scheduler and wait for them, and any
+		// background tasks, to finish what they are doing, but don't wait past the
+		// deadline.
+		app.logger.Info("completing background tasks", "addr", srv.Addr)
+		cancel()
+
+		done := make(chan struct{})
+		go func() {
+			app.wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			shutdownError <- nil
+		case <-shutdownCtx.Done():
+			shutdownError <- fmt.Errorf("background tasks did not finish: %w", shutdownCtx.Err())
+		}
+	}()
+
+	app.logger.Info("starting server", "addr", srv.Addr, "env", app.config.Env)
+
+	// Shutdown() makes ListenAndServe() return http.ErrServerClosed straight away, so
+	// that error means a graceful shutdown has started.
+	err := srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	err = <-shutdownError
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("stopped server", "addr", srv.Addr)
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/ebitezion/backend-framework/internal/data"
+	"github.com/ebitezion/backend-framework/internal/notify"
+	"github.com/ebitezion/backend-framework/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// claimTransferTagHandler gives the user a transfer tag for their account, or changes
+// the one they have. A tag can only be changed once every tags.change_cooldown.
+func (app *application) claimTransferTagHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input data.TransferTagRequest
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	tag := &data.TransferTag{UserID: user.ID, Tag: data.NormalizeTransferTag(input.Tag), AccountName: user.Name}
+	v := validator.New()
+	if data.ValidateTransferTag(v, tag.Tag); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	tag.AccountNumber, err = app.models.AccountModel.GetUserAccountNoByID(r.Context(), strconv.FormatInt(user.ID, 10))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.RecordNotFound(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.TransferTags.Claim(r.Context(), tag, app.config.Tags.ChangeCooldown)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateTransferTag):
+			v.AddError("tag", "is already taken")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrTransferTagCooldown):
+			app.transferTagCooldownResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := app.SuccessFormater(tag, "Success")
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showTransferTagHandler resolves a transfer tag to the account it pays into, so that
+// the sender can check who they are paying. The account name and number are masked.
+func (app *application) showTransferTagHandler(w http.ResponseWriter, r *http.Request) {
+	name := data.NormalizeTransferTag(httprouter.ParamsFromContext(r.Context()).ByName("tag"))
+	if !validator.Matches(name, data.TagRX) {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	tag, err := app.models.TransferTags.GetByTag(r.Context(), name)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	tag.AccountName = notify.MaskName(tag.AccountName)
+	tag.AccountNumber = notify.MaskAccountNumber(tag.AccountNumber)
+	env := app.SuccessFormater(tag, "Success")
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
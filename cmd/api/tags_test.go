@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ebitezion/backend-framework/internal/data"
+)
+
+func TestTransferTags(t *testing.T) {
+	ts := newTestServer(t)
+	ts.app.config.Tags.ChangeCooldown = time.Hour
+	token := newCustomer(t, ts, "ada@example.com", "0123456789", 1000)
+	other := newCustomer(t, ts, "eve@example.com", "9876543210", 0)
+
+	claim := func(token, tag string) (int, string) {
+		resp := ts.Do(t, http.MethodPut, "/v1/tags", token, data.TransferTagRequest{Tag: tag})
+		return resp.Status, resp.StatusCode
+	}
+
+	if status, _ := claim(token, "@Ada_99"); status != http.StatusOK {
+		t.Fatalf("claim: got %d", status)
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		tag    string
+		status int
+		code   string
+	}{
+		{"same tag again", token, "ada_99", http.StatusOK, Success.Code},
+		{"too short", other, "ev", http.StatusUnprocessableEntity, ValidationError.Code},
+		{"bad characters", other, "eve-smith", http.StatusUnprocessableEntity, ValidationError.Code},
+		{"reserved", other, "support", http.StatusUnprocessableEntity, ValidationError.Code},
+		{"offensive", other, "shitposter", http.StatusUnprocessableEntity, ValidationError.Code},
+		{"taken", other, "ADA_99", http.StatusUnprocessableEntity, ValidationError.Code},
+		{"changed too recently", token, "ada_100", http.StatusForbidden, TransferTagCooldown.Code},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, code := claim(tt.token, tt.tag); status != tt.status || code != tt.code {
+				t.Fatalf("got %d %s", status, code)
+			}
+		})
+	}
+
+	// Once the cooldown has passed the tag can be changed, and the old one is free.
+	ts.app.config.Tags.ChangeCooldown = 0
+	if status, _ := claim(token, "ada_100"); status != http.StatusOK {
+		t.Fatalf("change: got %d", status)
+	}
+	if status, _ := claim(other, "ada_99"); status != http.StatusOK {
+		t.Fatalf("claim released tag: got %d", status)
+	}
+
+	resp := ts.Do(t, http.MethodGet, "/v1/tags/@ADA_100", other, nil)
+	if resp.Status != http.StatusOK {
+		t.Fatalf("resolve: got %d %s", resp.Status, resp.Body)
+	}
+	var tag data.TransferTag
+	resp.Decode(t, &tag)
+	if tag.Tag != "ada_100" || tag.AccountName != "Test U***" || tag.AccountNumber != "012****789" {
+		t.Errorf("resolve: got %+v", tag)
+	}
+	if resp := ts.Do(t, http.MethodGet, "/v1/tags/nobody", other, nil); resp.Status != http.StatusNotFound {
+		t.Errorf("unknown tag: got %d %s", resp.Status, resp.Body)
+	}
+}
+
+func TestInternalTransferToTag(t *testing.T) {
+	ts := newTestServer(t)
+	token := newCustomer(t, ts, "ada@example.com", "0123456789", 1000)
+	recipient := newCustomer(t, ts, "eve@example.com", "9876543210", 0)
+	setPIN(t, ts, token, "1234")
+	for tok, tag := range map[string]string{token: "ada", recipient: "eve"} {
+		if resp := ts.Do(t, http.MethodPut, "/v1/tags", tok, data.TransferTagRequest{Tag: tag}); resp.Status != http.StatusOK {
+			t.Fatalf("claim %s: got %d %s", tag, resp.Status, resp.Body)
+		}
+	}
+
+	resp := ts.Do(t, http.MethodPost, "/v1/transfers/internal", token, data.InternalTransferRequest{SendersAccountNo: "0123456789", ToTag: "@Eve", Amount: 250, PIN: "1234", Reference: "ref-1"})
+	if resp.Status != http.StatusCreated {
+		t.Fatalf("got %d %s", resp.Status, resp.Body)
+	}
+	var transfer data.InternalTransfer
+	resp.Decode(t, &transfer)
+	if transfer.RecipientAccountNumber != "9876543210" {
+		t.Errorf("got %+v", transfer)
+	}
+	if got := balance(t, ts, recipient); got != "250.00" {
+		t.Errorf("recipient: got balance %s, want 250.00", got)
+	}
+
+	tests := []struct {
+		name    string
+		request data.InternalTransferRequest
+		status  int
+		code    string
+	}{
+		{"unknown tag", data.InternalTransferRequest{SendersAccountNo: "0123456789", ToTag: "nobody", Amount: 1, PIN: "1234", Reference: "ref-2"}, http.StatusNotFound, RecordNotFound.Code},
+		{"own tag", data.InternalTransferRequest{SendersAccountNo: "0123456789", ToTag: "ada", Amount: 1, PIN: "1234", Reference: "ref-3"}, http.StatusUnprocessableEntity, ValidationError.Code},
+		{"tag and account", data.InternalTransferRequest{SendersAccountNo: "0123456789", ReceiverAccountNo: "9876543210", ToTag: "eve", Amount: 1, PIN: "1234", Reference: "ref-4"}, http.StatusUnprocessableEntity, ValidationError.Code},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := ts.Do(t, http.MethodPost, "/v1/transfers/internal", token, tt.request)
+			if resp.Status != tt.status || resp.StatusCode != tt.code {
+				t.Fatalf("got %d %s", resp.Status, resp.Body)
+			}
+		})
+	}
+}
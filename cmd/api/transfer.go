@@ -1,22 +1,31 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/ebitezion/backend-framework/internal/data"
-	"github.com/ebitezion/backend-framework/internal/mock"
+	"github.com/ebitezion/backend-framework/internal/metrics"
+	thirdparty "github.com/ebitezion/backend-framework/internal/third_party"
+	"github.com/ebitezion/backend-framework/internal/validator"
 	"github.com/shopspring/decimal"
 )
 
-func (app application) PaymentInitiation(w http.ResponseWriter, r *http.Request) {
-	//get senders details
-
+// PaymentInitiation creates a debit on the caller's account through the third-party
+// provider. Credits are refused: they come in through the provider's callbacks. The
+// payment is recorded as pending first, with the debit already taken from the
+// balance, under a reference derived from the client's, so that a retried request is
+// turned away as a duplicate. It is then sent to the provider and settled by the
+// answer: completed, or failed, which refunds the debit. If the answer doesn't
+// settle it (the call timed out, say) the payment is accepted as pending and left to
+// the status requery queued with it. The account holder is alerted in the
+// background once it completes.
+func (app *application) PaymentInitiation(w http.ResponseWriter, r *http.Request) {
 	// Retrieve token and validate
 	token := app.GetBearerToken(w, r)
 	if token == "" {
@@ -24,23 +33,22 @@ func (app application) PaymentInitiation(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Read user input
-	var Transaction data.Payment
-	err := app.readJSON(w, r, &Transaction)
+	var payment data.Payment
+	err := app.readJSON(w, r, &payment)
 	if err != nil {
 		err = errors.New(err.Error() + "from: Transaction ")
 		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	// TODO: Validate user input
-	//v := validator.New()
-	// if data.ValidatePayment(v, &InternalTransaction); !v.Valid() {
-	// 	app.failedValidationResponse(w, r, v.Errors)
-	// 	return
-	// }
+	v := validator.New()
+	if data.ValidatePayment(v, &payment); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 
 	// Retrieve user details
-	userDetail, err := app.models.Users.GetUserDetailsFromToken(data.ScopeAuthentication, token)
+	userDetail, err := app.models.Users.GetUserDetailsFromToken(r.Context(), data.ScopeAuthentication, token)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -51,180 +59,401 @@ func (app application) PaymentInitiation(w http.ResponseWriter, r *http.Request)
 			return
 		}
 	}
-	fmt.Println("User detail", userDetail)
-	//simulate third party payment
-	// response, err := paythirdparty(Tra) 
-	// if err != nil {
-
-	// 	app.serverErrorResponse(w, r, err)
-	// 	return
-	// }
 
-	// //if response successful, debit or credit user based on request type
-	// if response["status"] != "success" {
-	// 	app.serverErrorResponse(w, r, err)
-	// }
+	// Consumers can only move money on their own account.
+	if payment.AccountID != userDetail.AccountNumber {
+		app.SendersAccountNoUnauthorizedResponse(w, r, " Senders AccountNo Unauthorized Response ")
+		return
+	}
 
-	// if Transaction.Type == "credit" {
-	// 	//perform credit that is increase user account balance
-	// }
+	err = app.checkLimits(userDetail, data.ChannelTransfers, payment.Amount)
+	if err != nil {
+		app.paymentErrorResponse(w, r, err)
+		return
+	}
 
-	// if Transaction.Type == "debit" {
-	// 	//perform debit that is decrease user account balance
-	// }
+	// Fail fast on insufficient funds. InitiatePayment re-checks under a row lock.
+	amount := decimal.NewFromInt(int64(payment.Amount))
+	balance, err := decimal.NewFromString(userDetail.Balance)
+	if err == nil && balance.LessThan(amount) {
+		app.insufficientFundsResponse(w, r)
+		return
+	}
 
-	//save transaction
+	userID, _ := strconv.ParseUint(userDetail.UserID, 10, 64)
+	transaction := &data.Transaction{
+		UserID:            userID,
+		Type:              string(payment.Type),
+		Source:            "api",
+		Narration:         payment.Narration,
+		AccountNumber:     payment.AccountID,
+		RequestID:         payment.Reference,
+		InternalReference: data.PaymentReference(payment.AccountID, payment.Reference),
+		Amount:            float64(payment.Amount),
+	}
 
-	//send response to user
-}
+	// Record the payment before it goes out, so that a retried request is turned away
+	// and the money is accounted for whatever happens upstream.
+	err = app.models.Transactions.InitiatePayment(r.Context(), transaction, app.config.Provider.RequeryDelay)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInsufficientFunds):
+			app.insufficientFundsResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateTransaction):
+			app.duplicateTransactionResponse(w, r)
+		case errors.Is(err, data.ErrSingleLimitExceeded), errors.Is(err, data.ErrDailyLimitExceeded):
+			app.paymentErrorResponse(w, r, limitError(data.ChannelTransfers, err))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
 
-func paythirdparty(amt decimal.Decimal, accountId, ref string) {
-	// Start the mock server
-	baseURL := mock.StartMockServer()
+	// From here on the payment is seen through even if the client goes away.
+	ctx := context.WithoutCancel(r.Context())
+	providerPayment, err := thirdparty.CreatePayment(ctx, app.config.Provider.URL, &thirdparty.Payment{
+		AccountID: transaction.AccountNumber,
+		Reference: transaction.InternalReference,
+		Amount:    transaction.Amount,
+	})
+
+	var apiErr *thirdparty.APIError
+	switch {
+	case err == nil:
+		settled, settleErr := app.settlePayment(ctx, transaction.InternalReference, data.Completed, providerPayment.Reference)
+		if settleErr != nil {
+			// The requery will settle it.
+			app.logError(r, settleErr)
+		} else {
+			transaction = settled
+		}
+	case errors.As(err, &apiErr) && apiErr.Indeterminate():
+		app.logError(r, err)
+	default:
+		_, settleErr := app.settlePayment(ctx, transaction.InternalReference, data.Failed, "")
+		if settleErr != nil {
+			app.logError(r, err)
+			app.serverErrorResponse(w, r, settleErr)
+			return
+		}
+		app.logError(r, err)
+		if !app.upstreamErrorResponse(w, r, err) {
+			app.FailedTransferResponse(w, r, err.Error())
+		}
+		return
+	}
 
-	// Define the request body
-	requestBody := map[string]interface{}{
-		"account_id": accountId,
-		"reference":  ref,
-		"amount":     amt,
+	status := http.StatusCreated
+	if transaction.Status == data.Pending {
+		status = http.StatusAccepted
 	}
+	env := app.SuccessFormater(transaction, "Success")
+	err = app.writeJSON(w, status, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
 
-	// Convert request data to JSON
-	reqBody, err := json.Marshal(requestBody)
+// settlePayment settles a pending payment through the provider and counts the
+// outcome.
+func (app *application) settlePayment(ctx context.Context, reference, status, externalReference string) (*data.Transaction, error) {
+	transaction, changed, err := app.models.Transactions.SettlePayment(ctx, reference, status, externalReference)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	if changed {
+		metrics.Transactions.WithLabelValues(transaction.Type, transaction.Status).Inc()
 	}
+	return transaction, nil
+}
 
-	// Perform the POST request
-	resp, err := http.Post(baseURL+"/third-party/payments", "application/json", bytes.NewBuffer(reqBody))
+// requeryPayment is the outbox handler for the status requery queued with every
+// payment through the provider. A payment that has been settled in the meantime is
+// left alone. Otherwise the provider is asked for it: a payment it has is completed,
+// and one it doesn't know about is failed, which refunds a debit. Any other answer is
+// returned as an error, so that the outbox asks again later.
+func (app *application) requeryPayment(ctx context.Context, msg *data.OutboxMessage) error {
+	var ref data.TransactionReference
+	err := json.Unmarshal(msg.Payload, &ref)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	defer resp.Body.Close()
 
-	// Read and print the response body
-	responseBody, err := ioutil.ReadAll(resp.Body)
+	transaction, err := app.models.Transactions.GetTransactionByReference(ctx, ref.Reference)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	if transaction.Status != data.Pending {
+		return nil
+	}
+
+	payment, err := thirdparty.GetPayment(ctx, app.config.Provider.URL, transaction.InternalReference)
+	var apiErr *thirdparty.APIError
+	switch {
+	case err == nil:
+		_, err = app.settlePayment(ctx, transaction.InternalReference, data.Completed, payment.Reference)
+	case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound:
+		_, err = app.settlePayment(ctx, transaction.InternalReference, data.Failed, "")
 	}
+	return err
+}
 
-	fmt.Println("Response Body:", string(responseBody))
+// channelLimits are the error codes for a payment that breaks each channel's limits,
+// and the name its limits are counted under in metrics.
+var channelLimits = map[string]struct {
+	label         string
+	single, daily ErrorCode
+}{
+	data.ChannelTransfers: {"transfer", TransferSingleLimitExceeded, TransferDailyLimitExceeded},
+	data.ChannelBills:     {"bill", BillSingleLimitExceeded, BillDailyLimitExceeded},
+	data.ChannelUSSD:      {"ussd", USSDSingleLimitExceeded, USSDDailyLimitExceeded},
 }
 
-/*
+// checkLimits checks a debit of amount on channel against the payer's single and
+// daily limits for that channel as they stood when userDetail was read, and returns a
+// paymentError if it breaks either. It only turns away a payment that is bound to
+// fail: the debit itself is counted against the limits, and checked again, under the
+// payer's row lock when it is recorded.
+func (app *application) checkLimits(userDetail *data.UserDetailsForLimits, channel string, amount int) error {
+	_, err := userDetail.Limits.Check(userDetail.Counter, channel, amount)
+	return limitError(channel, err)
+}
 
-// Retrieve token and validate
+// limitError returns the paymentError for a debit on channel that the data layer
+// turned away with ErrSingleLimitExceeded, ErrDailyLimitExceeded or
+// ErrCoolingOffLimitExceeded, and counts the rejection. Any other error is returned
+// as it is.
+func limitError(channel string, err error) error {
+	limits := channelLimits[channel]
+	switch {
+	case errors.Is(err, data.ErrCoolingOffLimitExceeded):
+		metrics.LimitRejections.WithLabelValues("beneficiary_cooling_off").Inc()
+		return errBeneficiaryCoolingOff
+	case errors.Is(err, data.ErrSingleLimitExceeded):
+		metrics.LimitRejections.WithLabelValues(limits.label + "_single").Inc()
+		return &paymentError{http.StatusForbidden, limits.single.Code, limits.single, limits.single.Value}
+	case errors.Is(err, data.ErrDailyLimitExceeded):
+		metrics.LimitRejections.WithLabelValues(limits.label + "_daily").Inc()
+		return &paymentError{http.StatusForbidden, limits.daily.Code, limits.daily, limits.daily.Value}
+	}
+	return err
+}
+
+// internalTransferHandler moves money from the caller's account to another of our
+// own accounts, given by account number, transfer tag or saved beneficiary. The
+// sender's account must be the caller's, and the transfer is authorised with their
+// transaction PIN before it goes through internalTransfer.
+func (app *application) internalTransferHandler(w http.ResponseWriter, r *http.Request) {
 	token := app.GetBearerToken(w, r)
 	if token == "" {
 		return
 	}
 
-	// Read user input
-	var InternalTransaction data.InternalTransaction
-	err := app.readJSON(w, r, &InternalTransaction)
+	var input data.InternalTransferRequest
+	err := app.readJSON(w, r, &input)
 	if err != nil {
-		err = errors.New(err.Error() + "from: InternalTransaction ")
 		app.badRequestResponse(w, r, err)
 		return
 	}
 
 	v := validator.New()
-
-	// Validate user input
-	if data.ValidateInternalTransfer(v, &InternalTransaction); !v.Valid() {
+	if data.ValidateInternalTransferRequest(v, &input); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	// Retrieve user details
-	userDetail, err := app.models.Users.GetUserDetailsAndPINFromToken(data.ScopeAuthentication, token)
+	userDetail, err := app.models.Users.GetUserDetailsFromToken(r.Context(), data.ScopeAuthentication, token)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.RecordNotFound(w, r, err)
-			return
 		default:
 			app.serverErrorResponse(w, r, err)
-			return
 		}
+		return
 	}
 
-	// Verify PIN
-	if !VerifyPIN(userDetail.PIN, InternalTransaction.PIN) {
-		app.errorResponse(w, r, http.StatusForbidden, InvalidTransferPIN.Code, InvalidTransferPIN, InvalidTransferPIN.Value)
+	if input.SendersAccountNo != userDetail.AccountNumber {
+		app.SendersAccountNoUnauthorizedResponse(w, r, " Senders AccountNo Unauthorized Response ")
 		return
 	}
-
-	// Unmarshal user details JSON
-	var userDetails UserDetails
-	err = json.Unmarshal([]byte(userDetail.Limits), &userDetails)
-	if err != nil {
-		fmt.Println("Error unmarshalling user details JSON:", err)
+	if userDetail.PIN == "" {
+		app.TransactionPINNotSet(w, r, data.ErrTransactionPINNotSet)
+		return
+	}
+	if !VerifyPIN(userDetail.PIN, input.PIN) {
+		app.invalidTransferPINResponse(w, r)
 		return
 	}
 
-	// Unmarshal counter JSON
-	var counter Counter
-	err = json.Unmarshal([]byte(userDetail.Counter), &counter)
+	transfer, err := app.internalTransfer(r.Context(), userDetail, data.ChannelTransfers, &input)
 	if err != nil {
-		fmt.Println("Error unmarshalling counter JSON:", err)
+		app.paymentErrorResponse(w, r, err)
 		return
 	}
 
-	// Get single and daily limits for transfers
-	singleLimit := userDetails.Transfers.Single
-	dailyLimit := userDetails.Transfers.Daily
-
-	// Convert InternalTransaction.Amount to decimal
-	amount, err := decimal.NewFromString(InternalTransaction.Amount)
+	status := http.StatusCreated
+	if transfer.Status == data.Pending {
+		status = http.StatusAccepted
+	}
+	env := app.SuccessFormater(transfer, "Success")
+	err = app.writeJSON(w, status, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
-		return
 	}
+}
 
-	// Calculate new counter value
-	count := counter.Transfers.Add(amount)
+// internalTransfer is the payment pipeline for a transfer from the account in
+// userDetail to another of our own accounts, shared by the transfer endpoint,
+// scheduled payments and USSD. The caller must already have authorised the transfer.
+// The recipient is resolved and the transfer checked against the sender's limits for
+// the channel it was made on. Then, like PaymentInitiation, it is recorded as pending
+// with the sender debited before it goes to core banking, and settled by the
+// answer: completed, crediting the recipient and alerting both account holders, or
+// failed and refunded. A transfer whose answer is lost is returned still pending. A
+// reference the sender has used before is refused with ErrDuplicateTransaction.
+func (app *application) internalTransfer(ctx context.Context, userDetail *data.UserDetailsForLimits, channel string, input *data.InternalTransferRequest) (*data.InternalTransfer, error) {
+	userID, _ := strconv.ParseInt(userDetail.UserID, 10, 64)
+
+	var beneficiary *data.Beneficiary
+	if input.BeneficiaryID != 0 {
+		var err error
+		beneficiary, err = app.payableBeneficiary(ctx, userID, input.BeneficiaryID, input.Amount, data.BeneficiaryInternal, data.BeneficiaryTag)
+		if err != nil {
+			return nil, err
+		}
+		input.ToTag = beneficiary.Tag
+		input.ReceiverAccountNo = beneficiary.AccountNumber
+	}
 
-	// Check transfer limits
-	if amount.GreaterThan(singleLimit) {
-		app.errorResponse(w, r, http.StatusForbidden, TransferSingleLimitExceeded.Code, TransferSingleLimitExceeded, "Transfer amount exceeds single limit")
-		return
+	if input.ToTag != "" {
+		tag, err := app.models.TransferTags.GetByTag(ctx, data.NormalizeTransferTag(input.ToTag))
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return nil, errBeneficiaryNotFound
+			}
+			return nil, err
+		}
+		if tag.AccountNumber == userDetail.AccountNumber {
+			return nil, paymentValidationError("to_tag", "must not be your own tag")
+		}
+		if beneficiary != nil && tag.AccountNumber != beneficiary.TagAccountNumber {
+			return nil, errBeneficiaryTagMoved
+		}
+		input.ReceiverAccountNo = tag.AccountNumber
 	}
 
-	if count.GreaterThan(dailyLimit) {
-		app.errorResponse(w, r, http.StatusForbidden, TransferDailyLimitExceeded.Code, TransferDailyLimitExceeded, "Transfer amount exceeds daily limit")
-		return
+	recipient, err := app.models.AccountModel.GetAccountHolder(ctx, input.ReceiverAccountNo)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, errBeneficiaryNotFound
+		}
+		return nil, err
 	}
 
-	// Prepare for transfer
-	// InternalTransaction.SendersAccountNo = userDetail.AccountNumber
-	// validate sendersaccount no
-	var acct data.AccountNumber
-	acct.AccountNumber = userDetail.AccountNumber
-	isValidSenderAccountNo, err := ValidateSenderAccountno(&acct, InternalTransaction.SendersAccountNo, userDetail.AccountNumber)
+	err = app.checkLimits(userDetail, channel, input.Amount)
 	if err != nil {
-		app.badRequestResponse(w, r, errors.New("Cannot verify Account at the moment, try again later"))
-		return
+		return nil, err
 	}
-	if !isValidSenderAccountNo {
-		//account Invalid Error
-		app.SendersAccountNoUnauthorizedResponse(w, r, " Senders AccountNo Unauthorized Response ")
-		return
+
+	amount := decimal.NewFromInt(int64(input.Amount))
+	// Fail fast on insufficient funds rather than sending the transfer upstream. Post
+	// re-checks under a row lock.
+	balance, err := decimal.NewFromString(userDetail.Balance)
+	if err == nil && balance.LessThan(amount) {
+		return nil, data.ErrInsufficientFunds
 	}
-	val := orbit.NewTransfer(InternalTransaction.SendersAccountNo, InternalTransaction.ReceiverAccountNo, InternalTransaction.Amount, "0", "0", InternalTransaction.Narration)
 
-	// Initiate transfer
-	resp, err := orbit.SpectrumTransfer(val)
+	reference, err := data.NewInternalReference()
 	if err != nil {
-		// Handle transfer failure
-		app.handleTransferFailure(w, r, userDetail, InternalTransaction, count.String(), err)
-		app.FailedTransferResponse(w, r, err)
-		return
+		return nil, err
+	}
+	transfer := &data.InternalTransfer{
+		Reference:              reference,
+		RequestID:              input.Reference,
+		UserID:                 userID,
+		AccountNumber:          userDetail.AccountNumber,
+		RecipientUserID:        recipient.UserID,
+		RecipientAccountNumber: recipient.AccountNumber,
+		RecipientAccountName:   recipient.Name,
+		Amount:                 float64(input.Amount),
+		Narration:              input.Narration,
+		Channel:                channel,
+	}
+	if beneficiary != nil {
+		transfer.BeneficiaryID = beneficiary.ID
+		transfer.CoolingOffLimit = app.config.Beneficiaries.CoolingOffLimit
 	}
 
-	// Handle transfer success
-	app.handleTransferSuccess(w, r, resp, userDetail, InternalTransaction, count.String())
+	err = app.models.InternalTransfers.Post(ctx, transfer, internalReconcileDelay)
+	if err != nil {
+		return nil, limitError(channel, err)
+	}
 
+	// The sender has been debited, so from here on the transfer is seen through even
+	// if the client goes away. Core banking has no status enquiry, so the transfer is
+	// marked as submitted before it goes: the reconciliation Post queued refunds one
+	// that never got that far, and leaves one that did to be settled here.
+	ctx = context.WithoutCancel(ctx)
+	err = app.models.InternalTransfers.Submit(ctx, reference)
+	if err != nil {
+		return nil, err
+	}
+	sent, err := thirdparty.SpectrumTransfer(ctx, thirdparty.NewTransfer(transfer.AccountNumber, transfer.RecipientAccountNumber, amount.StringFixed(2), "0", "0", transfer.Narration))
+	status, externalReference := data.Completed, ""
+	var apiErr *thirdparty.APIError
+	switch {
+	case err == nil:
+		externalReference = sent.TransactionReference
+	case errors.As(err, &apiErr) && apiErr.Indeterminate():
+		// Core banking may have carried it out, so refunding it could pay twice.
+		app.logger.Error("internal transfer outcome unknown", "reference", reference, "error", err)
+		return transfer, nil
+	default:
+		status = data.Failed
+	}
+	settled, _, settleErr := app.models.InternalTransfers.Settle(ctx, reference, status, externalReference)
+	if settleErr != nil {
+		app.logger.Error("internal transfer left pending", "reference", reference, "status", status, "error", settleErr)
+		return nil, settleErr
+	}
+	settled.RecipientAccountName = transfer.RecipientAccountName
+	transfer = settled
+	if err != nil {
+		metrics.Transactions.WithLabelValues(string(data.Debit), transfer.Status).Inc()
+		return nil, err
+	}
+	metrics.Transactions.WithLabelValues(string(data.Debit), transfer.Status).Inc()
+	metrics.Transactions.WithLabelValues(string(data.Credit), transfer.Status).Inc()
+	app.recordBeneficiaryUse(ctx, beneficiary)
+	return transfer, nil
+}
 
+// internalReconcileDelay is how long an internal transfer is left before its
+// reconciliation runs, long enough for the request to have had core banking's
+// answer.
+const internalReconcileDelay = time.Minute
+
+// reconcileInternalTransfer is the outbox handler for the reconciliation queued with
+// every internal transfer. A transfer that has been settled in the meantime is left
+// alone, and one that never went to core banking, because its request died first,
+// is failed and refunded. Core banking has no status enquiry, so one that went and is
+// still pending is returned as an error: the outbox tries again in case the request
+// is still waiting for the answer, and a transfer that is still pending when the
+// outbox gives up needs a human.
+func (app *application) reconcileInternalTransfer(ctx context.Context, msg *data.OutboxMessage) error {
+	var ref data.TransactionReference
+	err := json.Unmarshal(msg.Payload, &ref)
+	if err != nil {
+		return err
+	}
 
-*/
+	transfer, changed, err := app.models.InternalTransfers.Abandon(ctx, ref.Reference)
+	if err != nil {
+		return fmt.Errorf("internal transfer %s: %w", ref.Reference, err)
+	}
+	if changed {
+		metrics.Transactions.WithLabelValues(string(data.Debit), transfer.Status).Inc()
+	}
+	return nil
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ebitezion/backend-framework/internal/apitest"
+	"github.com/ebitezion/backend-framework/internal/data"
+	"github.com/ebitezion/backend-framework/internal/mock"
+)
+
+// newCustomer seeds an activated user with a funded account and returns their token.
+func newCustomer(t *testing.T, ts *testServer, email, accountNumber string, balance float64) string {
+	t.Helper()
+	user := apitest.NewUser(t, ts.models, email)
+	apitest.NewAccount(t, ts.models, user, accountNumber, balance)
+	return apitest.NewToken(t, ts.models, user)
+}
+
+func TestPaymentInitiation(t *testing.T) {
+	ts := newTestServer(t)
+	token := newCustomer(t, ts, "ada@example.com", "0123456789", 1000)
+	newCustomer(t, ts, "eve@example.com", "9876543210", 1000)
+
+	tests := []struct {
+		name    string
+		payment data.Payment
+		status  int
+		code    string
+	}{
+		{"debit", data.Payment{AccountID: "0123456789", Reference: "ref-1", Amount: 700, Type: data.Debit}, http.StatusCreated, Success.Code},
+		{"credit", data.Payment{AccountID: "0123456789", Reference: "ref-2", Amount: 500, Type: data.Credit}, http.StatusUnprocessableEntity, ValidationError.Code},
+		{"insufficient funds", data.Payment{AccountID: "0123456789", Reference: "ref-3", Amount: 301, Type: data.Debit}, http.StatusUnprocessableEntity, InsufficientFunds.Code},
+		{"another account", data.Payment{AccountID: "9876543210", Reference: "ref-4", Amount: 1, Type: data.Debit}, http.StatusUnauthorized, UnauthorizedAccountNo.Code},
+		{"invalid", data.Payment{AccountID: "0123", Reference: "ref-5", Amount: 0, Type: "refund"}, http.StatusUnprocessableEntity, ValidationError.Code},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := ts.Pay(t, token, tt.payment)
+			if resp.Status != tt.status || resp.StatusCode != tt.code {
+				t.Fatalf("got %d %s", resp.Status, resp.Body)
+			}
+		})
+	}
+
+	// Only the accepted payments reached the provider.
+	if got := ts.provider.Payments(); got != 1 {
+		t.Errorf("provider has %d payments, want 1", got)
+	}
+
+	if resp := ts.Pay(t, "", tests[0].payment); resp.Status != http.StatusUnauthorized {
+		t.Errorf("without token: got %d %s", resp.Status, resp.Body)
+	}
+}
+
+func TestPaymentLimits(t *testing.T) {
+	ts := newTestServer(t)
+	token := newCustomer(t, ts, "ada@example.com", "0123456789", 1_000_000)
+
+	debit := func(reference string, amount int) *apitest.Response {
+		return ts.Pay(t, token, data.Payment{AccountID: "0123456789", Reference: reference, Amount: amount, Type: data.Debit})
+	}
+
+	// The single limit is inclusive.
+	if resp := debit("over-single", 200_001); resp.Status != http.StatusForbidden || resp.StatusCode != TransferSingleLimitExceeded.Code {
+		t.Fatalf("over single limit: got %d %s", resp.Status, resp.Body)
+	}
+	for _, reference := range []string{"day-1", "day-2", "day-3"} {
+		if resp := debit(reference, 200_000); resp.Status != http.StatusCreated {
+			t.Fatalf("%s: got %d %s", reference, resp.Status, resp.Body)
+		}
+	}
+
+	// That used up the whole daily limit, so even the smallest debit is refused.
+	if resp := debit("over-daily", 1); resp.Status != http.StatusForbidden || resp.StatusCode != TransferDailyLimitExceeded.Code {
+		t.Fatalf("over daily limit: got %d %s", resp.Status, resp.Body)
+	}
+}
+
+func TestPaymentProviderDeclined(t *testing.T) {
+	ts := newTestServer(t)
+	token := newCustomer(t, ts, "ada@example.com", "0123456789", 1000)
+
+	ts.provider.Script(mock.Fault{Status: http.StatusBadRequest})
+	resp := ts.Pay(t, token, data.Payment{AccountID: "0123456789", Reference: "ref-1", Amount: 100, Type: data.Debit})
+	if resp.Status != http.StatusUnauthorized || resp.StatusCode != FailedApiResponse.Code {
+		t.Fatalf("got %d %s", resp.Status, resp.Body)
+	}
+
+	// The attempt is on record as failed and the debit has been refunded.
+	history, err := ts.models.Transactions.GetAccountHistory(context.Background(), "0123456789", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Status != data.Failed || history[0].RequestID != "ref-1" {
+		t.Errorf("got history %+v", history)
+	}
+	if got := balance(t, ts, token); got != "1000.00" {
+		t.Errorf("got balance %s, want 1000.00", got)
+	}
+	// Nor does it count against the daily limit.
+	details, err := ts.models.Users.GetUserDetailsFromToken(context.Background(), data.ScopeAuthentication, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.Counter.Transfers != 0 {
+		t.Errorf("got counts %+v", details.Counter)
+	}
+
+	// The request ID has been used, whatever came of it.
+	if resp := ts.Pay(t, token, data.Payment{AccountID: "0123456789", Reference: "ref-1", Amount: 100, Type: data.Debit}); resp.Status != http.StatusConflict {
+		t.Errorf("retry: got %d %s", resp.Status, resp.Body)
+	}
+}
+
+// requeryPayment runs the status requery of a payment, as the outbox would.
+func requeryPayment(t *testing.T, ts *testServer, reference string) error {
+	t.Helper()
+	msg, err := data.NewOutboxMessage(data.OutboxPaymentRequery, data.TransactionReference{Reference: reference})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts.app.requeryPayment(context.Background(), msg)
+}
+
+func TestPaymentPending(t *testing.T) {
+	tests := []struct {
+		name    string
+		fault   mock.Fault
+		status  string
+		balance string
+	}{
+		// The payment went through but the answer was lost.
+		{"carried out", mock.Fault{Malformed: true}, data.Completed, "900.00"},
+		// The provider timed out before it got to the payment.
+		{"not carried out", mock.Fault{Status: http.StatusGatewayTimeout}, data.Failed, "1000.00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			token := newCustomer(t, ts, "ada@example.com", "0123456789", 1000)
+			payment := data.Payment{AccountID: "0123456789", Reference: "ref-1", Amount: 100, Type: data.Debit}
+
+			ts.provider.Script(tt.fault)
+			resp := ts.Pay(t, token, payment)
+			if resp.Status != http.StatusAccepted {
+				t.Fatalf("got %d %s", resp.Status, resp.Body)
+			}
+			var transaction data.Transaction
+			resp.Decode(t, &transaction)
+			if transaction.Status != data.Pending || transaction.InternalReference != data.PaymentReference("0123456789", "ref-1") {
+				t.Errorf("got %+v", transaction)
+			}
+			if got := balance(t, ts, token); got != "900.00" {
+				t.Errorf("pending: got balance %s, want 900.00", got)
+			}
+
+			// A retry of the request doesn't pay again.
+			if resp := ts.Pay(t, token, payment); resp.Status != http.StatusConflict || resp.StatusCode != DuplicateEntry.Code {
+				t.Errorf("retry: got %d %s", resp.Status, resp.Body)
+			}
+
+			// While the provider can't say, the payment stays pending.
+			unavailable := mock.Fault{Status: http.StatusServiceUnavailable}
+			ts.provider.Script(unavailable, unavailable, unavailable)
+			if err := requeryPayment(t, ts, transaction.InternalReference); err == nil {
+				t.Error("requery with the provider down: got no error")
+			}
+
+			if err := requeryPayment(t, ts, transaction.InternalReference); err != nil {
+				t.Fatal(err)
+			}
+			stored, err := ts.models.Transactions.GetTransactionByReference(context.Background(), transaction.InternalReference)
+			if err != nil || stored.Status != tt.status {
+				t.Errorf("got %+v, %v; want %s", stored, err, tt.status)
+			}
+			if got := balance(t, ts, token); got != tt.balance {
+				t.Errorf("got balance %s, want %s", got, tt.balance)
+			}
+		})
+	}
+}
+
+// setPIN gives the token's user a transaction PIN.
+func setPIN(t *testing.T, ts *testServer, token, pin string) {
+	t.Helper()
+	details, err := ts.models.Users.GetUserDetailsFromToken(context.Background(), data.ScopeAuthentication, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := EncryptPIN(pin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ts.models.Users.UpdateTransactionPin2(context.Background(), hash, details.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInternalTransfer(t *testing.T) {
+	ts := newTestServer(t)
+	token := newCustomer(t, ts, "ada@example.com", "0123456789", 1000)
+	recipient := newCustomer(t, ts, "eve@example.com", "9876543210", 50)
+	setPIN(t, ts, token, "1234")
+
+	request := data.InternalTransferRequest{SendersAccountNo: "0123456789", ReceiverAccountNo: "9876543210", Amount: 400, Narration: "lunch", PIN: "1234", Reference: "ref-1"}
+	resp := ts.Do(t, http.MethodPost, "/v1/transfers/internal", token, request)
+	if resp.Status != http.StatusCreated {
+		t.Fatalf("got %d %s", resp.Status, resp.Body)
+	}
+	var transfer data.InternalTransfer
+	resp.Decode(t, &transfer)
+	if transfer.Status != data.Completed || transfer.RecipientAccountName != "Test User" || transfer.ExternalReference == nil || transfer.BalanceAfter == nil || *transfer.BalanceAfter != 600 {
+		t.Errorf("got %+v", transfer)
+	}
+	if got := balance(t, ts, token); got != "600.00" {
+		t.Errorf("sender: got balance %s, want 600.00", got)
+	}
+	if got := balance(t, ts, recipient); got != "450.00" {
+		t.Errorf("recipient: got balance %s, want 450.00", got)
+	}
+
+	// Each account has its own leg, and each account holder is alerted about theirs.
+	ctx := context.Background()
+	legs := map[string]string{transfer.Reference + "-DR": "0123456789", transfer.Reference + "-CR": "9876543210"}
+	for reference, accountNumber := range legs {
+		leg, err := ts.models.Transactions.GetTransactionByReference(ctx, reference)
+		if err != nil || leg.AccountNumber != accountNumber || leg.Source != data.SourceInternal || leg.Status != data.Completed || leg.RequestID != "ref-1" {
+			t.Errorf("%s: got %+v, %v", reference, leg, err)
+		}
+	}
+	messages, err := ts.models.Outbox.ClaimDue(ctx, 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range messages {
+		if msg.Kind != data.OutboxTransactionAlert {
+			continue
+		}
+		var ref data.TransactionReference
+		if err := json.Unmarshal(msg.Payload, &ref); err != nil {
+			t.Fatal(err)
+		}
+		delete(legs, ref.Reference)
+	}
+	if len(legs) != 0 {
+		t.Errorf("no alert for %v", legs)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		request data.InternalTransferRequest
+		status  int
+		code    string
+	}{
+		{"wrong PIN", token, data.InternalTransferRequest{SendersAccountNo: "0123456789", ReceiverAccountNo: "9876543210", Amount: 1, PIN: "4321", Reference: "ref-2"}, http.StatusForbidden, InvalidTransferPIN.Code},
+		{"PIN not set", recipient, data.InternalTransferRequest{SendersAccountNo: "9876543210", ReceiverAccountNo: "0123456789", Amount: 1, PIN: "1234", Reference: "ref-3"}, http.StatusUnauthorized, TransactionPINNotSet.Code},
+		{"another account", token, data.InternalTransferRequest{SendersAccountNo: "9876543210", ReceiverAccountNo: "0123456789", Amount: 1, PIN: "1234", Reference: "ref-4"}, http.StatusUnauthorized, UnauthorizedAccountNo.Code},
+		{"unknown recipient", token, data.InternalTransferRequest{SendersAccountNo: "0123456789", ReceiverAccountNo: "5555555555", Amount: 1, PIN: "1234", Reference: "ref-5"}, http.StatusNotFound, RecordNotFound.Code},
+		{"over single limit", token, data.InternalTransferRequest{SendersAccountNo: "0123456789", ReceiverAccountNo: "9876543210", Amount: 200_001, PIN: "1234", Reference: "ref-6"}, http.StatusForbidden, TransferSingleLimitExceeded.Code},
+		{"insufficient funds", token, data.InternalTransferRequest{SendersAccountNo: "0123456789", ReceiverAccountNo: "9876543210", Amount: 601, PIN: "1234", Reference: "ref-7"}, http.StatusUnprocessableEntity, InsufficientFunds.Code},
+		{"to self", token, data.InternalTransferRequest{SendersAccountNo: "0123456789", ReceiverAccountNo: "0123456789", Amount: 1, PIN: "1234", Reference: "ref-8"}, http.StatusUnprocessableEntity, ValidationError.Code},
+		{"repeated reference", token, data.InternalTransferRequest{SendersAccountNo: "0123456789", ReceiverAccountNo: "9876543210", Amount: 1, PIN: "1234", Reference: "ref-1"}, http.StatusConflict, DuplicateEntry.Code},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := ts.Do(t, http.MethodPost, "/v1/transfers/internal", tt.token, tt.request)
+			if resp.Status != tt.status || resp.StatusCode != tt.code {
+				t.Fatalf("got %d %s", resp.Status, resp.Body)
+			}
+		})
+	}
+	if got := balance(t, ts, token); got != "600.00" {
+		t.Errorf("after refusals: got balance %s, want 600.00", got)
+	}
+}
+
+func TestInternalTransferDeclined(t *testing.T) {
+	ts := newTestServer(t)
+	token := newCustomer(t, ts, "ada@example.com", "0123456789", 1000)
+	recipient := newCustomer(t, ts, "eve@example.com", "9876543210", 0)
+	setPIN(t, ts, token, "1234")
+
+	ts.provider.Script(mock.Fault{ResponseCode: "51"})
+	resp := ts.Do(t, http.MethodPost, "/v1/transfers/internal", token, data.InternalTransferRequest{SendersAccountNo: "0123456789", ReceiverAccountNo: "9876543210", Amount: 100, PIN: "1234", Reference: "ref-1"})
+	if resp.Status != http.StatusUnauthorized || resp.StatusCode != FailedApiResponse.Code {
+		t.Fatalf("got %d %s", resp.Status, resp.Body)
+	}
+
+	// The attempt is on record as failed and neither balance has moved.
+	history, err := ts.models.Transactions.GetAccountHistory(context.Background(), "0123456789", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Source != data.SourceInternal || history[0].Status != data.Failed {
+		t.Errorf("got history %+v", history)
+	}
+	if got := balance(t, ts, token); got != "1000.00" {
+		t.Errorf("sender: got balance %s, want 1000.00", got)
+	}
+	if got := balance(t, ts, recipient); got != "0.00" {
+		t.Errorf("recipient: got balance %s, want 0.00", got)
+	}
+}
+
+func TestInternalTransferPending(t *testing.T) {
+	ts := newTestServer(t)
+	token := newCustomer(t, ts, "ada@example.com", "0123456789", 1000)
+	recipient := newCustomer(t, ts, "eve@example.com", "9876543210", 0)
+	setPIN(t, ts, token, "1234")
+
+	// Core banking may have moved the money but the answer was lost, so the transfer
+	// isn't refunded.
+	ts.provider.Script(mock.Fault{Malformed: true})
+	resp := ts.Do(t, http.MethodPost, "/v1/transfers/internal", token, data.InternalTransferRequest{SendersAccountNo: "0123456789", ReceiverAccountNo: "9876543210", Amount: 100, PIN: "1234", Reference: "ref-1"})
+	if resp.Status != http.StatusAccepted {
+		t.Fatalf("got %d %s", resp.Status, resp.Body)
+	}
+	var transfer data.InternalTransfer
+	resp.Decode(t, &transfer)
+	if transfer.Status != data.Pending {
+		t.Errorf("got %+v", transfer)
+	}
+
+	// Nor does the reconciliation refund it, as it went to core banking.
+	msg, err := data.NewOutboxMessage(data.OutboxInternalReconcile, data.TransactionReference{Reference: transfer.Reference})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.app.reconcileInternalTransfer(context.Background(), msg); !errors.Is(err, data.ErrTransferSubmitted) {
+		t.Errorf("reconcile: got %v, want ErrTransferSubmitted", err)
+	}
+	if got := balance(t, ts, token); got != "900.00" {
+		t.Errorf("sender: got balance %s, want 900.00", got)
+	}
+	if got := balance(t, ts, recipient); got != "0.00" {
+		t.Errorf("recipient: got balance %s, want 0.00", got)
+	}
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"errors"
-	"fmt"
 	"net/http"
 	"strings"
 
@@ -34,7 +33,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		Username:   input.Username,
 		Email:      input.Email,
 		Activated:  null.BoolFrom(true),
-		UserDevice: data.UserDevice{input.Device_id, input.Device_os, input.Device_name}, //TODO: Validate these fields
+		UserDevice: data.UserDevice{DeviceID: input.Device_id, DeviceOS: input.Device_os, DeviceName: input.Device_name}, //TODO: Validate these fields
 	}
 
 	v := validator.New()
@@ -53,7 +52,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	// Insert the user data into the database.
-	err = app.models.Users.Insert(user)
+	err = app.models.Users.Insert(r.Context(), user)
 	if err != nil {
 		//TODO 3: Keep LOGS of failed Registration as background task
 		switch {
@@ -68,7 +67,6 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		}
 		return
 	}
-	fmt.Println("User", ".....")
 	// Add the "account:read" permission for the new user.
 	// err = app.models.Permissions.AddForUser(user.ID, "account:read")
 	// if err != nil {
@@ -128,7 +126,7 @@ func (app *application) GetUserDetails(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userDetails, err := app.models.Users.GetUserDetailsFromToken(data.ScopeAuthentication, token)
+	userDetails, err := app.models.Users.GetUserDetailsFromToken(r.Context(), data.ScopeAuthentication, token)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
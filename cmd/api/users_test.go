@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/ebitezion/backend-framework/internal/apitest"
+	"github.com/ebitezion/backend-framework/internal/data"
+)
+
+func TestRegisterUser(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp := ts.Register(t, "ada@example.com", apitest.Password)
+	if resp.Status != http.StatusCreated || resp.StatusCode != Success.Code {
+		t.Fatalf("register: got %d %s", resp.Status, resp.Body)
+	}
+
+	resp = ts.Register(t, "ada@example.com", apitest.Password)
+	if resp.Status != http.StatusUnprocessableEntity || resp.StatusCode != ValidationError.Code {
+		t.Errorf("duplicate email: got %d %s", resp.Status, resp.Body)
+	}
+
+	resp = ts.Register(t, "not-an-email", apitest.Password)
+	if resp.Status != http.StatusUnprocessableEntity {
+		t.Errorf("invalid email: got %d %s", resp.Status, resp.Body)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	ts := newTestServer(t)
+	ts.Register(t, "ada@example.com", apitest.Password)
+
+	tests := []struct {
+		name     string
+		email    string
+		password string
+		status   int
+		code     string
+	}{
+		{"valid", "ada@example.com", apitest.Password, http.StatusCreated, ""},
+		{"wrong password", "ada@example.com", "wrong-password", http.StatusUnauthorized, UnauthorizedAccess.Code},
+		{"unknown user", "eve@example.com", apitest.Password, http.StatusUnauthorized, UnauthorizedAccess.Code},
+		{"short password", "ada@example.com", "short", http.StatusUnprocessableEntity, ValidationError.Code},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, resp := ts.Login(t, tt.email, tt.password)
+			if resp.Status != tt.status || resp.StatusCode != tt.code {
+				t.Fatalf("got %d %s", resp.Status, resp.Body)
+			}
+			if tt.status == http.StatusCreated && token == "" {
+				t.Error("no token in response")
+			}
+		})
+	}
+
+	// The token authenticates later requests; anything else is turned away.
+	token, _ := ts.Login(t, "ada@example.com", apitest.Password)
+	if resp := ts.Do(t, http.MethodGet, "/v1/users/notifications", token, nil); resp.Status != http.StatusOK {
+		t.Errorf("with token: got %d %s", resp.Status, resp.Body)
+	}
+	if resp := ts.Do(t, http.MethodGet, "/v1/users/notifications", "", nil); resp.Status != http.StatusUnauthorized {
+		t.Errorf("without token: got %d %s", resp.Status, resp.Body)
+	}
+	if resp := ts.Do(t, http.MethodGet, "/v1/users/notifications", "ABCDEFGHIJKLMNOPQRSTUVWXYZ", nil); resp.Status != http.StatusUnauthorized {
+		t.Errorf("unknown token: got %d %s", resp.Status, resp.Body)
+	}
+}
+
+func TestCreateAccount(t *testing.T) {
+	ts := newTestServer(t)
+	ts.Register(t, "ada@example.com", apitest.Password)
+	token, _ := ts.Login(t, "ada@example.com", apitest.Password)
+
+	accountNumber := ts.CreateAccount(t, token)
+	if len(accountNumber) != 10 {
+		t.Fatalf("got account number %q", accountNumber)
+	}
+	// The account is saved in the background after the response has been sent.
+	ts.wait()
+
+	resp := ts.Do(t, http.MethodGet, "/v1/users/userDetails", token, nil)
+	if resp.Status != http.StatusCreated {
+		t.Fatalf("user details: got %d %s", resp.Status, resp.Body)
+	}
+	var details data.UserDetailsForLimits
+	resp.Decode(t, &details)
+	if details.AccountNumber != accountNumber || details.Balance != "0.00" {
+		t.Errorf("got details %+v", details)
+	}
+
+	resp = ts.Do(t, http.MethodPost, "/v1/accounts", token, data.Account{Surname: "User"})
+	if resp.Status != http.StatusUnprocessableEntity {
+		t.Errorf("missing fields: got %d %s", resp.Status, resp.Body)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ebitezion/backend-framework/internal/data"
+	"github.com/ebitezion/backend-framework/internal/notify"
+	"github.com/ebitezion/backend-framework/internal/validator"
+	"github.com/shopspring/decimal"
+)
+
+// ussdHandler serves sessions from the USSD gateway. The gateway posts each step of a
+// session as a form carrying the sessionId, serviceCode, phoneNumber and everything
+// the customer has entered so far in text, their answers separated by "*". The reply
+// is plain text that the gateway shows on the handset: "CON " and a prompt to carry
+// the session on, or "END " and a message to close it. Since text holds the whole
+// session, each step is worked out from it afresh and nothing is kept between
+// requests.
+//
+// The gateway authenticates with the key in ussd.gateway_key, sent in X-Api-Key. The
+// endpoint doesn't exist while no key is configured.
+func (app *application) ussdHandler(w http.ResponseWriter, r *http.Request) {
+	if app.config.USSD.GatewayKey == "" {
+		app.notFoundResponse(w, r)
+		return
+	}
+	key := r.Header.Get("X-Api-Key")
+	if key == "" {
+		app.noAPIKey(w, r)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(key), []byte(app.config.USSD.GatewayKey)) != 1 {
+		app.invalidKey(w, r)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+	err := r.ParseForm()
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	sessionID := r.PostFormValue("sessionId")
+	phoneNumber := r.PostFormValue("phoneNumber")
+	if sessionID == "" || phoneNumber == "" {
+		app.badRequestResponse(w, r, errors.New("sessionId and phoneNumber must be provided"))
+		return
+	}
+
+	reply := app.ussdSession(r, sessionID, phoneNumber, r.PostFormValue("text"))
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, err = io.WriteString(w, reply)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// USSD replies that are used more than once.
+const (
+	ussdUnavailable = "END The service is not available at the moment. Please try again later."
+	ussdInvalid     = "END Invalid input. Please start again."
+	ussdWrongPIN    = "END The PIN you entered is incorrect."
+	ussdNoPIN       = "END Please set your transaction PIN in the app before banking by USSD."
+	ussdLocked      = "END USSD banking is locked after too many incorrect PINs. Please change your transaction PIN in the app to unlock it."
+)
+
+// ussdSession returns the reply to the step of a session that text has reached. The
+// customer is found by the phone number the session comes from, and must have an
+// active account.
+func (app *application) ussdSession(r *http.Request, sessionID, phoneNumber, text string) string {
+	ctx := r.Context()
+
+	user, err := app.ussdUser(ctx, phoneNumber)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return "END This phone number is not registered for mobile banking."
+		}
+		app.logError(r, err)
+		return ussdUnavailable
+	}
+	if !user.Activated.Bool {
+		return "END Your account is not active. Please activate it in the app."
+	}
+	userDetail, err := app.models.Users.GetUserDetailsByUserID(ctx, user.ID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return "END You don't have an account yet. Please open one in the app."
+		}
+		app.logError(r, err)
+		return ussdUnavailable
+	}
+
+	var input []string
+	if text != "" {
+		input = strings.Split(text, "*")
+	}
+	switch {
+	case len(input) == 0:
+		return "CON What would you like to do?\n1. Check balance\n2. Transfer money"
+	case input[0] == "1":
+		return app.ussdBalance(r, userDetail, input[1:])
+	case input[0] == "2":
+		return app.ussdTransfer(r, sessionID, userDetail, input[1:])
+	default:
+		return ussdInvalid
+	}
+}
+
+// ussdUser finds the user with the phone number. Gateways send numbers in
+// international format, while users register with the local one, so a +234 number is
+// tried in both.
+func (app *application) ussdUser(ctx context.Context, phoneNumber string) (*data.User, error) {
+	user, err := app.models.Users.GetByPhoneNumber(ctx, phoneNumber)
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		return user, err
+	}
+	local, ok := strings.CutPrefix(strings.TrimPrefix(phoneNumber, "+"), "234")
+	if !ok {
+		return nil, err
+	}
+	return app.models.Users.GetByPhoneNumber(ctx, "0"+local)
+}
+
+// ussdCheckPIN returns the reply that ends the session if pin isn't the customer's
+// transaction PIN, or "" if it is. Anyone with the customer's phone can dial in, so
+// after ussd.max_pin_attempts wrong PINs in a row the customer is locked out of USSD
+// until they change their PIN in the app.
+func (app *application) ussdCheckPIN(r *http.Request, userDetail *data.UserDetailsForLimits, pin string) string {
+	if userDetail.PIN == "" {
+		return ussdNoPIN
+	}
+	userID, _ := strconv.ParseInt(userDetail.UserID, 10, 64)
+	err := app.models.Users.ClaimUSSDPINAttempt(r.Context(), userID, app.config.USSD.MaxPINAttempts)
+	if err != nil {
+		if errors.Is(err, data.ErrUSSDLocked) {
+			return ussdLocked
+		}
+		app.logError(r, err)
+		return ussdUnavailable
+	}
+	if !VerifyPIN(userDetail.PIN, pin) {
+		return ussdWrongPIN
+	}
+	err = app.models.Users.ResetUSSDPINAttempts(r.Context(), userID)
+	if err != nil {
+		app.logError(r, err)
+		return ussdUnavailable
+	}
+	return ""
+}
+
+// ussdBalance is the balance enquiry: the customer's PIN, then their balance.
+func (app *application) ussdBalance(r *http.Request, userDetail *data.UserDetailsForLimits, input []string) string {
+	if len(input) == 0 {
+		return "CON Enter your transaction PIN"
+	}
+	if reply := app.ussdCheckPIN(r, userDetail, input[0]); reply != "" {
+		return reply
+	}
+	balance, err := decimal.NewFromString(userDetail.Balance)
+	if err != nil {
+		balance = decimal.Zero
+	}
+	return fmt.Sprintf("END Account %s\nAvailable balance: %s", notify.MaskAccountNumber(userDetail.AccountNumber), balance.StringFixed(2))
+}
+
+// ussdTransfer is a transfer to another of our own accounts: the account number, the
+// amount, then the customer's PIN to confirm it once they have seen the name on the
+// account, masked as a transfer tag lookup masks it. The transfer goes through
+// internalTransfer and counts against the customer's USSD limits, with
+// ussdReference as the client reference, so a session can only pay once.
+func (app *application) ussdTransfer(r *http.Request, sessionID string, userDetail *data.UserDetailsForLimits, input []string) string {
+	ctx := r.Context()
+
+	if len(input) == 0 {
+		return "CON Enter the account number to send to"
+	}
+	accountNumber := input[0]
+	if accountNumber == userDetail.AccountNumber {
+		return "END You can't transfer to your own account."
+	}
+	recipient, err := app.models.AccountModel.GetAccountHolder(ctx, accountNumber)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return "END The account could not be found."
+		}
+		app.logError(r, err)
+		return ussdUnavailable
+	}
+	if len(input) == 1 {
+		return "CON Enter the amount"
+	}
+	amount, err := strconv.Atoi(input[1])
+	if err != nil || amount <= 0 {
+		return ussdInvalid
+	}
+	if len(input) == 2 {
+		return fmt.Sprintf("CON Send %d to %s (%s)?\nEnter your transaction PIN to confirm", amount, notify.MaskName(recipient.Name), notify.MaskAccountNumber(recipient.AccountNumber))
+	}
+	if len(input) > 3 {
+		return ussdInvalid
+	}
+	if reply := app.ussdCheckPIN(r, userDetail, input[2]); reply != "" {
+		return reply
+	}
+
+	request := &data.InternalTransferRequest{
+		SendersAccountNo:  userDetail.AccountNumber,
+		ReceiverAccountNo: recipient.AccountNumber,
+		Amount:            amount,
+		Narration:         "USSD transfer",
+		PIN:               input[2],
+		Reference:         ussdReference(sessionID),
+	}
+	v := validator.New()
+	if data.ValidateInternalTransferRequest(v, request); !v.Valid() {
+		return ussdInvalid
+	}
+
+	transfer, err := app.internalTransfer(ctx, userDetail, data.ChannelUSSD, request)
+	if err != nil {
+		var paymentErr *paymentError
+		switch {
+		case errors.As(err, &paymentErr) && (paymentErr.code == USSDSingleLimitExceeded || paymentErr.code == USSDDailyLimitExceeded):
+			return "END The amount is more than your USSD transfer limit allows."
+		case errors.Is(err, errBeneficiaryNotFound):
+			return "END The account could not be found."
+		case errors.Is(err, data.ErrInsufficientFunds):
+			return "END You don't have enough money for this transfer."
+		case errors.Is(err, data.ErrDuplicateTransaction):
+			return "END This transfer has already been made. Check your balance to see it."
+		default:
+			app.logError(r, err)
+			return "END The transfer could not be completed. Please try again later."
+		}
+	}
+	if transfer.Status == data.Pending {
+		return fmt.Sprintf("END Your transfer of %d to %s is being processed.\nReference: %s", amount, notify.MaskName(transfer.RecipientAccountName), transfer.Reference)
+	}
+	return fmt.Sprintf("END You sent %d to %s.\nReference: %s", amount, notify.MaskName(transfer.RecipientAccountName), transfer.Reference)
+}
+
+// ussdReference returns the client reference for a transfer made in the session. The
+// gateway doesn't bound the length of a session ID, so it is hashed to fit a
+// reference rather than cut short, which could leave two sessions sharing one.
+func ussdReference(sessionID string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	return "USSD" + hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ebitezion/backend-framework/internal/apitest"
+	"github.com/ebitezion/backend-framework/internal/data"
+	"gopkg.in/guregu/null.v4"
+)
+
+// ussd sends a step of a USSD session from the phone number, as the gateway would,
+// and returns the status and the reply.
+func ussd(t *testing.T, ts *testServer, key, phoneNumber, text string) (int, string) {
+	t.Helper()
+	return ussdIn(t, ts, "ATUid_1", key, phoneNumber, text)
+}
+
+// ussdIn is ussd for a step of the session with the given ID.
+func ussdIn(t *testing.T, ts *testServer, sessionID, key, phoneNumber, text string) (int, string) {
+	t.Helper()
+	form := url.Values{"sessionId": {sessionID}, "serviceCode": {"*123#"}, "phoneNumber": {phoneNumber}, "text": {text}}
+	req, err := http.NewRequest(http.MethodPost, ts.BaseURL+"/v1/ussd", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if key != "" {
+		req.Header.Set("X-Api-Key", key)
+	}
+	res, err := ts.HTTP.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, string(body)
+}
+
+// newUSSDCustomer is newCustomer for a user registered with a phone number.
+func newUSSDCustomer(t *testing.T, ts *testServer, phoneNumber, accountNumber string, balance float64) string {
+	t.Helper()
+	user := &data.User{Name: "Test User", Username: phoneNumber, Email: phoneNumber + "@example.com", PhoneNumber: phoneNumber, Activated: null.BoolFrom(true)}
+	if err := ts.models.Users.Insert(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	apitest.NewAccount(t, ts.models, user, accountNumber, balance)
+	return apitest.NewToken(t, ts.models, user)
+}
+
+func TestUSSDGatewayKey(t *testing.T) {
+	ts := newTestServer(t)
+
+	// Without a key configured there is no USSD endpoint.
+	if status, _ := ussd(t, ts, "ussd-key", "08031234567", ""); status != http.StatusNotFound {
+		t.Errorf("disabled: got %d", status)
+	}
+
+	ts.app.config.USSD.GatewayKey = "ussd-key"
+	if status, _ := ussd(t, ts, "", "08031234567", ""); status != http.StatusUnauthorized {
+		t.Errorf("no key: got %d", status)
+	}
+	if status, _ := ussd(t, ts, "wrong-key", "08031234567", ""); status != http.StatusUnauthorized {
+		t.Errorf("wrong key: got %d", status)
+	}
+	if status, reply := ussd(t, ts, "ussd-key", "08031234567", ""); status != http.StatusOK || reply != "END This phone number is not registered for mobile banking." {
+		t.Errorf("unregistered: got %d %q", status, reply)
+	}
+}
+
+func TestUSSDBalance(t *testing.T) {
+	ts := newTestServer(t)
+	ts.app.config.USSD.GatewayKey = "ussd-key"
+	token := newUSSDCustomer(t, ts, "08031234567", "0123456789", 1500)
+	setPIN(t, ts, token, "1234")
+
+	tests := []struct {
+		name, phoneNumber, text, reply string
+	}{
+		{"menu", "08031234567", "", "CON What would you like to do?\n1. Check balance\n2. Transfer money"},
+		{"international number", "+2348031234567", "", "CON What would you like to do?\n1. Check balance\n2. Transfer money"},
+		{"PIN", "08031234567", "1", "CON Enter your transaction PIN"},
+		{"balance", "08031234567", "1*1234", "END Account 012****789\nAvailable balance: 1500.00"},
+		{"wrong PIN", "08031234567", "1*4321", ussdWrongPIN},
+		{"unknown option", "08031234567", "9", ussdInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, reply := ussd(t, ts, "ussd-key", tt.phoneNumber, tt.text)
+			if status != http.StatusOK || reply != tt.reply {
+				t.Errorf("got %d %q, want %q", status, reply, tt.reply)
+			}
+		})
+	}
+}
+
+func TestUSSDPINLockout(t *testing.T) {
+	ts := newTestServer(t)
+	ts.app.config.USSD.GatewayKey = "ussd-key"
+	token := newUSSDCustomer(t, ts, "08031234567", "0123456789", 1500)
+	setPIN(t, ts, token, "1234")
+	newCustomer(t, ts, "eve@example.com", "9876543210", 0)
+
+	// A right PIN starts the count again, and the third wrong one in a row locks
+	// USSD, even for the right PIN, until the PIN is changed in the app.
+	tests := []struct {
+		text, reply string
+	}{
+		{"1*4321", ussdWrongPIN},
+		{"1*4321", ussdWrongPIN},
+		{"1*1234", "END Account 012****789\nAvailable balance: 1500.00"},
+		{"1*4321", ussdWrongPIN},
+		{"2*9876543210*100*4321", ussdWrongPIN},
+		{"1*4321", ussdWrongPIN},
+		{"1*1234", ussdLocked},
+		{"2*9876543210*100*1234", ussdLocked},
+	}
+	for i, tt := range tests {
+		if _, reply := ussd(t, ts, "ussd-key", "08031234567", tt.text); reply != tt.reply {
+			t.Errorf("step %d (%s): got %q, want %q", i+1, tt.text, reply, tt.reply)
+		}
+	}
+
+	setPIN(t, ts, token, "5678")
+	if _, reply := ussd(t, ts, "ussd-key", "08031234567", "1*5678"); reply != "END Account 012****789\nAvailable balance: 1500.00" {
+		t.Errorf("new PIN: got %q", reply)
+	}
+}
+
+func TestUSSDTransfer(t *testing.T) {
+	ts := newTestServer(t)
+	ts.app.config.USSD.GatewayKey = "ussd-key"
+	token := newUSSDCustomer(t, ts, "08031234567", "0123456789", 50_000)
+	setPIN(t, ts, token, "1234")
+	recipient := newCustomer(t, ts, "eve@example.com", "9876543210", 0)
+
+	tests := []struct {
+		name, text, reply string
+	}{
+		{"account", "2", "CON Enter the account number to send to"},
+		{"amount", "2*9876543210", "CON Enter the amount"},
+		{"confirm", "2*9876543210*5000", "CON Send 5000 to Test U*** (987****210)?\nEnter your transaction PIN to confirm"},
+		{"unknown account", "2*5555555555", "END The account could not be found."},
+		{"own account", "2*0123456789", "END You can't transfer to your own account."},
+		{"invalid amount", "2*9876543210*five", ussdInvalid},
+		{"wrong PIN", "2*9876543210*5000*4321", ussdWrongPIN},
+		// The USSD limits are lower than the transfer limits.
+		{"over single limit", "2*9876543210*10001*1234", "END The amount is more than your USSD transfer limit allows."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, reply := ussd(t, ts, "ussd-key", "08031234567", tt.text)
+			if status != http.StatusOK || reply != tt.reply {
+				t.Errorf("got %d %q, want %q", status, reply, tt.reply)
+			}
+		})
+	}
+
+	for i, want := range []string{"END You sent 10000 to Test U***.", "END You sent 10000 to Test U***.", "END The amount is more than your USSD transfer limit allows."} {
+		_, reply := ussdIn(t, ts, fmt.Sprintf("ATUid_%d", i+2), "ussd-key", "08031234567", "2*9876543210*10000*1234")
+		if !strings.HasPrefix(reply, want) {
+			t.Errorf("transfer %d: got %q, want %q", i+1, reply, want)
+		}
+	}
+	if got := balance(t, ts, token); got != "30000.00" {
+		t.Errorf("got balance %s, want 30000.00", got)
+	}
+	if got := balance(t, ts, recipient); got != "20000.00" {
+		t.Errorf("recipient: got balance %s, want 20000.00", got)
+	}
+
+	// Transfers by USSD count against the USSD limits only.
+	details, err := ts.models.Users.GetUserDetailsFromToken(context.Background(), data.ScopeAuthentication, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.Counter.USSD != 20_000 || details.Counter.Transfers != 0 {
+		t.Errorf("got counts %+v", details.Counter)
+	}
+}
+
+func TestUSSDTransferSession(t *testing.T) {
+	ts := newTestServer(t)
+	ts.app.config.USSD.GatewayKey = "ussd-key"
+	token := newUSSDCustomer(t, ts, "08031234567", "0123456789", 50_000)
+	setPIN(t, ts, token, "1234")
+	recipient := newCustomer(t, ts, "eve@example.com", "9876543210", 0)
+
+	// Session IDs longer than a reference, alike but for their ends, are separate
+	// transfers; a session sent again is the same one.
+	prefix := strings.Repeat("ATUid_", 10)
+	tests := []struct {
+		sessionID, reply string
+	}{
+		{prefix + "1", "END You sent 1000 to Test U***."},
+		{prefix + "2", "END You sent 1000 to Test U***."},
+		{prefix + "1", "END This transfer has already been made. Check your balance to see it."},
+	}
+	for i, tt := range tests {
+		_, reply := ussdIn(t, ts, tt.sessionID, "ussd-key", "08031234567", "2*9876543210*1000*1234")
+		if !strings.HasPrefix(reply, tt.reply) {
+			t.Errorf("transfer %d: got %q, want %q", i+1, reply, tt.reply)
+		}
+	}
+	if got := balance(t, ts, token); got != "48000.00" {
+		t.Errorf("got balance %s, want 48000.00", got)
+	}
+	if got := balance(t, ts, recipient); got != "2000.00" {
+		t.Errorf("recipient: got balance %s, want 2000.00", got)
+	}
+}
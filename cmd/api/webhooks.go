@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/ebitezion/backend-framework/internal/data"
+	"github.com/ebitezion/backend-framework/internal/validator"
+	"github.com/ebitezion/backend-framework/internal/webhook"
+	"github.com/julienschmidt/httprouter"
+)
+
+// maxWebhookDeliveries is the number of deliveries shown in an endpoint's log.
+const maxWebhookDeliveries = 100
+
+// createWebhookEndpointHandler registers a new endpoint for the calling API client.
+// The signing secret is generated here and returned only in this response. Outside
+// development the URL's host must resolve only to public addresses, so partners
+// can't use deliveries to reach our internal network.
+func (app *application) createWebhookEndpointHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	u, err := url.Parse(input.URL)
+	v.Check(err == nil && u.Host != "" && (u.Scheme == "https" || (u.Scheme == "http" && app.config.Env == "development")), "url", "must be an absolute https URL")
+	v.Check(len(input.Events) > 0, "events", "must contain at least one event")
+	v.Check(validator.Unique(input.Events), "events", "must not contain duplicate values")
+	for _, event := range input.Events {
+		v.Check(validator.In(event, data.WebhookEvents...), "events", "contains an unknown event")
+	}
+	if v.Valid() {
+		err = app.webhooks.CheckHost(r.Context(), u.Hostname())
+		switch {
+		case errors.Is(err, webhook.ErrPrivateAddress):
+			v.AddError("url", "must not point to a private or loopback address")
+		case err != nil:
+			v.AddError("url", "must have a host that resolves")
+		}
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	secret, err := webhook.NewSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	endpoint := &data.WebhookEndpoint{
+		UserID: app.contextGetUser(r).ID,
+		URL:    input.URL,
+		Secret: secret,
+		Events: input.Events,
+	}
+	err = app.models.Webhooks.InsertEndpoint(r.Context(), endpoint)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := app.SuccessFormater(endpoint, "Success")
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listWebhookEndpointsHandler returns the caller's endpoints, without their secrets.
+func (app *application) listWebhookEndpointsHandler(w http.ResponseWriter, r *http.Request) {
+	endpoints, err := app.models.Webhooks.GetEndpointsForUser(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	for _, endpoint := range endpoints {
+		endpoint.Secret = ""
+	}
+
+	env := app.SuccessFormater(endpoints, "Success")
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteWebhookEndpointHandler removes one of the caller's endpoints.
+func (app *application) deleteWebhookEndpointHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Webhooks.DeleteEndpoint(r.Context(), id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := app.SuccessFormater(nil, "Webhook endpoint deleted")
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// ownWebhookEndpoint loads the endpoint named by the :id parameter, writing a 404 if
+// it doesn't exist or belongs to another client.
+func (app *application) ownWebhookEndpoint(w http.ResponseWriter, r *http.Request) (*data.WebhookEndpoint, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	endpoint, err := app.models.Webhooks.GetEndpoint(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	if endpoint.UserID != app.contextGetUser(r).ID {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+	return endpoint, true
+}
+
+// listWebhookDeliveriesHandler returns the delivery log for one of the caller's
+// endpoints.
+func (app *application) listWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := app.ownWebhookEndpoint(w, r)
+	if !ok {
+		return
+	}
+
+	deliveries, err := app.models.Webhooks.GetDeliveriesForEndpoint(r.Context(), endpoint.ID, maxWebhookDeliveries)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := app.SuccessFormater(deliveries, "Success")
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// replayWebhookDeliveryHandler queues a delivery to be sent again, for example after
+// the partner has fixed their endpoint. Deliveries that are still pending, or whose
+// failed attempt is still waiting to be retried, are already queued and can't be
+// replayed.
+func (app *application) replayWebhookDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := app.ownWebhookEndpoint(w, r)
+	if !ok {
+		return
+	}
+
+	deliveryID, err := strconv.ParseInt(httprouter.ParamsFromContext(r.Context()).ByName("delivery"), 10, 64)
+	if err != nil || deliveryID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	delivery, err := app.models.Webhooks.GetDelivery(r.Context(), deliveryID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if delivery.EndpointID != endpoint.ID {
+		app.notFoundResponse(w, r)
+		return
+	}
+	if delivery.Status == data.Pending {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	err = app.models.Webhooks.ReplayDelivery(r.Context(), delivery)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := app.SuccessFormater(delivery, "Delivery queued")
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// expandWebhookEvent is the outbox handler for an emitted event. It records a
+// delivery for every endpoint the user has subscribed to the event, and queues each
+// one to be sent.
+func (app *application) expandWebhookEvent(ctx context.Context, msg *data.OutboxMessage) error {
+	var event data.WebhookEvent
+	err := json.Unmarshal(msg.Payload, &event)
+	if err != nil {
+		return err
+	}
+
+	endpoints, err := app.models.Webhooks.GetEndpointsForUser(ctx, event.UserID)
+	if err != nil {
+		return err
+	}
+
+	var subscribed []*data.WebhookEndpoint
+	for _, endpoint := range endpoints {
+		if endpoint.Subscribed(event.Type) {
+			subscribed = append(subscribed, endpoint)
+		}
+	}
+	if len(subscribed) == 0 {
+		return nil
+	}
+	return app.models.Webhooks.CreateDeliveries(ctx, &event, subscribed)
+}
+
+// deliverWebhook is the outbox handler for a single delivery. Every attempt is
+// recorded in the delivery log; returning the error lets the outbox retry it.
+func (app *application) deliverWebhook(ctx context.Context, msg *data.OutboxMessage) error {
+	var ref data.WebhookDeliveryReference
+	err := json.Unmarshal(msg.Payload, &ref)
+	if err != nil {
+		return err
+	}
+
+	// The endpoint (and with it the delivery log) may have been deleted since the
+	// delivery was queued, in which case there is nothing left to do.
+	delivery, err := app.models.Webhooks.GetDelivery(ctx, ref.DeliveryID)
+	if errors.Is(err, data.ErrRecordNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	endpoint, err := app.models.Webhooks.GetEndpoint(ctx, delivery.EndpointID)
+	if errors.Is(err, data.ErrRecordNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	status, sendErr := app.webhooks.Send(ctx, endpoint.URL, endpoint.Secret, delivery.Event, delivery.EventID, delivery.Payload)
+
+	delivery.ResponseStatus = status
+	delivery.Status = data.WebhookDelivered
+	delivery.LastError = ""
+	if sendErr != nil {
+		delivery.Status = data.WebhookFailed
+		delivery.LastError = sendErr.Error()
+	}
+	err = app.models.Webhooks.RecordAttempt(ctx, delivery)
+	if err != nil {
+		app.logger.Error(err.Error(), "delivery_id", delivery.ID)
+	}
+	return sendErr
+}
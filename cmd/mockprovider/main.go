@@ -0,0 +1,69 @@
+// Command mockprovider runs the mock third-party payment provider on its own, for
+// local development against an API that isn't running in development mode.
+//
+// Faults for upcoming calls are queued by posting a JSON array to /mock/faults, for
+// example:
+//
+//	curl -X POST localhost:4100/mock/faults -d '[{"status":503},{"latency":2000000000}]'
+//
+// and cleared with DELETE /mock/faults.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/ebitezion/backend-framework/internal/logging"
+	"github.com/ebitezion/backend-framework/internal/mock"
+)
+
+func main() {
+	var port int
+	flag.IntVar(&port, "port", 4100, "Mock provider port")
+	flag.Parse()
+
+	logger := logging.New(os.Stdout, slog.LevelInfo)
+
+	provider := mock.NewProvider()
+	srv := &http.Server{
+		Addr:     fmt.Sprintf(":%d", port),
+		Handler:  provider,
+		ErrorLog: slog.NewLogLogger(logger.Handler(), slog.LevelError),
+	}
+
+	// Shut down on SIGINT or SIGTERM, releasing any calls held open by a timeout
+	// fault first so that Shutdown doesn't wait on them.
+	shutdownError := make(chan error)
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		s := <-quit
+		logger.Info("shutting down mock provider", "signal", s.String())
+
+		provider.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdownError <- srv.Shutdown(ctx)
+	}()
+
+	logger.Info("starting mock provider", "addr", srv.Addr)
+	err := srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	err = <-shutdownError
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	logger.Info("stopped mock provider")
+}
@@ -0,0 +1,142 @@
+package apitest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ebitezion/backend-framework/internal/data"
+)
+
+// Client makes requests against a running API.
+type Client struct {
+	BaseURL string
+	HTTP    *http.Client
+}
+
+// NewClient returns a client for the API at baseURL.
+func NewClient(baseURL string) *Client {
+	return &Client{BaseURL: baseURL, HTTP: http.DefaultClient}
+}
+
+// Response is an API response. The envelope fields are decoded when the body is a
+// JSON object; Data is left raw so that tests can decode it into what they expect.
+type Response struct {
+	Status int
+	Body   []byte
+
+	StatusCode string          `json:"status_code"`
+	Message    string          `json:"status"`
+	Data       json.RawMessage `json:"data"`
+	ErrorMsg   any             `json:"error_msg"`
+	Error      any             `json:"error"`
+}
+
+// Decode decodes the data field of the envelope into v.
+func (r *Response) Decode(t testing.TB, v any) {
+	t.Helper()
+	err := json.Unmarshal(r.Data, v)
+	if err != nil {
+		t.Fatalf("apitest: decoding data %s: %v", r.Data, err)
+	}
+}
+
+// Do sends a request with body encoded as JSON (unless it is nil) and the token as
+// a bearer token (unless it is empty).
+func (c *Client) Do(t testing.TB, method, path, token string, body any) *Response {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		js, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("apitest: encoding request: %v", err)
+		}
+		reader = bytes.NewReader(js)
+	}
+	req, err := http.NewRequest(method, c.BaseURL+path, reader)
+	if err != nil {
+		t.Fatalf("apitest: %v", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		t.Fatalf("apitest: %s %s: %v", method, path, err)
+	}
+	defer res.Body.Close()
+
+	resp := &Response{Status: res.StatusCode}
+	resp.Body, err = io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("apitest: reading %s %s: %v", method, path, err)
+	}
+	if strings.HasPrefix(res.Header.Get("Content-Type"), "application/json") {
+		// Not every response is an envelope, so a mismatch here isn't an error.
+		_ = json.Unmarshal(resp.Body, resp)
+	}
+	return resp
+}
+
+// Register signs up a user with the given email address and password.
+func (c *Client) Register(t testing.TB, email, password string) *Response {
+	t.Helper()
+	username, _, _ := strings.Cut(email, "@")
+	return c.Do(t, http.MethodPost, "/v1/users", "", map[string]string{
+		"name":     "Test User",
+		"username": username,
+		"email":    email,
+		"password": password,
+	})
+}
+
+// Login requests an authentication token and returns the raw response alongside the
+// token, which is empty if the login failed.
+func (c *Client) Login(t testing.TB, email, password string) (string, *Response) {
+	t.Helper()
+	resp := c.Do(t, http.MethodPost, "/v1/tokens/authentication", "", map[string]string{
+		"email":    email,
+		"password": password,
+	})
+	var body struct {
+		Token struct {
+			Plaintext string `json:"token"`
+		} `json:"authentication_token"`
+	}
+	_ = json.Unmarshal(resp.Body, &body)
+	return body.Token.Plaintext, resp
+}
+
+// CreateAccount opens an account for the token's user and returns its number. It
+// fails the test unless the account was created.
+func (c *Client) CreateAccount(t testing.TB, token string) string {
+	t.Helper()
+	resp := c.Do(t, http.MethodPost, "/v1/accounts", token, data.Account{
+		Surname:     "User",
+		FirstName:   "Test",
+		HomeAddress: "1 Marina Road",
+		City:        "Lagos",
+		PhoneNumber: "08012345678",
+		BVN:         "22222222222",
+	})
+	if resp.Status != http.StatusCreated {
+		t.Fatalf("apitest: creating account: got %d %s", resp.Status, resp.Body)
+	}
+	var details data.AccountDetails
+	resp.Decode(t, &details)
+	return details.Account_number
+}
+
+// Pay posts a payment as the token's user.
+func (c *Client) Pay(t testing.TB, token string, payment data.Payment) *Response {
+	t.Helper()
+	return c.Do(t, http.MethodPost, "/v1/transactions", token, payment)
+}
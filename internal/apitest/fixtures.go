@@ -0,0 +1,74 @@
+package apitest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ebitezion/backend-framework/internal/data"
+	"gopkg.in/guregu/null.v4"
+)
+
+// Password is the password every fixture user is created with.
+const Password = "pa55word1234"
+
+// NewUser inserts an activated user with the fixture password. The username is the
+// local part of the email address.
+func NewUser(t testing.TB, models data.Models, email string) *data.User {
+	t.Helper()
+	username, _, _ := strings.Cut(email, "@")
+	user := &data.User{
+		Name:      "Test User",
+		Username:  username,
+		Email:     email,
+		Activated: null.BoolFrom(true),
+	}
+	err := user.Password.Set(Password)
+	if err != nil {
+		t.Fatalf("apitest: setting password: %v", err)
+	}
+	err = models.Users.Insert(context.Background(), user)
+	if err != nil {
+		t.Fatalf("apitest: inserting user %s: %v", email, err)
+	}
+	return user
+}
+
+// NewToken issues an authentication token for the user and returns its plaintext.
+func NewToken(t testing.TB, models data.Models, user *data.User) string {
+	t.Helper()
+	token, err := models.Tokens.New(context.Background(), user.ID, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatalf("apitest: issuing token for user %d: %v", user.ID, err)
+	}
+	return token.Plaintext
+}
+
+// NewAccount gives the user an account with the default limits and, if balance is
+// positive, funds it with a completed credit.
+func NewAccount(t testing.TB, models data.Models, user *data.User, accountNumber string, balance float64) {
+	t.Helper()
+	ctx := context.Background()
+	err := models.AccountModel.SaveCreatedAccountNo(ctx, &data.AccountDetails{User_id: int(user.ID), Account_number: accountNumber})
+	if err != nil {
+		t.Fatalf("apitest: saving account %s: %v", accountNumber, err)
+	}
+	if balance <= 0 {
+		return
+	}
+	err = models.Transactions.PostTransaction(ctx, &data.Transaction{
+		UserID:            uint64(user.ID),
+		Type:              string(data.Credit),
+		Source:            "apitest",
+		Narration:         "opening balance",
+		AccountNumber:     accountNumber,
+		InternalReference: fmt.Sprintf("OPEN%s", accountNumber),
+		Amount:            balance,
+		Status:            data.Completed,
+	})
+	if err != nil {
+		t.Fatalf("apitest: funding account %s: %v", accountNumber, err)
+	}
+}
@@ -0,0 +1,20 @@
+// Package apitest has the pieces for end-to-end API tests: a third-party provider
+// that tests can script, fixtures that seed the data models directly, and an HTTP
+// client with helpers for the common flows (register, log in, open an account, pay).
+//
+// The application itself is booted by the tests in cmd/api, since routes() lives in
+// package main.
+package apitest
+
+import (
+	"testing"
+
+	"github.com/ebitezion/backend-framework/internal/mock"
+)
+
+// NewProvider starts a mock payment provider. It is closed when the test finishes.
+func NewProvider(t testing.TB) *mock.Server {
+	server := mock.Start()
+	t.Cleanup(server.Close)
+	return server
+}
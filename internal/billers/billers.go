@@ -0,0 +1,225 @@
+// Package billers is the catalogue of billers that customers can pay from their
+// accounts: airtime, data, electricity and TV subscriptions. Billers come from
+// providers, each an aggregator or a biller's own API behind the Provider interface,
+// so that one can be added or swapped without touching the payment pipeline.
+package billers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Biller categories.
+const (
+	CategoryAirtime     = "airtime"
+	CategoryData        = "data"
+	CategoryElectricity = "electricity"
+	CategoryTV          = "tv"
+)
+
+// Categories are the biller categories, in the order they are shown.
+var Categories = []string{CategoryAirtime, CategoryData, CategoryElectricity, CategoryTV}
+
+// Biller is someone customers can pay, and the products they sell.
+type Biller struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Category string `json:"category"`
+	// CustomerField names what the biller knows its customers by, such as a phone
+	// number or meter number.
+	CustomerField string    `json:"customer_field"`
+	Products      []Product `json:"products"`
+}
+
+// Product returns the biller's product with the given code.
+func (b *Biller) Product(code string) (Product, bool) {
+	for _, product := range b.Products {
+		if product.Code == code {
+			return product, true
+		}
+	}
+	return Product{}, false
+}
+
+// Product is something a biller sells. Amount is its fixed price, or 0 if the
+// customer chooses how much to pay, as for airtime or prepaid electricity.
+type Product struct {
+	Code   string `json:"code"`
+	Name   string `json:"name"`
+	Amount int64  `json:"amount"`
+}
+
+// Customer is a customer of a biller, as the biller knows them.
+type Customer struct {
+	BillerID   string `json:"biller_id"`
+	CustomerID string `json:"customer_id"`
+	Name       string `json:"name"`
+}
+
+// Payment is a payment to a biller for one of its customers. Reference is our own
+// reference for the payment; a provider must turn down a second payment with the
+// same reference.
+type Payment struct {
+	Reference   string
+	BillerID    string
+	ProductCode string
+	CustomerID  string
+	Amount      int64
+}
+
+// Receipt is a payment a biller accepted. Token is what the customer needs to use
+// what they paid for, such as a prepaid electricity token; most payments have none.
+type Receipt struct {
+	BillerReference string
+	Token           string
+}
+
+// Provider is a source of billers that payments can be made through.
+type Provider interface {
+	// Name identifies the provider. It is stored with each payment, so that the
+	// payment's outcome is asked of the provider that made it.
+	Name() string
+	// Billers returns the billers the provider can pay.
+	Billers(ctx context.Context) ([]Biller, error)
+	// ValidateCustomer looks up a customer of a biller, returning ErrCustomerNotFound
+	// for a customer the biller doesn't know.
+	ValidateCustomer(ctx context.Context, billerID, customerID string) (*Customer, error)
+	// Pay makes a payment. An error that wraps ErrDeclined means the payment was not
+	// made; any other error leaves its outcome open, to be learned from Status.
+	Pay(ctx context.Context, payment *Payment) (*Receipt, error)
+	// Status returns the receipt of an earlier payment by its reference. As with Pay,
+	// an error that wraps ErrDeclined means the payment was not made.
+	Status(ctx context.Context, reference string) (*Receipt, error)
+}
+
+var (
+	// ErrUnknownBiller is returned for a biller that no provider has.
+	ErrUnknownBiller = errors.New("billers: unknown biller")
+	// ErrUnknownProvider is returned for a provider that isn't registered.
+	ErrUnknownProvider = errors.New("billers: unknown provider")
+	// ErrCustomerNotFound is returned for a customer the biller doesn't know.
+	ErrCustomerNotFound = errors.New("billers: customer not found")
+	// ErrDeclined is wrapped by the error for a payment that was not made.
+	ErrDeclined = errors.New("billers: payment declined")
+)
+
+// Catalogue is the billers of every registered provider. Each provider's billers are
+// cached, since they rarely change; if a provider's list can't be refreshed, the
+// stale copy is served instead. A biller ID offered by more than one provider is
+// paid through the first to be registered.
+type Catalogue struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries []*entry
+}
+
+// entry is a provider and its cached billers.
+type entry struct {
+	provider Provider
+	billers  []Biller
+	expires  time.Time
+}
+
+// NewCatalogue returns a catalogue of the providers' billers, cached for ttl.
+func NewCatalogue(ttl time.Duration, providers ...Provider) *Catalogue {
+	c := &Catalogue{ttl: ttl}
+	for _, provider := range providers {
+		c.Register(provider)
+	}
+	return c
+}
+
+// Register adds a provider to the catalogue.
+func (c *Catalogue) Register(provider Provider) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = append(c.entries, &entry{provider: provider})
+}
+
+// Billers returns the billers in category, or every biller if category is empty.
+// An error is only returned if no provider's billers could be loaded. Callers must
+// not modify the result.
+func (c *Catalogue) Billers(ctx context.Context, category string) ([]Biller, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	billers := []Biller{}
+	seen := map[string]bool{}
+	var firstErr error
+	for _, e := range c.entries {
+		list, err := c.load(ctx, e)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		for _, biller := range list {
+			if seen[biller.ID] {
+				continue
+			}
+			seen[biller.ID] = true
+			if category == "" || biller.Category == category {
+				billers = append(billers, biller)
+			}
+		}
+	}
+	if len(seen) == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+	return billers, nil
+}
+
+// load returns a provider's billers, fetching them if the cached copy has expired.
+// The caller must hold c.mu.
+func (c *Catalogue) load(ctx context.Context, e *entry) ([]Biller, error) {
+	if e.billers != nil && time.Now().Before(e.expires) {
+		return e.billers, nil
+	}
+	billers, err := e.provider.Billers(ctx)
+	if err != nil {
+		return e.billers, err
+	}
+	if billers == nil {
+		billers = []Biller{}
+	}
+	e.billers = billers
+	e.expires = time.Now().Add(c.ttl)
+	return billers, nil
+}
+
+// Biller returns the biller with the given ID and the provider that pays it.
+func (c *Catalogue) Biller(ctx context.Context, id string) (*Biller, Provider, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var firstErr error
+	for _, e := range c.entries {
+		list, err := c.load(ctx, e)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		for i := range list {
+			if list[i].ID == id {
+				biller := list[i]
+				return &biller, e.provider, nil
+			}
+		}
+	}
+	if firstErr != nil {
+		return nil, nil, firstErr
+	}
+	return nil, nil, ErrUnknownBiller
+}
+
+// Provider returns the registered provider with the given name.
+func (c *Catalogue) Provider(name string) (Provider, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range c.entries {
+		if e.provider.Name() == name {
+			return e.provider, nil
+		}
+	}
+	return nil, ErrUnknownProvider
+}
@@ -0,0 +1,115 @@
+package billers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeProvider serves a fixed list of billers, or fails while err is set.
+type fakeProvider struct {
+	name    string
+	billers []Biller
+	err     error
+	calls   int
+}
+
+func (p *fakeProvider) Name() string { return p.name }
+
+func (p *fakeProvider) Billers(context.Context) ([]Biller, error) {
+	p.calls++
+	if p.err != nil {
+		return nil, p.err
+	}
+	return p.billers, nil
+}
+
+func (p *fakeProvider) ValidateCustomer(context.Context, string, string) (*Customer, error) {
+	return nil, ErrCustomerNotFound
+}
+
+func (p *fakeProvider) Pay(context.Context, *Payment) (*Receipt, error) {
+	return &Receipt{}, nil
+}
+
+func (p *fakeProvider) Status(context.Context, string) (*Receipt, error) {
+	return &Receipt{}, nil
+}
+
+func TestCatalogue(t *testing.T) {
+	ctx := context.Background()
+	first := &fakeProvider{name: "first", billers: []Biller{
+		{ID: "mtn-airtime", Category: CategoryAirtime},
+		{ID: "dstv", Category: CategoryTV, Products: []Product{{Code: "compact", Amount: 12500}}},
+	}}
+	second := &fakeProvider{name: "second", billers: []Biller{
+		{ID: "dstv", Category: CategoryTV},
+		{ID: "ikedc-prepaid", Category: CategoryElectricity},
+	}}
+	c := NewCatalogue(time.Hour, first, second)
+
+	all, err := c.Billers(ctx, "")
+	if err != nil || len(all) != 3 {
+		t.Fatalf("got %+v, %v", all, err)
+	}
+	tv, err := c.Billers(ctx, CategoryTV)
+	if err != nil || len(tv) != 1 || len(tv[0].Products) != 1 {
+		t.Errorf("tv: got %+v, %v", tv, err)
+	}
+	if first.calls != 1 || second.calls != 1 {
+		t.Errorf("got %d and %d calls, want the billers cached", first.calls, second.calls)
+	}
+
+	// A biller offered twice is paid through the first provider.
+	biller, provider, err := c.Biller(ctx, "dstv")
+	if err != nil || provider.Name() != "first" {
+		t.Fatalf("got %+v, %v", provider, err)
+	}
+	if product, ok := biller.Product("compact"); !ok || product.Amount != 12500 {
+		t.Errorf("got product %+v", product)
+	}
+	if _, _, err := c.Biller(ctx, "unknown"); !errors.Is(err, ErrUnknownBiller) {
+		t.Errorf("unknown biller: got %v", err)
+	}
+
+	if p, err := c.Provider("second"); err != nil || p != second {
+		t.Errorf("provider: got %v, %v", p, err)
+	}
+	if _, err := c.Provider("third"); !errors.Is(err, ErrUnknownProvider) {
+		t.Errorf("unknown provider: got %v", err)
+	}
+}
+
+func TestCatalogueProviderFailures(t *testing.T) {
+	ctx := context.Background()
+	failing := &fakeProvider{name: "failing", err: errors.New("unavailable")}
+	working := &fakeProvider{name: "working", billers: []Biller{{ID: "mtn-data", Category: CategoryData}}}
+
+	// A failing provider doesn't hide the others' billers.
+	c := NewCatalogue(time.Hour, failing, working)
+	billers, err := c.Billers(ctx, "")
+	if err != nil || len(billers) != 1 {
+		t.Errorf("got %+v, %v", billers, err)
+	}
+	if _, _, err := c.Biller(ctx, "mtn-data"); err != nil {
+		t.Errorf("biller: got %v", err)
+	}
+
+	// With nothing loaded at all, the error comes through.
+	if _, err := NewCatalogue(time.Hour, failing).Billers(ctx, ""); err == nil {
+		t.Error("got no error")
+	}
+
+	// Once loaded, the stale copy is served while the provider is down.
+	working.calls = 0
+	c = NewCatalogue(0, working)
+	if _, err := c.Billers(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	working.err = errors.New("unavailable")
+	billers, err = c.Billers(ctx, "")
+	if err != nil || len(billers) != 1 || working.calls != 2 {
+		t.Errorf("stale: got %+v, %v after %d calls", billers, err, working.calls)
+	}
+}
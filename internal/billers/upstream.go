@@ -0,0 +1,98 @@
+package billers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	thirdparty "github.com/ebitezion/backend-framework/internal/third_party"
+	"github.com/shopspring/decimal"
+)
+
+// Upstream is the Provider for the bills API that thirdparty is configured with.
+type Upstream struct{}
+
+// Name implements Provider.
+func (Upstream) Name() string {
+	return "upstream"
+}
+
+// Billers implements Provider. A product price that can't be read is treated as
+// open, so the customer is asked for the amount.
+func (Upstream) Billers(ctx context.Context) ([]Biller, error) {
+	list, err := thirdparty.GetBillers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	billers := make([]Biller, 0, len(list))
+	for _, b := range list {
+		biller := Biller{
+			ID:            b.ID,
+			Name:          b.Name,
+			Category:      b.Category,
+			CustomerField: b.CustomerField,
+			Products:      make([]Product, 0, len(b.Products)),
+		}
+		for _, p := range b.Products {
+			product := Product{Code: p.Code, Name: p.Name}
+			if amount, err := decimal.NewFromString(p.Amount); err == nil {
+				product.Amount = amount.Ceil().IntPart()
+			}
+			biller.Products = append(biller.Products, product)
+		}
+		billers = append(billers, biller)
+	}
+	return billers, nil
+}
+
+// ValidateCustomer implements Provider.
+func (Upstream) ValidateCustomer(ctx context.Context, billerID, customerID string) (*Customer, error) {
+	customer, err := thirdparty.ValidateBillCustomer(ctx, &thirdparty.BillCustomerRequest{
+		BillerID:   billerID,
+		CustomerID: customerID,
+	})
+	var apiErr *thirdparty.APIError
+	switch {
+	case errors.As(err, &apiErr) && apiErr.Declined():
+		return nil, ErrCustomerNotFound
+	case err != nil:
+		return nil, err
+	}
+	return &Customer{BillerID: billerID, CustomerID: customer.CustomerID, Name: customer.CustomerName}, nil
+}
+
+// Pay implements Provider. The upstream error is kept in the chain, so that callers
+// can still tell a payment that was turned down from one that couldn't be sent.
+func (Upstream) Pay(ctx context.Context, payment *Payment) (*Receipt, error) {
+	paid, err := thirdparty.PayBill(ctx, &thirdparty.BillPaymentRequest{
+		BillerID:         payment.BillerID,
+		ProductCode:      payment.ProductCode,
+		CustomerID:       payment.CustomerID,
+		Amount:           decimal.NewFromInt(payment.Amount).StringFixed(2),
+		PaymentReference: payment.Reference,
+	})
+	var apiErr *thirdparty.APIError
+	switch {
+	case errors.As(err, &apiErr) && !apiErr.Indeterminate():
+		return nil, fmt.Errorf("%w: %w", ErrDeclined, err)
+	case err != nil:
+		return nil, err
+	}
+	return &Receipt{BillerReference: paid.BillerReference, Token: paid.Token}, nil
+}
+
+// Status implements Provider.
+func (Upstream) Status(ctx context.Context, reference string) (*Receipt, error) {
+	paid, err := thirdparty.BillPaymentStatus(ctx, reference)
+	var apiErr *thirdparty.APIError
+	switch {
+	case errors.As(err, &apiErr) && apiErr.Declined() && !apiErr.Indeterminate():
+		// Only an answer about the payment itself settles it; a query that failed
+		// leaves it open.
+		return nil, fmt.Errorf("%w: %w", ErrDeclined, err)
+	case err != nil:
+		return nil, err
+	}
+	return &Receipt{BillerReference: paid.BillerReference, Token: paid.Token}, nil
+}
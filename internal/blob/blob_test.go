@@ -0,0 +1,53 @@
+package blob
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLocalStore(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStore failed: %v", err)
+	}
+
+	t.Run("Put then Get", func(t *testing.T) {
+		err := store.Put("upgrades/1/id.pdf", strings.NewReader("%PDF-1.4"))
+		if err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+
+		rc, err := store.Get("upgrades/1/id.pdf")
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		defer rc.Close()
+
+		body, _ := io.ReadAll(rc)
+		if string(body) != "%PDF-1.4" {
+			t.Errorf("Unexpected contents: got %q", body)
+		}
+	})
+
+	t.Run("Delete removes the object", func(t *testing.T) {
+		err := store.Delete("upgrades/1/id.pdf")
+		if err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		_, err = store.Get("upgrades/1/id.pdf")
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("Unexpected error: got %v, want %v", err, ErrNotFound)
+		}
+	})
+
+	t.Run("Keys cannot escape the root", func(t *testing.T) {
+		for _, key := range []string{"", "../secret", "/etc/passwd"} {
+			err := store.Put(key, strings.NewReader("x"))
+			if !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("Put(%q): got %v, want %v", key, err, ErrInvalidKey)
+			}
+		}
+	})
+}
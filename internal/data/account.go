@@ -3,10 +3,11 @@ package data
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
-	"fmt"
-	"strconv"
 	"time"
+
+	"github.com/shopspring/decimal"
 )
 
 type Email struct {
@@ -39,21 +40,29 @@ type AccountNumber struct {
 }
 
 type AccountUpgradeData struct {
-	Title         string `json:"title"`
-	FirstName     string `json:"firstName"`
-	LastName      string `json:"lastName"`
-	MiddleName    string `json:"middleName"`
-	AccountNumber string `json:"accountNumber"`
-	BVN           string `json:"bvn"`
-	DOB           string `json:"dob"`
-	PhoneNumber   string `json:"phoneNumber"`
-	Email         string `json:"email"`
-	MaidenName    string `json:"maidenName"`
-	Nationality   string `json:"nationality"`
-	Country       string `json:"country"`
-	Address       string `json:"address"`
-	IdType        string `json:"idType"`
-	Document      string `json:"document"`
+	ID            int64   `json:"id"`
+	UserID        int64   `json:"userID"`
+	Title         string  `json:"title"`
+	FirstName     string  `json:"firstName"`
+	LastName      string  `json:"lastName"`
+	MiddleName    string  `json:"middleName"`
+	AccountNumber string  `json:"accountNumber"`
+	BVN           string  `json:"bvn"`
+	DOB           string  `json:"dob"`
+	PhoneNumber   string  `json:"phoneNumber"`
+	Email         string  `json:"email"`
+	MaidenName    string  `json:"maidenName"`
+	Nationality   string  `json:"nationality"`
+	Country       string  `json:"country"`
+	Address       string  `json:"address"`
+	IdType        string  `json:"idType"`
+	Document      string  `json:"document"`
+	UtilityBill   string  `json:"utilityBill"`
+	Status        string  `json:"status"`
+	ReviewedBy    *int64  `json:"reviewedBy"`
+	ReviewNote    string  `json:"reviewNote"`
+	CreatedAt     string  `json:"createdAt"`
+	ReviewedAt    *string `json:"reviewedAt"`
 }
 type AccountProfile struct {
 	TransferTag    *string `json:"transferTag"`
@@ -66,6 +75,17 @@ type AccountProfile struct {
 	KycLevel       *string `json:"kycLevel"`
 	Username       *string `json:"username"`
 }
+
+// AccountHolder is the owner of one of our accounts, as someone sending money to it
+// sees them. BVN is only known once an account upgrade has been approved.
+type AccountHolder struct {
+	UserID        int64  `json:"-"`
+	AccountNumber string `json:"accountNumber"`
+	Name          string `json:"accountName"`
+	KYCLevel      int    `json:"kycLevel"`
+	BVN           string `json:"-"`
+}
+
 type Transaction struct {
 	ID                uint64   `json:"id"`
 	UserID            uint64   `json:"user_id"`
@@ -114,8 +134,133 @@ type UssdLimits struct {
 	Daily  int64 `json:"daily"`
 }
 
+// Limit channels. Each payment counts against the bucket of Limits and LimitCounts
+// for the channel it was made on.
+const (
+	ChannelTransfers = "transfers"
+	ChannelBills     = "bills"
+	ChannelUSSD      = "ussd"
+)
+
+// For returns the single and daily limits for the channel.
+func (l Limits) For(channel string) (single, daily int64) {
+	switch channel {
+	case ChannelBills:
+		return l.Bills.Single, l.Bills.Daily
+	case ChannelUSSD:
+		return l.Ussd.Single, l.Ussd.Daily
+	default:
+		return l.Transfers.Single, l.Transfers.Daily
+	}
+}
+
+var (
+	ErrSingleLimitExceeded = errors.New("single payment limit exceeded")
+	ErrDailyLimitExceeded  = errors.New("daily payment limit exceeded")
+)
+
+// Check counts a payment of amount on channel against the limits, given what has
+// been paid on each channel today. It returns the counts with the payment added, or
+// ErrSingleLimitExceeded or ErrDailyLimitExceeded if the payment breaks either limit.
+func (l Limits) Check(counter LimitCounts, channel string, amount int) (LimitCounts, error) {
+	single, daily := l.For(channel)
+	if int64(amount) > single {
+		return counter, ErrSingleLimitExceeded
+	}
+	charged := counter
+	charged.Add(channel, amount)
+	if int64(charged.For(channel)) > daily {
+		return counter, ErrDailyLimitExceeded
+	}
+	return charged, nil
+}
+
+// Release takes a payment of amount on channel back out of the counts, as when the
+// payment fails, without going below zero.
+func (c *LimitCounts) Release(channel string, amount int) {
+	if amount > c.For(channel) {
+		amount = c.For(channel)
+	}
+	c.Add(channel, -amount)
+}
+
+// LimitAmount is what a debit of amount counts against a limit: limits are in whole
+// units, so any part of a unit counts as a whole one.
+func LimitAmount(amount float64) int {
+	return int(decimal.NewFromFloat(amount).Ceil().IntPart())
+}
+
+// chargeLimit counts a debit of amount on channel against the limits of the account,
+// in tx. The account's user_details row must already be locked, as lockBalance does,
+// so that concurrent debits can't both fit under a daily limit that only has room
+// for one. A channel of "" isn't limited.
+func chargeLimit(ctx context.Context, tx *Tx, accountNumber, channel string, amount float64) error {
+	if channel == "" {
+		return nil
+	}
+	limits, counter, err := readLimits(ctx, tx, accountNumber)
+	if err != nil {
+		return err
+	}
+	counter, err = limits.Check(counter, channel, LimitAmount(amount))
+	if err != nil {
+		return err
+	}
+	return saveCounter(ctx, tx, accountNumber, counter)
+}
+
+// releaseLimit gives back what the failed debit with the given internal reference
+// counted against its payer's limits, in tx, with the payer's user_details row
+// already locked.
+func releaseLimit(ctx context.Context, tx *Tx, reference string) error {
+	var channel, accountNumber string
+	var amount float64
+	err := tx.QueryRowContext(ctx, `SELECT limit_channel, account_number, amount FROM transactions WHERE internal_reference = ?`, reference).Scan(&channel, &accountNumber, &amount)
+	if err != nil || channel == "" {
+		return err
+	}
+	_, counter, err := readLimits(ctx, tx, accountNumber)
+	if err != nil {
+		return err
+	}
+	counter.Release(channel, LimitAmount(amount))
+	return saveCounter(ctx, tx, accountNumber, counter)
+}
+
+func readLimits(ctx context.Context, tx *Tx, accountNumber string) (Limits, LimitCounts, error) {
+	var limits Limits
+	var counter LimitCounts
+	var limitsJSON, counterJSON string
+	err := tx.QueryRowContext(ctx, `SELECT limits, counter FROM user_details WHERE account_number = ?`, accountNumber).Scan(&limitsJSON, &counterJSON)
+	if err != nil {
+		return limits, counter, err
+	}
+	err = json.Unmarshal([]byte(limitsJSON), &limits)
+	if err != nil {
+		return limits, counter, err
+	}
+	err = json.Unmarshal([]byte(counterJSON), &counter)
+	return limits, counter, err
+}
+
+func saveCounter(ctx context.Context, tx *Tx, accountNumber string, counter LimitCounts) error {
+	encoded, err := json.Marshal(counter)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE user_details SET counter = ? WHERE account_number = ?`, string(encoded), accountNumber)
+	return err
+}
+
+// DefaultLimits and DefaultCounter are the limits and usage counts a new account
+// starts out with.
+const (
+	DefaultLimits  = `{"transfers":{"single":200000,"daily":600000},"bills":{"single":100000,"daily":200000},"ussd":{"single":10000,"daily":20000}}`
+	DefaultCounter = `{"transfers": 0, "bills": 0, "ussd": 0, "ibank": 0}`
+)
+
 type AccountModel struct {
-	DB *sql.DB
+	DB *DB
 }
 type AccountHistoryResponse struct {
 	AccountHistory  []AccountStatementData `json:"accountHistory"`
@@ -132,7 +277,7 @@ type AccountStatementData struct {
 	TransactionType   string `json:"transactionType"`
 }
 
-func (m AccountModel) UpdateLimitStatus(LimitID int) error {
+func (m AccountModel) UpdateLimitStatus(ctx context.Context, LimitID int) error {
 
 	// Update the user's activated column in the database
 	query := `
@@ -142,7 +287,7 @@ func (m AccountModel) UpdateLimitStatus(LimitID int) error {
 		Completed,
 		LimitID,
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, args...)
 
@@ -157,7 +302,7 @@ func (m AccountModel) UpdateLimitStatus(LimitID int) error {
 	return nil
 }
 
-func (m AccountModel) UpdateLimitInDB(limit string, userID string) error {
+func (m AccountModel) UpdateLimitInDB(ctx context.Context, limit string, userID string) error {
 	// Update the user's activated column in the database
 	query := `
 	UPDATE user_details SET limits = ? WHERE user_id = ? 
@@ -167,7 +312,7 @@ func (m AccountModel) UpdateLimitInDB(limit string, userID string) error {
 		limit,
 		userID,
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, args...)
 
@@ -182,20 +327,24 @@ func (m AccountModel) UpdateLimitInDB(limit string, userID string) error {
 	return nil
 }
 
-func (m AccountModel) UpdateLimitCounterInDB(count string, userID string) error {
-	// Update the user's activated column in the database
+// UpdateLimitCounterInDB saves the user's usage counts. Every bucket is written, so
+// counter must be the user's full counts with the latest payment added.
+func (m AccountModel) UpdateLimitCounterInDB(ctx context.Context, counter LimitCounts, userID string) error {
+	encoded, err := json.Marshal(counter)
+	if err != nil {
+		return err
+	}
 	query := `
 	UPDATE user_details SET counter = ? WHERE user_id = ? 
 	`
-	var counter string = `{"transfers":` + count + `, "bills": 0, "ussd": 0, "ibank": 0}`
 	args := []interface{}{
-		counter,
+		string(encoded),
 		userID,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
-	_, err := m.DB.ExecContext(ctx, query, args...)
+	_, err = m.DB.ExecContext(ctx, query, args...)
 
 	if err != nil {
 		switch {
@@ -208,7 +357,7 @@ func (m AccountModel) UpdateLimitCounterInDB(count string, userID string) error
 	return nil
 }
 
-func (m AccountModel) GetAccountLimits(userID string) (string, error) {
+func (m AccountModel) GetAccountLimits(ctx context.Context, userID string) (string, error) {
 
 	// Set up the SQL query.
 	query := `
@@ -217,7 +366,7 @@ func (m AccountModel) GetAccountLimits(userID string) (string, error) {
 	// Create a slice containing the query arguments.
 	args := []interface{}{userID}
 	var limits string
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
 
 	// Execute the query, scanning the return values into a User struct.
@@ -236,7 +385,7 @@ func (m AccountModel) GetAccountLimits(userID string) (string, error) {
 	return limits, nil
 }
 
-func (m AccountModel) GetUserAccountNoByID(userID string) (string, error) {
+func (m AccountModel) GetUserAccountNoByID(ctx context.Context, userID string) (string, error) {
 
 	// Set up the SQL query.
 	query := `
@@ -245,7 +394,7 @@ func (m AccountModel) GetUserAccountNoByID(userID string) (string, error) {
 	// Create a slice containing the query arguments.
 	args := []interface{}{userID}
 	var accountNo string
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
 
 	// Execute the query, scanning the return values into a User struct.
@@ -264,7 +413,7 @@ func (m AccountModel) GetUserAccountNoByID(userID string) (string, error) {
 	return accountNo, nil
 }
 
-func (m AccountModel) GetLimitUpgradeRequest(LimitID int) (*UpgradeLimitRequest, error) {
+func (m AccountModel) GetLimitUpgradeRequest(ctx context.Context, LimitID int) (*UpgradeLimitRequest, error) {
 
 	// Set up the SQL query.
 	query := `
@@ -273,7 +422,7 @@ func (m AccountModel) GetLimitUpgradeRequest(LimitID int) (*UpgradeLimitRequest,
 	// Create a slice containing the query arguments.
 	args := []interface{}{LimitID}
 	var limits UpgradeLimitRequest
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
 
 	// Execute the query, scanning the return values into a User struct.
@@ -296,7 +445,7 @@ func (m AccountModel) GetLimitUpgradeRequest(LimitID int) (*UpgradeLimitRequest,
 }
 
 // NewAaccountUpgrade inserts account upgrade data into the database.
-func (a AccountModel) CreateNewLimitRequest(data *UpgradeLimitRequest) error {
+func (a AccountModel) CreateNewLimitRequest(ctx context.Context, data *UpgradeLimitRequest) error {
 
 	query := `
 	INSERT INTO limit_upgrade_requests (user_id,type,amount,daily)
@@ -307,31 +456,35 @@ func (a AccountModel) CreateNewLimitRequest(data *UpgradeLimitRequest) error {
 		data.Single,
 		data.Daily,
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, a.DB.Timeout)
 	defer cancel()
 	_, err := a.DB.ExecContext(ctx, query, args...)
 	return err
 }
-func (a AccountModel) NewMaillingList(email string) error {
+func (a AccountModel) NewMaillingList(ctx context.Context, email string) error {
 
 	query := `
 	INSERT INTO mailing_list (email)
-	VALUES (?, ?)`
+	VALUES (?)`
 	args := []interface{}{
 		email,
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, a.DB.Timeout)
 	defer cancel()
 	_, err := a.DB.ExecContext(ctx, query, args...)
 	return err
 }
 
-// NewAaccountUpgrade inserts account upgrade data into the database.
-func (a AccountModel) NewAccountUpgrade(data *AccountUpgradeData) error {
+// NewAaccountUpgrade inserts account upgrade data into the database. The request
+// starts out in the pending state and waits in the review queue until an operator
+// approves or rejects it.
+func (a AccountModel) NewAccountUpgrade(ctx context.Context, data *AccountUpgradeData) error {
 	query := `
-	INSERT INTO account_upgrade (title, first_name, last_name, middle_name, account_number, bvn, dob, phone_number, email, maiden_name, nationality, country, address, id_type, document)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	INSERT INTO account_upgrade (user_id, title, first_name, last_name, middle_name, account_number, bvn, dob, phone_number, email, maiden_name, nationality, country, address, id_type, document, utility_bill, status)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	data.Status = Pending
 	args := []interface{}{
+		data.UserID,
 		data.Title,
 		data.FirstName,
 		data.LastName,
@@ -347,63 +500,180 @@ func (a AccountModel) NewAccountUpgrade(data *AccountUpgradeData) error {
 		data.Address,
 		data.IdType,
 		data.Document,
+		data.UtilityBill,
+		data.Status,
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, a.DB.Timeout)
 	defer cancel()
-	_, err := a.DB.ExecContext(ctx, query, args...)
+	var err error
+	data.ID, err = insertID(ctx, a.DB, query, args...)
 	return err
 }
 
-func (a AccountModel) GetAccountHistory(accountNumber string, pagination string) ([]Transaction, error) {
-
-	// Calculate the offset based on the pagination parameter.
-	number, err := strconv.Atoi(pagination)
+// accountUpgradeColumns lists the columns scanned by scanAccountUpgrade, in order.
+const accountUpgradeColumns = `id, user_id, title, first_name, last_name, middle_name, account_number, bvn, dob, phone_number, email, maiden_name, nationality, country, address, id_type, document, utility_bill, status, reviewed_by, review_note, created_at, reviewed_at`
+
+// scanAccountUpgrade scans a single account_upgrade row selected with
+// accountUpgradeColumns.
+func scanAccountUpgrade(row interface{ Scan(...interface{}) error }) (*AccountUpgradeData, error) {
+	var upgrade AccountUpgradeData
+	var reviewNote sql.NullString
+	err := row.Scan(
+		&upgrade.ID,
+		&upgrade.UserID,
+		&upgrade.Title,
+		&upgrade.FirstName,
+		&upgrade.LastName,
+		&upgrade.MiddleName,
+		&upgrade.AccountNumber,
+		&upgrade.BVN,
+		&upgrade.DOB,
+		&upgrade.PhoneNumber,
+		&upgrade.Email,
+		&upgrade.MaidenName,
+		&upgrade.Nationality,
+		&upgrade.Country,
+		&upgrade.Address,
+		&upgrade.IdType,
+		&upgrade.Document,
+		&upgrade.UtilityBill,
+		&upgrade.Status,
+		&upgrade.ReviewedBy,
+		&reviewNote,
+		&upgrade.CreatedAt,
+		&upgrade.ReviewedAt,
+	)
 	if err != nil {
 		return nil, err
 	}
-	offset := (number - 1) * 10
+	upgrade.ReviewNote = reviewNote.String
+	return &upgrade, nil
+}
 
-	query := "SELECT id, user_id, type, source, narration, account_number, request_id, internal_reference, external_reference, amount, created_at, updated_at, status, commission, balance_after FROM transactions WHERE account_number = ? ORDER BY created_at DESC LIMIT 10 OFFSET ?"
+// GetAccountUpgrade returns a single account upgrade request by its id.
+func (a AccountModel) GetAccountUpgrade(ctx context.Context, id int64) (*AccountUpgradeData, error) {
+	query := `SELECT ` + accountUpgradeColumns + ` FROM account_upgrade WHERE id = ?`
 
-	var transactions []Transaction // Slice to hold multiple transaction records.
+	ctx, cancel := context.WithTimeout(ctx, a.DB.Timeout)
+	defer cancel()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	upgrade, err := scanAccountUpgrade(a.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return upgrade, nil
+}
+
+// GetAccountUpgradesByStatus returns the review queue: every account upgrade request
+// in the given status, oldest first.
+func (a AccountModel) GetAccountUpgradesByStatus(ctx context.Context, status string) ([]*AccountUpgradeData, error) {
+	query := `SELECT ` + accountUpgradeColumns + ` FROM account_upgrade WHERE status = ? ORDER BY created_at ASC, id ASC`
+
+	ctx, cancel := context.WithTimeout(ctx, a.DB.Timeout)
 	defer cancel()
 
-	// Use the QueryContext method to execute the query, passing in the context.
-	rows, err := a.DB.QueryContext(ctx, query, accountNumber, offset)
+	rows, err := a.DB.QueryContext(ctx, query, status)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	// Iterate through the result set and scan each row into a Transaction struct.
+	upgrades := []*AccountUpgradeData{}
 	for rows.Next() {
-		var t Transaction
-		err := rows.Scan(&t.ID, &t.UserID, &t.Type, &t.Source, &t.Narration, &t.AccountNumber, &t.RequestID, &t.InternalReference, &t.ExternalReference, &t.Amount, &t.CreatedAt, &t.UpdatedAt, &t.Status, &t.Commission, &t.BalanceAfter)
+		upgrade, err := scanAccountUpgrade(rows)
 		if err != nil {
 			return nil, err
 		}
-		transactions = append(transactions, t)
+		upgrades = append(upgrades, upgrade)
 	}
-	if len(transactions) == 0 {
-		return []Transaction{}, ErrRecordNotFound // Return empty slice if no transactions found.
+	if err = rows.Err(); err != nil {
+		return nil, err
 	}
-	return transactions, nil
+	return upgrades, nil
+}
+
+// ReviewAccountUpgrade moves a pending account upgrade request to either the approved
+// or the rejected state. Only pending requests can be reviewed; if the request has
+// already been reviewed (possibly by another operator at the same time) we return an
+// ErrEditConflict error. Approval raises the user to KYC level 4 and marks their
+// account as upgraded and their address as verified, since the utility bill doubles
+// as proof of address, and emits the kyc.upgraded webhook event, all in the same
+// database transaction as the review.
+func (a AccountModel) ReviewAccountUpgrade(ctx context.Context, upgrade *AccountUpgradeData, status string, reviewerID int64, note string) error {
+	query := `
+	UPDATE account_upgrade
+	SET status = ?, reviewed_by = ?, review_note = ?, reviewed_at = NOW()
+	WHERE id = ? AND status = ?`
+
+	ctx, cancel := context.WithTimeout(ctx, a.DB.Timeout)
+	defer cancel()
+
+	tx, err := a.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, status, reviewerID, note, upgrade.ID, Pending)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	if status == Approved {
+		query = `
+		UPDATE users
+		SET kyc_level = ?, account_upgraded = ?, address_verified = ?
+		WHERE id = ?`
+		_, err = tx.ExecContext(ctx, query, KYCLEVEL4, true, true, upgrade.UserID)
+		if err != nil {
+			return err
+		}
+
+		err = insertWebhookEvent(ctx, tx, upgrade.UserID, EventKYCUpgraded, map[string]interface{}{
+			"upgrade_id":     upgrade.ID,
+			"account_number": upgrade.AccountNumber,
+			"kyc_level":      KYCLEVEL4,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	upgrade.Status = status
+	upgrade.ReviewedBy = &reviewerID
+	upgrade.ReviewNote = note
+	return nil
 }
 
 // NewAaccountUpgrade inserts account upgrade data into the database.
-func (a AccountModel) SaveCreatedAccountNo(data *AccountDetails) error {
+func (a AccountModel) SaveCreatedAccountNo(ctx context.Context, data *AccountDetails) error {
 	query := `
 	INSERT INTO user_details(user_id, account_number, limits, created_at, updated_at, counter)
-	VALUES (?, ?, COALESCE(?, '{"transfers":{"single":200000,"daily":600000},"bills":{"single":100000,"daily":200000},"ussd":{"single":10000,"daily":20000}}'), COALESCE(?, NOW()), COALESCE(?, NOW()),?)`
+	VALUES (?, ?, ?, ?, ?, ?)`
 
 	// Set default values for limits, created_at, and updated_at if not provided
 	if data.Limits == "" {
-		data.Limits = `{"transfers":{"single":200000,"daily":600000},"bills":{"single":100000,"daily":200000},"ussd":{"single":10000,"daily":20000}}`
+		data.Limits = DefaultLimits
 	}
 	if data.Counter == "" {
-		data.Counter = `{"transfers": 0, "bills": 0, "ussd": 0, "ibank": 0}`
+		data.Counter = DefaultCounter
 	}
 	now := time.Now().Format("2006-01-02 15:04:05")
 	if data.Created_at == "" {
@@ -413,48 +683,32 @@ func (a AccountModel) SaveCreatedAccountNo(data *AccountDetails) error {
 		data.Updated_at = now
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, a.DB.Timeout)
 	defer cancel()
 
-	// Execute the query directly with the values
-	_, err := a.DB.ExecContext(ctx, query, data.User_id, data.Account_number, data.Limits, data.Created_at, data.Updated_at, data.Counter)
+	tx, err := a.DB.BeginTx(ctx, nil)
 	if err != nil {
-		fmt.Println("ERRRRR:", err)
 		return err
 	}
-	
-	return nil
-}
-
+	defer tx.Rollback()
 
+	// Execute the query directly with the values
+	_, err = tx.ExecContext(ctx, query, data.User_id, data.Account_number, data.Limits, data.Created_at, data.Updated_at, data.Counter)
+	if err != nil {
+		return err
+	}
 
-func (a AccountModel) SaveTransactionDetails(transaction *Transaction) error {
-	// Define the SQL query with correct placeholders created_at, updated_at,
-	query := `
-	INSERT INTO transactions(user_id,type, source, narration, account_number, request_id, internal_reference, external_reference, amount,  status)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
-	// Create a context with a timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	err = insertWebhookEvent(ctx, tx, int64(data.User_id), EventAccountCreated, map[string]interface{}{
+		"account_number": data.Account_number,
+	})
+	if err != nil {
+		return err
+	}
 
-	// Execute the query with ExecContext.
-	_, err := a.DB.ExecContext(ctx, query,
-		transaction.UserID,
-		transaction.Type,
-		transaction.Source,
-		transaction.Narration,
-		transaction.AccountNumber,
-		transaction.RequestID,
-		transaction.InternalReference,
-		transaction.ExternalReference,
-		transaction.Amount,
-		transaction.Status,
-	)
-	return err
+	return tx.Commit()
 }
 
-
-func (a AccountModel) SaveCreatedAccountNo2(User_id, Account_number, Limits, Counter string, Created_at, Updated_at time.Time) error {
+func (a AccountModel) SaveCreatedAccountNo2(ctx context.Context, User_id, Account_number, Limits, Counter string, Created_at, Updated_at time.Time) error {
 	// Define the SQL query with correct placeholders
 	query := `
 	INSERT INTO user_details(user_id, account_number, created_at, updated_at, limits, counter)
@@ -467,7 +721,7 @@ func (a AccountModel) SaveCreatedAccountNo2(User_id, Account_number, Limits, Cou
 		Limits,
 		Counter,
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, a.DB.Timeout)
 	defer cancel()
 
 	// Execute the query
@@ -475,7 +729,7 @@ func (a AccountModel) SaveCreatedAccountNo2(User_id, Account_number, Limits, Cou
 	return err
 }
 
-func (a AccountModel) GetAccountProfile(userID int64) (*AccountProfile, error) {
+func (a AccountModel) GetAccountProfile(ctx context.Context, userID int64) (*AccountProfile, error) {
 	query := `
 		SELECT u.name, u.email, u.phone_number, u.kyc_level,u.username, t.transfer_tag,t.account_number
 		FROM users u
@@ -485,7 +739,7 @@ func (a AccountModel) GetAccountProfile(userID int64) (*AccountProfile, error) {
 
 	var account AccountProfile
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, a.DB.Timeout)
 	defer cancel()
 	err := a.DB.QueryRowContext(ctx, query, userID).Scan(
 		&account.AccountName,
@@ -507,3 +761,34 @@ func (a AccountModel) GetAccountProfile(userID int64) (*AccountProfile, error) {
 
 	return &account, nil
 }
+
+// GetAccountHolder returns the owner of the account with the given number.
+func (a AccountModel) GetAccountHolder(ctx context.Context, accountNumber string) (*AccountHolder, error) {
+	query := `
+	SELECT u.id, d.account_number, u.name, u.kyc_level,
+		COALESCE((SELECT a.bvn FROM account_upgrade a WHERE a.user_id = u.id AND a.status = 'approved' ORDER BY a.id DESC LIMIT 1), '')
+	FROM user_details d
+	INNER JOIN users u ON u.id = d.user_id
+	WHERE d.account_number = ?`
+
+	ctx, cancel := context.WithTimeout(ctx, a.DB.Timeout)
+	defer cancel()
+
+	var holder AccountHolder
+	err := a.DB.QueryRowContext(ctx, query, accountNumber).Scan(
+		&holder.UserID,
+		&holder.AccountNumber,
+		&holder.Name,
+		&holder.KYCLevel,
+		&holder.BVN,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &holder, nil
+}
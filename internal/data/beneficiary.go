@@ -0,0 +1,278 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/ebitezion/backend-framework/internal/validator"
+)
+
+// Beneficiary types: one of our own accounts, an account at another bank, or a
+// transfer tag.
+const (
+	BeneficiaryInternal  = "internal"
+	BeneficiaryInterbank = "interbank"
+	BeneficiaryTag       = "tag"
+)
+
+// BeneficiaryTypes lists every beneficiary type, for validation.
+var BeneficiaryTypes = []string{BeneficiaryInternal, BeneficiaryInterbank, BeneficiaryTag}
+
+var (
+	ErrDuplicateBeneficiary    = errors.New("duplicate beneficiary")
+	ErrCoolingOffLimitExceeded = errors.New("beneficiary cooling-off limit exceeded")
+)
+
+// Beneficiary is a recipient a user has saved, so that they can be paid again by ID.
+// The account name is the one confirmed when the beneficiary was saved. A tag
+// beneficiary shows only the tag and the masked name of its owner; the account the
+// tag led to when it was saved is kept in TagAccountNumber, so that a tag that has
+// since moved to another account isn't paid.
+type Beneficiary struct {
+	ID            int64  `json:"id"`
+	UserID        int64  `json:"-"`
+	Type          string `json:"type"`
+	Nickname      string `json:"nickname"`
+	AccountNumber string `json:"accountNumber"`
+	AccountName   string `json:"accountName"`
+	BankCode      string `json:"bankCode"`
+	BankName      string `json:"bankName"`
+	Tag           string `json:"tag"`
+	// TagAccountNumber isn't shown, as tags are there so that account numbers
+	// needn't be.
+	TagAccountNumber string  `json:"-"`
+	UsageCount       int     `json:"usageCount"`
+	LastUsedAt       *string `json:"lastUsedAt"`
+	CreatedAt        *string `json:"createdAt"`
+	// CoolingOffUntil is written when the beneficiary is saved but isn't read back;
+	// CoolingOff reports whether it is still in the future.
+	CoolingOffUntil time.Time `json:"-"`
+	CoolingOff      bool      `json:"coolingOff"`
+}
+
+// BeneficiaryRequest is a recipient a user wants to save. Internal and interbank
+// beneficiaries are given by account number, interbank ones with their bank code;
+// tag beneficiaries by tag.
+type BeneficiaryRequest struct {
+	Type          string `json:"type"`
+	AccountNumber string `json:"accountNumber"`
+	BankCode      string `json:"bankCode"`
+	Tag           string `json:"tag"`
+	Nickname      string `json:"nickname"`
+}
+
+func ValidateBeneficiaryRequest(v *validator.Validator, request *BeneficiaryRequest) {
+	v.Check(validator.In(request.Type, BeneficiaryTypes...), "type", "must be internal, interbank or tag")
+	switch request.Type {
+	case BeneficiaryInternal:
+		v.Check(len(request.AccountNumber) == 10, "accountNumber", "should be 10 characters")
+		v.Check(request.BankCode == "", "bankCode", "must not be given for an internal beneficiary")
+		v.Check(request.Tag == "", "tag", "must not be given for an internal beneficiary")
+	case BeneficiaryInterbank:
+		v.Check(len(request.AccountNumber) == 10, "accountNumber", "should be 10 characters")
+		v.Check(len(request.BankCode) == 6, "bankCode", "should be 6 characters")
+		v.Check(request.Tag == "", "tag", "must not be given for an interbank beneficiary")
+	case BeneficiaryTag:
+		v.Check(validator.Matches(request.Tag, TagRX), "tag", "must be a valid transfer tag")
+		v.Check(request.AccountNumber == "", "accountNumber", "must not be given for a tag beneficiary")
+		v.Check(request.BankCode == "", "bankCode", "must not be given for a tag beneficiary")
+	}
+	ValidateBeneficiaryNickname(v, request.Nickname)
+}
+
+func ValidateBeneficiaryNickname(v *validator.Validator, nickname string) {
+	v.Check(len(nickname) <= 50, "nickname", "must not be more than 50 bytes long")
+}
+
+// BeneficiaryModel wraps the beneficiaries table.
+type BeneficiaryModel struct {
+	DB *DB
+}
+
+// Insert saves a beneficiary. A user can only save the same recipient once; a second
+// time returns ErrDuplicateBeneficiary.
+func (m BeneficiaryModel) Insert(ctx context.Context, beneficiary *Beneficiary) error {
+	query := `
+	INSERT INTO beneficiaries (user_id, type, nickname, account_number, account_name, bank_code, bank_name, tag, tag_account_number, cooling_off_until)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	var err error
+	beneficiary.ID, err = insertID(ctx, m.DB, query,
+		beneficiary.UserID,
+		beneficiary.Type,
+		beneficiary.Nickname,
+		beneficiary.AccountNumber,
+		beneficiary.AccountName,
+		beneficiary.BankCode,
+		beneficiary.BankName,
+		beneficiary.Tag,
+		beneficiary.TagAccountNumber,
+		beneficiary.CoolingOffUntil,
+	)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err):
+			return ErrDuplicateBeneficiary
+		default:
+			return err
+		}
+	}
+	beneficiary.CoolingOff = beneficiary.CoolingOffUntil.After(time.Now())
+	return nil
+}
+
+// chargeCoolingOff checks a debit of amount to the beneficiary against limit, the
+// most that may be paid to it in all while it is cooling off, counting every payment
+// made to it since it was saved that hasn't failed. It returns the beneficiary_id to
+// record on the debit, which is NULL if the debit isn't to a beneficiary. The payer's
+// user_details row must already be locked, as lockBalance does, so that concurrent
+// payments to the beneficiary can't both fit under the limit.
+func chargeCoolingOff(ctx context.Context, tx *Tx, beneficiaryID int64, amount float64, limit int64) (sql.NullInt64, error) {
+	if beneficiaryID == 0 {
+		return sql.NullInt64{}, nil
+	}
+	query := `
+	SELECT b.cooling_off_until > NOW(), COALESCE(SUM(t.amount), 0)
+	FROM beneficiaries b
+	LEFT JOIN transactions t ON t.beneficiary_id = b.id AND t.status <> ?
+	WHERE b.id = ?
+	GROUP BY b.id, b.cooling_off_until`
+	var coolingOff sql.NullBool
+	var paid decimal.Decimal
+	err := tx.QueryRowContext(ctx, query, Failed, beneficiaryID).Scan(&coolingOff, &paid)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return sql.NullInt64{}, err
+	}
+	// A beneficiary deleted since the payment was checked is no longer cooling off.
+	if coolingOff.Bool && paid.Add(decimal.NewFromFloat(amount)).GreaterThan(decimal.NewFromInt(limit)) {
+		return sql.NullInt64{}, ErrCoolingOffLimitExceeded
+	}
+	return sql.NullInt64{Int64: beneficiaryID, Valid: true}, nil
+}
+
+const beneficiaryColumns = `id, user_id, type, nickname, account_number, account_name, bank_code, bank_name, tag, tag_account_number, usage_count, last_used_at, created_at, cooling_off_until > NOW()`
+
+// scanBeneficiary scans a row of beneficiaryColumns.
+func scanBeneficiary(row interface{ Scan(...interface{}) error }) (*Beneficiary, error) {
+	var beneficiary Beneficiary
+	var coolingOff sql.NullBool
+	err := row.Scan(
+		&beneficiary.ID,
+		&beneficiary.UserID,
+		&beneficiary.Type,
+		&beneficiary.Nickname,
+		&beneficiary.AccountNumber,
+		&beneficiary.AccountName,
+		&beneficiary.BankCode,
+		&beneficiary.BankName,
+		&beneficiary.Tag,
+		&beneficiary.TagAccountNumber,
+		&beneficiary.UsageCount,
+		&beneficiary.LastUsedAt,
+		&beneficiary.CreatedAt,
+		&coolingOff,
+	)
+	if err != nil {
+		return nil, err
+	}
+	beneficiary.CoolingOff = coolingOff.Bool
+	return &beneficiary, nil
+}
+
+// Get returns one of the user's beneficiaries. Another user's beneficiary is
+// reported as ErrRecordNotFound.
+func (m BeneficiaryModel) Get(ctx context.Context, id, userID int64) (*Beneficiary, error) {
+	query := `SELECT ` + beneficiaryColumns + ` FROM beneficiaries WHERE id = ? AND user_id = ?`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	beneficiary, err := scanBeneficiary(m.DB.QueryRowContext(ctx, query, id, userID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return beneficiary, nil
+}
+
+// GetAllForUser returns the user's beneficiaries, the most used first.
+func (m BeneficiaryModel) GetAllForUser(ctx context.Context, userID int64) ([]*Beneficiary, error) {
+	query := `SELECT ` + beneficiaryColumns + ` FROM beneficiaries WHERE user_id = ? ORDER BY usage_count DESC, id`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	beneficiaries := []*Beneficiary{}
+	for rows.Next() {
+		beneficiary, err := scanBeneficiary(rows)
+		if err != nil {
+			return nil, err
+		}
+		beneficiaries = append(beneficiaries, beneficiary)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return beneficiaries, nil
+}
+
+// UpdateNickname saves a new nickname for the beneficiary. The recipient itself
+// can't be changed; it has to be saved again as a new beneficiary.
+func (m BeneficiaryModel) UpdateNickname(ctx context.Context, beneficiary *Beneficiary) error {
+	query := `UPDATE beneficiaries SET nickname = ? WHERE id = ? AND user_id = ?`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, beneficiary.Nickname, beneficiary.ID, beneficiary.UserID)
+	return err
+}
+
+// Delete removes one of the user's beneficiaries.
+func (m BeneficiaryModel) Delete(ctx context.Context, id, userID int64) error {
+	query := `DELETE FROM beneficiaries WHERE id = ? AND user_id = ?`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// RecordUse counts a payment made to the beneficiary.
+func (m BeneficiaryModel) RecordUse(ctx context.Context, id int64) error {
+	query := `UPDATE beneficiaries SET usage_count = usage_count + 1, last_used_at = NOW() WHERE id = ?`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
@@ -0,0 +1,390 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/ebitezion/backend-framework/internal/validator"
+	"github.com/shopspring/decimal"
+)
+
+// SourceBills is the source of the transactions bill payments debit.
+const SourceBills = "bills"
+
+// BillPayment is a payment to a biller for one of its customers: airtime, data, an
+// electricity top-up or a TV subscription. Like an interbank transfer, the amount is
+// taken from the payer's balance into suspense before the payment goes out, and the
+// pending debit is completed or failed and refunded by the biller's answer. The
+// biller's details of the payment are kept in bill_payments.
+type BillPayment struct {
+	Reference     string `json:"reference"`
+	RequestID     string `json:"request_id"`
+	UserID        int64  `json:"-"`
+	AccountNumber string `json:"account_number"`
+	// Provider is the billers.Provider the payment was made through.
+	Provider        string  `json:"-"`
+	BillerID        string  `json:"biller_id"`
+	BillerName      string  `json:"biller_name"`
+	Category        string  `json:"category"`
+	ProductCode     string  `json:"product_code"`
+	CustomerID      string  `json:"customer_id"`
+	CustomerName    string  `json:"customer_name"`
+	Amount          float64 `json:"amount"`
+	Narration       string  `json:"narration"`
+	BillerReference string  `json:"biller_reference"`
+	// Token is what the customer needs to use what they paid for, such as a prepaid
+	// electricity token.
+	Token     string  `json:"token,omitempty"`
+	Status    string  `json:"status"`
+	CreatedAt *string `json:"created_at"`
+}
+
+// BillCustomerRequest asks a biller who its customer with the given ID is, before
+// they are paid for.
+type BillCustomerRequest struct {
+	BillerID   string `json:"biller_id"`
+	CustomerID string `json:"customer_id"`
+}
+
+func ValidateBillCustomerRequest(v *validator.Validator, request *BillCustomerRequest) {
+	v.Check(request.BillerID != "", "biller_id", "must be provided")
+	v.Check(request.CustomerID != "", "customer_id", "must be provided")
+	v.Check(len(request.CustomerID) <= 50, "customer_id", "must not be more than 50 bytes long")
+}
+
+// BillPaymentRequest is a payment for one of a biller's products. Amount can be left
+// out for a product with a fixed price. Reference is the client's own reference for
+// the payment.
+type BillPaymentRequest struct {
+	BillerID    string `json:"biller_id"`
+	ProductCode string `json:"product_code"`
+	CustomerID  string `json:"customer_id"`
+	Amount      int    `json:"amount"`
+	PIN         string `json:"pin"`
+	Reference   string `json:"reference"`
+}
+
+func ValidateBillPaymentRequest(v *validator.Validator, request *BillPaymentRequest) {
+	ValidateBillCustomerRequest(v, &BillCustomerRequest{BillerID: request.BillerID, CustomerID: request.CustomerID})
+	v.Check(request.ProductCode != "", "product_code", "must be provided")
+	v.Check(request.Amount >= 0, "amount", "must not be negative")
+	v.Check(request.PIN != "", "pin", "must be provided")
+	v.Check(request.Reference != "", "reference", "must be provided")
+	v.Check(len(request.Reference) <= 50, "reference", "must not be more than 50 bytes long")
+}
+
+// NewBillReference returns a random reference for a bill payment. It is sent to the
+// biller as the payment reference, so it is kept to 30 characters.
+func NewBillReference() (string, error) {
+	b := make([]byte, 14)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return "BP" + hex.EncodeToString(b), nil
+}
+
+// BillPaymentModel wraps the bill_payments table, along with the transactions row and
+// the balance that each payment moves.
+type BillPaymentModel struct {
+	DB *DB
+}
+
+const billPaymentColumns = `
+	b.reference, t.request_id, b.user_id, t.account_number, b.provider, b.biller_id, b.biller_name, b.category, b.product_code,
+		b.customer_id, b.customer_name, t.amount, t.narration, b.biller_reference, b.token, t.status, t.created_at
+	FROM bill_payments b
+	INNER JOIN transactions t ON t.internal_reference = b.reference`
+
+func scanBillPayment(row interface{ Scan(...interface{}) error }) (*BillPayment, error) {
+	var payment BillPayment
+	err := row.Scan(
+		&payment.Reference,
+		&payment.RequestID,
+		&payment.UserID,
+		&payment.AccountNumber,
+		&payment.Provider,
+		&payment.BillerID,
+		&payment.BillerName,
+		&payment.Category,
+		&payment.ProductCode,
+		&payment.CustomerID,
+		&payment.CustomerName,
+		&payment.Amount,
+		&payment.Narration,
+		&payment.BillerReference,
+		&payment.Token,
+		&payment.Status,
+		&payment.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &payment, nil
+}
+
+// Debit takes the amount of a payment from the payer's balance and records the
+// payment as pending, counting it against the payer's bills limits, with the
+// user_details row locked so that concurrent debits can't overdraw the account or go
+// over its limits; the amount is credited to bills suspense. As with an
+// interbank transfer, a requery is queued in the outbox, due after requeryAfter, so
+// that a payment whose outcome is never learned is still settled. On success the
+// payment's Status and CreatedAt fields are populated.
+func (m BillPaymentModel) Debit(ctx context.Context, payment *BillPayment, requeryAfter time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current, err := lockBalance(ctx, tx, payment.AccountNumber)
+	if err != nil {
+		return err
+	}
+	err = chargeLimit(ctx, tx, payment.AccountNumber, ChannelBills, payment.Amount)
+	if err != nil {
+		return err
+	}
+	amount := decimal.NewFromFloat(payment.Amount)
+	if current.LessThan(amount) {
+		return ErrInsufficientFunds
+	}
+	after := current.Sub(amount)
+
+	_, err = tx.ExecContext(ctx, `UPDATE user_details SET balance = ?, updated_at = NOW() WHERE account_number = ?`, after.StringFixed(2), payment.AccountNumber)
+	if err != nil {
+		return err
+	}
+
+	balanceAfter, _ := after.Float64()
+	query := `
+	INSERT INTO transactions(user_id, type, source, narration, account_number, request_id, internal_reference, amount, status, balance_after, limit_channel)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = tx.ExecContext(ctx, query,
+		payment.UserID,
+		string(Debit),
+		SourceBills,
+		payment.Narration,
+		payment.AccountNumber,
+		payment.RequestID,
+		payment.Reference,
+		payment.Amount,
+		Pending,
+		balanceAfter,
+		ChannelBills,
+	)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err):
+			return ErrDuplicateTransaction
+		default:
+			return err
+		}
+	}
+
+	query = `
+	INSERT INTO bill_payments (reference, user_id, provider, biller_id, biller_name, category, product_code, customer_id, customer_name)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = tx.ExecContext(ctx, query,
+		payment.Reference,
+		payment.UserID,
+		payment.Provider,
+		payment.BillerID,
+		payment.BillerName,
+		payment.Category,
+		payment.ProductCode,
+		payment.CustomerID,
+		payment.CustomerName,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = postLedger(ctx, tx, payment.Reference, LedgerEntry{Ledger: LedgerBillsSuspense, Type: Credit, Amount: amount})
+	if err != nil {
+		return err
+	}
+
+	requery, err := NewOutboxMessage(OutboxBillRequery, TransactionReference{Reference: payment.Reference})
+	if err != nil {
+		return err
+	}
+	requery.NotBefore = time.Now().Add(requeryAfter)
+	err = insertOutboxMessages(ctx, tx, requery)
+	if err != nil {
+		return err
+	}
+
+	var createdAt *string
+	err = tx.QueryRowContext(ctx, `SELECT created_at FROM transactions WHERE internal_reference = ?`, payment.Reference).Scan(&createdAt)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	payment.Status = Pending
+	payment.CreatedAt = createdAt
+	return nil
+}
+
+// Get returns the bill payment with the given reference.
+func (m BillPaymentModel) Get(ctx context.Context, reference string) (*BillPayment, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	return scanBillPayment(m.DB.QueryRowContext(ctx, `SELECT `+billPaymentColumns+` WHERE b.reference = ?`, reference))
+}
+
+// GetAllForUser returns the user's bill payments, the latest first.
+func (m BillPaymentModel) GetAllForUser(ctx context.Context, userID int64) ([]*BillPayment, error) {
+	query := `SELECT ` + billPaymentColumns + ` WHERE b.user_id = ? ORDER BY t.id DESC`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []*BillPayment{}
+	for rows.Next() {
+		payment, err := scanBillPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, payment)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return payments, nil
+}
+
+// Settle applies the outcome of a pending payment. Completing it records the
+// biller's reference and token, moves the amount from suspense to biller
+// settlement, and queues the payer's alert and the transaction.completed webhook
+// event. Failing it refunds the payer out of suspense, gives back what it counted
+// against their limits and emits the transaction.failed webhook event. A payment that
+// has already been settled is left alone and changed is false; status must be
+// Completed or Failed, or ErrInvalidTransition is returned.
+func (m BillPaymentModel) Settle(ctx context.Context, reference, status, billerReference, token string) (payment *BillPayment, changed bool, err error) {
+	if status != Completed && status != Failed {
+		return nil, false, ErrInvalidTransition
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	payment, err = scanBillPayment(tx.QueryRowContext(ctx, `SELECT `+billPaymentColumns+` WHERE b.reference = ? FOR UPDATE`, reference))
+	if err != nil {
+		return nil, false, err
+	}
+	if payment.Status != Pending {
+		return payment, false, nil
+	}
+
+	amount := decimal.NewFromFloat(payment.Amount)
+	if status == Failed {
+		// Refund the payer. The transactions row stays as it was, so its
+		// balance_after is what the debit left.
+		current, err := lockBalance(ctx, tx, payment.AccountNumber)
+		if err != nil {
+			return nil, false, err
+		}
+		after := current.Add(amount)
+		_, err = tx.ExecContext(ctx, `UPDATE user_details SET balance = ?, updated_at = NOW() WHERE account_number = ?`, after.StringFixed(2), payment.AccountNumber)
+		if err != nil {
+			return nil, false, err
+		}
+		err = releaseLimit(ctx, tx, reference)
+		if err != nil {
+			return nil, false, err
+		}
+		err = postLedger(ctx, tx, reference, LedgerEntry{Ledger: LedgerBillsSuspense, Type: Debit, Amount: amount})
+		if err != nil {
+			return nil, false, err
+		}
+	} else {
+		payment.BillerReference = billerReference
+		payment.Token = token
+		_, err = tx.ExecContext(ctx, `UPDATE bill_payments SET biller_reference = ?, token = ? WHERE reference = ?`, billerReference, token, reference)
+		if err != nil {
+			return nil, false, err
+		}
+		err = postLedger(ctx, tx, reference,
+			LedgerEntry{Ledger: LedgerBillsSuspense, Type: Debit, Amount: amount},
+			LedgerEntry{Ledger: LedgerBillsSettlement, Type: Credit, Amount: amount},
+		)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	var external *string
+	if status == Completed && billerReference != "" {
+		external = &billerReference
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE transactions SET status = ?, external_reference = ?, updated_at = NOW() WHERE internal_reference = ?`, status, external, reference)
+	if err != nil {
+		return nil, false, err
+	}
+	payment.Status = status
+
+	query := "SELECT id, user_id, type, source, narration, account_number, request_id, internal_reference, external_reference, amount, created_at, updated_at, status, commission, balance_after FROM transactions WHERE internal_reference = ?"
+	var t Transaction
+	err = tx.QueryRowContext(ctx, query, reference).Scan(&t.ID, &t.UserID, &t.Type, &t.Source, &t.Narration, &t.AccountNumber, &t.RequestID, &t.InternalReference, &t.ExternalReference, &t.Amount, &t.CreatedAt, &t.UpdatedAt, &t.Status, &t.Commission, &t.BalanceAfter)
+	if err != nil {
+		return nil, false, err
+	}
+
+	switch status {
+	case Completed:
+		alert, err := NewOutboxMessage(OutboxTransactionAlert, TransactionReference{Reference: reference})
+		if err != nil {
+			return nil, false, err
+		}
+		err = insertOutboxMessages(ctx, tx, alert)
+		if err != nil {
+			return nil, false, err
+		}
+		err = insertWebhookEvent(ctx, tx, payment.UserID, EventTransactionCompleted, t)
+		if err != nil {
+			return nil, false, err
+		}
+	case Failed:
+		err = insertWebhookEvent(ctx, tx, payment.UserID, EventTransactionFailed, t)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, false, err
+	}
+	return payment, true, nil
+}
@@ -0,0 +1,77 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// Outcomes recorded against every provider callback we receive.
+const (
+	CallbackApplied          = "applied"
+	CallbackUnchanged        = "unchanged"
+	CallbackDuplicate        = "duplicate"
+	CallbackInvalidSignature = "invalid_signature"
+	CallbackRejected         = "rejected"
+	CallbackError            = "error"
+)
+
+// ProviderCallback is the raw record of a callback from the payment provider, kept
+// for auditing whether or not it was accepted.
+type ProviderCallback struct {
+	ID        int64  `json:"id"`
+	Nonce     string `json:"nonce"`
+	Reference string `json:"reference"`
+	Status    string `json:"status"`
+	Signature string `json:"signature"`
+	Timestamp string `json:"timestamp"`
+	Payload   string `json:"payload"`
+	Outcome   string `json:"outcome"`
+	Error     string `json:"error"`
+}
+
+// ProviderCallbackModel wraps the provider_callbacks table.
+type ProviderCallbackModel struct {
+	DB *DB
+}
+
+// Insert records a received callback and its outcome.
+func (m ProviderCallbackModel) Insert(ctx context.Context, callback *ProviderCallback) error {
+	query := `
+	INSERT INTO provider_callbacks (nonce, reference, status, signature, timestamp, payload, outcome, error)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	id, err := insertID(ctx, m.DB, query,
+		callback.Nonce,
+		callback.Reference,
+		callback.Status,
+		callback.Signature,
+		callback.Timestamp,
+		callback.Payload,
+		callback.Outcome,
+		callback.Error,
+	)
+	if err != nil {
+		return err
+	}
+	callback.ID = id
+	return nil
+}
+
+// PruneNonces deletes the callback nonces stored before the given time and returns
+// how many went. A nonce only has to be kept for as long as a callback carrying it
+// would still pass the timestamp check; after that the signature check rejects it.
+func (m ProviderCallbackModel) PruneNonces(ctx context.Context, before time.Time) (int64, error) {
+	query := `DELETE FROM provider_callback_nonces WHERE created_at < ?`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,248 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+// Dialect identifies the database the models are running against. Queries are
+// written once, in MySQL style with ? placeholders, and the dialect smooths over
+// the differences that matter to us.
+type Dialect string
+
+const (
+	MySQL    Dialect = "mysql"
+	Postgres Dialect = "postgres"
+)
+
+// ParseDialect returns the dialect for a database/sql driver name.
+func ParseDialect(driver string) (Dialect, error) {
+	switch Dialect(driver) {
+	case MySQL, Postgres:
+		return Dialect(driver), nil
+	default:
+		return "", fmt.Errorf("unsupported database driver %q (want mysql or postgres)", driver)
+	}
+}
+
+// SessionDSN returns dsn with the session pinned to UTC. The models write times from
+// Go in UTC and compare them with NOW(), and NOW() and the created_at defaults
+// follow the session's time zone, which is the server's unless the DSN says
+// otherwise; on a server an hour ahead of UTC a ten-minute name enquiry would be
+// expired as soon as it was made. Postgres columns are timestamptz, which don't
+// depend on the session, so a Postgres DSN is returned as it is.
+func (d Dialect) SessionDSN(dsn string) (string, error) {
+	if d != MySQL {
+		return dsn, nil
+	}
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "", err
+	}
+	if cfg.Params == nil {
+		cfg.Params = map[string]string{}
+	}
+	cfg.Params["time_zone"] = "'+00:00'"
+	cfg.Loc = time.UTC
+	return cfg.FormatDSN(), nil
+}
+
+// Rebind rewrites the ? placeholders in query into the dialect's bind variables.
+// Question marks inside quoted strings and identifiers are left alone.
+func (d Dialect) Rebind(query string) string {
+	if d != Postgres || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	var quote rune
+	for _, r := range query {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == '?':
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// isUniqueViolation reports whether err came from a unique or primary key
+// constraint being violated.
+func isUniqueViolation(err error) bool {
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		return myErr.Number == 1062 // ER_DUP_ENTRY
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505" // unique_violation
+	}
+	return false
+}
+
+// mysqlTimeLayout is how the MySQL driver returns a datetime when the DSN doesn't ask
+// for parseTime. The fraction is there only if the column has one.
+const mysqlTimeLayout = "2006-01-02 15:04:05.999999"
+
+// nullTime scans a nullable datetime or timestamp column, which the Postgres driver
+// returns as a time.Time and the MySQL driver as text in UTC. Times written by the
+// models are in UTC, so either way they read back as they were written.
+type nullTime struct {
+	Time  time.Time
+	Valid bool
+}
+
+func (t *nullTime) Scan(value interface{}) error {
+	var err error
+	switch v := value.(type) {
+	case nil:
+		*t = nullTime{}
+		return nil
+	case time.Time:
+		t.Time = v
+	case []byte:
+		t.Time, err = time.Parse(mysqlTimeLayout, string(v))
+	case string:
+		t.Time, err = time.Parse(mysqlTimeLayout, v)
+	default:
+		err = fmt.Errorf("can't scan %T into a time", value)
+	}
+	if err != nil {
+		return err
+	}
+	t.Time, t.Valid = t.Time.UTC(), true
+	return nil
+}
+
+// Ptr returns the time, or nil if the column was NULL.
+func (t nullTime) Ptr() *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// DB wraps a connection pool so that every query is rebound for its dialect before
+// it reaches the driver. Models hold a *DB rather than a *sql.DB.
+type DB struct {
+	*sql.DB
+	Dialect Dialect
+	// Timeout bounds each model method, on top of whatever deadline the caller's
+	// context already carries.
+	Timeout time.Duration
+}
+
+// NewDB wraps db, which must have been opened with the driver for dialect.
+func NewDB(db *sql.DB, dialect Dialect, timeout time.Duration) *DB {
+	return &DB{DB: db, Dialect: dialect, Timeout: timeout}
+}
+
+func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.ExecContext(ctx, db.Dialect.Rebind(query), args...)
+}
+
+func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.QueryContext(ctx, db.Dialect.Rebind(query), args...)
+}
+
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRowContext(ctx, db.Dialect.Rebind(query), args...)
+}
+
+// BeginTx starts a transaction whose queries are rebound in the same way.
+func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	tx, err := db.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx, Dialect: db.Dialect}, nil
+}
+
+// Tx is a transaction started from a *DB.
+type Tx struct {
+	*sql.Tx
+	Dialect Dialect
+}
+
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.ExecContext(ctx, tx.Dialect.Rebind(query), args...)
+}
+
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.QueryContext(ctx, tx.Dialect.Rebind(query), args...)
+}
+
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRowContext(ctx, tx.Dialect.Rebind(query), args...)
+}
+
+// execer is satisfied by both *DB and *Tx, so that rows can be written inside a
+// caller's transaction.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	dialect() Dialect
+}
+
+func (db *DB) dialect() Dialect { return db.Dialect }
+func (tx *Tx) dialect() Dialect { return tx.Dialect }
+
+// insertID runs an INSERT into a table with an auto-generated id column and returns
+// the new id. The Postgres driver doesn't implement LastInsertId, so there the id
+// comes back through a RETURNING clause instead.
+func insertID(ctx context.Context, exec execer, query string, args ...interface{}) (int64, error) {
+	var id int64
+	if exec.dialect() == Postgres {
+		err := exec.QueryRowContext(ctx, query+" RETURNING id", args...).Scan(&id)
+		return id, err
+	}
+
+	result, err := exec.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// updateVersion runs an UPDATE which bumps a row's version column (with a WHERE
+// clause on the old version, for optimistic locking) and stores the new version in
+// version. It returns sql.ErrNoRows if no row matched. MySQL has no UPDATE ...
+// RETURNING, so there the new version is worked out from the one that matched.
+func updateVersion(ctx context.Context, exec execer, version *int, query string, args ...interface{}) error {
+	if exec.dialect() == Postgres {
+		return exec.QueryRowContext(ctx, query+" RETURNING version", args...).Scan(version)
+	}
+
+	result, err := exec.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	*version++
+	return nil
+}
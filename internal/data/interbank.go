@@ -0,0 +1,378 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/ebitezion/backend-framework/internal/validator"
+	"github.com/shopspring/decimal"
+)
+
+// SourceInterbank is the source of the transactions interbank transfers debit.
+const SourceInterbank = "interbank"
+
+// InterbankTransfer is money sent to an account at another bank. The amount is
+// taken from the sender's balance into suspense, and the fee into fee income, before
+// the transfer goes out: the debit is recorded in transactions as pending, and stays
+// that way until the destination bank's answer completes it, or fails it and
+// refunds the sender. The network's details of the transfer are kept in
+// interbank_transfers.
+type InterbankTransfer struct {
+	Reference                string  `json:"reference"`
+	RequestID                string  `json:"requestId"`
+	UserID                   int64   `json:"-"`
+	AccountNumber            string  `json:"accountNumber"`
+	NameEnquiryRef           string  `json:"nameEnquiryRef"`
+	SessionID                string  `json:"sessionID"`
+	BankCode                 string  `json:"bankCode"`
+	BankName                 string  `json:"bankName"`
+	BeneficiaryAccountNumber string  `json:"beneficiaryAccountNumber"`
+	BeneficiaryAccountName   string  `json:"beneficiaryAccountName"`
+	Amount                   float64 `json:"amount"`
+	Fee                      float64 `json:"fee"`
+	Narration                string  `json:"narration"`
+	Status                   string  `json:"status"`
+	CreatedAt                *string `json:"createdAt"`
+	// BeneficiaryID is the saved beneficiary the transfer pays, if it pays one, and
+	// CoolingOffLimit the most that may be paid to it in all while it is cooling off.
+	BeneficiaryID   int64 `json:"-"`
+	CoolingOffLimit int64 `json:"-"`
+}
+
+// InterbankTransferRequest is a transfer to the account a name enquiry confirmed, or
+// to a saved interbank beneficiary. Reference is the client's own reference for the
+// transfer.
+type InterbankTransferRequest struct {
+	NameEnquiryRef string `json:"nameEnquiryRef"`
+	BeneficiaryID  int64  `json:"beneficiary_id"`
+	Amount         int    `json:"amount"`
+	Narration      string `json:"narration"`
+	Reference      string `json:"reference"`
+}
+
+func ValidateInterbankTransferRequest(v *validator.Validator, request *InterbankTransferRequest) {
+	if request.BeneficiaryID == 0 {
+		v.Check(request.NameEnquiryRef != "", "nameEnquiryRef", "must be provided")
+	} else {
+		v.Check(request.NameEnquiryRef == "", "nameEnquiryRef", "must not be given with beneficiary_id")
+	}
+	v.Check(request.Amount > 0, "amount", "must be greater than zero")
+	v.Check(request.Reference != "", "reference", "must be provided")
+	v.Check(len(request.Reference) <= 50, "reference", "must not be more than 50 bytes long")
+	v.Check(len(request.Narration) <= 100, "narration", "must not be more than 100 bytes long")
+}
+
+// InterbankFee returns the fee charged for sending amount to another bank, on the
+// network's tiers.
+func InterbankFee(amount decimal.Decimal) decimal.Decimal {
+	switch {
+	case amount.LessThanOrEqual(decimal.NewFromInt(5_000)):
+		return decimal.RequireFromString("10.75")
+	case amount.LessThanOrEqual(decimal.NewFromInt(50_000)):
+		return decimal.RequireFromString("26.88")
+	default:
+		return decimal.RequireFromString("53.75")
+	}
+}
+
+// NewInterbankReference returns a random reference for an interbank transfer. It is
+// sent to the network as the payment reference, so it is kept to 30 characters.
+func NewInterbankReference() (string, error) {
+	b := make([]byte, 14)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return "IB" + hex.EncodeToString(b), nil
+}
+
+// InterbankTransferModel wraps the interbank_transfers table, along with the
+// transactions row and the balance that each transfer moves.
+type InterbankTransferModel struct {
+	DB *DB
+}
+
+const interbankTransferQuery = `
+	SELECT i.reference, t.request_id, i.user_id, t.account_number, i.name_enquiry_ref, i.session_id, i.bank_code, i.bank_name,
+		i.beneficiary_account_number, i.beneficiary_account_name, t.amount, i.fee, t.narration, t.status, t.created_at
+	FROM interbank_transfers i
+	INNER JOIN transactions t ON t.internal_reference = i.reference
+	WHERE i.reference = ?`
+
+func scanInterbankTransfer(row *sql.Row) (*InterbankTransfer, error) {
+	var transfer InterbankTransfer
+	err := row.Scan(
+		&transfer.Reference,
+		&transfer.RequestID,
+		&transfer.UserID,
+		&transfer.AccountNumber,
+		&transfer.NameEnquiryRef,
+		&transfer.SessionID,
+		&transfer.BankCode,
+		&transfer.BankName,
+		&transfer.BeneficiaryAccountNumber,
+		&transfer.BeneficiaryAccountName,
+		&transfer.Amount,
+		&transfer.Fee,
+		&transfer.Narration,
+		&transfer.Status,
+		&transfer.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &transfer, nil
+}
+
+// Debit takes the amount and the fee of a transfer from the sender's balance and
+// records the transfer as pending, counting it against the sender's transfer limits
+// and, if it pays a beneficiary that is still cooling off, the cooling-off limit.
+// The user_details row is locked so that concurrent debits can't overdraw the
+// account or go over either limit. The amount is credited to interbank suspense and
+// the fee to fee income in the same transaction. A requery of the transfer is queued
+// in the outbox, due after requeryAfter, so that a transfer whose outcome is never
+// learned (because the process died mid-call, say) is still settled. On success the
+// transfer's Status and CreatedAt fields are populated.
+func (m InterbankTransferModel) Debit(ctx context.Context, transfer *InterbankTransfer, requeryAfter time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current, err := lockBalance(ctx, tx, transfer.AccountNumber)
+	if err != nil {
+		return err
+	}
+	err = chargeLimit(ctx, tx, transfer.AccountNumber, ChannelTransfers, transfer.Amount)
+	if err != nil {
+		return err
+	}
+	beneficiaryID, err := chargeCoolingOff(ctx, tx, transfer.BeneficiaryID, transfer.Amount, transfer.CoolingOffLimit)
+	if err != nil {
+		return err
+	}
+	amount, fee := decimal.NewFromFloat(transfer.Amount), decimal.NewFromFloat(transfer.Fee)
+	total := amount.Add(fee)
+	if current.LessThan(total) {
+		return ErrInsufficientFunds
+	}
+	after := current.Sub(total)
+
+	_, err = tx.ExecContext(ctx, `UPDATE user_details SET balance = ?, updated_at = NOW() WHERE account_number = ?`, after.StringFixed(2), transfer.AccountNumber)
+	if err != nil {
+		return err
+	}
+
+	balanceAfter, _ := after.Float64()
+	query := `
+	INSERT INTO transactions(user_id, type, source, narration, account_number, request_id, internal_reference, amount, commission, status, balance_after, limit_channel, beneficiary_id)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = tx.ExecContext(ctx, query,
+		transfer.UserID,
+		string(Debit),
+		SourceInterbank,
+		transfer.Narration,
+		transfer.AccountNumber,
+		transfer.RequestID,
+		transfer.Reference,
+		transfer.Amount,
+		transfer.Fee,
+		Pending,
+		balanceAfter,
+		ChannelTransfers,
+		beneficiaryID,
+	)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err):
+			return ErrDuplicateTransaction
+		default:
+			return err
+		}
+	}
+
+	query = `
+	INSERT INTO interbank_transfers (reference, user_id, name_enquiry_ref, session_id, bank_code, bank_name, beneficiary_account_number, beneficiary_account_name, fee)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = tx.ExecContext(ctx, query,
+		transfer.Reference,
+		transfer.UserID,
+		transfer.NameEnquiryRef,
+		transfer.SessionID,
+		transfer.BankCode,
+		transfer.BankName,
+		transfer.BeneficiaryAccountNumber,
+		transfer.BeneficiaryAccountName,
+		transfer.Fee,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = postLedger(ctx, tx, transfer.Reference,
+		LedgerEntry{Ledger: LedgerInterbankSuspense, Type: Credit, Amount: amount},
+		LedgerEntry{Ledger: LedgerFeeIncome, Type: Credit, Amount: fee},
+	)
+	if err != nil {
+		return err
+	}
+
+	requery, err := NewOutboxMessage(OutboxInterbankRequery, TransactionReference{Reference: transfer.Reference})
+	if err != nil {
+		return err
+	}
+	requery.NotBefore = time.Now().Add(requeryAfter)
+	err = insertOutboxMessages(ctx, tx, requery)
+	if err != nil {
+		return err
+	}
+
+	var createdAt *string
+	err = tx.QueryRowContext(ctx, `SELECT created_at FROM transactions WHERE internal_reference = ?`, transfer.Reference).Scan(&createdAt)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	transfer.Status = Pending
+	transfer.CreatedAt = createdAt
+	return nil
+}
+
+// Get returns the interbank transfer with the given reference.
+func (m InterbankTransferModel) Get(ctx context.Context, reference string) (*InterbankTransfer, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	return scanInterbankTransfer(m.DB.QueryRowContext(ctx, interbankTransferQuery, reference))
+}
+
+// Settle applies the outcome of a pending transfer. Completing it records the
+// network's session ID, moves the amount from suspense to interbank settlement, and
+// queues the sender's alert and the transaction.completed webhook event. Failing it
+// refunds the amount and the fee to the sender out of suspense and fee income, gives
+// back what it counted against the sender's limits, and emits the transaction.failed
+// webhook event. A transfer that has already been settled is left alone and changed
+// is false; status must be Completed or Failed, or ErrInvalidTransition is returned.
+func (m InterbankTransferModel) Settle(ctx context.Context, reference, status, sessionID string) (transfer *InterbankTransfer, changed bool, err error) {
+	if status != Completed && status != Failed {
+		return nil, false, ErrInvalidTransition
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	transfer, err = scanInterbankTransfer(tx.QueryRowContext(ctx, interbankTransferQuery+" FOR UPDATE", reference))
+	if err != nil {
+		return nil, false, err
+	}
+	if transfer.Status != Pending {
+		return transfer, false, nil
+	}
+
+	amount, fee := decimal.NewFromFloat(transfer.Amount), decimal.NewFromFloat(transfer.Fee)
+	if status == Failed {
+		// Refund the sender. The transactions row stays as it was, so its balance_after
+		// is what the debit left.
+		current, err := lockBalance(ctx, tx, transfer.AccountNumber)
+		if err != nil {
+			return nil, false, err
+		}
+		after := current.Add(amount).Add(fee)
+		_, err = tx.ExecContext(ctx, `UPDATE user_details SET balance = ?, updated_at = NOW() WHERE account_number = ?`, after.StringFixed(2), transfer.AccountNumber)
+		if err != nil {
+			return nil, false, err
+		}
+		err = releaseLimit(ctx, tx, reference)
+		if err != nil {
+			return nil, false, err
+		}
+		err = postLedger(ctx, tx, reference,
+			LedgerEntry{Ledger: LedgerInterbankSuspense, Type: Debit, Amount: amount},
+			LedgerEntry{Ledger: LedgerFeeIncome, Type: Debit, Amount: fee},
+		)
+		if err != nil {
+			return nil, false, err
+		}
+	} else {
+		transfer.SessionID = sessionID
+		_, err = tx.ExecContext(ctx, `UPDATE interbank_transfers SET session_id = ? WHERE reference = ?`, sessionID, reference)
+		if err != nil {
+			return nil, false, err
+		}
+		err = postLedger(ctx, tx, reference,
+			LedgerEntry{Ledger: LedgerInterbankSuspense, Type: Debit, Amount: amount},
+			LedgerEntry{Ledger: LedgerInterbankSettlement, Type: Credit, Amount: amount},
+		)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	var external *string
+	if status == Completed && sessionID != "" {
+		external = &sessionID
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE transactions SET status = ?, external_reference = ?, updated_at = NOW() WHERE internal_reference = ?`, status, external, reference)
+	if err != nil {
+		return nil, false, err
+	}
+	transfer.Status = status
+
+	query := "SELECT id, user_id, type, source, narration, account_number, request_id, internal_reference, external_reference, amount, created_at, updated_at, status, commission, balance_after FROM transactions WHERE internal_reference = ?"
+	var t Transaction
+	err = tx.QueryRowContext(ctx, query, reference).Scan(&t.ID, &t.UserID, &t.Type, &t.Source, &t.Narration, &t.AccountNumber, &t.RequestID, &t.InternalReference, &t.ExternalReference, &t.Amount, &t.CreatedAt, &t.UpdatedAt, &t.Status, &t.Commission, &t.BalanceAfter)
+	if err != nil {
+		return nil, false, err
+	}
+
+	switch status {
+	case Completed:
+		alert, err := NewOutboxMessage(OutboxTransactionAlert, TransactionReference{Reference: reference})
+		if err != nil {
+			return nil, false, err
+		}
+		err = insertOutboxMessages(ctx, tx, alert)
+		if err != nil {
+			return nil, false, err
+		}
+		err = insertWebhookEvent(ctx, tx, transfer.UserID, EventTransactionCompleted, t)
+		if err != nil {
+			return nil, false, err
+		}
+	case Failed:
+		err = insertWebhookEvent(ctx, tx, transfer.UserID, EventTransactionFailed, t)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, false, err
+	}
+	return transfer, true, nil
+}
@@ -0,0 +1,398 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ebitezion/backend-framework/internal/validator"
+	"github.com/shopspring/decimal"
+)
+
+// SourceInternal is the source of both legs of a transfer between two of our own
+// accounts.
+const SourceInternal = "internal"
+
+// ErrTransferSubmitted is returned by Abandon for a transfer that has gone to core
+// banking, whose outcome can't be known here.
+var ErrTransferSubmitted = errors.New("transfer submitted to core banking")
+
+// InternalTransfer is money moved between two of our own accounts. It is recorded as
+// two transactions, a debit on the sender's account and a credit on the recipient's,
+// whose internal references are DebitReference and CreditReference. Both legs are
+// pending until core banking has carried the transfer out, and then completed or
+// failed together. internal_transfers links both legs to the transfer's Reference.
+type InternalTransfer struct {
+	Reference              string   `json:"reference"`
+	RequestID              string   `json:"requestId"`
+	UserID                 int64    `json:"-"`
+	AccountNumber          string   `json:"accountNumber"`
+	RecipientUserID        int64    `json:"-"`
+	RecipientAccountNumber string   `json:"recipientAccountNumber"`
+	RecipientAccountName   string   `json:"recipientAccountName"`
+	ExternalReference      *string  `json:"externalReference"`
+	Amount                 float64  `json:"amount"`
+	Narration              string   `json:"narration"`
+	Status                 string   `json:"status"`
+	BalanceAfter           *float64 `json:"balanceAfter"`
+	CreatedAt              *string  `json:"createdAt"`
+	// Channel is the channel whose limits the transfer counts against.
+	Channel string `json:"-"`
+	// BeneficiaryID is the saved beneficiary the transfer pays, if it pays one, and
+	// CoolingOffLimit the most that may be paid to it in all while it is cooling off.
+	BeneficiaryID   int64 `json:"-"`
+	CoolingOffLimit int64 `json:"-"`
+}
+
+// DebitReference is the internal reference of the sender's leg of the transfer.
+func (t *InternalTransfer) DebitReference() string {
+	return t.Reference + "-DR"
+}
+
+// CreditReference is the internal reference of the recipient's leg of the transfer.
+func (t *InternalTransfer) CreditReference() string {
+	return t.Reference + "-CR"
+}
+
+// TransferReference returns the reference of the payment the transaction was made
+// for: the transfer's Reference for either leg of an internal transfer, and the
+// transaction's own internal reference otherwise.
+func (t *Transaction) TransferReference() string {
+	if t.Source != SourceInternal {
+		return t.InternalReference
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(t.InternalReference, "-DR"), "-CR")
+}
+
+// InternalTransferRequest is a transfer from the caller's account to another of our
+// accounts, authorised with the caller's transaction PIN. The recipient is given by
+// exactly one of account number, transfer tag or saved beneficiary. Reference is the
+// client's own reference for the transfer.
+type InternalTransferRequest struct {
+	SendersAccountNo  string `json:"sendersAccountNo"`
+	ReceiverAccountNo string `json:"receiverAccountNo"`
+	ToTag             string `json:"to_tag"`
+	BeneficiaryID     int64  `json:"beneficiary_id"`
+	Amount            int    `json:"amount"`
+	Narration         string `json:"narration"`
+	PIN               string `json:"pin"`
+	Reference         string `json:"reference"`
+}
+
+func ValidateInternalTransferRequest(v *validator.Validator, request *InternalTransferRequest) {
+	v.Check(len(request.SendersAccountNo) == 10, "sendersAccountNo", "must be 10 digits long")
+	switch {
+	case request.BeneficiaryID != 0:
+		v.Check(request.ReceiverAccountNo == "" && request.ToTag == "", "beneficiary_id", "must not be given with receiverAccountNo or to_tag")
+	case request.ToTag != "":
+		v.Check(request.ReceiverAccountNo == "", "receiverAccountNo", "must not be given with to_tag")
+	default:
+		v.Check(len(request.ReceiverAccountNo) == 10, "receiverAccountNo", "must be 10 digits long")
+	}
+	v.Check(request.ReceiverAccountNo != request.SendersAccountNo, "receiverAccountNo", "must not be the sender's account")
+	v.Check(request.Amount > 0, "amount", "must be greater than zero")
+	v.Check(len(request.PIN) == 4, "pin", "must be 4 digits long")
+	v.Check(request.Reference != "", "reference", "must be provided")
+	v.Check(len(request.Reference) <= 50, "reference", "must not be more than 50 bytes long")
+	v.Check(len(request.Narration) <= 100, "narration", "must not be more than 100 bytes long")
+}
+
+// NewInternalReference returns a random reference for an internal transfer.
+func NewInternalReference() (string, error) {
+	b := make([]byte, 14)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return "IT" + hex.EncodeToString(b), nil
+}
+
+// InternalTransferModel wraps the internal_transfers table, along with the two
+// transactions rows and the two balances that each transfer moves.
+type InternalTransferModel struct {
+	DB *DB
+}
+
+// lockBalance locks the user_details row of an account and returns its balance.
+func lockBalance(ctx context.Context, tx *Tx, accountNumber string) (decimal.Decimal, error) {
+	var balance sql.NullString
+	err := tx.QueryRowContext(ctx, `SELECT balance FROM user_details WHERE account_number = ? FOR UPDATE`, accountNumber).Scan(&balance)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return decimal.Zero, ErrRecordNotFound
+		default:
+			return decimal.Zero, err
+		}
+	}
+	if !balance.Valid || balance.String == "" {
+		return decimal.Zero, nil
+	}
+	return decimal.NewFromString(balance.String)
+}
+
+// Post records a transfer as pending before it goes to core banking: the amount is
+// counted against the sender's limits for the transfer's Channel, and the
+// cooling-off limit if it pays a beneficiary that is still cooling off, and taken
+// from their balance, and both legs are written as pending, along with the
+// internal_transfers row, all in a single database transaction. The two
+// user_details rows are locked in account number order, so that transfers going
+// opposite ways between the same accounts can't deadlock; the recipient is only
+// credited when Settle completes the transfer. A reconciliation of the transfer is
+// queued in the outbox in the same transaction, due after reconcileAfter, so that a
+// transfer left pending by a crash is seen to. A reference, or a request ID the
+// sender has used before, returns ErrDuplicateTransaction. On success the transfer's
+// Status, BalanceAfter (the sender's) and CreatedAt fields are populated.
+func (m InternalTransferModel) Post(ctx context.Context, transfer *InternalTransfer, reconcileAfter time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	accounts := []string{transfer.AccountNumber, transfer.RecipientAccountNumber}
+	sort.Strings(accounts)
+	balances := map[string]decimal.Decimal{}
+	for _, accountNumber := range accounts {
+		balances[accountNumber], err = lockBalance(ctx, tx, accountNumber)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = chargeLimit(ctx, tx, transfer.AccountNumber, transfer.Channel, transfer.Amount)
+	if err != nil {
+		return err
+	}
+	beneficiaryID, err := chargeCoolingOff(ctx, tx, transfer.BeneficiaryID, transfer.Amount, transfer.CoolingOffLimit)
+	if err != nil {
+		return err
+	}
+	amount := decimal.NewFromFloat(transfer.Amount)
+	if balances[transfer.AccountNumber].LessThan(amount) {
+		return ErrInsufficientFunds
+	}
+	senderAfter := balances[transfer.AccountNumber].Sub(amount)
+	_, err = tx.ExecContext(ctx, `UPDATE user_details SET balance = ?, updated_at = NOW() WHERE account_number = ?`, senderAfter.StringFixed(2), transfer.AccountNumber)
+	if err != nil {
+		return err
+	}
+	balanceAfter, _ := senderAfter.Float64()
+
+	legs := []struct {
+		transaction   *Transaction
+		channel       string
+		beneficiaryID sql.NullInt64
+	}{
+		{&Transaction{UserID: uint64(transfer.UserID), Type: string(Debit), AccountNumber: transfer.AccountNumber, InternalReference: transfer.DebitReference(), BalanceAfter: &balanceAfter}, transfer.Channel, beneficiaryID},
+		{&Transaction{UserID: uint64(transfer.RecipientUserID), Type: string(Credit), AccountNumber: transfer.RecipientAccountNumber, InternalReference: transfer.CreditReference()}, "", sql.NullInt64{}},
+	}
+	for _, leg := range legs {
+		t := leg.transaction
+		query := `
+		INSERT INTO transactions(user_id, type, source, narration, account_number, request_id, internal_reference, amount, status, balance_after, limit_channel, beneficiary_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		_, err = tx.ExecContext(ctx, query,
+			t.UserID,
+			t.Type,
+			SourceInternal,
+			transfer.Narration,
+			t.AccountNumber,
+			transfer.RequestID,
+			t.InternalReference,
+			transfer.Amount,
+			Pending,
+			t.BalanceAfter,
+			leg.channel,
+			leg.beneficiaryID,
+		)
+		if err != nil {
+			switch {
+			case isUniqueViolation(err):
+				return ErrDuplicateTransaction
+			default:
+				return err
+			}
+		}
+	}
+
+	query := `
+	INSERT INTO internal_transfers (reference, user_id, recipient_user_id, debit_reference, credit_reference)
+	VALUES (?, ?, ?, ?, ?)`
+	_, err = tx.ExecContext(ctx, query, transfer.Reference, transfer.UserID, transfer.RecipientUserID, transfer.DebitReference(), transfer.CreditReference())
+	if err != nil {
+		switch {
+		case isUniqueViolation(err):
+			return ErrDuplicateTransaction
+		default:
+			return err
+		}
+	}
+
+	reconcile, err := NewOutboxMessage(OutboxInternalReconcile, TransactionReference{Reference: transfer.Reference})
+	if err != nil {
+		return err
+	}
+	reconcile.NotBefore = time.Now().Add(reconcileAfter)
+	err = insertOutboxMessages(ctx, tx, reconcile)
+	if err != nil {
+		return err
+	}
+
+	var createdAt *string
+	err = tx.QueryRowContext(ctx, `SELECT created_at FROM transactions WHERE internal_reference = ?`, transfer.DebitReference()).Scan(&createdAt)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	transfer.Status = Pending
+	transfer.BalanceAfter = &balanceAfter
+	transfer.CreatedAt = createdAt
+	return nil
+}
+
+// Submit records that a pending transfer is about to be sent to core banking, after
+// which only core banking's answer can settle it. It returns ErrInvalidTransition if
+// the transfer is no longer pending, because Abandon got to it first, in which case
+// it must not be sent.
+func (m InternalTransferModel) Submit(ctx context.Context, reference string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	transfer := &InternalTransfer{Reference: reference}
+	debit, err := lockTransaction(ctx, tx, transfer.DebitReference())
+	if err != nil {
+		return err
+	}
+	if debit.Status != Pending {
+		return ErrInvalidTransition
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE internal_transfers SET submitted_at = NOW() WHERE reference = ?`, reference)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Settle applies core banking's answer to a pending transfer, with core banking's
+// reference for a completed one. Completing it credits the recipient and queues
+// both account holders' alerts and transaction.completed webhook events, each about
+// their own leg. Failing it refunds the sender and emits the transaction.failed
+// webhook event for the sender's leg; the recipient never saw the transfer, so their
+// leg is failed quietly. A transfer that has already been settled is left alone and
+// changed is false; status must be Completed or Failed, or ErrInvalidTransition is
+// returned.
+func (m InternalTransferModel) Settle(ctx context.Context, reference, status, externalReference string) (transfer *InternalTransfer, changed bool, err error) {
+	if status != Completed && status != Failed {
+		return nil, false, ErrInvalidTransition
+	}
+	return m.settle(ctx, reference, status, externalReference, false)
+}
+
+// Abandon fails a pending transfer that was never submitted to core banking, such as
+// one whose request died between Post and Submit, refunding the sender as Settle
+// does. A transfer that has already been settled is left alone and changed is
+// false. One that has been submitted returns ErrTransferSubmitted.
+func (m InternalTransferModel) Abandon(ctx context.Context, reference string) (transfer *InternalTransfer, changed bool, err error) {
+	return m.settle(ctx, reference, Failed, "", true)
+}
+
+// settle does the work of Settle and Abandon. With unsubmitted set, a transfer that
+// has been submitted to core banking is left pending and ErrTransferSubmitted
+// returned. The check is made under the debit leg's row lock, which Submit takes
+// too, so a transfer can't be abandoned while it is being sent.
+func (m InternalTransferModel) settle(ctx context.Context, reference, status, externalReference string, unsubmitted bool) (transfer *InternalTransfer, changed bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	transfer = &InternalTransfer{Reference: reference}
+	debit, err := lockTransaction(ctx, tx, transfer.DebitReference())
+	if err != nil {
+		return nil, false, err
+	}
+	credit, err := lockTransaction(ctx, tx, transfer.CreditReference())
+	if err != nil {
+		return nil, false, err
+	}
+	if debit.Status != Pending {
+		return internalTransferFromLegs(reference, debit, credit), false, nil
+	}
+	if unsubmitted {
+		var submittedAt *string
+		err = tx.QueryRowContext(ctx, `SELECT submitted_at FROM internal_transfers WHERE reference = ?`, reference).Scan(&submittedAt)
+		if err != nil {
+			return nil, false, err
+		}
+		if submittedAt != nil {
+			return nil, false, ErrTransferSubmitted
+		}
+	}
+
+	if status == Completed && externalReference != "" {
+		debit.ExternalReference = &externalReference
+		credit.ExternalReference = &externalReference
+	}
+	err = settlePending(ctx, tx, debit, status)
+	if err != nil {
+		return nil, false, err
+	}
+	if status == Completed {
+		err = settlePending(ctx, tx, credit, status)
+	} else {
+		_, err = tx.ExecContext(ctx, `UPDATE transactions SET status = ?, updated_at = NOW() WHERE id = ?`, status, credit.ID)
+		credit.Status = status
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, false, err
+	}
+	return internalTransferFromLegs(reference, debit, credit), true, nil
+}
+
+// internalTransferFromLegs puts a transfer back together from its two legs. The
+// recipient's name isn't stored.
+func internalTransferFromLegs(reference string, debit, credit *Transaction) *InternalTransfer {
+	return &InternalTransfer{
+		Reference:              reference,
+		RequestID:              debit.RequestID,
+		UserID:                 int64(debit.UserID),
+		AccountNumber:          debit.AccountNumber,
+		RecipientUserID:        int64(credit.UserID),
+		RecipientAccountNumber: credit.AccountNumber,
+		ExternalReference:      debit.ExternalReference,
+		Amount:                 debit.Amount,
+		Narration:              debit.Narration,
+		Status:                 debit.Status,
+		BalanceAfter:           debit.BalanceAfter,
+		CreatedAt:              debit.CreatedAt,
+	}
+}
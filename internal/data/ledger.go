@@ -0,0 +1,51 @@
+package data
+
+import (
+	"context"
+
+	"github.com/shopspring/decimal"
+)
+
+// The bank's own ledger accounts, kept in ledger_accounts. Money a customer sends
+// out of the bank waits in the suspense account for its network until the network
+// answers: a completed payment moves it on to the network's settlement account, and
+// a failed one hands it back to the customer. Fees are credited to fee income when
+// they are charged and debited again if they are refunded. Balances aren't stored:
+// the ledger_balances view sums them from ledger_entries, so that payments only
+// append entries rather than all queueing to update the same few rows.
+const (
+	LedgerInterbankSuspense   = "interbank_suspense"
+	LedgerInterbankSettlement = "interbank_settlement"
+	LedgerBillsSuspense       = "bills_suspense"
+	LedgerBillsSettlement     = "bills_settlement"
+	LedgerFeeIncome           = "fee_income"
+)
+
+// LedgerEntry is a movement on one of the ledger accounts. A credit adds Amount to
+// the account's balance and a debit takes it away, so that the entries made for a
+// payment, together with the customer's own transaction, always net to zero.
+type LedgerEntry struct {
+	Ledger string
+	Type   TransactionType
+	Amount decimal.Decimal
+}
+
+// postLedger records entries in ledger_entries against reference, as part of tx.
+// Entries for nothing are skipped.
+func postLedger(ctx context.Context, tx *Tx, reference string, entries ...LedgerEntry) error {
+	for _, entry := range entries {
+		if entry.Amount.IsZero() {
+			continue
+		}
+		_, err := tx.ExecContext(ctx, `INSERT INTO ledger_entries (ledger_code, reference, type, amount) VALUES (?, ?, ?, ?)`,
+			entry.Ledger,
+			reference,
+			string(entry.Type),
+			entry.Amount.StringFixed(2),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
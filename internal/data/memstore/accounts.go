@@ -0,0 +1,828 @@
+package memstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gopkg.in/guregu/null.v4"
+
+	"github.com/ebitezion/backend-framework/internal/data"
+)
+
+// account is a row of user_details.
+type account struct {
+	userID    int64
+	number    string
+	limits    string
+	counter   string
+	pin       string
+	balance   decimal.Decimal
+	createdAt string
+	updatedAt string
+	// pinFailures counts USSD PIN checks since the last one that passed.
+	pinFailures int
+}
+
+// accountByUserID returns the account of a user whose ID is passed as a string.
+func (s *Store) accountByUserID(userID string) (*account, error) {
+	id, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		return nil, data.ErrRecordNotFound
+	}
+	a, ok := s.accounts[id]
+	if !ok {
+		return nil, data.ErrRecordNotFound
+	}
+	return a, nil
+}
+
+// accountByNumber returns the account with the given number, or nil.
+func (s *Store) accountByNumber(number string) *account {
+	for _, a := range s.accounts {
+		if a.number == number {
+			return a
+		}
+	}
+	return nil
+}
+
+// chargeLimit returns the account's usage counts with a debit of amount on channel
+// counted against its limits, as the SQL models' chargeLimit does, or the error that
+// turns the debit away. The counts aren't stored, so that the caller can still fail
+// without undoing them. A channel of "" isn't limited.
+func (a *account) chargeLimit(channel string, amount float64) (string, error) {
+	if channel == "" {
+		return a.counter, nil
+	}
+	var limits data.Limits
+	var counter data.LimitCounts
+	err := json.Unmarshal([]byte(a.limits), &limits)
+	if err != nil {
+		return "", err
+	}
+	err = json.Unmarshal([]byte(a.counter), &counter)
+	if err != nil {
+		return "", err
+	}
+	counter, err = limits.Check(counter, channel, data.LimitAmount(amount))
+	if err != nil {
+		return "", err
+	}
+	encoded, err := json.Marshal(counter)
+	return string(encoded), err
+}
+
+// releaseLimit gives back what the failed debit t counted against its payer's
+// limits.
+func (s *Store) releaseLimit(t *data.Transaction) error {
+	channel := s.limitChannels[t.InternalReference]
+	a := s.accountByNumber(t.AccountNumber)
+	if channel == "" || a == nil {
+		return nil
+	}
+	var counter data.LimitCounts
+	err := json.Unmarshal([]byte(a.counter), &counter)
+	if err != nil {
+		return err
+	}
+	counter.Release(channel, data.LimitAmount(t.Amount))
+	encoded, err := json.Marshal(counter)
+	if err != nil {
+		return err
+	}
+	a.counter = string(encoded)
+	return nil
+}
+
+// limitRequest is a row of limit_upgrade_requests.
+type limitRequest struct {
+	request data.UpgradeLimitRequest
+	status  string
+}
+
+type accountRepo struct{ s *Store }
+
+func (r accountRepo) UpdateLimitStatus(_ context.Context, LimitID int) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if req, ok := r.s.limitRequests[int64(LimitID)]; ok {
+		req.status = data.Completed
+	}
+	return nil
+}
+
+// updateAccount applies fn to the user's account, if there is one.
+func (r accountRepo) updateAccount(userID string, fn func(a *account)) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if a, err := r.s.accountByUserID(userID); err == nil {
+		fn(a)
+	}
+	return nil
+}
+
+func (r accountRepo) UpdateLimitInDB(_ context.Context, limit string, userID string) error {
+	return r.updateAccount(userID, func(a *account) {
+		a.limits = limit
+	})
+}
+
+func (r accountRepo) UpdateLimitCounterInDB(_ context.Context, counter data.LimitCounts, userID string) error {
+	encoded, err := json.Marshal(counter)
+	if err != nil {
+		return err
+	}
+	return r.updateAccount(userID, func(a *account) {
+		a.counter = string(encoded)
+	})
+}
+
+func (r accountRepo) GetAccountLimits(_ context.Context, userID string) (string, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	a, err := r.s.accountByUserID(userID)
+	if err != nil {
+		return "", err
+	}
+	return a.limits, nil
+}
+
+func (r accountRepo) GetUserAccountNoByID(_ context.Context, userID string) (string, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	a, err := r.s.accountByUserID(userID)
+	if err != nil {
+		return "", err
+	}
+	return a.number, nil
+}
+
+func (r accountRepo) GetLimitUpgradeRequest(_ context.Context, LimitID int) (*data.UpgradeLimitRequest, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	req, ok := r.s.limitRequests[int64(LimitID)]
+	if !ok {
+		return nil, data.ErrRecordNotFound
+	}
+	request := req.request
+	return &request, nil
+}
+
+func (r accountRepo) CreateNewLimitRequest(_ context.Context, request *data.UpgradeLimitRequest) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if r.s.userByID(request.UserID) == nil {
+		return errForeignKey("limit_upgrade_requests_user_id_fk")
+	}
+	r.s.limitRequests[r.s.nextID("limit_upgrade_requests")] = &limitRequest{request: *request, status: data.Pending}
+	return nil
+}
+
+func (r accountRepo) NewMaillingList(_ context.Context, email string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if r.s.mailingList[email] {
+		return errDuplicate("mailing_list_email_key")
+	}
+	r.s.mailingList[email] = true
+	return nil
+}
+
+func (r accountRepo) NewAccountUpgrade(_ context.Context, upgrade *data.AccountUpgradeData) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	err := r.s.requireUser(upgrade.UserID, "account_upgrade_user_id_fk")
+	if err != nil {
+		return err
+	}
+
+	upgrade.Status = data.Pending
+	upgrade.ID = r.s.nextID("account_upgrade")
+	stored := *upgrade
+	stored.CreatedAt = now()
+	r.s.upgrades[stored.ID] = &stored
+	return nil
+}
+
+func (r accountRepo) GetAccountUpgrade(_ context.Context, id int64) (*data.AccountUpgradeData, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	upgrade, ok := r.s.upgrades[id]
+	if !ok {
+		return nil, data.ErrRecordNotFound
+	}
+	found := *upgrade
+	return &found, nil
+}
+
+func (r accountRepo) GetAccountUpgradesByStatus(_ context.Context, status string) ([]*data.AccountUpgradeData, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	upgrades := []*data.AccountUpgradeData{}
+	for id := int64(1); id <= r.s.seq["account_upgrade"]; id++ {
+		if upgrade, ok := r.s.upgrades[id]; ok && upgrade.Status == status {
+			found := *upgrade
+			upgrades = append(upgrades, &found)
+		}
+	}
+	return upgrades, nil
+}
+
+func (r accountRepo) ReviewAccountUpgrade(_ context.Context, upgrade *data.AccountUpgradeData, status string, reviewerID int64, note string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	stored, ok := r.s.upgrades[upgrade.ID]
+	if !ok || stored.Status != data.Pending {
+		return data.ErrEditConflict
+	}
+
+	if status == data.Approved {
+		event, err := data.NewWebhookEventMessage(upgrade.UserID, data.EventKYCUpgraded, map[string]interface{}{
+			"upgrade_id":     upgrade.ID,
+			"account_number": upgrade.AccountNumber,
+			"kyc_level":      data.KYCLEVEL4,
+		})
+		if err != nil {
+			return err
+		}
+		if rec, ok := r.s.users[upgrade.UserID]; ok {
+			rec.user.KYC_level, _ = strconv.Atoi(data.KYCLEVEL4)
+			rec.user.Account_upgraded = null.BoolFrom(true)
+			rec.user.Address_verified = null.BoolFrom(true)
+		}
+		r.s.enqueue(event)
+	}
+
+	reviewedAt := now()
+	stored.Status = status
+	stored.ReviewedBy = &reviewerID
+	stored.ReviewNote = note
+	stored.ReviewedAt = &reviewedAt
+
+	upgrade.Status = status
+	upgrade.ReviewedBy = &reviewerID
+	upgrade.ReviewNote = note
+	return nil
+}
+
+// insertAccount adds a row to user_details, enforcing its keys.
+func (s *Store) insertAccount(a *account) error {
+	if _, ok := s.accounts[a.userID]; ok {
+		return errDuplicate("user_details.PRIMARY")
+	}
+	if s.accountByNumber(a.number) != nil {
+		return errDuplicate("user_details_account_number_key")
+	}
+	err := s.requireUser(a.userID, "user_details_user_id_fk")
+	if err != nil {
+		return err
+	}
+	s.accounts[a.userID] = a
+	return nil
+}
+
+func (r accountRepo) SaveCreatedAccountNo(_ context.Context, details *data.AccountDetails) error {
+	if details.Limits == "" {
+		details.Limits = data.DefaultLimits
+	}
+	if details.Counter == "" {
+		details.Counter = data.DefaultCounter
+	}
+	createdAt := now()
+	if details.Created_at == "" {
+		details.Created_at = createdAt
+	}
+	if details.Updated_at == "" {
+		details.Updated_at = createdAt
+	}
+
+	event, err := data.NewWebhookEventMessage(int64(details.User_id), data.EventAccountCreated, map[string]interface{}{
+		"account_number": details.Account_number,
+	})
+	if err != nil {
+		return err
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	err = r.s.insertAccount(&account{
+		userID:    int64(details.User_id),
+		number:    details.Account_number,
+		limits:    details.Limits,
+		counter:   details.Counter,
+		createdAt: details.Created_at,
+		updatedAt: details.Updated_at,
+	})
+	if err != nil {
+		return err
+	}
+	r.s.enqueue(event)
+	return nil
+}
+
+func (r accountRepo) SaveCreatedAccountNo2(_ context.Context, User_id, Account_number, Limits, Counter string, Created_at, Updated_at time.Time) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	id, err := strconv.ParseInt(User_id, 10, 64)
+	if err != nil {
+		return errForeignKey("user_details_user_id_fk")
+	}
+	return r.s.insertAccount(&account{
+		userID:    id,
+		number:    Account_number,
+		limits:    Limits,
+		counter:   Counter,
+		createdAt: Created_at.Format(timeFormat),
+		updatedAt: Updated_at.Format(timeFormat),
+	})
+}
+
+func (r accountRepo) GetAccountProfile(_ context.Context, userID int64) (*data.AccountProfile, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	rec, ok := r.s.users[userID]
+	if !ok {
+		return nil, data.ErrRecordNotFound
+	}
+
+	user := rec.user
+	kycLevel := strconv.Itoa(user.KYC_level)
+	profile := &data.AccountProfile{
+		AccountName: &user.Name,
+		Email:       &user.Email,
+		PhoneNumber: &user.PhoneNumber,
+		KycLevel:    &kycLevel,
+		Username:    &user.Username,
+	}
+	// The transfer tag and its account number come from transfer_tag, as the SQL
+	// model's LEFT JOIN does.
+	if tag, ok := r.s.tags[userID]; ok {
+		profile.TransferTag = &tag.tag
+		profile.AccountNumber = &tag.accountNumber
+	}
+	return profile, nil
+}
+
+func (r accountRepo) GetAccountHolder(_ context.Context, accountNumber string) (*data.AccountHolder, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	a := r.s.accountByNumber(accountNumber)
+	if a == nil {
+		return nil, data.ErrRecordNotFound
+	}
+	user := r.s.users[a.userID].user
+	holder := &data.AccountHolder{
+		UserID:        a.userID,
+		AccountNumber: a.number,
+		Name:          user.Name,
+		KYCLevel:      user.KYC_level,
+	}
+	// The BVN comes from the latest approved account upgrade.
+	var latest int64
+	for id, upgrade := range r.s.upgrades {
+		if upgrade.UserID == a.userID && upgrade.Status == data.Approved && id > latest {
+			latest = id
+			holder.BVN = upgrade.BVN
+		}
+	}
+	return holder, nil
+}
+
+type transactionRepo struct{ s *Store }
+
+// insertTransaction adds a row to transactions. duplicate is returned if the
+// internal reference has been used before, or a debit repeats a request ID on its
+// account.
+func (s *Store) insertTransaction(transaction *data.Transaction, duplicate error) error {
+	for _, t := range s.transactions {
+		if t.InternalReference == transaction.InternalReference || isRepeatedDebit(t, transaction) {
+			return duplicate
+		}
+	}
+	err := s.requireUser(int64(transaction.UserID), "transactions_user_id_fk")
+	if err != nil {
+		return err
+	}
+
+	stored := *transaction
+	stored.ID = uint64(s.nextID("transactions"))
+	createdAt := now()
+	stored.CreatedAt = &createdAt
+	stored.UpdatedAt = &createdAt
+	if stored.Status == "" {
+		stored.Status = data.Pending
+	}
+	s.transactions = append(s.transactions, &stored)
+	transaction.ID = stored.ID
+	return nil
+}
+
+// isRepeatedDebit reports whether t breaks the unique key on the request IDs of debits
+// that stored holds.
+func isRepeatedDebit(stored, t *data.Transaction) bool {
+	return t.Type == string(data.Debit) && t.RequestID != "" &&
+		stored.Type == t.Type && stored.AccountNumber == t.AccountNumber && stored.RequestID == t.RequestID
+}
+
+func (r transactionRepo) PostTransaction(_ context.Context, transaction *data.Transaction) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	a := r.s.accountByNumber(transaction.AccountNumber)
+	if a == nil {
+		return data.ErrRecordNotFound
+	}
+
+	amount := decimal.NewFromFloat(transaction.Amount)
+	var after decimal.Decimal
+	switch data.TransactionType(transaction.Type) {
+	case data.Debit:
+		if a.balance.LessThan(amount) {
+			return data.ErrInsufficientFunds
+		}
+		after = a.balance.Sub(amount)
+	default:
+		after = a.balance.Add(amount)
+	}
+
+	alert, err := data.NewOutboxMessage(data.OutboxTransactionAlert, data.TransactionReference{Reference: transaction.InternalReference})
+	if err != nil {
+		return err
+	}
+
+	balanceAfter, _ := after.Float64()
+	completed := *transaction
+	completed.BalanceAfter = &balanceAfter
+	err = r.s.insertTransaction(&completed, data.ErrDuplicateTransaction)
+	if err != nil {
+		return err
+	}
+
+	// The event carries the ID the insert assigned, so it is built afterwards.
+	event, err := data.NewWebhookEventMessage(int64(transaction.UserID), data.EventTransactionCompleted, completed)
+	if err != nil {
+		r.s.transactions = r.s.transactions[:len(r.s.transactions)-1]
+		return err
+	}
+	r.s.enqueue(alert, event)
+
+	a.balance = after.Round(2)
+	a.updatedAt = now()
+	*transaction = completed
+	return nil
+}
+
+func (r transactionRepo) GetTransactionByReference(_ context.Context, reference string) (*data.Transaction, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for _, t := range r.s.transactions {
+		if t.InternalReference == reference {
+			found := *t
+			return &found, nil
+		}
+	}
+	return nil, data.ErrRecordNotFound
+}
+
+func (r transactionRepo) GetDebitByRequestID(_ context.Context, accountNumber, requestID string) (*data.Transaction, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for _, t := range r.s.transactions {
+		if t.AccountNumber == accountNumber && t.RequestID == requestID && data.TransactionType(t.Type) == data.Debit {
+			found := *t
+			return &found, nil
+		}
+	}
+	return nil, data.ErrRecordNotFound
+}
+
+func (r transactionRepo) SaveTransactionDetails(_ context.Context, transaction *data.Transaction) error {
+	var event *data.OutboxMessage
+	if transaction.Status == data.Failed {
+		var err error
+		event, err = data.NewWebhookEventMessage(int64(transaction.UserID), data.EventTransactionFailed, transaction)
+		if err != nil {
+			return err
+		}
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	// The SQL model doesn't report the new row's ID, so the caller's copy is left
+	// alone.
+	stored := *transaction
+	err := r.s.insertTransaction(&stored, errDuplicate("transactions_internal_reference_key"))
+	if err != nil {
+		return err
+	}
+	if event != nil {
+		r.s.enqueue(event)
+	}
+	return nil
+}
+
+func (r transactionRepo) GetAccountHistory(_ context.Context, accountNumber string, pagination string) ([]data.Transaction, error) {
+	number, err := strconv.Atoi(pagination)
+	if err != nil {
+		return nil, err
+	}
+	offset := (number - 1) * 10
+	if offset < 0 {
+		return nil, errors.New("memstore: negative OFFSET")
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	var transactions []data.Transaction
+	for i := len(r.s.transactions) - 1; i >= 0 && len(transactions) < 10; i-- {
+		t := r.s.transactions[i]
+		if t.AccountNumber != accountNumber {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		transactions = append(transactions, *t)
+	}
+	if len(transactions) == 0 {
+		return []data.Transaction{}, data.ErrRecordNotFound
+	}
+	return transactions, nil
+}
+
+func (r transactionRepo) InitiatePayment(_ context.Context, transaction *data.Transaction, requeryAfter time.Duration) error {
+	requery, err := data.NewOutboxMessage(data.OutboxPaymentRequery, data.TransactionReference{Reference: transaction.InternalReference})
+	if err != nil {
+		return err
+	}
+	requery.NotBefore = time.Now().Add(requeryAfter)
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	a := r.s.accountByNumber(transaction.AccountNumber)
+	if a == nil {
+		return data.ErrRecordNotFound
+	}
+
+	pending := *transaction
+	pending.Status = data.Pending
+	pending.ExternalReference = nil
+	after, counter, channel := a.balance, a.counter, ""
+	if data.TransactionType(transaction.Type) == data.Debit {
+		channel = data.ChannelTransfers
+		counter, err = a.chargeLimit(channel, transaction.Amount)
+		if err != nil {
+			return err
+		}
+		amount := decimal.NewFromFloat(transaction.Amount)
+		if a.balance.LessThan(amount) {
+			return data.ErrInsufficientFunds
+		}
+		after = a.balance.Sub(amount).Round(2)
+		balanceAfter, _ := after.Float64()
+		pending.BalanceAfter = &balanceAfter
+	}
+	err = r.s.insertTransaction(&pending, data.ErrDuplicateTransaction)
+	if err != nil {
+		return err
+	}
+	r.s.enqueue(requery)
+	if channel != "" {
+		r.s.limitChannels[pending.InternalReference] = channel
+	}
+	a.counter = counter
+	if !after.Equal(a.balance) {
+		a.balance = after
+		a.updatedAt = now()
+	}
+
+	stored := r.s.transactions[len(r.s.transactions)-1]
+	transaction.ID = stored.ID
+	transaction.Status = stored.Status
+	transaction.BalanceAfter = stored.BalanceAfter
+	transaction.CreatedAt = stored.CreatedAt
+	return nil
+}
+
+func (r transactionRepo) SettlePayment(_ context.Context, reference, status, externalReference string) (*data.Transaction, bool, error) {
+	if status != data.Completed && status != data.Failed {
+		return nil, false, data.ErrInvalidTransition
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	t := r.s.transaction(reference)
+	if t == nil {
+		return nil, false, data.ErrRecordNotFound
+	}
+	if t.Status != data.Pending {
+		found := *t
+		return &found, false, nil
+	}
+
+	settled := *t
+	if status == data.Completed && externalReference != "" {
+		settled.ExternalReference = &externalReference
+	}
+	err := r.s.settlePending(t, &settled, status)
+	if err != nil {
+		return nil, false, err
+	}
+	found := settled
+	return &found, true, nil
+}
+
+// transaction returns the row of transactions with the given internal reference, or
+// nil.
+func (s *Store) transaction(reference string) *data.Transaction {
+	for _, t := range s.transactions {
+		if t.InternalReference == reference {
+			return t
+		}
+	}
+	return nil
+}
+
+// settlePending stores settled, a copy of the pending transaction t moved to status,
+// with the balance change and the outbox messages that the SQL model's settlePending
+// makes.
+func (s *Store) settlePending(t, settled *data.Transaction, status string) error {
+	a := s.accountByNumber(t.AccountNumber)
+	if a == nil {
+		return data.ErrRecordNotFound
+	}
+	settled.Status = status
+	updatedAt := now()
+	settled.UpdatedAt = &updatedAt
+
+	balance := a.balance
+	debit := data.TransactionType(t.Type) == data.Debit
+	if (status == data.Failed && debit) || (status == data.Completed && !debit) {
+		balance = a.balance.Add(decimal.NewFromFloat(t.Amount)).Round(2)
+		if status == data.Completed {
+			balanceAfter, _ := balance.Float64()
+			settled.BalanceAfter = &balanceAfter
+		}
+	}
+
+	var messages []*data.OutboxMessage
+	if status == data.Failed {
+		event, err := data.NewWebhookEventMessage(int64(t.UserID), data.EventTransactionFailed, *settled)
+		if err != nil {
+			return err
+		}
+		messages = append(messages, event)
+		if debit {
+			err = s.releaseLimit(t)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		alert, err := data.NewOutboxMessage(data.OutboxTransactionAlert, data.TransactionReference{Reference: t.InternalReference})
+		if err != nil {
+			return err
+		}
+		event, err := data.NewWebhookEventMessage(int64(t.UserID), data.EventTransactionCompleted, *settled)
+		if err != nil {
+			return err
+		}
+		messages = append(messages, alert, event)
+	}
+
+	s.enqueue(messages...)
+	if !balance.Equal(a.balance) {
+		a.balance = balance
+		a.updatedAt = updatedAt
+	}
+	*t = *settled
+	return nil
+}
+
+func (r transactionRepo) SettleTransaction(_ context.Context, nonce, reference, status string) (*data.Transaction, bool, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if _, ok := r.s.nonces[nonce]; ok {
+		return nil, false, data.ErrDuplicateCallback
+	}
+
+	t := r.s.transaction(reference)
+	if t == nil {
+		return nil, false, data.ErrRecordNotFound
+	}
+	switch t.Source {
+	case data.SourceInternal, data.SourceInterbank, data.SourceBills:
+		return nil, false, data.ErrInvalidTransition
+	}
+
+	var reversed *account
+	var balance decimal.Decimal
+	switch {
+	case t.Status == status:
+		// Nothing to do, but keep the nonce so the callback isn't processed again.
+		r.s.nonces[nonce] = time.Now()
+		found := *t
+		return &found, false, nil
+	case t.Status == data.Pending && (status == data.Completed || status == data.Failed):
+		settled := *t
+		err := r.s.settlePending(t, &settled, status)
+		if err != nil {
+			return nil, false, err
+		}
+		r.s.nonces[nonce] = time.Now()
+		return &settled, true, nil
+	case t.Status == data.Completed && status == data.Failed:
+		reversed = r.s.accountByNumber(t.AccountNumber)
+		if reversed == nil {
+			return nil, false, data.ErrRecordNotFound
+		}
+		amount := decimal.NewFromFloat(t.Amount)
+		switch data.TransactionType(t.Type) {
+		case data.Debit:
+			balance = reversed.balance.Add(amount)
+		default:
+			if reversed.balance.LessThan(amount) {
+				return nil, false, data.ErrInsufficientFunds
+			}
+			balance = reversed.balance.Sub(amount)
+		}
+	default:
+		return nil, false, data.ErrInvalidTransition
+	}
+
+	settled := *t
+	settled.Status = status
+	updatedAt := now()
+	settled.UpdatedAt = &updatedAt
+	event, err := data.NewWebhookEventMessage(int64(t.UserID), data.EventTransactionFailed, settled)
+	if err != nil {
+		return nil, false, err
+	}
+	if data.TransactionType(t.Type) == data.Debit {
+		err = r.s.releaseLimit(t)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	r.s.enqueue(event)
+
+	reversed.balance = balance.Round(2)
+	reversed.updatedAt = updatedAt
+	*t = settled
+	r.s.nonces[nonce] = time.Now()
+	found := settled
+	return &found, true, nil
+}
+
+// transferTag is a row of transfer_tag.
+type transferTag struct {
+	tag           string
+	accountNumber string
+	updatedAt     time.Time
+}
+
+type transferTagRepo struct{ s *Store }
+
+func (r transferTagRepo) Claim(_ context.Context, tag *data.TransferTag, cooldown time.Duration) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	current, ok := r.s.tags[tag.UserID]
+	switch {
+	case ok && current.tag == tag.Tag:
+		return nil
+	case ok && time.Since(current.updatedAt) < cooldown:
+		return data.ErrTransferTagCooldown
+	}
+	for userID, other := range r.s.tags {
+		if other.tag == tag.Tag && userID != tag.UserID {
+			return data.ErrDuplicateTransferTag
+		}
+	}
+	err := r.s.requireUser(tag.UserID, "transfer_tag_user_id_fk")
+	if err != nil {
+		return err
+	}
+	r.s.tags[tag.UserID] = &transferTag{tag: tag.Tag, accountNumber: tag.AccountNumber, updatedAt: time.Now()}
+	return nil
+}
+
+func (r transferTagRepo) GetByTag(_ context.Context, tag string) (*data.TransferTag, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for userID, stored := range r.s.tags {
+		if stored.tag == tag {
+			return &data.TransferTag{
+				UserID:        userID,
+				Tag:           stored.tag,
+				AccountNumber: stored.accountNumber,
+				AccountName:   r.s.users[userID].user.Name,
+			}, nil
+		}
+	}
+	return nil, data.ErrRecordNotFound
+}
@@ -0,0 +1,190 @@
+package memstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/ebitezion/backend-framework/internal/data"
+)
+
+type billPaymentRepo struct{ s *Store }
+
+// billPayment joins a row of bill_payments to its transactions row, as the SQL
+// model's query does.
+func (s *Store) billPayment(reference string) (*data.BillPayment, *data.Transaction) {
+	stored, ok := s.billPayments[reference]
+	if !ok {
+		return nil, nil
+	}
+	for _, t := range s.transactions {
+		if t.InternalReference == reference {
+			payment := *stored
+			payment.RequestID = t.RequestID
+			payment.AccountNumber = t.AccountNumber
+			payment.Amount = t.Amount
+			payment.Narration = t.Narration
+			payment.Status = t.Status
+			payment.CreatedAt = t.CreatedAt
+			return &payment, t
+		}
+	}
+	return nil, nil
+}
+
+func (r billPaymentRepo) Debit(_ context.Context, payment *data.BillPayment, requeryAfter time.Duration) error {
+	requery, err := data.NewOutboxMessage(data.OutboxBillRequery, data.TransactionReference{Reference: payment.Reference})
+	if err != nil {
+		return err
+	}
+	requery.NotBefore = time.Now().Add(requeryAfter)
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	a := r.s.accountByNumber(payment.AccountNumber)
+	if a == nil {
+		return data.ErrRecordNotFound
+	}
+	counter, err := a.chargeLimit(data.ChannelBills, payment.Amount)
+	if err != nil {
+		return err
+	}
+	amount := decimal.NewFromFloat(payment.Amount)
+	if a.balance.LessThan(amount) {
+		return data.ErrInsufficientFunds
+	}
+	if _, ok := r.s.billPayments[payment.Reference]; ok {
+		return data.ErrDuplicateTransaction
+	}
+	err = r.s.requireUser(payment.UserID, "bill_payments_user_id_fk")
+	if err != nil {
+		return err
+	}
+
+	after := a.balance.Sub(amount).Round(2)
+	balanceAfter, _ := after.Float64()
+	debit := &data.Transaction{
+		UserID:            uint64(payment.UserID),
+		Type:              string(data.Debit),
+		Source:            data.SourceBills,
+		Narration:         payment.Narration,
+		AccountNumber:     payment.AccountNumber,
+		RequestID:         payment.RequestID,
+		InternalReference: payment.Reference,
+		Amount:            payment.Amount,
+		Status:            data.Pending,
+		BalanceAfter:      &balanceAfter,
+	}
+	err = r.s.insertTransaction(debit, data.ErrDuplicateTransaction)
+	if err != nil {
+		return err
+	}
+
+	stored := *payment
+	stored.BillerReference, stored.Token = "", ""
+	r.s.billPayments[stored.Reference] = &stored
+	r.s.postLedger(data.LedgerEntry{Ledger: data.LedgerBillsSuspense, Type: data.Credit, Amount: amount})
+	r.s.enqueue(requery)
+	r.s.limitChannels[payment.Reference] = data.ChannelBills
+	a.balance, a.counter = after, counter
+	a.updatedAt = now()
+
+	_, t := r.s.billPayment(payment.Reference)
+	payment.Status = data.Pending
+	payment.CreatedAt = t.CreatedAt
+	return nil
+}
+
+func (r billPaymentRepo) Get(_ context.Context, reference string) (*data.BillPayment, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	payment, _ := r.s.billPayment(reference)
+	if payment == nil {
+		return nil, data.ErrRecordNotFound
+	}
+	return payment, nil
+}
+
+func (r billPaymentRepo) GetAllForUser(_ context.Context, userID int64) ([]*data.BillPayment, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	payments := []*data.BillPayment{}
+	for i := len(r.s.transactions) - 1; i >= 0; i-- {
+		payment, _ := r.s.billPayment(r.s.transactions[i].InternalReference)
+		if payment != nil && payment.UserID == userID {
+			payments = append(payments, payment)
+		}
+	}
+	return payments, nil
+}
+
+func (r billPaymentRepo) Settle(_ context.Context, reference, status, billerReference, token string) (*data.BillPayment, bool, error) {
+	if status != data.Completed && status != data.Failed {
+		return nil, false, data.ErrInvalidTransition
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	payment, t := r.s.billPayment(reference)
+	if payment == nil {
+		return nil, false, data.ErrRecordNotFound
+	}
+	if payment.Status != data.Pending {
+		return payment, false, nil
+	}
+
+	settled := *t
+	settled.Status = status
+	updatedAt := now()
+	settled.UpdatedAt = &updatedAt
+	var messages []*data.OutboxMessage
+	switch status {
+	case data.Completed:
+		if billerReference != "" {
+			settled.ExternalReference = &billerReference
+		}
+		alert, err := data.NewOutboxMessage(data.OutboxTransactionAlert, data.TransactionReference{Reference: reference})
+		if err != nil {
+			return nil, false, err
+		}
+		event, err := data.NewWebhookEventMessage(payment.UserID, data.EventTransactionCompleted, settled)
+		if err != nil {
+			return nil, false, err
+		}
+		messages = append(messages, alert, event)
+	case data.Failed:
+		event, err := data.NewWebhookEventMessage(payment.UserID, data.EventTransactionFailed, settled)
+		if err != nil {
+			return nil, false, err
+		}
+		messages = append(messages, event)
+	}
+
+	amount := decimal.NewFromFloat(payment.Amount)
+	if status == data.Failed {
+		a := r.s.accountByNumber(payment.AccountNumber)
+		if a == nil {
+			return nil, false, data.ErrRecordNotFound
+		}
+		err := r.s.releaseLimit(t)
+		if err != nil {
+			return nil, false, err
+		}
+		a.balance = a.balance.Add(amount).Round(2)
+		a.updatedAt = updatedAt
+		r.s.postLedger(data.LedgerEntry{Ledger: data.LedgerBillsSuspense, Type: data.Debit, Amount: amount})
+	} else {
+		stored := r.s.billPayments[reference]
+		stored.BillerReference, stored.Token = billerReference, token
+		payment.BillerReference, payment.Token = billerReference, token
+		r.s.postLedger(
+			data.LedgerEntry{Ledger: data.LedgerBillsSuspense, Type: data.Debit, Amount: amount},
+			data.LedgerEntry{Ledger: data.LedgerBillsSettlement, Type: data.Credit, Amount: amount},
+		)
+	}
+	*t = settled
+	r.s.enqueue(messages...)
+	payment.Status = status
+	return payment, true, nil
+}
@@ -0,0 +1,201 @@
+// Package memstore is an in-memory implementation of the data repositories, for
+// tests that exercise handlers without a database.
+//
+// It follows the contracts of the SQL models rather than their queries: the same
+// sentinel errors come back in the same situations (ErrRecordNotFound,
+// ErrDuplicateEmailOrUsername, ErrEditConflict, ErrInsufficientFunds and so on),
+// unique keys and foreign keys are enforced, and the outbox messages and webhook
+// events that the SQL models write alongside a change are written here too. A write
+// that fails leaves the store untouched, as a rolled back transaction would.
+package memstore
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/ebitezion/backend-framework/internal/data"
+)
+
+// timeFormat is how MySQL returns timestamps into the models' string fields.
+const timeFormat = "2006-01-02 15:04:05"
+
+// permissionCodes are the permissions created by the migrations.
+var permissionCodes = []string{"account:read", "account:write", "kyc:review", "webhooks:manage"}
+
+// Store holds every table. One mutex guards the lot, so each repository method is
+// atomic and isolated, like a database transaction.
+type Store struct {
+	mu  sync.Mutex
+	seq map[string]int64
+
+	users         map[int64]*userRecord
+	tokens        map[[32]byte]data.Token
+	permissions   map[string]bool
+	grants        map[int64]data.Permissions
+	accounts      map[int64]*account
+	limitRequests map[int64]*limitRequest
+	mailingList   map[string]bool
+	upgrades      map[int64]*data.AccountUpgradeData
+	transactions  []*data.Transaction
+	nonces        map[string]time.Time
+	preferences   map[int64]data.NotificationPreferences
+	outbox        map[int64]*outboxMessage
+	endpoints     map[int64]*data.WebhookEndpoint
+	deliveries    map[int64]*data.WebhookDelivery
+	// deliveryOutbox holds the outbox_id column of webhook_deliveries.
+	deliveryOutbox map[int64]int64
+	callbacks      []*data.ProviderCallback
+	nameEnquiries  map[string]*data.NameEnquiry
+	// interbankTransfers holds the rows of interbank_transfers. The amount, status
+	// and so on live in the matching transactions row.
+	interbankTransfers map[string]*data.InterbankTransfer
+	// internalTransfers holds the rows of internal_transfers, which link the two legs
+	// of each transfer.
+	internalTransfers map[string]*data.InternalTransfer
+	// submittedTransfers holds the references of the internal_transfers rows whose
+	// submitted_at is set.
+	submittedTransfers map[string]bool
+	tags               map[int64]*transferTag
+	beneficiaries      map[int64]*data.Beneficiary
+	schedules          map[int64]*data.Schedule
+	scheduleRuns       []*data.ScheduleRun
+	// billPayments holds the rows of bill_payments. Like interbankTransfers, the
+	// amount and status live in the matching transactions row.
+	billPayments map[string]*data.BillPayment
+	// ledgerEntries holds the rows of ledger_entries.
+	ledgerEntries []data.LedgerEntry
+	// limitChannels holds the limit_channel column of transactions, by internal
+	// reference, for the debits that were counted against a limit.
+	limitChannels map[string]string
+	// beneficiaryPayments holds the beneficiary_id column of transactions, by
+	// internal reference, for the debits that paid a saved beneficiary.
+	beneficiaryPayments map[string]int64
+}
+
+// New returns an empty store, with only the permission codes from the migrations.
+func New() *Store {
+	s := &Store{
+		seq:                 map[string]int64{},
+		users:               map[int64]*userRecord{},
+		tokens:              map[[32]byte]data.Token{},
+		permissions:         map[string]bool{},
+		grants:              map[int64]data.Permissions{},
+		accounts:            map[int64]*account{},
+		limitRequests:       map[int64]*limitRequest{},
+		mailingList:         map[string]bool{},
+		upgrades:            map[int64]*data.AccountUpgradeData{},
+		nonces:              map[string]time.Time{},
+		preferences:         map[int64]data.NotificationPreferences{},
+		outbox:              map[int64]*outboxMessage{},
+		endpoints:           map[int64]*data.WebhookEndpoint{},
+		deliveries:          map[int64]*data.WebhookDelivery{},
+		deliveryOutbox:      map[int64]int64{},
+		nameEnquiries:       map[string]*data.NameEnquiry{},
+		interbankTransfers:  map[string]*data.InterbankTransfer{},
+		internalTransfers:   map[string]*data.InternalTransfer{},
+		submittedTransfers:  map[string]bool{},
+		tags:                map[int64]*transferTag{},
+		beneficiaries:       map[int64]*data.Beneficiary{},
+		schedules:           map[int64]*data.Schedule{},
+		billPayments:        map[string]*data.BillPayment{},
+		limitChannels:       map[string]string{},
+		beneficiaryPayments: map[string]int64{},
+	}
+	for _, code := range permissionCodes {
+		s.permissions[code] = true
+	}
+	return s
+}
+
+// Models returns repositories backed by the store, ready to be used in place of
+// data.NewModels.
+func (s *Store) Models() data.Models {
+	return data.Models{
+		Users:              userRepo{s},
+		Tokens:             tokenRepo{s},
+		Permissions:        permissionRepo{s},
+		AccountModel:       accountRepo{s},
+		Transactions:       transactionRepo{s},
+		Notifications:      notificationRepo{s},
+		Outbox:             outboxRepo{s},
+		Webhooks:           webhookRepo{s},
+		Callbacks:          callbackRepo{s},
+		NameEnquiries:      nameEnquiryRepo{s},
+		InterbankTransfers: interbankTransferRepo{s},
+		InternalTransfers:  internalTransferRepo{s},
+		TransferTags:       transferTagRepo{s},
+		Beneficiaries:      beneficiaryRepo{s},
+		Schedules:          scheduleRepo{s},
+		BillPayments:       billPaymentRepo{s},
+	}
+}
+
+// nextID returns the next auto-increment value for table.
+func (s *Store) nextID(table string) int64 {
+	s.seq[table]++
+	return s.seq[table]
+}
+
+// errDuplicate and errForeignKey stand in for the driver errors the SQL models
+// pass straight through when a constraint has no sentinel error of its own.
+func errDuplicate(key string) error {
+	return fmt.Errorf("memstore: duplicate entry for key %s", key)
+}
+
+func errForeignKey(key string) error {
+	return fmt.Errorf("memstore: foreign key constraint %s fails", key)
+}
+
+// requireUser enforces a foreign key to the users table.
+func (s *Store) requireUser(id int64, key string) error {
+	if _, ok := s.users[id]; !ok {
+		return errForeignKey(key)
+	}
+	return nil
+}
+
+func now() string {
+	return time.Now().Format(timeFormat)
+}
+
+// postLedger records entries, as the SQL models' postLedger does.
+func (s *Store) postLedger(entries ...data.LedgerEntry) {
+	for _, entry := range entries {
+		if !entry.Amount.IsZero() {
+			s.ledgerEntries = append(s.ledgerEntries, entry)
+		}
+	}
+}
+
+// ledgerBalance sums the entries on a ledger account, as the ledger_balances view
+// does.
+func (s *Store) ledgerBalance(code string) decimal.Decimal {
+	balance := decimal.Zero
+	for _, entry := range s.ledgerEntries {
+		switch {
+		case entry.Ledger != code:
+		case entry.Type == data.Debit:
+			balance = balance.Sub(entry.Amount)
+		default:
+			balance = balance.Add(entry.Amount)
+		}
+	}
+	return balance
+}
+
+// enqueue adds messages to the outbox, as insertOutboxMessages does.
+func (s *Store) enqueue(messages ...*data.OutboxMessage) {
+	now := time.Now()
+	for _, msg := range messages {
+		msg.ID = s.nextID("outbox")
+		msg.Status = data.OutboxPending
+		due := now
+		if msg.NotBefore.After(now) {
+			due = msg.NotBefore
+		}
+		s.outbox[msg.ID] = &outboxMessage{msg: *msg, nextAttempt: due}
+	}
+}
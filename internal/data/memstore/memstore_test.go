@@ -0,0 +1,799 @@
+package memstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/ebitezion/backend-framework/internal/data"
+)
+
+// newUser inserts a user and gives them an account with the given number.
+func newUser(t *testing.T, models data.Models, email, accountNumber string) *data.User {
+	t.Helper()
+	ctx := context.Background()
+	user := &data.User{Name: "Ada", Username: email, Email: email}
+	if err := models.Users.Insert(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	err := models.AccountModel.SaveCreatedAccountNo(ctx, &data.AccountDetails{User_id: int(user.ID), Account_number: accountNumber})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestUsers(t *testing.T) {
+	ctx := context.Background()
+	models := New().Models()
+
+	user := &data.User{Name: "Ada", Username: "ada", Email: "ada@example.com"}
+	if err := user.Password.Set("pa55word1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := models.Users.Insert(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if user.ID == 0 {
+		t.Fatal("Insert didn't set the user ID")
+	}
+
+	dup := &data.User{Name: "Eve", Username: "eve", Email: "ada@example.com"}
+	if err := models.Users.Insert(ctx, dup); !errors.Is(err, data.ErrDuplicateEmailOrUsername) {
+		t.Errorf("duplicate email: got %v, want ErrDuplicateEmailOrUsername", err)
+	}
+
+	if _, err := models.Users.GetByEmail(ctx, "nobody@example.com"); !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("unknown email: got %v, want ErrRecordNotFound", err)
+	}
+	got, err := models.Users.GetByEmail(ctx, "ada@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := got.Password.Matches("pa55word1"); !ok {
+		t.Error("stored password doesn't match")
+	}
+
+	// Updates are checked against the version that was read.
+	got.Name = "Ada Lovelace"
+	if err := models.Users.Update(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	got.Version--
+	if err := models.Users.Update(ctx, got); !errors.Is(err, data.ErrEditConflict) {
+		t.Errorf("stale update: got %v, want ErrEditConflict", err)
+	}
+
+	// Fresh users haven't verified their BVN, so they can't sign in yet.
+	_, err = models.Users.GetByEmailAndDeviceID(ctx, "ada", "", "", "")
+	if !errors.Is(err, data.ErrDifferentSource) {
+		t.Errorf("sign in: got %v, want ErrDifferentSource", err)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	ctx := context.Background()
+	models := New().Models()
+	user := newUser(t, models, "ada@example.com", "0123456789")
+
+	if _, err := models.Tokens.New(ctx, 999, time.Hour, data.ScopeAuthentication); err == nil {
+		t.Error("expected a foreign key error for an unknown user")
+	}
+
+	token, err := models.Tokens.New(ctx, user.ID, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := models.Users.GetForToken(ctx, data.ScopeAuthentication, token.Plaintext)
+	if err != nil || got.ID != user.ID {
+		t.Fatalf("GetForToken: got %v, %v", got, err)
+	}
+	if _, err := models.Users.GetForToken(ctx, data.ScopeActivation, token.Plaintext); !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("wrong scope: got %v, want ErrRecordNotFound", err)
+	}
+
+	details, err := models.Users.GetUserDetailsFromToken(ctx, data.ScopeAuthentication, token.Plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.AccountNumber != "0123456789" || details.Limits.Transfers.Single != 200000 || details.Balance != "0.00" {
+		t.Errorf("got details %+v", details)
+	}
+
+	expired, err := models.Tokens.New(ctx, user.ID, -time.Minute, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := models.Users.GetForToken(ctx, data.ScopeAuthentication, expired.Plaintext); !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("expired token: got %v, want ErrRecordNotFound", err)
+	}
+
+	if err := models.Tokens.DeleteAllForUser(ctx, data.ScopeAuthentication, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := models.Users.GetForToken(ctx, data.ScopeAuthentication, token.Plaintext); !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("deleted token: got %v, want ErrRecordNotFound", err)
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	ctx := context.Background()
+	models := New().Models()
+	user := newUser(t, models, "ada@example.com", "0123456789")
+
+	if err := models.Permissions.AddForUser(ctx, user.ID, "kyc:review", "no:such"); err != nil {
+		t.Fatal(err)
+	}
+	permissions, err := models.Permissions.GetAllForUser(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(permissions) != 1 || !permissions.Include("kyc:review") {
+		t.Errorf("got permissions %v", permissions)
+	}
+	if err := models.Permissions.AddForUser(ctx, user.ID, "kyc:review"); err == nil {
+		t.Error("expected an error granting the same permission twice")
+	}
+}
+
+// claimKinds claims every due outbox message and returns their kinds.
+func claimKinds(t *testing.T, models data.Models) []string {
+	t.Helper()
+	messages, err := models.Outbox.ClaimDue(context.Background(), 100, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []string
+	for _, msg := range messages {
+		kind := msg.Kind
+		if kind == data.OutboxWebhookEvent {
+			var event data.WebhookEvent
+			if err := json.Unmarshal(msg.Payload, &event); err != nil {
+				t.Fatal(err)
+			}
+			kind += ":" + event.Type
+		}
+		kinds = append(kinds, kind)
+	}
+	return kinds
+}
+
+func TestTransactions(t *testing.T) {
+	ctx := context.Background()
+	models := New().Models()
+	user := newUser(t, models, "ada@example.com", "0123456789")
+	claimKinds(t, models)
+
+	credit := &data.Transaction{UserID: uint64(user.ID), Type: string(data.Credit), AccountNumber: "0123456789", InternalReference: "ref-1", Amount: 100, Status: data.Completed}
+	if err := models.Transactions.PostTransaction(ctx, credit); err != nil {
+		t.Fatal(err)
+	}
+	if credit.ID == 0 || credit.BalanceAfter == nil || *credit.BalanceAfter != 100 {
+		t.Errorf("got %+v", credit)
+	}
+	want := []string{data.OutboxTransactionAlert, data.OutboxWebhookEvent + ":" + data.EventTransactionCompleted}
+	if got := claimKinds(t, models); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got outbox %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		name        string
+		transaction data.Transaction
+		want        error
+	}{
+		{"duplicate", data.Transaction{Type: string(data.Credit), AccountNumber: "0123456789", InternalReference: "ref-1", Amount: 1}, data.ErrDuplicateTransaction},
+		{"overdraft", data.Transaction{Type: string(data.Debit), AccountNumber: "0123456789", InternalReference: "ref-2", Amount: 100.01}, data.ErrInsufficientFunds},
+		{"no account", data.Transaction{Type: string(data.Credit), AccountNumber: "9999999999", InternalReference: "ref-3", Amount: 1}, data.ErrRecordNotFound},
+	}
+	for _, tt := range tests {
+		tt.transaction.UserID = uint64(user.ID)
+		if err := models.Transactions.PostTransaction(ctx, &tt.transaction); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	// Failing the completed credit takes the money back out, once.
+	settled, changed, err := models.Transactions.SettleTransaction(ctx, "nonce-1", "ref-1", data.Failed)
+	if err != nil || !changed || settled.Status != data.Failed {
+		t.Fatalf("SettleTransaction: got %+v, %v, %v", settled, changed, err)
+	}
+	if _, _, err := models.Transactions.SettleTransaction(ctx, "nonce-1", "ref-1", data.Failed); !errors.Is(err, data.ErrDuplicateCallback) {
+		t.Errorf("repeated nonce: got %v, want ErrDuplicateCallback", err)
+	}
+	if _, _, err := models.Transactions.SettleTransaction(ctx, "nonce-2", "ref-1", data.Completed); !errors.Is(err, data.ErrInvalidTransition) {
+		t.Errorf("failed to completed: got %v, want ErrInvalidTransition", err)
+	}
+	// Once pruned, a nonce no longer counts as seen.
+	if n, err := models.Callbacks.PruneNonces(ctx, time.Now().Add(time.Second)); err != nil || n != 1 {
+		t.Errorf("PruneNonces: got %d, %v; want 1", n, err)
+	}
+	if _, changed, err := models.Transactions.SettleTransaction(ctx, "nonce-1", "ref-1", data.Failed); err != nil || changed {
+		t.Errorf("pruned nonce: got %v, %v; want unchanged", changed, err)
+	}
+	token, err := models.Tokens.New(ctx, user.ID, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+	details, err := models.Users.GetUserDetailsFromToken(ctx, data.ScopeAuthentication, token.Plaintext)
+	if err != nil || details.Balance != "0.00" {
+		t.Errorf("got balance %q, %v; want 0.00", details.Balance, err)
+	}
+
+	history, err := models.Transactions.GetAccountHistory(ctx, "0123456789", "1")
+	if err != nil || len(history) != 1 || history[0].Status != data.Failed {
+		t.Errorf("got history %+v, %v", history, err)
+	}
+	if _, err := models.Transactions.GetAccountHistory(ctx, "0123456789", "2"); !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("empty page: got %v, want ErrRecordNotFound", err)
+	}
+}
+
+func TestPayments(t *testing.T) {
+	ctx := context.Background()
+	models := New().Models()
+	user := newUser(t, models, "ada@example.com", "0123456789")
+	claimKinds(t, models)
+	details := func() *data.UserDetailsForLimits {
+		t.Helper()
+		token, err := models.Tokens.New(ctx, user.ID, time.Hour, data.ScopeAuthentication)
+		if err != nil {
+			t.Fatal(err)
+		}
+		details, err := models.Users.GetUserDetailsFromToken(ctx, data.ScopeAuthentication, token.Plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return details
+	}
+	balance := func() string {
+		t.Helper()
+		return details().Balance
+	}
+
+	// A pending credit waits for its completion to reach the balance.
+	credit := &data.Transaction{UserID: uint64(user.ID), Type: string(data.Credit), AccountNumber: "0123456789", RequestID: "ref-1", InternalReference: "FM0123456789ref-1", Amount: 100}
+	if err := models.Transactions.InitiatePayment(ctx, credit, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if credit.Status != data.Pending || credit.BalanceAfter != nil || balance() != "0.00" {
+		t.Errorf("pending credit: got %+v, balance %s", credit, balance())
+	}
+	settled, changed, err := models.Transactions.SettlePayment(ctx, credit.InternalReference, data.Completed, "PR-1")
+	if err != nil || !changed || settled.BalanceAfter == nil || *settled.BalanceAfter != 100 || *settled.ExternalReference != "PR-1" {
+		t.Fatalf("SettlePayment: got %+v, %v, %v", settled, changed, err)
+	}
+
+	// A pending debit is taken at once and counted against the transfer limits, and
+	// both are given back if it fails.
+	debit := &data.Transaction{UserID: uint64(user.ID), Type: string(data.Debit), AccountNumber: "0123456789", RequestID: "ref-2", InternalReference: "FM0123456789ref-2", Amount: 59.5}
+	if err := models.Transactions.InitiatePayment(ctx, debit, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if got := details(); got.Balance != "40.50" || got.Counter.Transfers != 60 {
+		t.Errorf("pending debit: got balance %s, counts %+v", got.Balance, got.Counter)
+	}
+	over := &data.Transaction{UserID: uint64(user.ID), Type: string(data.Debit), AccountNumber: "0123456789", RequestID: "ref-3", InternalReference: "FM0123456789ref-3", Amount: 200_001}
+	if err := models.Transactions.InitiatePayment(ctx, over, time.Minute); !errors.Is(err, data.ErrSingleLimitExceeded) {
+		t.Errorf("over single limit: got %v, want ErrSingleLimitExceeded", err)
+	}
+	repeat := &data.Transaction{UserID: uint64(user.ID), Type: string(data.Debit), AccountNumber: "0123456789", RequestID: "ref-2", InternalReference: "IB-2", Amount: 1}
+	if err := models.Transactions.InitiatePayment(ctx, repeat, time.Minute); !errors.Is(err, data.ErrDuplicateTransaction) {
+		t.Errorf("repeated request ID: got %v, want ErrDuplicateTransaction", err)
+	}
+	if _, changed, err := models.Transactions.SettlePayment(ctx, debit.InternalReference, data.Failed, ""); err != nil || !changed {
+		t.Fatalf("SettlePayment: got %v, %v", changed, err)
+	}
+	if _, changed, err := models.Transactions.SettlePayment(ctx, debit.InternalReference, data.Completed, ""); err != nil || changed {
+		t.Errorf("settled twice: got %v, %v", changed, err)
+	}
+	if got := details(); got.Balance != "100.00" || got.Counter.Transfers != 0 {
+		t.Errorf("refunded: got balance %s, counts %+v", got.Balance, got.Counter)
+	}
+
+	// The requeries aren't due yet.
+	want := []string{data.OutboxTransactionAlert, data.OutboxWebhookEvent + ":" + data.EventTransactionCompleted, data.OutboxWebhookEvent + ":" + data.EventTransactionFailed}
+	if got := claimKinds(t, models); len(got) != 3 || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("got outbox %v, want %v", got, want)
+	}
+
+	// A completed debit the provider fails later is refunded and given back to the
+	// limits, but a completed credit the balance no longer covers can't be reversed.
+	pay := func(requestID string, amount float64) string {
+		t.Helper()
+		debit := &data.Transaction{UserID: uint64(user.ID), Type: string(data.Debit), AccountNumber: "0123456789", RequestID: requestID, InternalReference: "FM0123456789" + requestID, Amount: amount}
+		if err := models.Transactions.InitiatePayment(ctx, debit, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := models.Transactions.SettlePayment(ctx, debit.InternalReference, data.Completed, ""); err != nil {
+			t.Fatal(err)
+		}
+		return debit.InternalReference
+	}
+	reference := pay("ref-4", 80)
+	if _, changed, err := models.Transactions.SettleTransaction(ctx, "nonce-1", reference, data.Failed); err != nil || !changed {
+		t.Fatalf("reversed debit: got %v, %v", changed, err)
+	}
+	if got := details(); got.Balance != "100.00" || got.Counter.Transfers != 0 {
+		t.Errorf("reversed debit: got balance %s, counts %+v", got.Balance, got.Counter)
+	}
+	pay("ref-5", 50)
+	if _, _, err := models.Transactions.SettleTransaction(ctx, "nonce-2", credit.InternalReference, data.Failed); !errors.Is(err, data.ErrInsufficientFunds) {
+		t.Errorf("spent credit: got %v, want ErrInsufficientFunds", err)
+	}
+	if got := balance(); got != "50.00" {
+		t.Errorf("spent credit: got balance %s, want 50.00", got)
+	}
+}
+
+func TestAccountUpgradeReview(t *testing.T) {
+	ctx := context.Background()
+	models := New().Models()
+	user := newUser(t, models, "ada@example.com", "0123456789")
+	claimKinds(t, models)
+
+	upgrade := &data.AccountUpgradeData{UserID: user.ID, AccountNumber: "0123456789"}
+	if err := models.AccountModel.NewAccountUpgrade(ctx, upgrade); err != nil {
+		t.Fatal(err)
+	}
+	if err := models.AccountModel.ReviewAccountUpgrade(ctx, upgrade, data.Approved, 1, "ok"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := models.Users.GetByEmail(ctx, "ada@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.KYC_level != 4 || !got.Account_upgraded.Bool || !got.Address_verified.Bool {
+		t.Errorf("approved user: got %+v", got)
+	}
+	if err := models.AccountModel.ReviewAccountUpgrade(ctx, upgrade, data.Rejected, 1, "no"); !errors.Is(err, data.ErrEditConflict) {
+		t.Errorf("second review: got %v, want ErrEditConflict", err)
+	}
+	pending, err := models.AccountModel.GetAccountUpgradesByStatus(ctx, data.Pending)
+	if err != nil || len(pending) != 0 {
+		t.Errorf("got pending %v, %v", pending, err)
+	}
+	if got := claimKinds(t, models); len(got) != 1 || got[0] != data.OutboxWebhookEvent+":"+data.EventKYCUpgraded {
+		t.Errorf("got outbox %v", got)
+	}
+}
+
+func TestNameEnquiries(t *testing.T) {
+	ctx := context.Background()
+	models := New().Models()
+	ada := newUser(t, models, "ada@example.com", "0123456789")
+	eve := newUser(t, models, "eve@example.com", "9876543210")
+
+	holder, err := models.AccountModel.GetAccountHolder(ctx, "9876543210")
+	if err != nil || holder.UserID != eve.ID || holder.Name != "Ada" {
+		t.Errorf("got holder %+v, %v", holder, err)
+	}
+	if _, err := models.AccountModel.GetAccountHolder(ctx, "5555555555"); !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("unknown account: got %v, want ErrRecordNotFound", err)
+	}
+
+	live := &data.NameEnquiry{Ref: "NE1", UserID: ada.ID, AccountNumber: "9876543210", ExpiresAt: time.Now().Add(time.Minute)}
+	expired := &data.NameEnquiry{Ref: "NE2", UserID: ada.ID, AccountNumber: "9876543210", ExpiresAt: time.Now().Add(-time.Second)}
+	for _, enquiry := range []*data.NameEnquiry{live, expired} {
+		if err := models.NameEnquiries.Insert(ctx, enquiry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := models.NameEnquiries.Insert(ctx, live); err == nil {
+		t.Error("duplicate ref was accepted")
+	}
+
+	if got, err := models.NameEnquiries.Get(ctx, "NE1", ada.ID); err != nil || got.AccountNumber != "9876543210" {
+		t.Errorf("got %+v, %v", got, err)
+	}
+	if _, err := models.NameEnquiries.Get(ctx, "NE1", eve.ID); !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("another user's enquiry: got %v, want ErrRecordNotFound", err)
+	}
+	if _, err := models.NameEnquiries.Get(ctx, "NE2", ada.ID); !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("expired enquiry: got %v, want ErrRecordNotFound", err)
+	}
+}
+
+func TestInternalTransfers(t *testing.T) {
+	ctx := context.Background()
+	models := New().Models()
+	ada := newUser(t, models, "ada@example.com", "0123456789")
+	eve := newUser(t, models, "eve@example.com", "9876543210")
+	funding := &data.Transaction{UserID: uint64(ada.ID), Type: string(data.Credit), AccountNumber: "0123456789", InternalReference: "ref-0", Amount: 100, Status: data.Completed}
+	if err := models.Transactions.PostTransaction(ctx, funding); err != nil {
+		t.Fatal(err)
+	}
+	claimKinds(t, models)
+
+	transfer := data.InternalTransfer{Reference: "IT1", RequestID: "req-1", UserID: ada.ID, AccountNumber: "0123456789", RecipientUserID: eve.ID, RecipientAccountNumber: "9876543210", Amount: 60}
+	if err := models.InternalTransfers.Post(ctx, &transfer, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if transfer.Status != data.Pending || transfer.BalanceAfter == nil || *transfer.BalanceAfter != 40 || transfer.CreatedAt == nil {
+		t.Errorf("got %+v", transfer)
+	}
+	if got := claimKinds(t, models); len(got) != 0 {
+		t.Errorf("pending transfer: got outbox %v", got)
+	}
+
+	// Completing the transfer credits the recipient.
+	settled, changed, err := models.InternalTransfers.Settle(ctx, "IT1", data.Completed, "CB1")
+	if err != nil || !changed || settled.Status != data.Completed || settled.ExternalReference == nil || *settled.ExternalReference != "CB1" || *settled.BalanceAfter != 40 {
+		t.Fatalf("got %+v, %v, %v", settled, changed, err)
+	}
+	if _, changed, _ := models.InternalTransfers.Settle(ctx, "IT1", data.Failed, ""); changed {
+		t.Error("settled twice")
+	}
+	credit, err := models.Transactions.GetTransactionByReference(ctx, "IT1-CR")
+	if err != nil || credit.UserID != uint64(eve.ID) || credit.RequestID != "req-1" || credit.Status != data.Completed || credit.BalanceAfter == nil || *credit.BalanceAfter != 60 {
+		t.Errorf("got credit %+v, %v", credit, err)
+	}
+	alert, completed := data.OutboxTransactionAlert, data.OutboxWebhookEvent+":"+data.EventTransactionCompleted
+	want := []string{alert, completed, alert, completed}
+	if got := claimKinds(t, models); len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] || got[3] != want[3] {
+		t.Errorf("got outbox %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		name     string
+		transfer data.InternalTransfer
+		want     error
+	}{
+		{"duplicate", data.InternalTransfer{Reference: "IT1", RecipientAccountNumber: "9876543210", Amount: 1}, data.ErrDuplicateTransaction},
+		{"repeated request", data.InternalTransfer{Reference: "IT4", RequestID: "req-1", RecipientAccountNumber: "9876543210", Amount: 1}, data.ErrDuplicateTransaction},
+		{"overdraft", data.InternalTransfer{Reference: "IT2", RecipientAccountNumber: "9876543210", Amount: 40.01}, data.ErrInsufficientFunds},
+		{"no recipient", data.InternalTransfer{Reference: "IT3", RecipientAccountNumber: "5555555555", Amount: 1}, data.ErrRecordNotFound},
+	}
+	for _, tt := range tests {
+		tt.transfer.UserID, tt.transfer.AccountNumber, tt.transfer.RecipientUserID = ada.ID, "0123456789", eve.ID
+		if err := models.InternalTransfers.Post(ctx, &tt.transfer, time.Minute); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+	if history, err := models.Transactions.GetAccountHistory(ctx, "9876543210", "1"); err != nil || len(history) != 1 {
+		t.Errorf("recipient history: got %+v, %v", history, err)
+	}
+	// A failed transfer refunds the sender and never reaches the recipient.
+	failed := data.InternalTransfer{Reference: "IT5", UserID: ada.ID, AccountNumber: "0123456789", RecipientUserID: eve.ID, RecipientAccountNumber: "9876543210", Amount: 40}
+	if err := models.InternalTransfers.Post(ctx, &failed, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := models.InternalTransfers.Settle(ctx, "IT5", data.Failed, ""); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{data.OutboxWebhookEvent + ":" + data.EventTransactionFailed}
+	if got := claimKinds(t, models); len(got) != 1 || got[0] != want[0] {
+		t.Errorf("got outbox %v, want %v", got, want)
+	}
+	balances := []struct {
+		userID  int64
+		balance string
+	}{{ada.ID, "40.00"}, {eve.ID, "60.00"}}
+	for _, want := range balances {
+		details, err := models.Users.GetUserDetailsByUserID(ctx, want.userID)
+		if err != nil || details.Balance != want.balance {
+			t.Errorf("user %d: got balance %+v, %v; want %s", want.userID, details, err, want.balance)
+		}
+	}
+
+	// A transfer that never went to core banking can be abandoned, which refunds the
+	// sender, and then can't be sent. One that went waits for core banking's answer.
+	for _, reference := range []string{"IT6", "IT7"} {
+		transfer := data.InternalTransfer{Reference: reference, UserID: ada.ID, AccountNumber: "0123456789", RecipientUserID: eve.ID, RecipientAccountNumber: "9876543210", Amount: 10}
+		if err := models.InternalTransfers.Post(ctx, &transfer, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := models.InternalTransfers.Submit(ctx, "IT7"); err != nil {
+		t.Fatal(err)
+	}
+	if abandoned, changed, err := models.InternalTransfers.Abandon(ctx, "IT6"); err != nil || !changed || abandoned.Status != data.Failed {
+		t.Errorf("abandon: got %+v, %v, %v", abandoned, changed, err)
+	}
+	if err := models.InternalTransfers.Submit(ctx, "IT6"); !errors.Is(err, data.ErrInvalidTransition) {
+		t.Errorf("submit after abandon: got %v, want ErrInvalidTransition", err)
+	}
+	if _, _, err := models.InternalTransfers.Abandon(ctx, "IT7"); !errors.Is(err, data.ErrTransferSubmitted) {
+		t.Errorf("abandon after submit: got %v, want ErrTransferSubmitted", err)
+	}
+	details, err := models.Users.GetUserDetailsByUserID(ctx, ada.ID)
+	if err != nil || details.Balance != "30.00" {
+		t.Errorf("got balance %+v, %v; want 30.00", details, err)
+	}
+}
+
+func TestBeneficiaries(t *testing.T) {
+	ctx := context.Background()
+	models := New().Models()
+	ada := newUser(t, models, "ada@example.com", "0123456789")
+	eve := newUser(t, models, "eve@example.com", "9876543210")
+
+	saved := &data.Beneficiary{UserID: ada.ID, Type: data.BeneficiaryInternal, AccountNumber: "9876543210", AccountName: "Eve", CoolingOffUntil: time.Now().Add(time.Hour)}
+	if err := models.Beneficiaries.Insert(ctx, saved); err != nil || saved.ID == 0 || !saved.CoolingOff {
+		t.Fatalf("got %+v, %v", saved, err)
+	}
+	duplicate := &data.Beneficiary{UserID: ada.ID, Type: data.BeneficiaryInternal, AccountNumber: "9876543210"}
+	if err := models.Beneficiaries.Insert(ctx, duplicate); !errors.Is(err, data.ErrDuplicateBeneficiary) {
+		t.Errorf("duplicate: got %v", err)
+	}
+	tag := &data.Beneficiary{UserID: ada.ID, Type: data.BeneficiaryTag, Tag: "eve"}
+	if err := models.Beneficiaries.Insert(ctx, tag); err != nil || tag.CoolingOff {
+		t.Fatalf("got %+v, %v", tag, err)
+	}
+
+	// The most used come first.
+	if err := models.Beneficiaries.RecordUse(ctx, tag.ID); err != nil {
+		t.Fatal(err)
+	}
+	list, err := models.Beneficiaries.GetAllForUser(ctx, ada.ID)
+	if err != nil || len(list) != 2 || list[0].ID != tag.ID || list[0].UsageCount != 1 || list[0].LastUsedAt == nil {
+		t.Errorf("got %+v, %v", list, err)
+	}
+
+	// Payments to a beneficiary that is cooling off add up to the cooling-off limit,
+	// leaving out those that failed.
+	funding := &data.Transaction{UserID: uint64(ada.ID), Type: string(data.Credit), AccountNumber: "0123456789", InternalReference: "ref-0", Amount: 100, Status: data.Completed}
+	if err := models.Transactions.PostTransaction(ctx, funding); err != nil {
+		t.Fatal(err)
+	}
+	pay := func(reference string) error {
+		transfer := data.InternalTransfer{Reference: reference, UserID: ada.ID, AccountNumber: "0123456789", RecipientUserID: eve.ID, RecipientAccountNumber: "9876543210", Amount: 30, BeneficiaryID: saved.ID, CoolingOffLimit: 50}
+		return models.InternalTransfers.Post(ctx, &transfer, time.Minute)
+	}
+	if err := pay("IT1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := pay("IT2"); !errors.Is(err, data.ErrCoolingOffLimitExceeded) {
+		t.Errorf("over cooling-off limit: got %v", err)
+	}
+	if _, _, err := models.InternalTransfers.Settle(ctx, "IT1", data.Failed, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := pay("IT2"); err != nil {
+		t.Errorf("after a failed payment: got %v", err)
+	}
+
+	if _, err := models.Beneficiaries.Get(ctx, saved.ID, eve.ID); !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("another user's: got %v", err)
+	}
+	if err := models.Beneficiaries.Delete(ctx, saved.ID, eve.ID); !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("delete another user's: got %v", err)
+	}
+	if err := models.Beneficiaries.Delete(ctx, saved.ID, ada.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := models.Beneficiaries.Get(ctx, saved.ID, ada.ID); !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("deleted: got %v", err)
+	}
+}
+
+func TestSchedules(t *testing.T) {
+	ctx := context.Background()
+	models := New().Models()
+	ada := newUser(t, models, "ada@example.com", "0123456789")
+	eve := newUser(t, models, "eve@example.com", "9876543210")
+
+	due := time.Now().Add(-time.Second)
+	later := time.Now().Add(time.Hour)
+	schedule := &data.Schedule{UserID: ada.ID, BeneficiaryID: 1, Type: data.BeneficiaryInternal, Amount: 100, Frequency: data.FrequencyDaily, StartAt: due, NextRunAt: &due, Status: data.ScheduleActive}
+	notDue := &data.Schedule{UserID: ada.ID, BeneficiaryID: 1, Type: data.BeneficiaryInternal, Amount: 100, Frequency: data.FrequencyOnce, StartAt: later, NextRunAt: &later, Status: data.ScheduleActive}
+	for _, s := range []*data.Schedule{schedule, notDue} {
+		if err := models.Schedules.Insert(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A claimed schedule is leased, and not handed out again.
+	claimed, err := models.Schedules.ClaimDue(ctx, 10, time.Minute)
+	if err != nil || len(claimed) != 1 || claimed[0].ID != schedule.ID {
+		t.Fatalf("got %+v, %v", claimed, err)
+	}
+	if again, _ := models.Schedules.ClaimDue(ctx, 10, time.Minute); len(again) != 0 {
+		t.Errorf("claimed again: got %+v", again)
+	}
+
+	next := due.Add(24 * time.Hour)
+	claimed[0].Occurrence, claimed[0].NextRunAt = 1, &next
+	run := &data.ScheduleRun{ScheduleID: schedule.ID, Occurrence: 1, Attempt: 1, Reference: "SCH1-1-1", Status: data.RunPaid}
+	if err := models.Schedules.RecordRun(ctx, claimed[0], run); err != nil {
+		t.Fatal(err)
+	}
+	found, err := models.Schedules.Get(ctx, schedule.ID, ada.ID)
+	if err != nil || found.Occurrence != 1 || found.NextRunAt.Unix() != next.Unix() {
+		t.Errorf("got %+v, %v", found, err)
+	}
+	runs, err := models.Schedules.GetRuns(ctx, schedule.ID, ada.ID)
+	if err != nil || len(runs) != 1 || runs[0].Reference != "SCH1-1-1" {
+		t.Errorf("runs: got %+v, %v", runs, err)
+	}
+	if runs, _ := models.Schedules.GetRuns(ctx, schedule.ID, eve.ID); len(runs) != 0 {
+		t.Errorf("another user's runs: got %+v", runs)
+	}
+
+	// A cancelled schedule stays cancelled, even if a run finishes after.
+	if err := models.Schedules.Cancel(ctx, &data.Schedule{ID: schedule.ID, UserID: eve.ID}); !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("cancel another user's: got %v", err)
+	}
+	if err := models.Schedules.Cancel(ctx, found); err != nil || found.Status != data.ScheduleCancelled {
+		t.Fatalf("got %+v, %v", found, err)
+	}
+	if err := models.Schedules.Cancel(ctx, found); !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("cancel twice: got %v", err)
+	}
+	claimed[0].Occurrence = 2
+	if err := models.Schedules.RecordRun(ctx, claimed[0], &data.ScheduleRun{ScheduleID: schedule.ID, Status: data.RunPaid}); err != nil {
+		t.Fatal(err)
+	}
+	list, err := models.Schedules.GetAllForUser(ctx, ada.ID)
+	if err != nil || len(list) != 2 || list[0].ID != notDue.ID || list[1].Status != data.ScheduleCancelled || list[1].Occurrence != 1 {
+		t.Errorf("got %+v, %v", list, err)
+	}
+}
+
+func TestInterbankTransfers(t *testing.T) {
+	ctx := context.Background()
+	store := New()
+	models := store.Models()
+	ada := newUser(t, models, "ada@example.com", "0123456789")
+	funding := &data.Transaction{UserID: uint64(ada.ID), Type: string(data.Credit), AccountNumber: "0123456789", InternalReference: "ref-0", Amount: 100, Status: data.Completed}
+	if err := models.Transactions.PostTransaction(ctx, funding); err != nil {
+		t.Fatal(err)
+	}
+
+	// The amount waits in suspense and the fee is earned until the network answers.
+	sent := data.InterbankTransfer{Reference: "IB1", UserID: ada.ID, AccountNumber: "0123456789", Amount: 50, Fee: 10.75}
+	if err := models.InterbankTransfers.Debit(ctx, &sent, 0); err != nil {
+		t.Fatal(err)
+	}
+	checkLedgers(t, store, map[string]string{data.LedgerInterbankSuspense: "50", data.LedgerFeeIncome: "10.75"})
+	if _, _, err := models.InterbankTransfers.Settle(ctx, "IB1", data.Completed, "S1"); err != nil {
+		t.Fatal(err)
+	}
+	checkLedgers(t, store, map[string]string{data.LedgerInterbankSuspense: "0", data.LedgerInterbankSettlement: "50", data.LedgerFeeIncome: "10.75"})
+
+	// A failed transfer hands back the amount and the fee.
+	failed := data.InterbankTransfer{Reference: "IB2", UserID: ada.ID, AccountNumber: "0123456789", Amount: 20, Fee: 10.75}
+	if err := models.InterbankTransfers.Debit(ctx, &failed, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := models.InterbankTransfers.Settle(ctx, "IB2", data.Failed, ""); err != nil {
+		t.Fatal(err)
+	}
+	checkLedgers(t, store, map[string]string{data.LedgerInterbankSuspense: "0", data.LedgerInterbankSettlement: "50", data.LedgerFeeIncome: "10.75"})
+	details, err := models.Users.GetUserDetailsByUserID(ctx, ada.ID)
+	if err != nil || details.Balance != "39.25" {
+		t.Errorf("got balance %q, %v; want 39.25", details.Balance, err)
+	}
+}
+
+// checkLedgers checks the balances of the given ledger accounts.
+func checkLedgers(t *testing.T, store *Store, want map[string]string) {
+	t.Helper()
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for code, balance := range want {
+		if got := store.ledgerBalance(code); !got.Equal(decimal.RequireFromString(balance)) {
+			t.Errorf("ledger %s: got %s, want %s", code, got, balance)
+		}
+	}
+}
+
+func TestBillPayments(t *testing.T) {
+	ctx := context.Background()
+	store := New()
+	models := store.Models()
+	ada := newUser(t, models, "ada@example.com", "0123456789")
+	funding := &data.Transaction{UserID: uint64(ada.ID), Type: string(data.Credit), AccountNumber: "0123456789", InternalReference: "ref-0", Amount: 100, Status: data.Completed}
+	if err := models.Transactions.PostTransaction(ctx, funding); err != nil {
+		t.Fatal(err)
+	}
+	claimKinds(t, models)
+
+	paid := data.BillPayment{Reference: "BP1", UserID: ada.ID, AccountNumber: "0123456789", BillerID: "ikedc-prepaid", Category: "electricity", Amount: 60}
+	if err := models.BillPayments.Debit(ctx, &paid, 0); err != nil {
+		t.Fatal(err)
+	}
+	if paid.Status != data.Pending || paid.CreatedAt == nil {
+		t.Errorf("got %+v", paid)
+	}
+	duplicate := data.BillPayment{Reference: "BP1", UserID: ada.ID, AccountNumber: "0123456789", Amount: 1}
+	if err := models.BillPayments.Debit(ctx, &duplicate, 0); !errors.Is(err, data.ErrDuplicateTransaction) {
+		t.Errorf("duplicate: got %v", err)
+	}
+	overdraft := data.BillPayment{Reference: "BP2", UserID: ada.ID, AccountNumber: "0123456789", Amount: 40.01}
+	if err := models.BillPayments.Debit(ctx, &overdraft, 0); !errors.Is(err, data.ErrInsufficientFunds) {
+		t.Errorf("overdraft: got %v", err)
+	}
+
+	settled, changed, err := models.BillPayments.Settle(ctx, "BP1", data.Completed, "BILL1", "1234-5678")
+	if err != nil || !changed || settled.Token != "1234-5678" || settled.BillerReference != "BILL1" {
+		t.Fatalf("got %+v, %v, %v", settled, changed, err)
+	}
+	if _, changed, _ := models.BillPayments.Settle(ctx, "BP1", data.Failed, "", ""); changed {
+		t.Error("settled twice")
+	}
+	// Bill payments are settled by the biller's answer, not by provider callbacks.
+	if _, _, err := models.Transactions.SettleTransaction(ctx, "nonce-1", "BP1", data.Failed); !errors.Is(err, data.ErrInvalidTransition) {
+		t.Errorf("provider callback: got %v, want ErrInvalidTransition", err)
+	}
+	alert, completed := data.OutboxTransactionAlert, data.OutboxWebhookEvent+":"+data.EventTransactionCompleted
+	if got := claimKinds(t, models); len(got) != 3 || got[0] != data.OutboxBillRequery || got[1] != alert || got[2] != completed {
+		t.Errorf("got outbox %v", got)
+	}
+
+	// A failed payment is refunded.
+	failed := data.BillPayment{Reference: "BP3", UserID: ada.ID, AccountNumber: "0123456789", Amount: 40}
+	if err := models.BillPayments.Debit(ctx, &failed, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := models.BillPayments.Settle(ctx, "BP3", data.Failed, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	details, err := models.Users.GetUserDetailsByUserID(ctx, ada.ID)
+	if err != nil || details.Balance != "40.00" {
+		t.Errorf("got balance %q, %v; want 40.00", details.Balance, err)
+	}
+
+	payments, err := models.BillPayments.GetAllForUser(ctx, ada.ID)
+	if err != nil || len(payments) != 2 || payments[0].Reference != "BP3" || payments[0].Status != data.Failed || payments[1].Token != "1234-5678" {
+		t.Errorf("got %+v, %v", payments, err)
+	}
+	checkLedgers(t, store, map[string]string{data.LedgerBillsSuspense: "0", data.LedgerBillsSettlement: "60"})
+}
+
+func TestWebhookReplay(t *testing.T) {
+	ctx := context.Background()
+	models := New().Models()
+	ada := newUser(t, models, "ada@example.com", "0123456789")
+	endpoint := &data.WebhookEndpoint{UserID: ada.ID, URL: "https://example.com/hook", Events: []string{data.EventKYCUpgraded}}
+	if err := models.Webhooks.InsertEndpoint(ctx, endpoint); err != nil {
+		t.Fatal(err)
+	}
+	event := &data.WebhookEvent{ID: "evt-1", Type: data.EventKYCUpgraded, UserID: ada.ID}
+	if err := models.Webhooks.CreateDeliveries(ctx, event, []*data.WebhookEndpoint{endpoint}); err != nil {
+		t.Fatal(err)
+	}
+	messages, err := models.Outbox.ClaimDue(ctx, 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sending *data.OutboxMessage
+	for _, msg := range messages {
+		if msg.Kind == data.OutboxWebhookDelivery {
+			sending = msg
+		}
+	}
+	if sending == nil {
+		t.Fatal("no delivery queued")
+	}
+
+	// A failed attempt that is waiting for its retry is still queued.
+	delivery := &data.WebhookDelivery{ID: 1, Status: data.WebhookFailed, LastError: "connection refused"}
+	if err := models.Webhooks.RecordAttempt(ctx, delivery); err != nil {
+		t.Fatal(err)
+	}
+	if err := models.Outbox.MarkFailed(ctx, sending.ID, "connection refused", time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := models.Webhooks.ReplayDelivery(ctx, delivery); !errors.Is(err, data.ErrEditConflict) {
+		t.Errorf("replay while retrying: got %v, want ErrEditConflict", err)
+	}
+
+	// Once the outbox gives up, the delivery can be replayed.
+	if err := models.Outbox.MarkDead(ctx, sending.ID, "connection refused"); err != nil {
+		t.Fatal(err)
+	}
+	if err := models.Webhooks.ReplayDelivery(ctx, delivery); err != nil {
+		t.Fatal(err)
+	}
+	if err := models.Webhooks.ReplayDelivery(ctx, delivery); !errors.Is(err, data.ErrEditConflict) {
+		t.Errorf("second replay: got %v, want ErrEditConflict", err)
+	}
+	found, err := models.Webhooks.GetDelivery(ctx, 1)
+	if err != nil || found.Status != data.Pending {
+		t.Errorf("got delivery %+v, %v; want pending", found, err)
+	}
+}
@@ -0,0 +1,290 @@
+package memstore
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/ebitezion/backend-framework/internal/data"
+)
+
+// outboxMessage is a row of outbox.
+type outboxMessage struct {
+	msg         data.OutboxMessage
+	nextAttempt time.Time
+}
+
+type outboxRepo struct{ s *Store }
+
+func (r outboxRepo) Insert(_ context.Context, messages ...*data.OutboxMessage) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.enqueue(messages...)
+	return nil
+}
+
+func (r outboxRepo) ClaimDue(_ context.Context, limit int, lease time.Duration) ([]*data.OutboxMessage, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	now := time.Now()
+	messages := []*data.OutboxMessage{}
+	for id := int64(1); id <= r.s.seq["outbox"] && len(messages) < limit; id++ {
+		m, ok := r.s.outbox[id]
+		if !ok || m.msg.Status != data.OutboxPending || m.nextAttempt.After(now) {
+			continue
+		}
+		m.msg.Attempts++
+		m.nextAttempt = now.Add(lease)
+		claimed := m.msg
+		messages = append(messages, &claimed)
+	}
+	return messages, nil
+}
+
+// mark applies fn to a message, if it exists.
+func (r outboxRepo) mark(id int64, fn func(m *outboxMessage)) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if m, ok := r.s.outbox[id]; ok {
+		fn(m)
+	}
+	return nil
+}
+
+func (r outboxRepo) MarkDelivered(_ context.Context, id int64) error {
+	return r.mark(id, func(m *outboxMessage) {
+		m.msg.Status = data.OutboxDelivered
+		m.msg.LastError = ""
+	})
+}
+
+func (r outboxRepo) MarkFailed(_ context.Context, id int64, lastError string, nextAttempt time.Time) error {
+	return r.mark(id, func(m *outboxMessage) {
+		m.msg.LastError = lastError
+		m.nextAttempt = nextAttempt
+	})
+}
+
+func (r outboxRepo) MarkDead(_ context.Context, id int64, lastError string) error {
+	return r.mark(id, func(m *outboxMessage) {
+		m.msg.Status = data.OutboxDead
+		m.msg.LastError = lastError
+	})
+}
+
+type webhookRepo struct{ s *Store }
+
+func (r webhookRepo) InsertEndpoint(_ context.Context, endpoint *data.WebhookEndpoint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	err := r.s.requireUser(endpoint.UserID, "webhook_endpoints_user_id_fk")
+	if err != nil {
+		return err
+	}
+
+	endpoint.ID = r.s.nextID("webhook_endpoints")
+	stored := *endpoint
+	stored.Events = append([]string(nil), endpoint.Events...)
+	createdAt := now()
+	stored.CreatedAt = &createdAt
+	r.s.endpoints[stored.ID] = &stored
+	return nil
+}
+
+func copyEndpoint(endpoint *data.WebhookEndpoint) *data.WebhookEndpoint {
+	found := *endpoint
+	found.Events = append([]string(nil), endpoint.Events...)
+	return &found
+}
+
+func (r webhookRepo) GetEndpoint(_ context.Context, id int64) (*data.WebhookEndpoint, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	endpoint, ok := r.s.endpoints[id]
+	if !ok {
+		return nil, data.ErrRecordNotFound
+	}
+	return copyEndpoint(endpoint), nil
+}
+
+func (r webhookRepo) GetEndpointsForUser(_ context.Context, userID int64) ([]*data.WebhookEndpoint, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	endpoints := []*data.WebhookEndpoint{}
+	for id := int64(1); id <= r.s.seq["webhook_endpoints"]; id++ {
+		if endpoint, ok := r.s.endpoints[id]; ok && endpoint.UserID == userID {
+			endpoints = append(endpoints, copyEndpoint(endpoint))
+		}
+	}
+	return endpoints, nil
+}
+
+func (r webhookRepo) DeleteEndpoint(_ context.Context, id, userID int64) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	endpoint, ok := r.s.endpoints[id]
+	if !ok || endpoint.UserID != userID {
+		return data.ErrRecordNotFound
+	}
+	delete(r.s.endpoints, id)
+	for deliveryID, delivery := range r.s.deliveries {
+		if delivery.EndpointID == id {
+			delete(r.s.deliveries, deliveryID)
+		}
+	}
+	return nil
+}
+
+// queueDelivery puts an outbox message in for sending a delivery and links the
+// delivery to it.
+func (s *Store) queueDelivery(id int64) error {
+	msg, err := data.NewOutboxMessage(data.OutboxWebhookDelivery, data.WebhookDeliveryReference{DeliveryID: id})
+	if err != nil {
+		return err
+	}
+	s.enqueue(msg)
+	s.deliveryOutbox[id] = msg.ID
+	return nil
+}
+
+func (r webhookRepo) CreateDeliveries(_ context.Context, event *data.WebhookEvent, endpoints []*data.WebhookEndpoint) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for _, endpoint := range endpoints {
+		if _, ok := r.s.endpoints[endpoint.ID]; !ok {
+			return errForeignKey("webhook_deliveries_endpoint_id_fk")
+		}
+	}
+
+	for _, endpoint := range endpoints {
+		createdAt := now()
+		delivery := &data.WebhookDelivery{
+			ID:         r.s.nextID("webhook_deliveries"),
+			EndpointID: endpoint.ID,
+			EventID:    event.ID,
+			Event:      event.Type,
+			Payload:    payload,
+			Status:     data.Pending,
+			CreatedAt:  &createdAt,
+		}
+		r.s.deliveries[delivery.ID] = delivery
+		err = r.s.queueDelivery(delivery.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r webhookRepo) GetDelivery(_ context.Context, id int64) (*data.WebhookDelivery, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	delivery, ok := r.s.deliveries[id]
+	if !ok {
+		return nil, data.ErrRecordNotFound
+	}
+	found := *delivery
+	return &found, nil
+}
+
+func (r webhookRepo) GetDeliveriesForEndpoint(_ context.Context, endpointID int64, limit int) ([]*data.WebhookDelivery, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	deliveries := []*data.WebhookDelivery{}
+	for id := r.s.seq["webhook_deliveries"]; id > 0 && len(deliveries) < limit; id-- {
+		if delivery, ok := r.s.deliveries[id]; ok && delivery.EndpointID == endpointID {
+			found := *delivery
+			deliveries = append(deliveries, &found)
+		}
+	}
+	return deliveries, nil
+}
+
+func (r webhookRepo) RecordAttempt(_ context.Context, delivery *data.WebhookDelivery) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if stored, ok := r.s.deliveries[delivery.ID]; ok {
+		stored.Status = delivery.Status
+		stored.Attempts++
+		stored.ResponseStatus = delivery.ResponseStatus
+		stored.LastError = delivery.LastError
+		if delivery.Status == data.WebhookDelivered {
+			deliveredAt := now()
+			stored.DeliveredAt = &deliveredAt
+		}
+	}
+	delivery.Attempts++
+	return nil
+}
+
+func (r webhookRepo) ReplayDelivery(_ context.Context, delivery *data.WebhookDelivery) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	stored, ok := r.s.deliveries[delivery.ID]
+	if !ok {
+		return data.ErrRecordNotFound
+	}
+	if m, ok := r.s.outbox[r.s.deliveryOutbox[delivery.ID]]; ok && m.msg.Status == data.OutboxPending {
+		return data.ErrEditConflict
+	}
+
+	err := r.s.queueDelivery(delivery.ID)
+	if err != nil {
+		return err
+	}
+	stored.Status = data.Pending
+	delivery.Status = data.Pending
+	return nil
+}
+
+type notificationRepo struct{ s *Store }
+
+func (r notificationRepo) GetForUser(_ context.Context, userID int64) (*data.NotificationPreferences, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	prefs, ok := r.s.preferences[userID]
+	if !ok {
+		return &data.NotificationPreferences{UserID: userID, Email: true, SMS: true, Push: true}, nil
+	}
+	return &prefs, nil
+}
+
+func (r notificationRepo) Upsert(_ context.Context, prefs *data.NotificationPreferences) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	err := r.s.requireUser(prefs.UserID, "notification_preferences_user_id_fk")
+	if err != nil {
+		return err
+	}
+	r.s.preferences[prefs.UserID] = *prefs
+	return nil
+}
+
+type callbackRepo struct{ s *Store }
+
+func (r callbackRepo) Insert(_ context.Context, callback *data.ProviderCallback) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	callback.ID = r.s.nextID("provider_callbacks")
+	stored := *callback
+	r.s.callbacks = append(r.s.callbacks, &stored)
+	return nil
+}
+
+func (r callbackRepo) PruneNonces(_ context.Context, before time.Time) (int64, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	var n int64
+	for nonce, created := range r.s.nonces {
+		if created.Before(before) {
+			delete(r.s.nonces, nonce)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,139 @@
+package memstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/ebitezion/backend-framework/internal/data"
+)
+
+type scheduleRepo struct{ s *Store }
+
+// columnTime returns t as it reads back from a schedule's columns: in UTC, to the
+// second.
+func columnTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC().Truncate(time.Second)
+	return &u
+}
+
+// copySchedule returns a copy of a stored schedule as Get reads it back.
+func copySchedule(schedule *data.Schedule) *data.Schedule {
+	found := *schedule
+	found.StartAt = *columnTime(&schedule.StartAt)
+	found.EndAt = columnTime(schedule.EndAt)
+	found.NextRunAt = columnTime(schedule.NextRunAt)
+	return &found
+}
+
+func (r scheduleRepo) Insert(_ context.Context, schedule *data.Schedule) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	err := r.s.requireUser(schedule.UserID, "schedules_user_id_fk")
+	if err != nil {
+		return err
+	}
+
+	schedule.ID = r.s.nextID("schedules")
+	stored := copySchedule(schedule)
+	createdAt := now()
+	stored.CreatedAt = &createdAt
+	schedule.CreatedAt = &createdAt
+	r.s.schedules[stored.ID] = stored
+	return nil
+}
+
+func (r scheduleRepo) Get(_ context.Context, id, userID int64) (*data.Schedule, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	schedule, ok := r.s.schedules[id]
+	if !ok || schedule.UserID != userID {
+		return nil, data.ErrRecordNotFound
+	}
+	return copySchedule(schedule), nil
+}
+
+func (r scheduleRepo) GetAllForUser(_ context.Context, userID int64) ([]*data.Schedule, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	schedules := []*data.Schedule{}
+	for id := r.s.seq["schedules"]; id > 0; id-- {
+		if schedule, ok := r.s.schedules[id]; ok && schedule.UserID == userID {
+			schedules = append(schedules, copySchedule(schedule))
+		}
+	}
+	return schedules, nil
+}
+
+func (r scheduleRepo) Cancel(_ context.Context, schedule *data.Schedule) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	stored, ok := r.s.schedules[schedule.ID]
+	if !ok || stored.UserID != schedule.UserID || stored.Status != data.ScheduleActive {
+		return data.ErrRecordNotFound
+	}
+	stored.Status = data.ScheduleCancelled
+	stored.NextRunAt = nil
+	schedule.Status = data.ScheduleCancelled
+	schedule.NextRunAt = nil
+	return nil
+}
+
+func (r scheduleRepo) GetRuns(_ context.Context, scheduleID, userID int64) ([]*data.ScheduleRun, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	runs := []*data.ScheduleRun{}
+	schedule, ok := r.s.schedules[scheduleID]
+	if !ok || schedule.UserID != userID {
+		return runs, nil
+	}
+	for i := len(r.s.scheduleRuns) - 1; i >= 0; i-- {
+		if run := r.s.scheduleRuns[i]; run.ScheduleID == scheduleID {
+			found := *run
+			runs = append(runs, &found)
+		}
+	}
+	return runs, nil
+}
+
+func (r scheduleRepo) ClaimDue(_ context.Context, limit int, lease time.Duration) ([]*data.Schedule, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	now := time.Now()
+	claimed := []*data.Schedule{}
+	for id := int64(1); id <= r.s.seq["schedules"] && len(claimed) < limit; id++ {
+		schedule, ok := r.s.schedules[id]
+		if !ok || schedule.Status != data.ScheduleActive || schedule.NextRunAt == nil || schedule.NextRunAt.Unix() > now.Unix() {
+			continue
+		}
+		claimed = append(claimed, copySchedule(schedule))
+		leased := now.Add(lease)
+		schedule.NextRunAt = &leased
+	}
+	return claimed, nil
+}
+
+func (r scheduleRepo) RecordRun(_ context.Context, schedule *data.Schedule, run *data.ScheduleRun) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	stored, ok := r.s.schedules[run.ScheduleID]
+	if !ok {
+		return errForeignKey("schedule_runs_schedule_id_fk")
+	}
+
+	run.ID = r.s.nextID("schedule_runs")
+	inserted := *run
+	createdAt := now()
+	inserted.CreatedAt = &createdAt
+	r.s.scheduleRuns = append(r.s.scheduleRuns, &inserted)
+
+	if stored.Status == data.ScheduleActive {
+		stored.Occurrence = schedule.Occurrence
+		stored.Retries = schedule.Retries
+		stored.NextRunAt = columnTime(schedule.NextRunAt)
+		stored.Status = schedule.Status
+	}
+	return nil
+}
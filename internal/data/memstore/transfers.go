@@ -0,0 +1,494 @@
+package memstore
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/ebitezion/backend-framework/internal/data"
+)
+
+type nameEnquiryRepo struct{ s *Store }
+
+func (r nameEnquiryRepo) Insert(_ context.Context, enquiry *data.NameEnquiry) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if _, ok := r.s.nameEnquiries[enquiry.Ref]; ok {
+		return errDuplicate("name_enquiries.PRIMARY")
+	}
+	err := r.s.requireUser(enquiry.UserID, "name_enquiries_user_id_fk")
+	if err != nil {
+		return err
+	}
+	stored := *enquiry
+	r.s.nameEnquiries[stored.Ref] = &stored
+	return nil
+}
+
+func (r nameEnquiryRepo) Get(_ context.Context, ref string, userID int64) (*data.NameEnquiry, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	enquiry, ok := r.s.nameEnquiries[ref]
+	if !ok || enquiry.UserID != userID || !enquiry.ExpiresAt.After(time.Now()) {
+		return nil, data.ErrRecordNotFound
+	}
+	found := *enquiry
+	found.ExpiresAt = time.Time{}
+	return &found, nil
+}
+
+type interbankTransferRepo struct{ s *Store }
+
+// interbankTransfer joins a row of interbank_transfers to its transactions row, as
+// the SQL model's query does.
+func (s *Store) interbankTransfer(reference string) (*data.InterbankTransfer, *data.Transaction) {
+	stored, ok := s.interbankTransfers[reference]
+	if !ok {
+		return nil, nil
+	}
+	for _, t := range s.transactions {
+		if t.InternalReference == reference {
+			transfer := *stored
+			transfer.RequestID = t.RequestID
+			transfer.AccountNumber = t.AccountNumber
+			transfer.Amount = t.Amount
+			transfer.Narration = t.Narration
+			transfer.Status = t.Status
+			transfer.CreatedAt = t.CreatedAt
+			return &transfer, t
+		}
+	}
+	return nil, nil
+}
+
+func (r interbankTransferRepo) Debit(_ context.Context, transfer *data.InterbankTransfer, requeryAfter time.Duration) error {
+	requery, err := data.NewOutboxMessage(data.OutboxInterbankRequery, data.TransactionReference{Reference: transfer.Reference})
+	if err != nil {
+		return err
+	}
+	requery.NotBefore = time.Now().Add(requeryAfter)
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	a := r.s.accountByNumber(transfer.AccountNumber)
+	if a == nil {
+		return data.ErrRecordNotFound
+	}
+	counter, err := a.chargeLimit(data.ChannelTransfers, transfer.Amount)
+	if err != nil {
+		return err
+	}
+	err = r.s.chargeCoolingOff(transfer.BeneficiaryID, transfer.Amount, transfer.CoolingOffLimit)
+	if err != nil {
+		return err
+	}
+	total := decimal.NewFromFloat(transfer.Amount).Add(decimal.NewFromFloat(transfer.Fee))
+	if a.balance.LessThan(total) {
+		return data.ErrInsufficientFunds
+	}
+	if _, ok := r.s.interbankTransfers[transfer.Reference]; ok {
+		return data.ErrDuplicateTransaction
+	}
+
+	after := a.balance.Sub(total).Round(2)
+	balanceAfter, _ := after.Float64()
+	fee := transfer.Fee
+	debit := &data.Transaction{
+		UserID:            uint64(transfer.UserID),
+		Type:              string(data.Debit),
+		Source:            data.SourceInterbank,
+		Narration:         transfer.Narration,
+		AccountNumber:     transfer.AccountNumber,
+		RequestID:         transfer.RequestID,
+		InternalReference: transfer.Reference,
+		Amount:            transfer.Amount,
+		Commission:        &fee,
+		Status:            data.Pending,
+		BalanceAfter:      &balanceAfter,
+	}
+	err = r.s.insertTransaction(debit, data.ErrDuplicateTransaction)
+	if err != nil {
+		return err
+	}
+
+	stored := *transfer
+	r.s.interbankTransfers[stored.Reference] = &stored
+	r.s.postLedger(
+		data.LedgerEntry{Ledger: data.LedgerInterbankSuspense, Type: data.Credit, Amount: decimal.NewFromFloat(transfer.Amount)},
+		data.LedgerEntry{Ledger: data.LedgerFeeIncome, Type: data.Credit, Amount: decimal.NewFromFloat(transfer.Fee)},
+	)
+	r.s.enqueue(requery)
+	r.s.limitChannels[transfer.Reference] = data.ChannelTransfers
+	if transfer.BeneficiaryID != 0 {
+		r.s.beneficiaryPayments[transfer.Reference] = transfer.BeneficiaryID
+	}
+	a.balance, a.counter = after, counter
+	a.updatedAt = now()
+
+	_, t := r.s.interbankTransfer(transfer.Reference)
+	transfer.Status = data.Pending
+	transfer.CreatedAt = t.CreatedAt
+	return nil
+}
+
+func (r interbankTransferRepo) Get(_ context.Context, reference string) (*data.InterbankTransfer, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	transfer, _ := r.s.interbankTransfer(reference)
+	if transfer == nil {
+		return nil, data.ErrRecordNotFound
+	}
+	return transfer, nil
+}
+
+func (r interbankTransferRepo) Settle(_ context.Context, reference, status, sessionID string) (*data.InterbankTransfer, bool, error) {
+	if status != data.Completed && status != data.Failed {
+		return nil, false, data.ErrInvalidTransition
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	transfer, t := r.s.interbankTransfer(reference)
+	if transfer == nil {
+		return nil, false, data.ErrRecordNotFound
+	}
+	if transfer.Status != data.Pending {
+		return transfer, false, nil
+	}
+
+	settled := *t
+	settled.Status = status
+	updatedAt := now()
+	settled.UpdatedAt = &updatedAt
+	var messages []*data.OutboxMessage
+	switch status {
+	case data.Completed:
+		if sessionID != "" {
+			settled.ExternalReference = &sessionID
+		}
+		alert, err := data.NewOutboxMessage(data.OutboxTransactionAlert, data.TransactionReference{Reference: reference})
+		if err != nil {
+			return nil, false, err
+		}
+		event, err := data.NewWebhookEventMessage(transfer.UserID, data.EventTransactionCompleted, settled)
+		if err != nil {
+			return nil, false, err
+		}
+		messages = append(messages, alert, event)
+	case data.Failed:
+		event, err := data.NewWebhookEventMessage(transfer.UserID, data.EventTransactionFailed, settled)
+		if err != nil {
+			return nil, false, err
+		}
+		messages = append(messages, event)
+	}
+
+	amount, fee := decimal.NewFromFloat(transfer.Amount), decimal.NewFromFloat(transfer.Fee)
+	if status == data.Failed {
+		a := r.s.accountByNumber(transfer.AccountNumber)
+		if a == nil {
+			return nil, false, data.ErrRecordNotFound
+		}
+		err := r.s.releaseLimit(t)
+		if err != nil {
+			return nil, false, err
+		}
+		a.balance = a.balance.Add(amount).Add(fee).Round(2)
+		a.updatedAt = updatedAt
+		r.s.postLedger(
+			data.LedgerEntry{Ledger: data.LedgerInterbankSuspense, Type: data.Debit, Amount: amount},
+			data.LedgerEntry{Ledger: data.LedgerFeeIncome, Type: data.Debit, Amount: fee},
+		)
+	} else {
+		r.s.interbankTransfers[reference].SessionID = sessionID
+		transfer.SessionID = sessionID
+		r.s.postLedger(
+			data.LedgerEntry{Ledger: data.LedgerInterbankSuspense, Type: data.Debit, Amount: amount},
+			data.LedgerEntry{Ledger: data.LedgerInterbankSettlement, Type: data.Credit, Amount: amount},
+		)
+	}
+	*t = settled
+	r.s.enqueue(messages...)
+	transfer.Status = status
+	return transfer, true, nil
+}
+
+type internalTransferRepo struct{ s *Store }
+
+func (r internalTransferRepo) Post(_ context.Context, transfer *data.InternalTransfer, reconcileAfter time.Duration) error {
+	reconcile, err := data.NewOutboxMessage(data.OutboxInternalReconcile, data.TransactionReference{Reference: transfer.Reference})
+	if err != nil {
+		return err
+	}
+	reconcile.NotBefore = time.Now().Add(reconcileAfter)
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	sender := r.s.accountByNumber(transfer.AccountNumber)
+	if sender == nil || r.s.accountByNumber(transfer.RecipientAccountNumber) == nil {
+		return data.ErrRecordNotFound
+	}
+	counter, err := sender.chargeLimit(transfer.Channel, transfer.Amount)
+	if err != nil {
+		return err
+	}
+	err = r.s.chargeCoolingOff(transfer.BeneficiaryID, transfer.Amount, transfer.CoolingOffLimit)
+	if err != nil {
+		return err
+	}
+	amount := decimal.NewFromFloat(transfer.Amount)
+	if sender.balance.LessThan(amount) {
+		return data.ErrInsufficientFunds
+	}
+	if _, ok := r.s.internalTransfers[transfer.Reference]; ok {
+		return data.ErrDuplicateTransaction
+	}
+
+	senderAfter := sender.balance.Sub(amount).Round(2)
+	balanceAfter, _ := senderAfter.Float64()
+	debit := &data.Transaction{
+		UserID:            uint64(transfer.UserID),
+		Type:              string(data.Debit),
+		Source:            data.SourceInternal,
+		Narration:         transfer.Narration,
+		AccountNumber:     transfer.AccountNumber,
+		RequestID:         transfer.RequestID,
+		InternalReference: transfer.DebitReference(),
+		Amount:            transfer.Amount,
+		Status:            data.Pending,
+		BalanceAfter:      &balanceAfter,
+	}
+	credit := &data.Transaction{
+		UserID:            uint64(transfer.RecipientUserID),
+		Type:              string(data.Credit),
+		Source:            data.SourceInternal,
+		Narration:         transfer.Narration,
+		AccountNumber:     transfer.RecipientAccountNumber,
+		RequestID:         transfer.RequestID,
+		InternalReference: transfer.CreditReference(),
+		Amount:            transfer.Amount,
+		Status:            data.Pending,
+	}
+
+	// Like the SQL model, all or nothing: undo the debit leg if the credit leg can't
+	// be written.
+	inserted := len(r.s.transactions)
+	for _, t := range []*data.Transaction{debit, credit} {
+		err := r.s.insertTransaction(t, data.ErrDuplicateTransaction)
+		if err != nil {
+			r.s.transactions = r.s.transactions[:inserted]
+			return err
+		}
+	}
+
+	stored := *transfer
+	r.s.internalTransfers[stored.Reference] = &stored
+	r.s.enqueue(reconcile)
+	if transfer.Channel != "" {
+		r.s.limitChannels[debit.InternalReference] = transfer.Channel
+	}
+	if transfer.BeneficiaryID != 0 {
+		r.s.beneficiaryPayments[debit.InternalReference] = transfer.BeneficiaryID
+	}
+	sender.balance, sender.counter, sender.updatedAt = senderAfter, counter, now()
+
+	transfer.Status = data.Pending
+	transfer.BalanceAfter = &balanceAfter
+	transfer.CreatedAt = r.s.transactions[inserted].CreatedAt
+	return nil
+}
+
+func (r internalTransferRepo) Submit(_ context.Context, reference string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	stored, ok := r.s.internalTransfers[reference]
+	if !ok {
+		return data.ErrRecordNotFound
+	}
+	if r.s.transaction(stored.DebitReference()).Status != data.Pending {
+		return data.ErrInvalidTransition
+	}
+	r.s.submittedTransfers[reference] = true
+	return nil
+}
+
+func (r internalTransferRepo) Settle(_ context.Context, reference, status, externalReference string) (*data.InternalTransfer, bool, error) {
+	if status != data.Completed && status != data.Failed {
+		return nil, false, data.ErrInvalidTransition
+	}
+	return r.settle(reference, status, externalReference, false)
+}
+
+func (r internalTransferRepo) Abandon(_ context.Context, reference string) (*data.InternalTransfer, bool, error) {
+	return r.settle(reference, data.Failed, "", true)
+}
+
+// settle does the work of Settle and Abandon, as the SQL model's settle does.
+func (r internalTransferRepo) settle(reference, status, externalReference string, unsubmitted bool) (*data.InternalTransfer, bool, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	stored, ok := r.s.internalTransfers[reference]
+	if !ok {
+		return nil, false, data.ErrRecordNotFound
+	}
+	debit, credit := r.s.transaction(stored.DebitReference()), r.s.transaction(stored.CreditReference())
+	if debit.Status != data.Pending {
+		return internalTransfer(reference, debit, credit), false, nil
+	}
+	if unsubmitted && r.s.submittedTransfers[reference] {
+		return nil, false, data.ErrTransferSubmitted
+	}
+
+	settledDebit, settledCredit := *debit, *credit
+	if status == data.Completed && externalReference != "" {
+		settledDebit.ExternalReference = &externalReference
+		settledCredit.ExternalReference = &externalReference
+	}
+	if r.s.accountByNumber(debit.AccountNumber) == nil || r.s.accountByNumber(credit.AccountNumber) == nil {
+		return nil, false, data.ErrRecordNotFound
+	}
+	if status == data.Completed {
+		err := r.s.settlePending(credit, &settledCredit, status)
+		if err != nil {
+			return nil, false, err
+		}
+	} else {
+		updatedAt := now()
+		settledCredit.Status, settledCredit.UpdatedAt = status, &updatedAt
+		*credit = settledCredit
+	}
+	err := r.s.settlePending(debit, &settledDebit, status)
+	if err != nil {
+		return nil, false, err
+	}
+	return internalTransfer(reference, debit, credit), true, nil
+}
+
+// internalTransfer puts a transfer back together from its two legs, as the SQL
+// model does.
+func internalTransfer(reference string, debit, credit *data.Transaction) *data.InternalTransfer {
+	return &data.InternalTransfer{
+		Reference:              reference,
+		RequestID:              debit.RequestID,
+		UserID:                 int64(debit.UserID),
+		AccountNumber:          debit.AccountNumber,
+		RecipientUserID:        int64(credit.UserID),
+		RecipientAccountNumber: credit.AccountNumber,
+		ExternalReference:      debit.ExternalReference,
+		Amount:                 debit.Amount,
+		Narration:              debit.Narration,
+		Status:                 debit.Status,
+		BalanceAfter:           debit.BalanceAfter,
+		CreatedAt:              debit.CreatedAt,
+	}
+}
+
+// chargeCoolingOff checks a debit of amount to the beneficiary against limit, counting
+// what has been paid to it, as the SQL models' chargeCoolingOff does.
+func (s *Store) chargeCoolingOff(beneficiaryID int64, amount float64, limit int64) error {
+	beneficiary, ok := s.beneficiaries[beneficiaryID]
+	if !ok || !beneficiary.CoolingOffUntil.After(time.Now()) {
+		return nil
+	}
+	paid := decimal.NewFromFloat(amount)
+	for _, t := range s.transactions {
+		if s.beneficiaryPayments[t.InternalReference] == beneficiaryID && t.Status != data.Failed {
+			paid = paid.Add(decimal.NewFromFloat(t.Amount))
+		}
+	}
+	if paid.GreaterThan(decimal.NewFromInt(limit)) {
+		return data.ErrCoolingOffLimitExceeded
+	}
+	return nil
+}
+
+type beneficiaryRepo struct{ s *Store }
+
+// copyBeneficiary returns a copy of a stored beneficiary as Get reads it back.
+func copyBeneficiary(beneficiary *data.Beneficiary) *data.Beneficiary {
+	found := *beneficiary
+	found.CoolingOff = found.CoolingOffUntil.After(time.Now())
+	found.CoolingOffUntil = time.Time{}
+	return &found
+}
+
+func (r beneficiaryRepo) Insert(_ context.Context, beneficiary *data.Beneficiary) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for _, other := range r.s.beneficiaries {
+		if other.UserID == beneficiary.UserID && other.Type == beneficiary.Type && other.BankCode == beneficiary.BankCode &&
+			other.AccountNumber == beneficiary.AccountNumber && other.Tag == beneficiary.Tag {
+			return data.ErrDuplicateBeneficiary
+		}
+	}
+	err := r.s.requireUser(beneficiary.UserID, "beneficiaries_user_id_fk")
+	if err != nil {
+		return err
+	}
+
+	beneficiary.ID = r.s.nextID("beneficiaries")
+	beneficiary.CoolingOff = beneficiary.CoolingOffUntil.After(time.Now())
+	stored := *beneficiary
+	createdAt := now()
+	stored.CreatedAt = &createdAt
+	r.s.beneficiaries[stored.ID] = &stored
+	return nil
+}
+
+func (r beneficiaryRepo) Get(_ context.Context, id, userID int64) (*data.Beneficiary, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	beneficiary, ok := r.s.beneficiaries[id]
+	if !ok || beneficiary.UserID != userID {
+		return nil, data.ErrRecordNotFound
+	}
+	return copyBeneficiary(beneficiary), nil
+}
+
+func (r beneficiaryRepo) GetAllForUser(_ context.Context, userID int64) ([]*data.Beneficiary, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	beneficiaries := []*data.Beneficiary{}
+	for id := int64(1); id <= r.s.seq["beneficiaries"]; id++ {
+		if beneficiary, ok := r.s.beneficiaries[id]; ok && beneficiary.UserID == userID {
+			beneficiaries = append(beneficiaries, copyBeneficiary(beneficiary))
+		}
+	}
+	sort.SliceStable(beneficiaries, func(i, j int) bool {
+		return beneficiaries[i].UsageCount > beneficiaries[j].UsageCount
+	})
+	return beneficiaries, nil
+}
+
+func (r beneficiaryRepo) UpdateNickname(_ context.Context, beneficiary *data.Beneficiary) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if stored, ok := r.s.beneficiaries[beneficiary.ID]; ok && stored.UserID == beneficiary.UserID {
+		stored.Nickname = beneficiary.Nickname
+	}
+	return nil
+}
+
+func (r beneficiaryRepo) Delete(_ context.Context, id, userID int64) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	beneficiary, ok := r.s.beneficiaries[id]
+	if !ok || beneficiary.UserID != userID {
+		return data.ErrRecordNotFound
+	}
+	delete(r.s.beneficiaries, id)
+	return nil
+}
+
+func (r beneficiaryRepo) RecordUse(_ context.Context, id int64) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if beneficiary, ok := r.s.beneficiaries[id]; ok {
+		beneficiary.UsageCount++
+		lastUsedAt := now()
+		beneficiary.LastUsedAt = &lastUsedAt
+	}
+	return nil
+}
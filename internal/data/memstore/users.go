@@ -0,0 +1,514 @@
+package memstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gopkg.in/guregu/null.v4"
+
+	"github.com/ebitezion/backend-framework/internal/data"
+)
+
+// userRecord is a row of users. The verification flags that data.User doesn't carry
+// are kept alongside it.
+type userRecord struct {
+	user          data.User
+	emailVerified bool
+	phoneVerified bool
+}
+
+// userByID parses a user ID passed as a string, as many of the models take it.
+func (s *Store) userByID(id string) *userRecord {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return s.users[n]
+}
+
+// userBy returns the first user, in ID order, for which match is true.
+func (s *Store) userBy(match func(u *data.User) bool) *userRecord {
+	var found *userRecord
+	for _, rec := range s.users {
+		if match(&rec.user) && (found == nil || rec.user.ID < found.user.ID) {
+			found = rec
+		}
+	}
+	return found
+}
+
+// clashes reports whether another user already has the email or username.
+func (s *Store) clashes(id int64, email, username string) bool {
+	for _, rec := range s.users {
+		if rec.user.ID != id && (rec.user.Email == email || rec.user.Username == username) {
+			return true
+		}
+	}
+	return false
+}
+
+// token returns the unexpired token with the plaintext and, unless scope is empty,
+// the scope.
+func (s *Store) token(scope, plaintext string) (data.Token, bool) {
+	token, ok := s.tokens[sha256.Sum256([]byte(plaintext))]
+	if !ok || !token.Expiry.After(time.Now()) || (scope != "" && token.Scope != scope) {
+		return data.Token{}, false
+	}
+	return token, true
+}
+
+type userRepo struct{ s *Store }
+
+func (r userRepo) insert(user *data.User, status string, spectrumExtra bool) error {
+	if r.s.clashes(0, user.Email, user.Username) {
+		return data.ErrDuplicateEmailOrUsername
+	}
+
+	stored := *user
+	stored.ID = r.s.nextID("users")
+	stored.Status = status
+	if !stored.Activated.Valid {
+		stored.Activated = null.BoolFrom(false)
+	}
+	stored.DeviceID = user.UserDevice.DeviceID
+	stored.DeviceOS = user.UserDevice.DeviceOS
+	stored.DeviceName = user.UserDevice.DeviceName
+	stored.UserDevice = data.UserDevice{}
+	stored.Address_verified = null.BoolFrom(false)
+	stored.BVN_verified = null.BoolFrom(false)
+	stored.Account_upgraded = null.BoolFrom(false)
+	stored.Is_spectrum_extra = null.BoolFrom(spectrumExtra)
+	stored.KYC_level = 0
+	stored.Version = 1
+	createdAt := now()
+	stored.CreatedAt = &createdAt
+
+	r.s.users[stored.ID] = &userRecord{user: stored}
+	user.ID = stored.ID
+	return nil
+}
+
+func (r userRepo) Insert(_ context.Context, user *data.User) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return r.insert(user, "pending", false)
+}
+
+func (r userRepo) Insert_Existing_Account_Holder(_ context.Context, user *data.User) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return r.insert(user, "existing", true)
+}
+
+func (r userRepo) UpdateUserDevice(_ context.Context, Email, DeviceID, DeviceName, DeviceOS string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for _, rec := range r.s.users {
+		if rec.user.Email == Email {
+			rec.user.DeviceID = DeviceID
+			rec.user.DeviceOS = DeviceOS
+			rec.user.DeviceName = DeviceName
+		}
+	}
+	return nil
+}
+
+// get returns a copy of the first matching user.
+func (r userRepo) get(match func(u *data.User) bool) (*data.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	rec := r.s.userBy(match)
+	if rec == nil {
+		return nil, data.ErrRecordNotFound
+	}
+	user := rec.user
+	return &user, nil
+}
+
+func (r userRepo) GetByEmail(_ context.Context, email string) (*data.User, error) {
+	return r.get(func(u *data.User) bool { return u.Email == email })
+}
+
+func (r userRepo) GetByPhoneNumber(_ context.Context, phoneNumber string) (*data.User, error) {
+	return r.get(func(u *data.User) bool { return u.PhoneNumber == phoneNumber })
+}
+
+func (r userRepo) GetUserIdByToken(_ context.Context, tokenScope, tokenPlaintext string) (int64, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	token, ok := r.s.token(tokenScope, tokenPlaintext)
+	if !ok {
+		return 0, data.ErrRecordNotFound
+	}
+	return token.UserID, nil
+}
+
+func (r userRepo) GetForToken(_ context.Context, tokenScope, tokenPlaintext string) (*data.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	token, ok := r.s.token(tokenScope, tokenPlaintext)
+	if !ok {
+		return nil, data.ErrRecordNotFound
+	}
+	rec, ok := r.s.users[token.UserID]
+	if !ok {
+		return nil, data.ErrRecordNotFound
+	}
+	user := rec.user
+	return &user, nil
+}
+
+// accountForToken returns the account of the user holding the token.
+func (s *Store) accountForToken(scope, plaintext string) (*account, error) {
+	token, ok := s.token(scope, plaintext)
+	if !ok {
+		return nil, data.ErrRecordNotFound
+	}
+	a, ok := s.accounts[token.UserID]
+	if !ok {
+		return nil, data.ErrRecordNotFound
+	}
+	return a, nil
+}
+
+func (r userRepo) GetUserDetailsFromToken(_ context.Context, tokenScope, tokenPlaintext string) (*data.UserDetailsForLimits, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	a, err := r.s.accountForToken(tokenScope, tokenPlaintext)
+	if err != nil {
+		return nil, err
+	}
+	return a.details()
+}
+
+func (r userRepo) GetUserDetailsByUserID(_ context.Context, userID int64) (*data.UserDetailsForLimits, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	a, ok := r.s.accounts[userID]
+	if !ok {
+		return nil, data.ErrRecordNotFound
+	}
+	return a.details()
+}
+
+// details returns the account as the user details the payment pipelines check.
+func (a *account) details() (*data.UserDetailsForLimits, error) {
+	details := data.UserDetailsForLimits{
+		UserID:        strconv.FormatInt(a.userID, 10),
+		PIN:           a.pin,
+		AccountNumber: a.number,
+		Balance:       a.balance.StringFixed(2),
+	}
+	err := json.Unmarshal([]byte(a.limits), &details.Limits)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(a.counter), &details.Counter)
+	if err != nil {
+		return nil, err
+	}
+	return &details, nil
+}
+
+func (r userRepo) GetByEmailAndDeviceID(_ context.Context, email string, deviceID, deviceName, deviceOS string) (*data.User, error) {
+	user, err := r.get(func(u *data.User) bool { return u.Email == email || u.Username == email })
+	if err != nil {
+		return nil, err
+	}
+	return data.CheckSignIn(user, deviceID, deviceName, deviceOS)
+}
+
+func (r userRepo) UpdatePassword(_ context.Context, password *data.ResetPassword) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for _, rec := range r.s.users {
+		if rec.user.Email == password.Email {
+			rec.user.Password.SetHash(password.Hash)
+		}
+	}
+	return nil
+}
+
+// versioned returns the user's record if its version still matches, for the
+// updates that use optimistic locking.
+func (s *Store) versioned(user *data.User) (*userRecord, error) {
+	rec, ok := s.users[user.ID]
+	if !ok || rec.user.Version != user.Version {
+		return nil, data.ErrEditConflict
+	}
+	return rec, nil
+}
+
+func (r userRepo) Update(_ context.Context, user *data.User) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	rec, err := r.s.versioned(user)
+	if err != nil {
+		return err
+	}
+	if r.s.clashes(user.ID, user.Email, user.Username) {
+		return data.ErrDuplicateEmailOrUsername
+	}
+
+	rec.user.Name = user.Name
+	rec.user.Username = user.Username
+	rec.user.Email = user.Email
+	rec.user.Password = user.Password
+	rec.user.Activated = user.Activated
+	rec.user.Version++
+	user.Version = rec.user.Version
+	return nil
+}
+
+func (r userRepo) GetUserDetailsAndPINFromToken(_ context.Context, tokenScope, tokenPlaintext string) (*data.UserDetailsWithPIN, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	a, err := r.s.accountForToken(tokenScope, tokenPlaintext)
+	if err != nil {
+		return nil, err
+	}
+	return &data.UserDetailsWithPIN{
+		UserID:        strconv.FormatInt(a.userID, 10),
+		PIN:           a.pin,
+		AccountNumber: a.number,
+		Limits:        a.limits,
+		Counter:       a.counter,
+	}, nil
+}
+
+func (r userRepo) GetUserByUserID(_ context.Context, UserId string) (*data.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	rec := r.s.userByID(UserId)
+	if rec == nil {
+		return nil, data.ErrRecordNotFound
+	}
+	user := rec.user
+	return &user, nil
+}
+
+func (r userRepo) GetAccountNoByID(_ context.Context, id string) (string, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	a, err := r.s.accountByUserID(id)
+	if err != nil {
+		return "", err
+	}
+	return a.number, nil
+}
+
+func (r userRepo) UpdateActivated(_ context.Context, user *data.User) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	rec, err := r.s.versioned(user)
+	if err != nil {
+		return err
+	}
+	rec.user.Activated = user.Activated
+	rec.user.Version++
+	user.Version = rec.user.Version
+	return nil
+}
+
+// update applies fn to the user's record, if there is one. Like an UPDATE that
+// matches no rows, a missing user is not an error.
+func (r userRepo) update(id int64, fn func(rec *userRecord)) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if rec, ok := r.s.users[id]; ok {
+		fn(rec)
+	}
+	return nil
+}
+
+func (r userRepo) UpdateBvnVerified(_ context.Context, user *data.User) error {
+	return r.update(user.ID, func(rec *userRecord) {
+		rec.user.BVN_verified = null.BoolFrom(true)
+	})
+}
+
+func (r userRepo) UpdateStatus_PhoneNumberVerified(_ context.Context, user *data.User) error {
+	return r.update(user.ID, func(rec *userRecord) {
+		rec.phoneVerified = true
+		rec.user.Status = "active"
+	})
+}
+
+func (r userRepo) UpdatePhoneNumberVerified(_ context.Context, user *data.User) error {
+	return r.update(user.ID, func(rec *userRecord) {
+		rec.phoneVerified = true
+		rec.user.Activated = null.BoolFrom(true)
+	})
+}
+
+func (r userRepo) UpdateEmailVerified(_ context.Context, user *data.User) error {
+	return r.update(user.ID, func(rec *userRecord) {
+		rec.emailVerified = true
+		rec.user.Activated = null.BoolFrom(true)
+	})
+}
+
+func (r userRepo) UpdateEmailVerified_for_Spectrumpay_Users(_ context.Context, user *data.User) error {
+	return r.update(user.ID, func(rec *userRecord) {
+		rec.emailVerified = true
+		rec.user.Is_spectrum_extra = null.BoolFrom(true)
+	})
+}
+
+func (r userRepo) SetFirstTimePIN(ctx context.Context, user *data.UserDetails) error {
+	return r.UpdateTransactionPin2(ctx, user.PIN, user.UserID)
+}
+
+func (r userRepo) UpdateKycLevel(_ context.Context, user *data.User, level string) error {
+	n, err := strconv.Atoi(level)
+	if err != nil {
+		return fmt.Errorf("memstore: invalid kyc_level %q", level)
+	}
+	return r.update(user.ID, func(rec *userRecord) {
+		rec.user.KYC_level = n
+	})
+}
+
+func (r userRepo) UpdateAccountUpgraded(_ context.Context, user *data.User) error {
+	err := r.update(user.ID, func(rec *userRecord) {
+		rec.user.Account_upgraded = null.BoolFrom(true)
+		rec.user.Address_verified = null.BoolFrom(true)
+	})
+	if err != nil {
+		return err
+	}
+	user.Account_upgraded = null.BoolFrom(true)
+	user.Address_verified = null.BoolFrom(true)
+	return nil
+}
+
+func (r userRepo) UpdateActivatedIfVerified(_ context.Context, user *data.User) error {
+	return r.update(user.ID, func(rec *userRecord) {
+		if rec.emailVerified || rec.phoneVerified {
+			rec.user.Activated = null.BoolFrom(true)
+		}
+	})
+}
+
+func (r userRepo) UpdateTransactionPin(ctx context.Context, pin *data.SetPinData) error {
+	return r.UpdateTransactionPin2(ctx, pin.PIN, pin.UserID)
+}
+
+func (r userRepo) UpdateTransactionPin2(_ context.Context, pin, userid string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if a, err := r.s.accountByUserID(userid); err == nil {
+		a.pin = pin
+		a.pinFailures = 0
+	}
+	return nil
+}
+
+func (r userRepo) ClaimUSSDPINAttempt(_ context.Context, userID int64, max int) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	a, ok := r.s.accounts[userID]
+	if !ok || a.pinFailures >= max {
+		return data.ErrUSSDLocked
+	}
+	a.pinFailures++
+	return nil
+}
+
+func (r userRepo) ResetUSSDPINAttempts(_ context.Context, userID int64) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if a, ok := r.s.accounts[userID]; ok {
+		a.pinFailures = 0
+	}
+	return nil
+}
+
+func (r userRepo) IsAuthTokenForUserID(_ context.Context, tokenPlaintext, UserID string) bool {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	token, ok := r.s.token("", tokenPlaintext)
+	if !ok {
+		return false
+	}
+	_, ok = r.s.users[token.UserID]
+	return ok && strconv.FormatInt(token.UserID, 10) == UserID
+}
+
+type tokenRepo struct{ s *Store }
+
+func (r tokenRepo) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*data.Token, error) {
+	token, err := data.GenerateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+	err = r.Insert(ctx, token)
+	return token, err
+}
+
+func (r tokenRepo) Insert(_ context.Context, token *data.Token) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	var hash [32]byte
+	copy(hash[:], token.Hash)
+	if _, ok := r.s.tokens[hash]; ok {
+		return errDuplicate("tokens.PRIMARY")
+	}
+	err := r.s.requireUser(token.UserID, "tokens_user_id_fk")
+	if err != nil {
+		return err
+	}
+	r.s.tokens[hash] = *token
+	return nil
+}
+
+func (r tokenRepo) DeleteAllForUser(_ context.Context, scope string, userID int64) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for hash, token := range r.s.tokens {
+		if token.Scope == scope && token.UserID == userID {
+			delete(r.s.tokens, hash)
+		}
+	}
+	return nil
+}
+
+type permissionRepo struct{ s *Store }
+
+func (r permissionRepo) GetAllForUser(_ context.Context, userID int64) (data.Permissions, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return append(data.Permissions(nil), r.s.grants[userID]...), nil
+}
+
+// AddForUser grants the codes that exist and, like the SQL model, silently skips
+// any that don't.
+func (r permissionRepo) AddForUser(_ context.Context, userID int64, codes ...string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if len(codes) == 0 {
+		return nil
+	}
+	err := r.s.requireUser(userID, "users_permissions_user_id_fk")
+	if err != nil {
+		return err
+	}
+
+	granted := r.s.grants[userID]
+	var added data.Permissions
+	for _, code := range codes {
+		if !r.s.permissions[code] || added.Include(code) {
+			continue
+		}
+		if granted.Include(code) {
+			return errDuplicate("users_permissions.PRIMARY")
+		}
+		added = append(added, code)
+	}
+	r.s.grants[userID] = append(granted, added...)
+	return nil
+}
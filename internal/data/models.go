@@ -1,8 +1,10 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
 )
 
 var (
@@ -10,6 +12,7 @@ var (
 	// looking up a movie that doesn't exist in our database.
 	ErrRecordNotFound       = errors.New("record not found")
 	ErrTransactionPINNotSet = errors.New("Transaction PIN Not Set")
+	ErrUSSDLocked           = errors.New("USSD locked after too many wrong PINs")
 	// Define a custom ErrEditConflict error. We'll return this from our Update() method
 	// when there is a data race.
 	ErrEditConflict = errors.New("edit conflict")
@@ -23,17 +26,225 @@ var (
 	Pending   = "pending"
 	Completed = "completed"
 	Cancelled = "cancelled"
+	Failed    = "failed"
+	Approved  = "approved"
+	Rejected  = "rejected"
 )
 
-// Create a Models struct which wraps the MovieModel. We'll add other models to this,
-// like a UserModel and PermissionModel, as our build progresses.
+// UserRepository is the storage behind user accounts, their devices and their
+// verification state.
+type UserRepository interface {
+	Insert(ctx context.Context, user *User) error
+	Insert_Existing_Account_Holder(ctx context.Context, user *User) error
+	UpdateUserDevice(ctx context.Context, Email, DeviceID, DeviceName, DeviceOS string) error
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	GetByPhoneNumber(ctx context.Context, phoneNumber string) (*User, error)
+	GetUserIdByToken(ctx context.Context, tokenScope, tokenPlaintext string) (int64, error)
+	GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error)
+	GetUserDetailsFromToken(ctx context.Context, tokenScope, tokenPlaintext string) (*UserDetailsForLimits, error)
+	GetUserDetailsByUserID(ctx context.Context, userID int64) (*UserDetailsForLimits, error)
+	GetByEmailAndDeviceID(ctx context.Context, email string, deviceID, deviceName, deviceOS string) (*User, error)
+	UpdatePassword(ctx context.Context, password *ResetPassword) error
+	Update(ctx context.Context, user *User) error
+	GetUserDetailsAndPINFromToken(ctx context.Context, tokenScope, tokenPlaintext string) (*UserDetailsWithPIN, error)
+	GetUserByUserID(ctx context.Context, UserId string) (*User, error)
+	GetAccountNoByID(ctx context.Context, id string) (string, error)
+	UpdateActivated(ctx context.Context, user *User) error
+	UpdateBvnVerified(ctx context.Context, user *User) error
+	UpdateStatus_PhoneNumberVerified(ctx context.Context, user *User) error
+	UpdatePhoneNumberVerified(ctx context.Context, user *User) error
+	UpdateEmailVerified(ctx context.Context, user *User) error
+	UpdateEmailVerified_for_Spectrumpay_Users(ctx context.Context, user *User) error
+	SetFirstTimePIN(ctx context.Context, user *UserDetails) error
+	UpdateKycLevel(ctx context.Context, user *User, level string) error
+	UpdateAccountUpgraded(ctx context.Context, user *User) error
+	UpdateActivatedIfVerified(ctx context.Context, user *User) error
+	UpdateTransactionPin(ctx context.Context, data *SetPinData) error
+	UpdateTransactionPin2(ctx context.Context, pin, userid string) error
+	ClaimUSSDPINAttempt(ctx context.Context, userID int64, max int) error
+	ResetUSSDPINAttempts(ctx context.Context, userID int64) error
+	IsAuthTokenForUserID(ctx context.Context, tokenPlaintext, UserID string) bool
+}
+
+// TokenRepository stores activation and authentication tokens.
+type TokenRepository interface {
+	New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error)
+	Insert(ctx context.Context, token *Token) error
+	DeleteAllForUser(ctx context.Context, scope string, userID int64) error
+}
+
+// PermissionRepository stores the permission codes granted to each user.
+type PermissionRepository interface {
+	GetAllForUser(ctx context.Context, userID int64) (Permissions, error)
+	AddForUser(ctx context.Context, userID int64, codes ...string) error
+}
+
+// AccountRepository is the storage behind bank accounts: their numbers and limits,
+// limit upgrade requests and KYC account upgrades.
+type AccountRepository interface {
+	UpdateLimitStatus(ctx context.Context, LimitID int) error
+	UpdateLimitInDB(ctx context.Context, limit string, userID string) error
+	UpdateLimitCounterInDB(ctx context.Context, counter LimitCounts, userID string) error
+	GetAccountLimits(ctx context.Context, userID string) (string, error)
+	GetUserAccountNoByID(ctx context.Context, userID string) (string, error)
+	GetLimitUpgradeRequest(ctx context.Context, LimitID int) (*UpgradeLimitRequest, error)
+	CreateNewLimitRequest(ctx context.Context, data *UpgradeLimitRequest) error
+	NewMaillingList(ctx context.Context, email string) error
+	NewAccountUpgrade(ctx context.Context, data *AccountUpgradeData) error
+	GetAccountUpgrade(ctx context.Context, id int64) (*AccountUpgradeData, error)
+	GetAccountUpgradesByStatus(ctx context.Context, status string) ([]*AccountUpgradeData, error)
+	ReviewAccountUpgrade(ctx context.Context, upgrade *AccountUpgradeData, status string, reviewerID int64, note string) error
+	SaveCreatedAccountNo(ctx context.Context, data *AccountDetails) error
+	SaveCreatedAccountNo2(ctx context.Context, User_id, Account_number, Limits, Counter string, Created_at, Updated_at time.Time) error
+	GetAccountProfile(ctx context.Context, userID int64) (*AccountProfile, error)
+	GetAccountHolder(ctx context.Context, accountNumber string) (*AccountHolder, error)
+}
+
+// TransactionRepository records transactions and applies them to account balances.
+type TransactionRepository interface {
+	PostTransaction(ctx context.Context, transaction *Transaction) error
+	GetTransactionByReference(ctx context.Context, reference string) (*Transaction, error)
+	GetDebitByRequestID(ctx context.Context, accountNumber, requestID string) (*Transaction, error)
+	SaveTransactionDetails(ctx context.Context, transaction *Transaction) error
+	GetAccountHistory(ctx context.Context, accountNumber string, pagination string) ([]Transaction, error)
+	SettleTransaction(ctx context.Context, nonce, reference, status string) (transaction *Transaction, changed bool, err error)
+	InitiatePayment(ctx context.Context, transaction *Transaction, requeryAfter time.Duration) error
+	SettlePayment(ctx context.Context, reference, status, externalReference string) (transaction *Transaction, changed bool, err error)
+}
+
+// NotificationRepository stores each user's notification preferences.
+type NotificationRepository interface {
+	GetForUser(ctx context.Context, userID int64) (*NotificationPreferences, error)
+	Upsert(ctx context.Context, prefs *NotificationPreferences) error
+}
+
+// OutboxRepository stores deferred work for the outbox worker.
+type OutboxRepository interface {
+	Insert(ctx context.Context, messages ...*OutboxMessage) error
+	ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]*OutboxMessage, error)
+	MarkDelivered(ctx context.Context, id int64) error
+	MarkFailed(ctx context.Context, id int64, lastError string, nextAttempt time.Time) error
+	MarkDead(ctx context.Context, id int64, lastError string) error
+}
+
+// WebhookRepository stores partner webhook endpoints and the log of deliveries to
+// them.
+type WebhookRepository interface {
+	InsertEndpoint(ctx context.Context, endpoint *WebhookEndpoint) error
+	GetEndpoint(ctx context.Context, id int64) (*WebhookEndpoint, error)
+	GetEndpointsForUser(ctx context.Context, userID int64) ([]*WebhookEndpoint, error)
+	DeleteEndpoint(ctx context.Context, id, userID int64) error
+	CreateDeliveries(ctx context.Context, event *WebhookEvent, endpoints []*WebhookEndpoint) error
+	GetDelivery(ctx context.Context, id int64) (*WebhookDelivery, error)
+	GetDeliveriesForEndpoint(ctx context.Context, endpointID int64, limit int) ([]*WebhookDelivery, error)
+	RecordAttempt(ctx context.Context, delivery *WebhookDelivery) error
+	ReplayDelivery(ctx context.Context, delivery *WebhookDelivery) error
+}
+
+// CallbackRepository keeps the audit log of provider callbacks and prunes the
+// nonces used for replay protection.
+type CallbackRepository interface {
+	Insert(ctx context.Context, callback *ProviderCallback) error
+	PruneNonces(ctx context.Context, before time.Time) (int64, error)
+}
+
+// NameEnquiryRepository keeps confirmed beneficiary accounts until they expire.
+type NameEnquiryRepository interface {
+	Insert(ctx context.Context, enquiry *NameEnquiry) error
+	Get(ctx context.Context, ref string, userID int64) (*NameEnquiry, error)
+}
+
+// InterbankTransferRepository records transfers to other banks and settles them.
+type InterbankTransferRepository interface {
+	Debit(ctx context.Context, transfer *InterbankTransfer, requeryAfter time.Duration) error
+	Get(ctx context.Context, reference string) (*InterbankTransfer, error)
+	Settle(ctx context.Context, reference, status, sessionID string) (transfer *InterbankTransfer, changed bool, err error)
+}
+
+// InternalTransferRepository records transfers between two of our own accounts.
+type InternalTransferRepository interface {
+	Post(ctx context.Context, transfer *InternalTransfer, reconcileAfter time.Duration) error
+	Submit(ctx context.Context, reference string) error
+	Settle(ctx context.Context, reference, status, externalReference string) (*InternalTransfer, bool, error)
+	Abandon(ctx context.Context, reference string) (*InternalTransfer, bool, error)
+}
+
+// TransferTagRepository stores the tags users can be paid by.
+type TransferTagRepository interface {
+	Claim(ctx context.Context, tag *TransferTag, cooldown time.Duration) error
+	GetByTag(ctx context.Context, tag string) (*TransferTag, error)
+}
+
+// BeneficiaryRepository stores the recipients users have saved.
+type BeneficiaryRepository interface {
+	Insert(ctx context.Context, beneficiary *Beneficiary) error
+	Get(ctx context.Context, id, userID int64) (*Beneficiary, error)
+	GetAllForUser(ctx context.Context, userID int64) ([]*Beneficiary, error)
+	UpdateNickname(ctx context.Context, beneficiary *Beneficiary) error
+	Delete(ctx context.Context, id, userID int64) error
+	RecordUse(ctx context.Context, id int64) error
+}
+
+// ScheduleRepository stores scheduled payments and the history of their runs.
+type ScheduleRepository interface {
+	Insert(ctx context.Context, schedule *Schedule) error
+	Get(ctx context.Context, id, userID int64) (*Schedule, error)
+	GetAllForUser(ctx context.Context, userID int64) ([]*Schedule, error)
+	Cancel(ctx context.Context, schedule *Schedule) error
+	GetRuns(ctx context.Context, scheduleID, userID int64) ([]*ScheduleRun, error)
+	ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]*Schedule, error)
+	RecordRun(ctx context.Context, schedule *Schedule, run *ScheduleRun) error
+}
+
+// BillPaymentRepository records payments to billers and settles them.
+type BillPaymentRepository interface {
+	Debit(ctx context.Context, payment *BillPayment, requeryAfter time.Duration) error
+	Get(ctx context.Context, reference string) (*BillPayment, error)
+	GetAllForUser(ctx context.Context, userID int64) ([]*BillPayment, error)
+	Settle(ctx context.Context, reference, status, billerReference, token string) (payment *BillPayment, changed bool, err error)
+}
+
+// The SQL models must implement the repositories they are returned as.
+var (
+	_ UserRepository              = UserModel{}
+	_ TokenRepository             = TokenModel{}
+	_ PermissionRepository        = PermissionModel{}
+	_ AccountRepository           = AccountModel{}
+	_ TransactionRepository       = TransactionModel{}
+	_ NotificationRepository      = NotificationPreferenceModel{}
+	_ OutboxRepository            = OutboxModel{}
+	_ WebhookRepository           = WebhookModel{}
+	_ CallbackRepository          = ProviderCallbackModel{}
+	_ NameEnquiryRepository       = NameEnquiryModel{}
+	_ InterbankTransferRepository = InterbankTransferModel{}
+	_ InternalTransferRepository  = InternalTransferModel{}
+	_ TransferTagRepository       = TransferTagModel{}
+	_ BeneficiaryRepository       = BeneficiaryModel{}
+	_ ScheduleRepository          = ScheduleModel{}
+	_ BillPaymentRepository       = BillPaymentModel{}
+)
+
+// Models holds a repository for each part of the schema. Handlers only see the
+// interfaces, so that tests can swap in the implementations from data/memstore.
 type Models struct {
 	//AModel MyModel
-	Users       UserModel
-	Tokens      TokenModel
-	Permissions PermissionModel
+	Users       UserRepository
+	Tokens      TokenRepository
+	Permissions PermissionRepository
 	// VersionModel     VersionModel
-	AccountModel AccountModel
+	AccountModel       AccountRepository
+	Transactions       TransactionRepository
+	Notifications      NotificationRepository
+	Outbox             OutboxRepository
+	Webhooks           WebhookRepository
+	Callbacks          CallbackRepository
+	NameEnquiries      NameEnquiryRepository
+	InterbankTransfers InterbankTransferRepository
+	InternalTransfers  InternalTransferRepository
+	TransferTags       TransferTagRepository
+	Beneficiaries      BeneficiaryRepository
+	Schedules          ScheduleRepository
+	BillPayments       BillPaymentRepository
 	// MediaModel       MediaModel
 	// ErrorModel       ErrorModel
 	// VerifyModel      VerifyModel
@@ -41,14 +252,28 @@ type Models struct {
 }
 
 // For ease of use, we also add a New() method which returns a Models struct containing
-// the intitialized MovieModel.
-func NewModels(db *sql.DB) Models {
+// the SQL implementations. The dialect must match the driver sqlDB was opened with,
+// and timeout caps how long any one model method may spend in the database.
+func NewModels(sqlDB *sql.DB, dialect Dialect, timeout time.Duration) Models {
+	db := NewDB(sqlDB, dialect, timeout)
 	return Models{
 		Users:       UserModel{DB: db},
 		Tokens:      TokenModel{DB: db},
 		Permissions: PermissionModel{DB: db},
 		// VersionModel:     VersionModel{DB: db},
-		AccountModel: AccountModel{DB: db},
+		AccountModel:       AccountModel{DB: db},
+		Transactions:       TransactionModel{DB: db},
+		Notifications:      NotificationPreferenceModel{DB: db},
+		Outbox:             OutboxModel{DB: db},
+		Webhooks:           WebhookModel{DB: db},
+		Callbacks:          ProviderCallbackModel{DB: db},
+		NameEnquiries:      NameEnquiryModel{DB: db},
+		InterbankTransfers: InterbankTransferModel{DB: db},
+		InternalTransfers:  InternalTransferModel{DB: db},
+		TransferTags:       TransferTagModel{DB: db},
+		Beneficiaries:      BeneficiaryModel{DB: db},
+		Schedules:          ScheduleModel{DB: db},
+		BillPayments:       BillPaymentModel{DB: db},
 		// MediaModel:       MediaModel{DB: db},
 		// ErrorModel:       ErrorModel{DB: db},
 		// VerifyModel:      VerifyModel{DB: db},
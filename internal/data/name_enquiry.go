@@ -0,0 +1,121 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/ebitezion/backend-framework/internal/validator"
+)
+
+// NameEnquiry is a beneficiary account that a user has confirmed the name on. A
+// transfer to another account refers to the enquiry by Ref, which is only good until
+// ExpiresAt and only for the user who made the enquiry.
+type NameEnquiry struct {
+	Ref           string `json:"nameEnquiryRef"`
+	UserID        int64  `json:"-"`
+	AccountNumber string `json:"accountNumber"`
+	BankCode      string `json:"bankCode"`
+	BankName      string `json:"bankName"`
+	AccountName   string `json:"accountName"`
+	// Internal is set for one of our own accounts, which was resolved locally.
+	Internal bool `json:"internal"`
+	// The beneficiary's BVN and KYC level, and the upstream session the enquiry was
+	// made in, which an interbank transfer has to quote.
+	BVN       string    `json:"-"`
+	KYCLevel  string    `json:"-"`
+	SessionID string    `json:"-"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// NameEnquiryRequest is the account a user wants to confirm. An empty bank code means
+// one of our own accounts.
+type NameEnquiryRequest struct {
+	AccountNumber string `json:"accountNumber"`
+	BankCode      string `json:"bankCode"`
+}
+
+func ValidateNameEnquiryRequest(v *validator.Validator, request *NameEnquiryRequest) {
+	v.Check(request.AccountNumber != "", "accountNumber", "must be provided")
+	v.Check(len(request.AccountNumber) == 10, "accountNumber", "should be 10 characters")
+	v.Check(request.BankCode == "" || len(request.BankCode) == 6, "bankCode", "should be 6 characters")
+}
+
+// NewNameEnquiryRef returns a random reference for a name enquiry.
+func NewNameEnquiryRef() (string, error) {
+	b := make([]byte, 12)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return "NE" + hex.EncodeToString(b), nil
+}
+
+// NameEnquiryModel wraps the name_enquiries table.
+type NameEnquiryModel struct {
+	DB *DB
+}
+
+// Insert stores a name enquiry until it expires.
+func (m NameEnquiryModel) Insert(ctx context.Context, enquiry *NameEnquiry) error {
+	query := `
+	INSERT INTO name_enquiries (ref, user_id, account_number, bank_code, bank_name, account_name, internal, bvn, kyc_level, session_id, expires_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query,
+		enquiry.Ref,
+		enquiry.UserID,
+		enquiry.AccountNumber,
+		enquiry.BankCode,
+		enquiry.BankName,
+		enquiry.AccountName,
+		enquiry.Internal,
+		enquiry.BVN,
+		enquiry.KYCLevel,
+		enquiry.SessionID,
+		enquiry.ExpiresAt,
+	)
+	return err
+}
+
+// Get returns the user's name enquiry with the given reference. An enquiry that has
+// expired, or that another user made, is reported as ErrRecordNotFound. ExpiresAt
+// isn't read back.
+func (m NameEnquiryModel) Get(ctx context.Context, ref string, userID int64) (*NameEnquiry, error) {
+	query := `
+	SELECT ref, user_id, account_number, bank_code, bank_name, account_name, internal, bvn, kyc_level, session_id
+	FROM name_enquiries
+	WHERE ref = ? AND user_id = ? AND expires_at > NOW()`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	var enquiry NameEnquiry
+	err := m.DB.QueryRowContext(ctx, query, ref, userID).Scan(
+		&enquiry.Ref,
+		&enquiry.UserID,
+		&enquiry.AccountNumber,
+		&enquiry.BankCode,
+		&enquiry.BankName,
+		&enquiry.AccountName,
+		&enquiry.Internal,
+		&enquiry.BVN,
+		&enquiry.KYCLevel,
+		&enquiry.SessionID,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &enquiry, nil
+}
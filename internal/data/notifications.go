@@ -0,0 +1,89 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// NotificationPreferences records which channels a user wants to be notified on,
+// along with the device token used for push notifications.
+type NotificationPreferences struct {
+	UserID    int64  `json:"-"`
+	Email     bool   `json:"email"`
+	SMS       bool   `json:"sms"`
+	Push      bool   `json:"push"`
+	PushToken string `json:"push_token"`
+}
+
+// NotificationPreferenceModel wraps the notification_preferences table.
+type NotificationPreferenceModel struct {
+	DB *DB
+}
+
+// GetForUser returns the notification preferences for a user. Users who have never
+// changed their preferences get every channel switched on.
+func (m NotificationPreferenceModel) GetForUser(ctx context.Context, userID int64) (*NotificationPreferences, error) {
+	query := `
+	SELECT email, sms, push, push_token
+	FROM notification_preferences
+	WHERE user_id = ?`
+
+	prefs := NotificationPreferences{UserID: userID}
+	var pushToken sql.NullString
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&prefs.Email,
+		&prefs.SMS,
+		&prefs.Push,
+		&pushToken,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return &NotificationPreferences{UserID: userID, Email: true, SMS: true, Push: true}, nil
+		default:
+			return nil, err
+		}
+	}
+	prefs.PushToken = pushToken.String
+	return &prefs, nil
+}
+
+// Upsert stores the notification preferences for a user, creating the row the first
+// time a user changes them.
+func (m NotificationPreferenceModel) Upsert(ctx context.Context, prefs *NotificationPreferences) error {
+	update := `
+	UPDATE notification_preferences
+	SET email = ?, sms = ?, push = ?, push_token = ?, updated_at = NOW()
+	WHERE user_id = ?`
+	insert := `
+	INSERT INTO notification_preferences (user_id, email, sms, push, push_token)
+	VALUES (?, ?, ?, ?, ?)`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, update, prefs.Email, prefs.SMS, prefs.Push, prefs.PushToken, prefs.UserID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected > 0 {
+		return nil
+	}
+
+	// Nothing was updated, so either there is no row yet or the row already holds
+	// exactly these values. In the second case the INSERT hits the primary key, which
+	// is fine.
+	_, err = m.DB.ExecContext(ctx, insert, prefs.UserID, prefs.Email, prefs.SMS, prefs.Push, prefs.PushToken)
+	if err != nil && !isUniqueViolation(err) {
+		return err
+	}
+	return nil
+}
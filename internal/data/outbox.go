@@ -0,0 +1,186 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Outbox message kinds. Each kind has a handler registered with the outbox worker.
+const (
+	OutboxEmail             = "email"
+	OutboxNotification      = "notification"
+	OutboxTransactionAlert  = "transaction_alert"
+	OutboxWebhookEvent      = "webhook_event"
+	OutboxWebhookDelivery   = "webhook_delivery"
+	OutboxInterbankRequery  = "interbank_requery"
+	OutboxBillRequery       = "bill_requery"
+	OutboxPaymentRequery    = "payment_requery"
+	OutboxInternalReconcile = "internal_reconcile"
+)
+
+// Outbox message states. Pending messages are picked up by the worker, delivered ones
+// are kept for auditing, and dead ones have exhausted their retries and need a human.
+const (
+	OutboxPending   = "pending"
+	OutboxDelivered = "delivered"
+	OutboxDead      = "dead"
+)
+
+// OutboxMessage is a unit of deferred work (an email, a notification, a webhook) that
+// must survive a process restart. Messages are written in the same database
+// transaction as the business change that caused them, so they are never lost and
+// never sent for a change that was rolled back.
+type OutboxMessage struct {
+	ID        int64           `json:"id"`
+	Kind      string          `json:"kind"`
+	Payload   json.RawMessage `json:"payload"`
+	Status    string          `json:"status"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"last_error"`
+	// NotBefore holds back the first attempt until the given time. The zero value
+	// means straight away.
+	NotBefore time.Time `json:"-"`
+}
+
+// NewOutboxMessage encodes payload as JSON and wraps it in a message of the given kind.
+func NewOutboxMessage(kind string, payload interface{}) (*OutboxMessage, error) {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &OutboxMessage{Kind: kind, Payload: js, Status: OutboxPending}, nil
+}
+
+// insertOutboxMessages writes messages using exec, which is normally the *Tx of
+// the business change that produced them.
+func insertOutboxMessages(ctx context.Context, exec execer, messages ...*OutboxMessage) error {
+	query := `
+	INSERT INTO outbox (kind, payload, status, attempts, next_attempt_at)
+	VALUES (?, ?, ?, 0, ?)`
+
+	now := time.Now()
+	for _, msg := range messages {
+		due := now
+		if msg.NotBefore.After(now) {
+			due = msg.NotBefore
+		}
+		id, err := insertID(ctx, exec, query, msg.Kind, string(msg.Payload), OutboxPending, due)
+		if err != nil {
+			return err
+		}
+		msg.ID = id
+		msg.Status = OutboxPending
+	}
+	return nil
+}
+
+// OutboxModel wraps the outbox table.
+type OutboxModel struct {
+	DB *DB
+}
+
+// Insert writes one or more messages to the outbox atomically, for callers that have
+// no business transaction of their own.
+func (m OutboxModel) Insert(ctx context.Context, messages ...*OutboxMessage) error {
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertOutboxMessages(ctx, tx, messages...)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ClaimDue returns up to limit pending messages whose next attempt is due, and leases
+// them to the caller by pushing their next attempt lease into the future. If the
+// worker dies mid-delivery the lease simply runs out and the message is retried.
+// SKIP LOCKED lets several workers (or replicas) claim batches concurrently without
+// handing out the same message twice.
+func (m OutboxModel) ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]*OutboxMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	SELECT id, kind, payload, attempts
+	FROM outbox
+	WHERE status = ? AND next_attempt_at <= ?
+	ORDER BY id
+	LIMIT ?
+	FOR UPDATE SKIP LOCKED`
+
+	now := time.Now()
+	rows, err := tx.QueryContext(ctx, query, OutboxPending, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []*OutboxMessage{}
+	for rows.Next() {
+		var msg OutboxMessage
+		var payload string
+		err := rows.Scan(&msg.ID, &msg.Kind, &payload, &msg.Attempts)
+		if err != nil {
+			return nil, err
+		}
+		msg.Payload = json.RawMessage(payload)
+		msg.Status = OutboxPending
+		messages = append(messages, &msg)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for _, msg := range messages {
+		_, err = tx.ExecContext(ctx, `UPDATE outbox SET attempts = attempts + 1, next_attempt_at = ? WHERE id = ?`, now.Add(lease), msg.ID)
+		if err != nil {
+			return nil, err
+		}
+		msg.Attempts++
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// MarkDelivered records that a message was handled successfully.
+func (m OutboxModel) MarkDelivered(ctx context.Context, id int64) error {
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, `UPDATE outbox SET status = ?, last_error = NULL, delivered_at = ? WHERE id = ?`, OutboxDelivered, time.Now(), id)
+	return err
+}
+
+// MarkFailed records a failed attempt and schedules the next one.
+func (m OutboxModel) MarkFailed(ctx context.Context, id int64, lastError string, nextAttempt time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, `UPDATE outbox SET last_error = ?, next_attempt_at = ? WHERE id = ?`, lastError, nextAttempt, id)
+	return err
+}
+
+// MarkDead moves a message to the dead-letter state after its final failed attempt.
+func (m OutboxModel) MarkDead(ctx context.Context, id int64, lastError string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, `UPDATE outbox SET status = ?, last_error = ? WHERE id = ?`, OutboxDead, lastError, id)
+	return err
+}
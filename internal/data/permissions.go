@@ -2,8 +2,7 @@ package data
 
 import (
 	"context"
-	"database/sql"
-	"time"
+	"strings"
 )
 
 // Define a Permissions slice, which we will use to will hold the permission codes (like
@@ -24,21 +23,21 @@ func (p Permissions) Include(code string) bool {
 
 // Define the PermissionModel type.
 type PermissionModel struct {
-	DB *sql.DB
+	DB *DB
 }
 
 // The GetAllForUser() method returns all permission codes for a specific user in a
 // Permissions slice. The code in this method should feel very familiar --- it uses the
 // standard pattern that we've already seen before for retrieving multiple data rows in
 // an SQL query.
-func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+func (m PermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
 	query := `
 	SELECT permissions.code
 	FROM permissions
 	INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
 	INNER JOIN users ON users_permissions.user_id = users.id
 	WHERE users.id = ?`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
 	rows, err := m.DB.QueryContext(ctx, query, userID)
 	if err != nil {
@@ -63,14 +62,25 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 // Add the provided permission codes for a specific user. Notice that we're using a
 // variadic parameter for the codes so that we can assign multiple permissions in a
 // single call.
-func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
+func (m PermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	if len(codes) == 0 {
+		return nil
+	}
+
+	// Neither driver expands a slice into an IN list, so there is a placeholder
+	// per code.
 	query := `
         INSERT INTO users_permissions
-        SELECT ?, permissions.id FROM permissions WHERE permissions.code IN (?)
+        SELECT ?, permissions.id FROM permissions WHERE permissions.code IN (?` + strings.Repeat(", ?", len(codes)-1) + `)
     `
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	args := []interface{}{userID}
+	for _, code := range codes {
+		args = append(args, code)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, userID, codes)
+	_, err := m.DB.ExecContext(ctx, query, args...)
 	return err
 }
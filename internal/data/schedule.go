@@ -0,0 +1,388 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/ebitezion/backend-framework/internal/validator"
+)
+
+// Schedule frequencies. A once schedule pays on its start date only; the others
+// repeat from it, and a cron schedule follows its cron rule.
+const (
+	FrequencyOnce    = "once"
+	FrequencyDaily   = "daily"
+	FrequencyWeekly  = "weekly"
+	FrequencyMonthly = "monthly"
+	FrequencyCron    = "cron"
+)
+
+// Frequencies lists every schedule frequency, for validation.
+var Frequencies = []string{FrequencyOnce, FrequencyDaily, FrequencyWeekly, FrequencyMonthly, FrequencyCron}
+
+// Schedule states. Only active schedules are run; a schedule is completed once it
+// has no more occurrences, and cancelled by its owner.
+const (
+	ScheduleActive    = "active"
+	ScheduleCompleted = "completed"
+	ScheduleCancelled = "cancelled"
+)
+
+// Schedule run outcomes. A run that failed for lack of funds is retrying until the
+// scheduler gives up on its occurrence.
+const (
+	RunPaid     = "paid"
+	RunRetrying = "retrying"
+	RunFailed   = "failed"
+)
+
+// Schedule is a payment to one of a user's beneficiaries, made by the scheduler on
+// the user's behalf once or on a recurring rule. The user authorises it with their
+// transaction PIN when it is created, and each run goes through the same payment
+// pipeline, limits included, as a transfer they make themselves.
+//
+// Its times are stored in UTC, to the second.
+type Schedule struct {
+	ID            int64      `json:"id"`
+	UserID        int64      `json:"-"`
+	BeneficiaryID int64      `json:"beneficiary_id"`
+	Type          string     `json:"type"`
+	Amount        int        `json:"amount"`
+	Narration     string     `json:"narration"`
+	Frequency     string     `json:"frequency"`
+	Cron          string     `json:"cron"`
+	StartAt       time.Time  `json:"start_at"`
+	EndAt         *time.Time `json:"end_at"`
+	NextRunAt     *time.Time `json:"next_run_at"`
+	// Occurrence counts the occurrences that have been run, and Retries the
+	// retries of the current one.
+	Occurrence int     `json:"occurrence"`
+	Retries    int     `json:"retries"`
+	Status     string  `json:"status"`
+	CreatedAt  *string `json:"created_at"`
+}
+
+// ScheduleRequest is a scheduled payment a user wants to set up.
+type ScheduleRequest struct {
+	BeneficiaryID int64      `json:"beneficiary_id"`
+	Amount        int        `json:"amount"`
+	Narration     string     `json:"narration"`
+	Frequency     string     `json:"frequency"`
+	Cron          string     `json:"cron"`
+	StartAt       time.Time  `json:"start_at"`
+	EndAt         *time.Time `json:"end_at"`
+	PIN           string     `json:"pin"`
+}
+
+// ValidateScheduleRequest checks the request's fields. Whether the cron rule parses
+// is checked by the caller.
+func ValidateScheduleRequest(v *validator.Validator, request *ScheduleRequest) {
+	v.Check(request.BeneficiaryID > 0, "beneficiary_id", "must be provided")
+	v.Check(request.Amount > 0, "amount", "must be greater than zero")
+	v.Check(len(request.Narration) <= 100, "narration", "must not be more than 100 bytes long")
+	v.Check(validator.In(request.Frequency, Frequencies...), "frequency", "must be once, daily, weekly, monthly or cron")
+	if request.Frequency == FrequencyCron {
+		v.Check(request.Cron != "", "cron", "must be provided for a cron schedule")
+	} else {
+		v.Check(request.Cron == "", "cron", "must only be given for a cron schedule")
+	}
+	v.Check(request.StartAt.After(time.Now()), "start_at", "must be in the future")
+	if request.EndAt != nil {
+		v.Check(request.Frequency != FrequencyOnce, "end_at", "must not be given for a once schedule")
+		v.Check(request.EndAt.After(request.StartAt), "end_at", "must be after start_at")
+	}
+	v.Check(len(request.PIN) == 4, "pin", "must be 4 digits long")
+}
+
+// ScheduleRun is one attempt at an occurrence of a schedule.
+type ScheduleRun struct {
+	ID         int64  `json:"id"`
+	ScheduleID int64  `json:"schedule_id"`
+	Occurrence int    `json:"occurrence"`
+	Attempt    int    `json:"attempt"`
+	Reference  string `json:"reference"`
+	Status     string `json:"status"`
+	// TransferReference is the reference of the transfer a paid run made.
+	TransferReference string  `json:"transfer_reference"`
+	Error             string  `json:"error"`
+	CreatedAt         *string `json:"created_at"`
+}
+
+// ScheduleModel wraps the schedules and schedule_runs tables.
+type ScheduleModel struct {
+	DB *DB
+}
+
+// columnTime returns t as a schedule's times are stored, or nil for a nil time.
+func columnTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Truncate(time.Second)
+}
+
+// Insert saves a new schedule.
+func (m ScheduleModel) Insert(ctx context.Context, schedule *Schedule) error {
+	query := `
+	INSERT INTO schedules (user_id, beneficiary_id, type, amount, narration, frequency, cron, start_at, end_at, next_run_at, status)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	var err error
+	schedule.ID, err = insertID(ctx, m.DB, query,
+		schedule.UserID,
+		schedule.BeneficiaryID,
+		schedule.Type,
+		schedule.Amount,
+		schedule.Narration,
+		schedule.Frequency,
+		schedule.Cron,
+		columnTime(&schedule.StartAt),
+		columnTime(schedule.EndAt),
+		columnTime(schedule.NextRunAt),
+		schedule.Status,
+	)
+	return err
+}
+
+const scheduleColumns = `id, user_id, beneficiary_id, type, amount, narration, frequency, cron, start_at, end_at, next_run_at, occurrence, retries, status, created_at`
+
+// scanSchedule scans a row of scheduleColumns.
+func scanSchedule(row interface{ Scan(...interface{}) error }) (*Schedule, error) {
+	var schedule Schedule
+	var startAt, endAt, nextRunAt nullTime
+	err := row.Scan(
+		&schedule.ID,
+		&schedule.UserID,
+		&schedule.BeneficiaryID,
+		&schedule.Type,
+		&schedule.Amount,
+		&schedule.Narration,
+		&schedule.Frequency,
+		&schedule.Cron,
+		&startAt,
+		&endAt,
+		&nextRunAt,
+		&schedule.Occurrence,
+		&schedule.Retries,
+		&schedule.Status,
+		&schedule.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	schedule.StartAt = startAt.Time
+	schedule.EndAt = endAt.Ptr()
+	schedule.NextRunAt = nextRunAt.Ptr()
+	return &schedule, nil
+}
+
+// Get returns one of the user's schedules. Another user's schedule is reported as
+// ErrRecordNotFound.
+func (m ScheduleModel) Get(ctx context.Context, id, userID int64) (*Schedule, error) {
+	query := `SELECT ` + scheduleColumns + ` FROM schedules WHERE id = ? AND user_id = ?`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	schedule, err := scanSchedule(m.DB.QueryRowContext(ctx, query, id, userID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return schedule, nil
+}
+
+// GetAllForUser returns the user's schedules, the newest first.
+func (m ScheduleModel) GetAllForUser(ctx context.Context, userID int64) ([]*Schedule, error) {
+	query := `SELECT ` + scheduleColumns + ` FROM schedules WHERE user_id = ? ORDER BY id DESC`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []*Schedule{}
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, schedule)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+// Cancel stops one of the user's active schedules. A schedule that isn't active is
+// reported as ErrRecordNotFound.
+func (m ScheduleModel) Cancel(ctx context.Context, schedule *Schedule) error {
+	query := `UPDATE schedules SET status = ?, next_run_at = NULL WHERE id = ? AND user_id = ? AND status = ?`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, ScheduleCancelled, schedule.ID, schedule.UserID, ScheduleActive)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	schedule.Status = ScheduleCancelled
+	schedule.NextRunAt = nil
+	return nil
+}
+
+// GetRuns returns the runs of one of the user's schedules, the latest first.
+func (m ScheduleModel) GetRuns(ctx context.Context, scheduleID, userID int64) ([]*ScheduleRun, error) {
+	query := `
+	SELECT schedule_runs.id, schedule_runs.schedule_id, schedule_runs.occurrence, schedule_runs.attempt, schedule_runs.reference,
+		schedule_runs.status, schedule_runs.transfer_reference, schedule_runs.error, schedule_runs.created_at
+	FROM schedule_runs
+	INNER JOIN schedules ON schedules.id = schedule_runs.schedule_id
+	WHERE schedule_runs.schedule_id = ? AND schedules.user_id = ?
+	ORDER BY schedule_runs.id DESC`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, scheduleID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []*ScheduleRun{}
+	for rows.Next() {
+		var run ScheduleRun
+		err := rows.Scan(
+			&run.ID,
+			&run.ScheduleID,
+			&run.Occurrence,
+			&run.Attempt,
+			&run.Reference,
+			&run.Status,
+			&run.TransferReference,
+			&run.Error,
+			&run.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, &run)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// ClaimDue returns up to limit active schedules whose next run is due, and leases
+// them to the caller by pushing their next run lease into the future, in the same
+// way as OutboxModel.ClaimDue. A replica that dies mid-run leaves the schedule to be
+// claimed again when the lease runs out, and SKIP LOCKED keeps two replicas from
+// claiming the same schedule.
+func (m ScheduleModel) ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]*Schedule, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	SELECT ` + scheduleColumns + `
+	FROM schedules
+	WHERE status = ? AND next_run_at <= ?
+	ORDER BY next_run_at
+	LIMIT ?
+	FOR UPDATE SKIP LOCKED`
+
+	now := time.Now()
+	rows, err := tx.QueryContext(ctx, query, ScheduleActive, now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []*Schedule{}
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, schedule)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	leased := now.Add(lease)
+	for _, schedule := range schedules {
+		_, err = tx.ExecContext(ctx, `UPDATE schedules SET next_run_at = ? WHERE id = ?`, columnTime(&leased), schedule.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+// RecordRun saves a run and, in the same transaction, the schedule's position after
+// it: its occurrence, retries, next run and status.
+func (m ScheduleModel) RecordRun(ctx context.Context, schedule *Schedule, run *ScheduleRun) error {
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO schedule_runs (schedule_id, occurrence, attempt, reference, status, transfer_reference, error)
+	VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+	run.ID, err = insertID(ctx, tx, query, run.ScheduleID, run.Occurrence, run.Attempt, run.Reference, run.Status, run.TransferReference, run.Error)
+	if err != nil {
+		return err
+	}
+
+	// A schedule cancelled while it was running stays cancelled.
+	query = `
+	UPDATE schedules SET occurrence = ?, retries = ?, next_run_at = ?, status = ?
+	WHERE id = ? AND status = ?`
+
+	_, err = tx.ExecContext(ctx, query, schedule.Occurrence, schedule.Retries, columnTime(schedule.NextRunAt), schedule.Status, schedule.ID, ScheduleActive)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
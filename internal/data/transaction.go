@@ -1 +1,596 @@
 package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+var (
+	ErrInsufficientFunds    = errors.New("insufficient funds")
+	ErrDuplicateTransaction = errors.New("duplicate transaction reference")
+	ErrDuplicateCallback    = errors.New("duplicate callback nonce")
+	ErrInvalidTransition    = errors.New("invalid transaction status transition")
+)
+
+// TransactionReference is the outbox payload for work that concerns a single
+// transaction, such as the account holder's alert.
+type TransactionReference struct {
+	Reference string `json:"reference"`
+}
+
+// TransactionModel wraps the transactions table, along with the balance in
+// user_details that completed transactions move.
+type TransactionModel struct {
+	DB *DB
+}
+
+// PaymentReference returns the internal reference of a payment made through the
+// provider: the account number followed by the client's request ID. A retried
+// request therefore maps onto the payment it repeats, and is turned away by the
+// unique key on internal references.
+func PaymentReference(accountNumber, requestID string) string {
+	return "FM" + accountNumber + requestID
+}
+
+const transactionQuery = "SELECT id, user_id, type, source, narration, account_number, request_id, internal_reference, external_reference, amount, created_at, updated_at, status, commission, balance_after FROM transactions WHERE internal_reference = ?"
+
+// lockTransaction returns the transaction with the given internal reference, with its
+// row locked until tx ends.
+func lockTransaction(ctx context.Context, tx *Tx, reference string) (*Transaction, error) {
+	var t Transaction
+	err := tx.QueryRowContext(ctx, transactionQuery+" FOR UPDATE", reference).Scan(&t.ID, &t.UserID, &t.Type, &t.Source, &t.Narration, &t.AccountNumber, &t.RequestID, &t.InternalReference, &t.ExternalReference, &t.Amount, &t.CreatedAt, &t.UpdatedAt, &t.Status, &t.Commission, &t.BalanceAfter)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &t, nil
+}
+
+// PostTransaction applies a completed debit or credit to the account balance and
+// records it in the transactions table. Both happen in a single database
+// transaction, with the user_details row locked, so concurrent payments against the
+// same account can't overdraw it. The account holder's alert and the
+// transaction.completed webhook event are queued in the outbox as part of the same
+// transaction. On success the transaction ID and BalanceAfter
+// fields are populated.
+func (m TransactionModel) PostTransaction(ctx context.Context, transaction *Transaction) error {
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	var balance sql.NullString
+	err = tx.QueryRowContext(ctx, `SELECT balance FROM user_details WHERE account_number = ? FOR UPDATE`, transaction.AccountNumber).Scan(&balance)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	current := decimal.Zero
+	if balance.Valid && balance.String != "" {
+		current, err = decimal.NewFromString(balance.String)
+		if err != nil {
+			return err
+		}
+	}
+
+	amount := decimal.NewFromFloat(transaction.Amount)
+	var after decimal.Decimal
+	switch TransactionType(transaction.Type) {
+	case Debit:
+		if current.LessThan(amount) {
+			return ErrInsufficientFunds
+		}
+		after = current.Sub(amount)
+	default:
+		after = current.Add(amount)
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE user_details SET balance = ?, updated_at = NOW() WHERE account_number = ?`, after.StringFixed(2), transaction.AccountNumber)
+	if err != nil {
+		return err
+	}
+
+	balanceAfter, _ := after.Float64()
+	query := `
+	INSERT INTO transactions(user_id, type, source, narration, account_number, request_id, internal_reference, external_reference, amount, status, balance_after)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	id, err := insertID(ctx, tx, query,
+		transaction.UserID,
+		transaction.Type,
+		transaction.Source,
+		transaction.Narration,
+		transaction.AccountNumber,
+		transaction.RequestID,
+		transaction.InternalReference,
+		transaction.ExternalReference,
+		transaction.Amount,
+		transaction.Status,
+		balanceAfter,
+	)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err):
+			return ErrDuplicateTransaction
+		default:
+			return err
+		}
+	}
+
+	alert, err := NewOutboxMessage(OutboxTransactionAlert, TransactionReference{Reference: transaction.InternalReference})
+	if err != nil {
+		return err
+	}
+	err = insertOutboxMessages(ctx, tx, alert)
+	if err != nil {
+		return err
+	}
+
+	completed := *transaction
+	completed.ID = uint64(id)
+	completed.BalanceAfter = &balanceAfter
+	err = insertWebhookEvent(ctx, tx, int64(transaction.UserID), EventTransactionCompleted, completed)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	*transaction = completed
+	return nil
+}
+
+// InitiatePayment records a payment through the provider as pending, before it is
+// sent. A debit is counted against the account's transfer limits and taken from the
+// balance straight away, with the user_details row locked so that concurrent
+// payments can't overdraw the account or go over its limits; a credit is only
+// applied once the payment completes. A requery of the payment is queued in the
+// outbox, due after requeryAfter, so that a payment whose outcome is never learned
+// is still settled. A reference or, for a debit, a request ID that the account has
+// used before returns ErrDuplicateTransaction. On success the transaction's ID,
+// Status, BalanceAfter and CreatedAt fields are populated.
+func (m TransactionModel) InitiatePayment(ctx context.Context, transaction *Transaction, requeryAfter time.Duration) error {
+	requery, err := NewOutboxMessage(OutboxPaymentRequery, TransactionReference{Reference: transaction.InternalReference})
+	if err != nil {
+		return err
+	}
+	requery.NotBefore = time.Now().Add(requeryAfter)
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	balance, err := lockBalance(ctx, tx, transaction.AccountNumber)
+	if err != nil {
+		return err
+	}
+
+	var balanceAfter *float64
+	var channel string
+	if TransactionType(transaction.Type) == Debit {
+		channel = ChannelTransfers
+		err = chargeLimit(ctx, tx, transaction.AccountNumber, channel, transaction.Amount)
+		if err != nil {
+			return err
+		}
+		amount := decimal.NewFromFloat(transaction.Amount)
+		if balance.LessThan(amount) {
+			return ErrInsufficientFunds
+		}
+		after := balance.Sub(amount)
+		_, err = tx.ExecContext(ctx, `UPDATE user_details SET balance = ?, updated_at = NOW() WHERE account_number = ?`, after.StringFixed(2), transaction.AccountNumber)
+		if err != nil {
+			return err
+		}
+		value, _ := after.Float64()
+		balanceAfter = &value
+	}
+
+	query := `
+	INSERT INTO transactions(user_id, type, source, narration, account_number, request_id, internal_reference, amount, status, balance_after, limit_channel)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	id, err := insertID(ctx, tx, query,
+		transaction.UserID,
+		transaction.Type,
+		transaction.Source,
+		transaction.Narration,
+		transaction.AccountNumber,
+		transaction.RequestID,
+		transaction.InternalReference,
+		transaction.Amount,
+		Pending,
+		balanceAfter,
+		channel,
+	)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err):
+			return ErrDuplicateTransaction
+		default:
+			return err
+		}
+	}
+
+	err = insertOutboxMessages(ctx, tx, requery)
+	if err != nil {
+		return err
+	}
+
+	var createdAt *string
+	err = tx.QueryRowContext(ctx, `SELECT created_at FROM transactions WHERE id = ?`, id).Scan(&createdAt)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	transaction.ID = uint64(id)
+	transaction.Status = Pending
+	transaction.BalanceAfter = balanceAfter
+	transaction.CreatedAt = createdAt
+	return nil
+}
+
+// SettlePayment applies the outcome of a pending payment through the provider, with
+// the provider's reference for a completed one. A payment that has already been
+// settled is left alone and changed is false; status must be Completed or Failed, or
+// ErrInvalidTransition is returned.
+func (m TransactionModel) SettlePayment(ctx context.Context, reference, status, externalReference string) (transaction *Transaction, changed bool, err error) {
+	if status != Completed && status != Failed {
+		return nil, false, ErrInvalidTransition
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	t, err := lockTransaction(ctx, tx, reference)
+	if err != nil {
+		return nil, false, err
+	}
+	if t.Status != Pending {
+		return t, false, nil
+	}
+
+	if status == Completed && externalReference != "" {
+		t.ExternalReference = &externalReference
+	}
+	err = settlePending(ctx, tx, t, status)
+	if err != nil {
+		return nil, false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, false, err
+	}
+	return t, true, nil
+}
+
+// GetTransactionByReference returns the transaction with the given internal
+// reference.
+func (m TransactionModel) GetTransactionByReference(ctx context.Context, reference string) (*Transaction, error) {
+	query := "SELECT id, user_id, type, source, narration, account_number, request_id, internal_reference, external_reference, amount, created_at, updated_at, status, commission, balance_after FROM transactions WHERE internal_reference = ?"
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	var t Transaction
+	err := m.DB.QueryRowContext(ctx, query, reference).Scan(&t.ID, &t.UserID, &t.Type, &t.Source, &t.Narration, &t.AccountNumber, &t.RequestID, &t.InternalReference, &t.ExternalReference, &t.Amount, &t.CreatedAt, &t.UpdatedAt, &t.Status, &t.Commission, &t.BalanceAfter)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &t, nil
+}
+
+// GetDebitByRequestID returns the debit on the account with the given request ID, of
+// which there can only be one.
+func (m TransactionModel) GetDebitByRequestID(ctx context.Context, accountNumber, requestID string) (*Transaction, error) {
+	query := "SELECT id, user_id, type, source, narration, account_number, request_id, internal_reference, external_reference, amount, created_at, updated_at, status, commission, balance_after FROM transactions WHERE account_number = ? AND request_id = ? AND type = ?"
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	var t Transaction
+	err := m.DB.QueryRowContext(ctx, query, accountNumber, requestID, Debit).Scan(&t.ID, &t.UserID, &t.Type, &t.Source, &t.Narration, &t.AccountNumber, &t.RequestID, &t.InternalReference, &t.ExternalReference, &t.Amount, &t.CreatedAt, &t.UpdatedAt, &t.Status, &t.Commission, &t.BalanceAfter)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &t, nil
+}
+
+// SaveTransactionDetails records a transaction without touching the balance. It is
+// used for payments the provider rejected.
+func (m TransactionModel) SaveTransactionDetails(ctx context.Context, transaction *Transaction) error {
+	// Define the SQL query with correct placeholders created_at, updated_at,
+	query := `
+	INSERT INTO transactions(user_id,type, source, narration, account_number, request_id, internal_reference, external_reference, amount,  status)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	// Create a context with a timeout.
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Execute the query with ExecContext.
+	_, err = tx.ExecContext(ctx, query,
+		transaction.UserID,
+		transaction.Type,
+		transaction.Source,
+		transaction.Narration,
+		transaction.AccountNumber,
+		transaction.RequestID,
+		transaction.InternalReference,
+		transaction.ExternalReference,
+		transaction.Amount,
+		transaction.Status,
+	)
+	if err != nil {
+		return err
+	}
+
+	// Failed transactions are recorded here rather than through PostTransaction, so
+	// this is where partners hear about them.
+	if transaction.Status == Failed {
+		err = insertWebhookEvent(ctx, tx, int64(transaction.UserID), EventTransactionFailed, transaction)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetAccountHistory returns a page of ten transactions on the account, newest first.
+func (m TransactionModel) GetAccountHistory(ctx context.Context, accountNumber string, pagination string) ([]Transaction, error) {
+
+	// Calculate the offset based on the pagination parameter.
+	number, err := strconv.Atoi(pagination)
+	if err != nil {
+		return nil, err
+	}
+	offset := (number - 1) * 10
+
+	query := "SELECT id, user_id, type, source, narration, account_number, request_id, internal_reference, external_reference, amount, created_at, updated_at, status, commission, balance_after FROM transactions WHERE account_number = ? ORDER BY created_at DESC LIMIT 10 OFFSET ?"
+
+	var transactions []Transaction // Slice to hold multiple transaction records.
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	// Use the QueryContext method to execute the query, passing in the context.
+	rows, err := m.DB.QueryContext(ctx, query, accountNumber, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate through the result set and scan each row into a Transaction struct.
+	for rows.Next() {
+		var t Transaction
+		err := rows.Scan(&t.ID, &t.UserID, &t.Type, &t.Source, &t.Narration, &t.AccountNumber, &t.RequestID, &t.InternalReference, &t.ExternalReference, &t.Amount, &t.CreatedAt, &t.UpdatedAt, &t.Status, &t.Commission, &t.BalanceAfter)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, t)
+	}
+	if len(transactions) == 0 {
+		return []Transaction{}, ErrRecordNotFound // Return empty slice if no transactions found.
+	}
+	return transactions, nil
+}
+
+// settledByProvider reports whether provider callbacks may settle t. Internal
+// transfers are complete when they are posted, and interbank transfers and bill
+// payments are settled by their own models from the network's answer, which refund
+// the fee along with the amount and keep their own rows in step.
+func settledByProvider(t *Transaction) bool {
+	switch t.Source {
+	case SourceInternal, SourceInterbank, SourceBills:
+		return false
+	}
+	return true
+}
+
+// SettleTransaction applies the final status the provider reports for a payment. The
+// callback's nonce is stored in the same database transaction, so a callback is
+// applied at most once: a repeated nonce returns ErrDuplicateCallback and a failed
+// settlement leaves the nonce free for the provider's retry.
+//
+// A transaction already in the reported status is left alone and changed is false.
+// Pending transactions can be completed or failed, as by settlePending; a completed
+// transaction can be failed, which reverses it as reverseBalance does, and returns
+// ErrInsufficientFunds for a credit that has already been spent. Any other move
+// returns ErrInvalidTransition, as does a transaction the provider doesn't settle
+// (see settledByProvider). Failing a transaction emits the transaction.failed webhook
+// event.
+func (m TransactionModel) SettleTransaction(ctx context.Context, nonce, reference, status string) (transaction *Transaction, changed bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO provider_callback_nonces (nonce) VALUES (?)`, nonce)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err):
+			return nil, false, ErrDuplicateCallback
+		default:
+			return nil, false, err
+		}
+	}
+
+	t, err := lockTransaction(ctx, tx, reference)
+	if err != nil {
+		return nil, false, err
+	}
+	if !settledByProvider(t) {
+		return nil, false, ErrInvalidTransition
+	}
+
+	switch {
+	case t.Status == status:
+		// Nothing to do, but keep the nonce so the callback isn't processed again.
+		return t, false, tx.Commit()
+	case t.Status == Pending && (status == Completed || status == Failed):
+		err = settlePending(ctx, tx, t, status)
+		if err != nil {
+			return nil, false, err
+		}
+	case t.Status == Completed && status == Failed:
+		err = reverseBalance(ctx, tx, t)
+		if err != nil {
+			return nil, false, err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE transactions SET status = ?, updated_at = NOW() WHERE id = ?`, status, t.ID)
+		if err != nil {
+			return nil, false, err
+		}
+		t.Status = status
+		err = insertWebhookEvent(ctx, tx, int64(t.UserID), EventTransactionFailed, t)
+		if err != nil {
+			return nil, false, err
+		}
+	default:
+		return nil, false, ErrInvalidTransition
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, false, err
+	}
+	return t, true, nil
+}
+
+// settlePending moves a pending transaction, locked in tx, to Completed or Failed,
+// along with its ExternalReference. A pending debit has already been taken from the
+// balance, so failing it refunds the amount and gives back what it counted against
+// the payer's limits; a pending credit is only applied once it completes, which also
+// sets its BalanceAfter. Completing a transaction queues the account holder's alert
+// and the transaction.completed webhook event, and failing it emits the
+// transaction.failed webhook event.
+func settlePending(ctx context.Context, tx *Tx, t *Transaction, status string) error {
+	debit := TransactionType(t.Type) == Debit
+	if (status == Failed && debit) || (status == Completed && !debit) {
+		balance, err := lockBalance(ctx, tx, t.AccountNumber)
+		if err != nil {
+			return err
+		}
+		after := balance.Add(decimal.NewFromFloat(t.Amount))
+		_, err = tx.ExecContext(ctx, `UPDATE user_details SET balance = ?, updated_at = NOW() WHERE account_number = ?`, after.StringFixed(2), t.AccountNumber)
+		if err != nil {
+			return err
+		}
+		if debit {
+			err = releaseLimit(ctx, tx, t.InternalReference)
+			if err != nil {
+				return err
+			}
+		}
+		if status == Completed {
+			balanceAfter, _ := after.Float64()
+			t.BalanceAfter = &balanceAfter
+		}
+	}
+
+	_, err := tx.ExecContext(ctx, `UPDATE transactions SET status = ?, external_reference = ?, balance_after = ?, updated_at = NOW() WHERE id = ?`, status, t.ExternalReference, t.BalanceAfter, t.ID)
+	if err != nil {
+		return err
+	}
+	t.Status = status
+
+	if status == Failed {
+		return insertWebhookEvent(ctx, tx, int64(t.UserID), EventTransactionFailed, t)
+	}
+	alert, err := NewOutboxMessage(OutboxTransactionAlert, TransactionReference{Reference: t.InternalReference})
+	if err != nil {
+		return err
+	}
+	err = insertOutboxMessages(ctx, tx, alert)
+	if err != nil {
+		return err
+	}
+	return insertWebhookEvent(ctx, tx, int64(t.UserID), EventTransactionCompleted, t)
+}
+
+// reverseBalance undoes the balance movement of a completed transaction: a debit is
+// refunded, giving back what it counted against the payer's limits, and a credit is
+// taken back. A credit the balance no longer covers returns ErrInsufficientFunds
+// rather than overdrawing the account.
+func reverseBalance(ctx context.Context, tx *Tx, t *Transaction) error {
+	current, err := lockBalance(ctx, tx, t.AccountNumber)
+	if err != nil {
+		return err
+	}
+
+	amount := decimal.NewFromFloat(t.Amount)
+	var after decimal.Decimal
+	switch TransactionType(t.Type) {
+	case Debit:
+		after = current.Add(amount)
+		err = releaseLimit(ctx, tx, t.InternalReference)
+		if err != nil {
+			return err
+		}
+	default:
+		if current.LessThan(amount) {
+			return ErrInsufficientFunds
+		}
+		after = current.Sub(amount)
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE user_details SET balance = ?, updated_at = NOW() WHERE account_number = ?`, after.StringFixed(2), t.AccountNumber)
+	return err
+}
@@ -1,5 +1,9 @@
 package data
 
+import (
+	"github.com/ebitezion/backend-framework/internal/validator"
+)
+
 // TransactionType represents the type of transaction (credit or debit)
 type TransactionType string
 
@@ -14,4 +18,20 @@ type Payment struct {
 	Reference string          `json:"reference"`
 	Amount    int             `json:"amount"`
 	Type      TransactionType `json:"type"`
+	Narration string          `json:"narration"`
+}
+
+func ValidatePayment(v *validator.Validator, payment *Payment) {
+	v.Check(payment.AccountID != "", "account_id", "must be provided")
+	v.Check(payment.Reference != "", "reference", "must be provided")
+	v.Check(len(payment.Reference) <= 50, "reference", "must not be more than 50 bytes long")
+	v.Check(payment.Amount > 0, "amount", "must be greater than zero")
+	// Account holders can't credit their own accounts; money comes in through the
+	// provider's callbacks.
+	v.Check(payment.Type == Debit, "type", "must be 'debit'")
+	v.Check(len(payment.Narration) <= 100, "narration", "must not be more than 100 bytes long")
+
+	if len(payment.AccountID) != 10 {
+		v.AddError("error", "account_id should be 10 characters")
+	}
 }
@@ -0,0 +1,142 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ebitezion/backend-framework/internal/validator"
+)
+
+var (
+	ErrDuplicateTransferTag = errors.New("duplicate transfer tag")
+	ErrTransferTagCooldown  = errors.New("transfer tag changed too recently")
+)
+
+// TransferTag is the username a user can be paid by instead of their account number.
+// Tags are stored in lower case, so they are unique regardless of case.
+type TransferTag struct {
+	UserID        int64  `json:"-"`
+	Tag           string `json:"tag"`
+	AccountNumber string `json:"accountNumber"`
+	// AccountName is the name of the user the tag belongs to. It is only read, never
+	// written.
+	AccountName string `json:"accountName"`
+}
+
+// TransferTagRequest is a tag a user wants to claim.
+type TransferTagRequest struct {
+	Tag string `json:"tag"`
+}
+
+// TagRX matches a well-formed transfer tag: a letter followed by 2 to 19 letters,
+// digits or underscores.
+var TagRX = regexp.MustCompile("^[a-z][a-z0-9_]{2,19}$")
+
+// reservedTags can't be claimed, because they could pass for the bank itself.
+var reservedTags = map[string]bool{
+	"admin": true, "administrator": true, "root": true, "system": true, "support": true,
+	"help": true, "helpdesk": true, "customercare": true, "official": true, "security": true,
+	"bank": true, "spectrum": true, "spectrumpay": true, "api": true, "null": true,
+	"undefined": true, "me": true, "settings": true, "compliance": true, "fraud": true,
+}
+
+// blockedWords can't appear anywhere in a tag.
+var blockedWords = []string{
+	"fuck", "shit", "bitch", "cunt", "bastard", "whore", "slut", "wank", "twat", "nigg",
+}
+
+// NormalizeTransferTag returns tag as it is stored: trimmed, without a leading @,
+// and in lower case.
+func NormalizeTransferTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "@"))
+}
+
+// ValidateTransferTag checks a normalised tag.
+func ValidateTransferTag(v *validator.Validator, tag string) {
+	v.Check(tag != "", "tag", "must be provided")
+	v.Check(validator.Matches(tag, TagRX), "tag", "must be 3 to 20 letters, digits or underscores, starting with a letter")
+	v.Check(!reservedTags[tag], "tag", "is reserved")
+	blocked := false
+	for _, word := range blockedWords {
+		blocked = blocked || strings.Contains(tag, word)
+	}
+	v.Check(!blocked, "tag", "is not allowed")
+}
+
+// TransferTagModel wraps the transfer_tag table.
+type TransferTagModel struct {
+	DB *DB
+}
+
+// Claim gives the user the tag, for their account, replacing any tag they already
+// have. The user_id row is locked while it is checked, so that a user can only
+// change their tag once the previous change is at least cooldown old; otherwise
+// ErrTransferTagCooldown is returned. Claiming the tag the user already has changes
+// nothing. A tag that belongs to another user returns ErrDuplicateTransferTag.
+func (m TransferTagModel) Claim(ctx context.Context, tag *TransferTag, cooldown time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current string
+	var changeable bool
+	query := `SELECT transfer_tag, updated_at <= ? FROM transfer_tag WHERE user_id = ? FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, time.Now().Add(-cooldown), tag.UserID).Scan(&current, &changeable)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		query = `INSERT INTO transfer_tag (user_id, transfer_tag, account_number) VALUES (?, ?, ?)`
+		_, err = tx.ExecContext(ctx, query, tag.UserID, tag.Tag, tag.AccountNumber)
+	case err != nil:
+		return err
+	case current == tag.Tag:
+		return nil
+	case !changeable:
+		return ErrTransferTagCooldown
+	default:
+		query = `UPDATE transfer_tag SET transfer_tag = ?, account_number = ?, updated_at = NOW() WHERE user_id = ?`
+		_, err = tx.ExecContext(ctx, query, tag.Tag, tag.AccountNumber, tag.UserID)
+	}
+	if err != nil {
+		switch {
+		case isUniqueViolation(err):
+			return ErrDuplicateTransferTag
+		default:
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetByTag returns the transfer tag with the given (normalised) name.
+func (m TransferTagModel) GetByTag(ctx context.Context, tag string) (*TransferTag, error) {
+	query := `
+	SELECT t.user_id, t.transfer_tag, t.account_number, u.name
+	FROM transfer_tag t
+	INNER JOIN users u ON u.id = t.user_id
+	WHERE t.transfer_tag = ?`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	var found TransferTag
+	err := m.DB.QueryRowContext(ctx, query, tag).Scan(&found.UserID, &found.Tag, &found.AccountNumber, &found.AccountName)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &found, nil
+}
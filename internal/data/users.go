@@ -7,7 +7,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"strings"
+	"log/slog"
 	"time"
 
 	"gopkg.in/guregu/null.v4"
@@ -76,6 +76,30 @@ type LimitCounts struct {
 	IBank     int `json:"ibank"`
 }
 
+// For returns the amount paid today on the channel.
+func (c LimitCounts) For(channel string) int {
+	switch channel {
+	case ChannelBills:
+		return c.Bills
+	case ChannelUSSD:
+		return c.USSD
+	default:
+		return c.Transfers
+	}
+}
+
+// Add counts a payment of amount on the channel.
+func (c *LimitCounts) Add(channel string, amount int) {
+	switch channel {
+	case ChannelBills:
+		c.Bills += amount
+	case ChannelUSSD:
+		c.USSD += amount
+	default:
+		c.Transfers += amount
+	}
+}
+
 type UserDetailsWithPIN struct {
 	UserID        string `json:"userID"`
 	PIN           string `json:"-"`
@@ -128,7 +152,7 @@ type password struct {
 
 // Create a UserModel struct which wraps the connection pool.
 type UserModel struct {
-	DB *sql.DB
+	DB *DB
 }
 
 // The Set() method calculates the bcrypt hash of a plaintext password, and stores both
@@ -166,11 +190,17 @@ func HashPassword(plaintextPassword string) ([]byte, error) {
 
 }
 
+// SetHash replaces the password with an already computed bcrypt hash, such as one
+// from HashPassword.
+func (p *password) SetHash(hash []byte) {
+	p.plaintext = nil
+	p.hash = hash
+}
+
 // The Matches() method checks whether the provided plaintext password matches the
 // hashed password stored in the struct, returning true if it matches and false
 // otherwise.
 func (p *password) Matches(plaintextPassword string) (bool, error) {
-	fmt.Println("GOTTT TO Passsword Match area")
 	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
 	if err != nil {
 		switch {
@@ -234,7 +264,7 @@ func ValidateUser(v *validator.Validator, user *User) {
 	// provided by the client. So rather than adding an error to the validation map we
 	// raise a panic instead.
 	if user.Password.hash == nil {
-		fmt.Println("missing password hash for user")
+		slog.Error("missing password hash for user", "user_id", user.ID)
 	}
 }
 func ValidateSetPinData(v *validator.Validator, user *SetPinData) {
@@ -255,108 +285,88 @@ func ValidateUpdatePinData(v *validator.Validator, user *UpdatePinData) {
 	}
 
 }
-func (m UserModel) Insert(user *User) error {
+func (m UserModel) Insert(ctx context.Context, user *User) error {
 
 	insertQuery := `
 	INSERT INTO users (name,username, email, password, activated, device_id, device_os, device_name)
 	VALUES (?, ?, ? ,?, ?, ?, ?, ?)`
 	args := []interface{}{user.Name, user.Username, user.Email, user.Password.hash, user.Activated, user.UserDevice.DeviceID, user.UserDevice.DeviceOS, user.UserDevice.DeviceName}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
 
-	// Execute the INSERT query
-	result, err := m.DB.ExecContext(ctx, insertQuery, args...)
+	// Execute the INSERT query and retrieve the new user's ID
+	id, err := insertID(ctx, m.DB, insertQuery, args...)
 	if err != nil {
 		switch {
-		case strings.Contains(err.Error(), "Duplicate entry"):
+		case isUniqueViolation(err):
 			return ErrDuplicateEmailOrUsername
 		default:
 			return err
 		}
 	}
-
-	// Retrieve the last inserted ID
-	lastInsertID, err := result.LastInsertId()
-	if err != nil {
-		return err
-	}
-	user.ID = lastInsertID
+	user.ID = id
 
 	return nil
 }
 
-func (m UserModel) Insert_Existing_Account_Holder(user *User) error {
+func (m UserModel) Insert_Existing_Account_Holder(ctx context.Context, user *User) error {
 	var is_spectrum_extra bool = true
 	var status string = "existing"
 	insertQuery := `
 	INSERT INTO users (name,username, email, password,status, activated, device_id, device_os, device_name, is_spectrum_extra)
 	VALUES (?, ?, ? ,?, ?, ?, ?, ?, ?, ?)`
 	args := []interface{}{user.Name, user.Username, user.Email, user.Password.hash, status, user.Activated, user.UserDevice.DeviceID, user.UserDevice.DeviceOS, user.UserDevice.DeviceName, is_spectrum_extra}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
 
-	// Execute the INSERT query
-	result, err := m.DB.ExecContext(ctx, insertQuery, args...)
+	// Execute the INSERT query and retrieve the new user's ID
+	id, err := insertID(ctx, m.DB, insertQuery, args...)
 	if err != nil {
 		switch {
-		case strings.Contains(err.Error(), "Duplicate entry"):
+		case isUniqueViolation(err):
 			return ErrDuplicateEmailOrUsername
 		default:
 			return err
 		}
 	}
-
-	// Retrieve the last inserted ID
-	lastInsertID, err := result.LastInsertId()
-	if err != nil {
-		return err
-	}
-	user.ID = lastInsertID
+	user.ID = id
 
 	return nil
 }
 
-func (m UserModel) UpdateUserDevice(Email, DeviceID, DeviceName, DeviceOS string) error {
+func (m UserModel) UpdateUserDevice(ctx context.Context, Email, DeviceID, DeviceName, DeviceOS string) error {
 	query := `
 	UPDATE users
 	SET device_id  = ?, device_os  = ?, device_name  = ?
 	WHERE email = ?
 	`
-	fmt.Println("Users Output", Email)
 	args := []interface{}{DeviceID, DeviceOS, DeviceName, Email}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
 
-	// Execute the INSERT query
-	result, err := m.DB.ExecContext(ctx, query, args...)
+	_, err := m.DB.ExecContext(ctx, query, args...)
 	if err != nil {
 		switch {
-		case strings.Contains(err.Error(), "Duplicate entry"):
+		case isUniqueViolation(err):
 			return ErrDuplicateEmailOrUsername
 		default:
 			return err
 		}
 	}
 
-	// Retrieve the last inserted ID
-	_, err = result.LastInsertId()
-	if err != nil {
-		return err
-	}
-
 	return nil
 }
 
 // Retrieve the User details from the database based on the user's email address.
 // Because we have a UNIQUE constraint on the email column, this SQL query will only
 // return one record (or none at all, in which case we return a ErrRecordNotFound error).
-func (m UserModel) GetByEmail(email string) (*User, error) {
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
 	SELECT id, name, username, email, activated,password, created_at, version
 	FROM users
 	WHERE email = ?`
 	var user User
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
 	err := m.DB.QueryRowContext(ctx, query, email).Scan(
 		&user.ID,
@@ -382,13 +392,13 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 // Retrieve the User details from the database based on the user's phone number .
 // Because we have a UNIQUE constraint on the phonenumer column, this SQL query will only
 // return one record (or none at all, in which case we return a ErrRecordNotFound error).
-func (m UserModel) GetByPhoneNumber(phoneNumber string) (*User, error) {
+func (m UserModel) GetByPhoneNumber(ctx context.Context, phoneNumber string) (*User, error) {
 	query := `
 	SELECT id, name, username, email, activated,password, created_at, version
 	FROM users
 	WHERE phone_number = ?`
 	var user User
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
 	err := m.DB.QueryRowContext(ctx, query, phoneNumber).Scan(
 		&user.ID,
@@ -411,7 +421,7 @@ func (m UserModel) GetByPhoneNumber(phoneNumber string) (*User, error) {
 	return &user, nil
 }
 
-func (m UserModel) GetUserIdByToken(tokenScope, tokenPlaintext string) (int64, error) {
+func (m UserModel) GetUserIdByToken(ctx context.Context, tokenScope, tokenPlaintext string) (int64, error) {
 	// Calculate the SHA-256 hash of the plaintext token provided by the client.
 	// Remember that this returns a byte *array* with length 32, not a slice.
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
@@ -429,7 +439,7 @@ func (m UserModel) GetUserIdByToken(tokenScope, tokenPlaintext string) (int64, e
 	// value to check against the token expiry.
 	args := []interface{}{tokenHash[:], tokenScope, time.Now()}
 	var ID int64
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
 	// Execute the query, scanning the return values into a User struct. If no matching
 	// record is found we return an ErrRecordNotFound error.
@@ -448,7 +458,7 @@ func (m UserModel) GetUserIdByToken(tokenScope, tokenPlaintext string) (int64, e
 	return ID, nil
 }
 
-func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+func (m UserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
 	// Calculate the SHA-256 hash of the plaintext token provided by the client.
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 
@@ -463,7 +473,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 	AND tokens.expiry > NOW()`
 
 	// Create a context with a timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
 
 	// Execute the query.
@@ -495,7 +505,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 	return &user, nil
 }
 
-func (m UserModel) GetUserDetailsFromToken(tokenScope, tokenPlaintext string) (*UserDetailsForLimits, error) {
+func (m UserModel) GetUserDetailsFromToken(ctx context.Context, tokenScope, tokenPlaintext string) (*UserDetailsForLimits, error) {
 	// Calculate the SHA-256 hash of the plaintext token provided by the client.
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 
@@ -509,19 +519,37 @@ func (m UserModel) GetUserDetailsFromToken(tokenScope, tokenPlaintext string) (*
     AND tokens.scope = ?
     AND tokens.expiry > NOW()`
 
-	// Create a slice containing the query arguments.
-	args := []interface{}{tokenHash[:], tokenScope}
-	var user UserDetailsForLimits
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
 
-	// Execute the query, scanning the return values into a User struct.
-	var limitsJSON, limitCount string // Assuming limitsJSON is retrieved from the database
+	return scanUserDetails(m.DB.QueryRowContext(ctx, query, tokenHash[:], tokenScope))
+}
+
+// GetUserDetailsByUserID returns the same details as GetUserDetailsFromToken for a
+// user given by ID, for payments made on the user's behalf without a request of
+// theirs, such as scheduled payments.
+func (m UserModel) GetUserDetailsByUserID(ctx context.Context, userID int64) (*UserDetailsForLimits, error) {
+	query := `
+    SELECT user_id, account_number, limits, counter, transaction_pin, balance
+    FROM user_details
+    WHERE user_id = ?`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	return scanUserDetails(m.DB.QueryRowContext(ctx, query, userID))
+}
+
+// scanUserDetails scans a row of user_id, account_number, limits, counter,
+// transaction_pin and balance from user_details.
+func scanUserDetails(row *sql.Row) (*UserDetailsForLimits, error) {
+	var user UserDetailsForLimits
+	var limitsJSON, limitCount string
 	var transactionPIN sql.NullString
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+	err := row.Scan(
 		&user.UserID,
 		&user.AccountNumber,
-		&limitsJSON, // Updated to scan limitsJSON
+		&limitsJSON,
 		&limitCount,
 		&transactionPIN,
 		&user.Balance,
@@ -545,8 +573,9 @@ func (m UserModel) GetUserDetailsFromToken(tokenScope, tokenPlaintext string) (*
 	if err != nil {
 		return nil, err
 	}
+	// The hashed transaction PIN, empty if the user hasn't set one.
+	user.PIN = transactionPIN.String
 
-	// Return the matching user.
 	return &user, nil
 }
 
@@ -561,7 +590,7 @@ Miscellaneous
 // Retrieve the User details from the database based on the user's email address and device ID.
 // Because we have a UNIQUE constraint on the email column, this SQL query will only
 // return one record (or none at all, in which case we return a ErrRecordNotFound error).
-func (m UserModel) GetByEmailAndDeviceID(email string, deviceID, deviceName, deviceOS string) (*User, error) {
+func (m UserModel) GetByEmailAndDeviceID(ctx context.Context, email string, deviceID, deviceName, deviceOS string) (*User, error) {
 	var phoneNumber sql.NullString
 	query := `
     SELECT id, name, username, email, status, activated, password, created_at, phone_number, source, device_id, device_name,version, device_os, address_verified, bvn_verified, account_upgraded, kyc_level, is_spectrum_extra
@@ -570,7 +599,7 @@ func (m UserModel) GetByEmailAndDeviceID(email string, deviceID, deviceName, dev
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
 	err := m.DB.QueryRowContext(ctx, query, email, email).Scan(
 		&user.ID,
@@ -605,26 +634,33 @@ func (m UserModel) GetByEmailAndDeviceID(email string, deviceID, deviceName, dev
 		user.PhoneNumber = phoneNumber.String
 	}
 
+	return CheckSignIn(&user, deviceID, deviceName, deviceOS)
+}
+
+// CheckSignIn applies the sign-in rules to a user found by GetByEmailAndDeviceID:
+// the user must come from this app, have verified their account and BVN, and be
+// signing in from the device they registered. Apart from an unactivated account,
+// the user is returned alongside the error.
+func CheckSignIn(user *User, deviceID, deviceName, deviceOS string) (*User, error) {
 	//Existing Users from old Spectrumpay app
 	//TODO: Add source for spectrumextra on registration
 
 	if !user.Is_spectrum_extra.Bool {
 
-		return &user, ErrDifferentSource
+		return user, ErrDifferentSource
 	}
 	if user.Status == "existing" {
-		fmt.Println("Existing User error")
-		return &user, ErrExistingAccountHolder
+		return user, ErrExistingAccountHolder
 	}
 	//device validation
 	if user.DeviceID != deviceID {
-		return &user, ErrDeviceIDNotFound
+		return user, ErrDeviceIDNotFound
 	}
 	if user.DeviceName != deviceName {
-		return &user, ErrDeviceIDNotFound
+		return user, ErrDeviceIDNotFound
 	}
 	if user.DeviceOS != deviceOS {
-		return &user, ErrDeviceIDNotFound
+		return user, ErrDeviceIDNotFound
 	}
 
 	//KYC levels
@@ -632,7 +668,7 @@ func (m UserModel) GetByEmailAndDeviceID(email string, deviceID, deviceName, dev
 	// 	return nil, ErrKYCAddressNotVerified
 	// }
 	if user.BVN_verified.Valid && !user.BVN_verified.Bool {
-		return &user, ErrKYCBVNNotVerified
+		return user, ErrKYCBVNNotVerified
 	}
 	// if !user.Account_upgraded {
 	// 	return nil, ErrKYCAccountUpgraded
@@ -642,10 +678,10 @@ func (m UserModel) GetByEmailAndDeviceID(email string, deviceID, deviceName, dev
 		return nil, ErrKYCStatusNotVerified
 	}
 
-	return &user, nil
+	return user, nil
 }
 
-func (m UserModel) UpdatePassword(password *ResetPassword) error {
+func (m UserModel) UpdatePassword(ctx context.Context, password *ResetPassword) error {
 
 	query := `
 	UPDATE users
@@ -656,7 +692,7 @@ func (m UserModel) UpdatePassword(password *ResetPassword) error {
 		password.Hash,
 		password.Email,
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, args...)
 
@@ -664,7 +700,7 @@ func (m UserModel) UpdatePassword(password *ResetPassword) error {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+		case isUniqueViolation(err):
 			return ErrDuplicateEmailOrUsername
 		default:
 			return err
@@ -679,12 +715,11 @@ func (m UserModel) UpdatePassword(password *ResetPassword) error {
 // when updating a movie. And we also check for a violation of the "users_email_key"
 // constraint when performing the update, just like we did when inserting the user
 // record originally.
-func (m UserModel) Update(user *User) error {
+func (m UserModel) Update(ctx context.Context, user *User) error {
 	query := `
 	UPDATE users
 	SET name = ?, username = ?,email = ?, password = ?, activated = ?, version = version + 1
-	WHERE id = ? AND version = ?
-	RETURNING version`
+	WHERE id = ? AND version = ?`
 	args := []interface{}{
 		user.Name,
 		user.Username,
@@ -694,12 +729,12 @@ func (m UserModel) Update(user *User) error {
 		user.ID,
 		user.Version,
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+	err := updateVersion(ctx, m.DB, &user.Version, query, args...)
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+		case isUniqueViolation(err):
 			return ErrDuplicateEmailOrUsername
 
 		case errors.Is(err, sql.ErrNoRows):
@@ -711,7 +746,7 @@ func (m UserModel) Update(user *User) error {
 	return nil
 }
 
-func (m UserModel) GetUserDetailsAndPINFromToken(tokenScope, tokenPlaintext string) (*UserDetailsWithPIN, error) {
+func (m UserModel) GetUserDetailsAndPINFromToken(ctx context.Context, tokenScope, tokenPlaintext string) (*UserDetailsWithPIN, error) {
 	// Calculate the SHA-256 hash of the plaintext token provided by the client.
 	// Remember that this returns a byte *array* with length 32, not a slice.
 
@@ -731,7 +766,7 @@ func (m UserModel) GetUserDetailsAndPINFromToken(tokenScope, tokenPlaintext stri
 	// Create a slice containing the query arguments.
 	args := []interface{}{tokenHash[:], tokenScope}
 	var user UserDetailsWithPIN
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
 
 	// Execute the query, scanning the return values into a User struct.
@@ -754,11 +789,11 @@ func (m UserModel) GetUserDetailsAndPINFromToken(tokenScope, tokenPlaintext stri
 	return &user, nil
 }
 
-func (m UserModel) GetUserByUserID(UserId string) (*User, error) {
+func (m UserModel) GetUserByUserID(ctx context.Context, UserId string) (*User, error) {
 
 	// Set up the SQL query.
 	query := `
-	SELECT users.id, users.created_at, users.name, users.username, users.email, users.password	, users.activated, users.version
+	SELECT users.id, users.created_at, users.name, users.username, users.email, users.password	, users.phone_number, users.activated, users.version
 	FROM users WHERE id = ?`
 
 	// Create a slice containing the query arguments. Notice how we use the [:] operator
@@ -767,7 +802,8 @@ func (m UserModel) GetUserByUserID(UserId string) (*User, error) {
 	// value to check against the token expiry.
 	args := []interface{}{UserId}
 	var user User
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	var phoneNumber sql.NullString
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
 	// Execute the query, scanning the return values into a User struct. If no matching
 	// record is found we return an ErrRecordNotFound error.
@@ -778,6 +814,7 @@ func (m UserModel) GetUserByUserID(UserId string) (*User, error) {
 		&user.Username,
 		&user.Email,
 		&user.Password.hash,
+		&phoneNumber,
 		&user.Activated,
 		&user.Version,
 	)
@@ -789,6 +826,7 @@ func (m UserModel) GetUserByUserID(UserId string) (*User, error) {
 			return nil, err
 		}
 	}
+	user.PhoneNumber = phoneNumber.String
 	// Return the matching user.
 	return &user, nil
 }
@@ -796,14 +834,13 @@ func (m UserModel) GetUserByUserID(UserId string) (*User, error) {
 // Retrieve the User details from the database based on the user's id.
 // Because we have a UNIQUE constraint on the email column, this SQL query will only
 // return one record (or none at all, in which case we return a ErrRecordNotFound error).
-func (m UserModel) GetAccountNoByID(id string) (string, error) {
-	fmt.Println("From GetAccountNoByID(id string) ID is", id)
+func (m UserModel) GetAccountNoByID(ctx context.Context, id string) (string, error) {
 	query := `
 	SELECT account_number
 	FROM user_details
 	WHERE user_id = ?`
 	var user UserDetails
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
 	err := m.DB.QueryRowContext(ctx, query, id).Scan(
 		&user.AccountNumber,
@@ -824,27 +861,22 @@ func (m UserModel) GetAccountNoByID(id string) (string, error) {
 // when updating a movie. And we also check for a violation of the "users_email_key"
 // constraint when performing the update, just like we did when inserting the user
 // record originally.
-func (m UserModel) UpdateActivated(user *User) error {
+func (m UserModel) UpdateActivated(ctx context.Context, user *User) error {
 	query := `
 	UPDATE users
 	SET activated = ?, version = version + 1
-	WHERE id = ? AND version = ?
-	RETURNING version`
+	WHERE id = ? AND version = ?`
 	args := []interface{}{
-		user.Name,
-		user.Username,
-		user.Email,
-		user.Password.hash,
 		user.Activated,
 		user.ID,
 		user.Version,
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+	err := updateVersion(ctx, m.DB, &user.Version, query, args...)
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+		case isUniqueViolation(err):
 			return ErrDuplicateEmailOrUsername
 
 		case errors.Is(err, sql.ErrNoRows):
@@ -856,7 +888,7 @@ func (m UserModel) UpdateActivated(user *User) error {
 	return nil
 }
 
-func (m UserModel) activateUser(user *User) error {
+func (m UserModel) activateUser(ctx context.Context, user *User) error {
 	// Update the user's activated column in the database
 	query := `
 	UPDATE users SET activated = true , version = version + 1 WHERE id = ? AND version = ?
@@ -866,43 +898,43 @@ func (m UserModel) activateUser(user *User) error {
 		user.ID,
 		user.Version,
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, args...)
 	return err
 }
 
 // updateExistingAccountNoUserStatus updates the status from exixting to active neccessary for login-Do this after Verification
-func (m UserModel) updateExistingAccountNoUserStatus(user *User) error {
+func (m UserModel) updateExistingAccountNoUserStatus(ctx context.Context, user *User) error {
 	// Update the user's activated column in the database
 	query := `
-	UPDATE users SET status = "active" , version = version + 1 WHERE id = ? AND version = ?
+	UPDATE users SET status = 'active', version = version + 1 WHERE id = ? AND version = ?
 	`
 	args := []interface{}{
 
 		user.ID,
 		user.Version,
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, args...)
 	return err
 }
 
-func (m UserModel) UpdateBvnVerified(user *User) error {
+func (m UserModel) UpdateBvnVerified(ctx context.Context, user *User) error {
 	query := `
 	UPDATE users
 	SET bvn_verified = ?
 	WHERE id = ? 
 	`
-	verified := 1
+	verified := true
 	args := []interface{}{verified, user.ID}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, args...)
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+		case isUniqueViolation(err):
 			return ErrDuplicateEmailOrUsername
 
 		case errors.Is(err, sql.ErrNoRows):
@@ -915,21 +947,21 @@ func (m UserModel) UpdateBvnVerified(user *User) error {
 }
 
 // UpdateStatus_PhoneNumberVerified
-func (m UserModel) UpdateStatus_PhoneNumberVerified(user *User) error {
+func (m UserModel) UpdateStatus_PhoneNumberVerified(ctx context.Context, user *User) error {
 	var status string = "active"
 	query := `
 	UPDATE users
 	SET phone_number_verified = ?, status = ?
 	WHERE id = ? 
 	`
-	verified := 1
+	verified := true
 	args := []interface{}{verified, status, user.ID}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, args...)
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+		case isUniqueViolation(err):
 			return ErrDuplicateEmailOrUsername
 
 		case errors.Is(err, sql.ErrNoRows):
@@ -940,20 +972,20 @@ func (m UserModel) UpdateStatus_PhoneNumberVerified(user *User) error {
 	}
 	return nil
 }
-func (m UserModel) UpdatePhoneNumberVerified(user *User) error {
+func (m UserModel) UpdatePhoneNumberVerified(ctx context.Context, user *User) error {
 	query := `
 	UPDATE users
 	SET phone_number_verified = ? , activated = ?
 	WHERE id = ? 
 	`
-	verified := 1
+	verified := true
 	args := []interface{}{verified, verified, user.ID}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, args...)
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+		case isUniqueViolation(err):
 			return ErrDuplicateEmailOrUsername
 
 		case errors.Is(err, sql.ErrNoRows):
@@ -965,21 +997,20 @@ func (m UserModel) UpdatePhoneNumberVerified(user *User) error {
 	return nil
 }
 
-func (m UserModel) UpdateEmailVerified(user *User) error {
+func (m UserModel) UpdateEmailVerified(ctx context.Context, user *User) error {
 	query := `
 	UPDATE users
 	SET email_verified = ? , activated = ?
 	WHERE id = ? 
 	`
-	verified := 1
+	verified := true
 	args := []interface{}{verified, verified, user.ID}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
-	re, err := m.DB.ExecContext(ctx, query, args...)
-	fmt.Println("Result, err :::", re, err)
+	_, err := m.DB.ExecContext(ctx, query, args...)
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+		case isUniqueViolation(err):
 			return ErrDuplicateEmailOrUsername
 
 		case errors.Is(err, sql.ErrNoRows):
@@ -991,20 +1022,20 @@ func (m UserModel) UpdateEmailVerified(user *User) error {
 	return nil
 }
 
-func (m UserModel) UpdateEmailVerified_for_Spectrumpay_Users(user *User) error {
+func (m UserModel) UpdateEmailVerified_for_Spectrumpay_Users(ctx context.Context, user *User) error {
 	query := `
 	UPDATE users
 	SET email_verified = ?, is_spectrum_extra = ?
 	WHERE id = ? 
 	`
-	verified := 1
+	verified := true
 	args := []interface{}{verified, verified, user.ID}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, args...)
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+		case isUniqueViolation(err):
 			return ErrDuplicateEmailOrUsername
 
 		case errors.Is(err, sql.ErrNoRows):
@@ -1017,20 +1048,20 @@ func (m UserModel) UpdateEmailVerified_for_Spectrumpay_Users(user *User) error {
 	return nil
 }
 
-func (m UserModel) SetFirstTimePIN(user *UserDetails) error {
+func (m UserModel) SetFirstTimePIN(ctx context.Context, user *UserDetails) error {
 	query := `
 	UPDATE user_details
-	SET transaction_pin = ?
+	SET transaction_pin = ?, ussd_pin_failures = 0
 	WHERE user_id = ? 
 	`
 
 	args := []interface{}{user.PIN, user.UserID}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, args...)
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_id_key"`:
+		case isUniqueViolation(err):
 			return ErrDuplicateEmailOrUsername
 
 		case errors.Is(err, sql.ErrNoRows):
@@ -1043,7 +1074,7 @@ func (m UserModel) SetFirstTimePIN(user *UserDetails) error {
 }
 
 // this function updats the kyc level of a user based on the kyc level provided
-func (m UserModel) UpdateKycLevel(user *User, level string) error {
+func (m UserModel) UpdateKycLevel(ctx context.Context, user *User, level string) error {
 	query := `
 	UPDATE users
 	SET kyc_level = ?
@@ -1051,12 +1082,12 @@ func (m UserModel) UpdateKycLevel(user *User, level string) error {
 	`
 
 	args := []interface{}{level, user.ID}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, args...)
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+		case isUniqueViolation(err):
 			return ErrDuplicateEmailOrUsername
 
 		case errors.Is(err, sql.ErrNoRows):
@@ -1068,14 +1099,36 @@ func (m UserModel) UpdateKycLevel(user *User, level string) error {
 	return nil
 }
 
-func (m UserModel) UpdateActivatedIfVerified(user *User) error {
+// UpdateAccountUpgraded marks a user's account as upgraded once their upgrade
+// documents have been approved. The utility bill doubles as proof of address, so the
+// address is marked as verified at the same time.
+func (m UserModel) UpdateAccountUpgraded(ctx context.Context, user *User) error {
+	query := `
+	UPDATE users
+	SET account_upgraded = ?, address_verified = ?
+	WHERE id = ?
+	`
+	verified := true
+	args := []interface{}{verified, verified, user.ID}
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	user.Account_upgraded = null.BoolFrom(true)
+	user.Address_verified = null.BoolFrom(true)
+	return nil
+}
+
+func (m UserModel) UpdateActivatedIfVerified(ctx context.Context, user *User) error {
 	query := `
 		UPDATE users
-		SET activated = 1
-		WHERE id = ? AND email_verified = 1 OR phone_number_verified = 1
+		SET activated = true
+		WHERE id = ? AND (email_verified = true OR phone_number_verified = true)
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, user.ID)
@@ -1089,63 +1142,90 @@ func (m UserModel) UpdateActivatedIfVerified(user *User) error {
 	}
 	return nil
 }
-func (m UserModel) UpdateTransactionPin(data *SetPinData) error {
+func (m UserModel) UpdateTransactionPin(ctx context.Context, data *SetPinData) error {
 	query := `
 	UPDATE user_details
-	SET transaction_pin = ?
+	SET transaction_pin = ?, ussd_pin_failures = 0
 	WHERE user_id = ?
 	`
-	args := []interface{}{
-		&data.PIN,
-		&data.UserID,
-	}
-	fmt.Println("Waht args shows", args)
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
-	re, err := m.DB.ExecContext(ctx, query, data.PIN, data.UserID)
+	_, err := m.DB.ExecContext(ctx, query, data.PIN, data.UserID)
 
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+		case isUniqueViolation(err):
 			return ErrDuplicateEmailOrUsername
 		default:
 			return err
 		}
 	}
-	ire, _ := re.LastInsertId()
-	fmt.Println("Result", ire)
 	return nil
 }
-func (m UserModel) UpdateTransactionPin2(pin, userid string) error {
+func (m UserModel) UpdateTransactionPin2(ctx context.Context, pin, userid string) error {
 	query := `
 	UPDATE user_details
-	SET transaction_pin = ?
+	SET transaction_pin = ?, ussd_pin_failures = 0
 	WHERE user_id = ?
 	`
 
-	fmt.Println("Waht args shows", pin, userid)
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
-	re, err := m.DB.ExecContext(ctx, query, pin, userid)
+	_, err := m.DB.ExecContext(ctx, query, pin, userid)
 
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+		case isUniqueViolation(err):
 			return ErrDuplicateEmailOrUsername
 		default:
 			return err
 		}
 	}
-	ire, _ := re.LastInsertId()
-	fmt.Println("Result", ire)
 	return nil
 }
 
-func (m UserModel) IsAuthTokenForUserID(tokenPlaintext, UserID string) bool {
+// ClaimUSSDPINAttempt counts a transaction PIN check by USSD against the user's
+// attempts before it is made, so that concurrent sessions can't get more than max
+// guesses between them. Once max checks in a row have failed it returns
+// ErrUSSDLocked, until ResetUSSDPINAttempts is called or the PIN is changed.
+func (m UserModel) ClaimUSSDPINAttempt(ctx context.Context, userID int64, max int) error {
+	query := `
+	UPDATE user_details
+	SET ussd_pin_failures = ussd_pin_failures + 1
+	WHERE user_id = ? AND ussd_pin_failures < ?`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, max)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUSSDLocked
+	}
+	return nil
+}
+
+// ResetUSSDPINAttempts clears the attempts counted by ClaimUSSDPINAttempt once a PIN
+// check has passed.
+func (m UserModel) ResetUSSDPINAttempts(ctx context.Context, userID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `UPDATE user_details SET ussd_pin_failures = 0 WHERE user_id = ?`, userID)
+	return err
+}
+
+func (m UserModel) IsAuthTokenForUserID(ctx context.Context, tokenPlaintext, UserID string) bool {
 	// Calculate the SHA-256 hash of the plaintext token provided by the client.
 	// Remember that this returns a byte *array* with length 32, not a slice.
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
@@ -1164,7 +1244,7 @@ func (m UserModel) IsAuthTokenForUserID(tokenPlaintext, UserID string) bool {
 	// value to check against the token expiry.
 	args := []interface{}{tokenHash[:], time.Now()}
 	var user User
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
 	defer cancel()
 	// Execute the query, scanning the return values into a User struct. If no matching
 	// record is found we return an ErrRecordNotFound error.
@@ -1179,8 +1259,7 @@ func (m UserModel) IsAuthTokenForUserID(tokenPlaintext, UserID string) bool {
 		&user.Version,
 	)
 	if err != nil {
-		//Handle Error Here
-		fmt.Println(err)
+		slog.Error("token lookup failed", "error", err)
 	}
 	if fmt.Sprint(user.ID) == UserID {
 		return true
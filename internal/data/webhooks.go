@@ -0,0 +1,413 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Webhook event types partners can subscribe to.
+const (
+	EventTransactionCompleted = "transaction.completed"
+	EventTransactionFailed    = "transaction.failed"
+	EventAccountCreated       = "account.created"
+	EventKYCUpgraded          = "kyc.upgraded"
+)
+
+// WebhookEvents lists every event type, for validating subscriptions.
+var WebhookEvents = []string{
+	EventTransactionCompleted,
+	EventTransactionFailed,
+	EventAccountCreated,
+	EventKYCUpgraded,
+}
+
+// Webhook delivery states, in addition to Pending.
+const (
+	WebhookDelivered = "delivered"
+	WebhookFailed    = "failed"
+)
+
+// WebhookEvent is the body posted to partner endpoints. The ID is stable across
+// retries and replays, so receivers can use it to discard duplicates.
+type WebhookEvent struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	UserID    int64           `json:"user_id"`
+	CreatedAt string          `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// WebhookEndpoint is a URL registered by an API client to receive events. The secret
+// is only returned when the endpoint is created.
+type WebhookEndpoint struct {
+	ID        int64    `json:"id"`
+	UserID    int64    `json:"user_id"`
+	URL       string   `json:"url"`
+	Secret    string   `json:"secret,omitempty"`
+	Events    []string `json:"events"`
+	CreatedAt *string  `json:"created_at"`
+}
+
+// Subscribed reports whether the endpoint receives events of the given type.
+func (e *WebhookEndpoint) Subscribed(eventType string) bool {
+	for _, event := range e.Events {
+		if event == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is the log of sending one event to one endpoint.
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	EndpointID     int64           `json:"endpoint_id"`
+	EventID        string          `json:"event_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus int             `json:"response_status"`
+	LastError      string          `json:"last_error"`
+	CreatedAt      *string         `json:"created_at"`
+	DeliveredAt    *string         `json:"delivered_at"`
+}
+
+// WebhookDeliveryReference is the outbox payload for sending a single delivery.
+type WebhookDeliveryReference struct {
+	DeliveryID int64 `json:"delivery_id"`
+}
+
+// NewWebhookEventMessage wraps a new event, with payload as its data, in an outbox
+// message. The outbox worker later fans it out to every endpoint the user has
+// subscribed.
+func NewWebhookEventMessage(userID int64, eventType string, payload interface{}) (*OutboxMessage, error) {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 12)
+	_, err = rand.Read(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewOutboxMessage(OutboxWebhookEvent, WebhookEvent{
+		ID:        "evt_" + hex.EncodeToString(b),
+		Type:      eventType,
+		UserID:    userID,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		Data:      js,
+	})
+}
+
+// insertWebhookEvent queues an event in the outbox using exec, which is the *Tx
+// of the change the event describes.
+func insertWebhookEvent(ctx context.Context, exec execer, userID int64, eventType string, payload interface{}) error {
+	msg, err := NewWebhookEventMessage(userID, eventType, payload)
+	if err != nil {
+		return err
+	}
+	return insertOutboxMessages(ctx, exec, msg)
+}
+
+// WebhookModel wraps the webhook_endpoints and webhook_deliveries tables.
+type WebhookModel struct {
+	DB *DB
+}
+
+// InsertEndpoint registers a new endpoint.
+func (m WebhookModel) InsertEndpoint(ctx context.Context, endpoint *WebhookEndpoint) error {
+	query := `
+	INSERT INTO webhook_endpoints (user_id, url, secret, events)
+	VALUES (?, ?, ?, ?)`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	var err error
+	endpoint.ID, err = insertID(ctx, m.DB, query, endpoint.UserID, endpoint.URL, endpoint.Secret, strings.Join(endpoint.Events, ","))
+	return err
+}
+
+// scanWebhookEndpoint scans a row of id, user_id, url, secret, events, created_at.
+func scanWebhookEndpoint(row interface{ Scan(...interface{}) error }) (*WebhookEndpoint, error) {
+	var endpoint WebhookEndpoint
+	var events string
+	err := row.Scan(&endpoint.ID, &endpoint.UserID, &endpoint.URL, &endpoint.Secret, &events, &endpoint.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	endpoint.Events = strings.Split(events, ",")
+	return &endpoint, nil
+}
+
+// GetEndpoint returns a single endpoint, including its secret.
+func (m WebhookModel) GetEndpoint(ctx context.Context, id int64) (*WebhookEndpoint, error) {
+	query := `SELECT id, user_id, url, secret, events, created_at FROM webhook_endpoints WHERE id = ?`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	endpoint, err := scanWebhookEndpoint(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return endpoint, nil
+}
+
+// GetEndpointsForUser returns every endpoint registered by the user, including their
+// secrets.
+func (m WebhookModel) GetEndpointsForUser(ctx context.Context, userID int64) ([]*WebhookEndpoint, error) {
+	query := `SELECT id, user_id, url, secret, events, created_at FROM webhook_endpoints WHERE user_id = ? ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	endpoints := []*WebhookEndpoint{}
+	for rows.Next() {
+		endpoint, err := scanWebhookEndpoint(rows)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return endpoints, nil
+}
+
+// DeleteEndpoint removes one of the user's endpoints, along with its delivery log.
+func (m WebhookModel) DeleteEndpoint(ctx context.Context, id, userID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM webhook_endpoints WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// CreateDeliveries records a pending delivery of event to each endpoint and queues
+// them for sending, all in one transaction. Each delivery remembers the outbox
+// message sending it, so a replay can tell whether it is still being retried.
+func (m WebhookModel) CreateDeliveries(ctx context.Context, event *WebhookEvent, endpoints []*WebhookEndpoint) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO webhook_deliveries (endpoint_id, event_id, event, payload, status)
+	VALUES (?, ?, ?, ?, ?)`
+
+	for _, endpoint := range endpoints {
+		id, err := insertID(ctx, tx, query, endpoint.ID, event.ID, event.Type, string(payload), Pending)
+		if err != nil {
+			return err
+		}
+
+		msg, err := NewOutboxMessage(OutboxWebhookDelivery, WebhookDeliveryReference{DeliveryID: id})
+		if err != nil {
+			return err
+		}
+		err = insertOutboxMessages(ctx, tx, msg)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE webhook_deliveries SET outbox_id = ? WHERE id = ?`, msg.ID, id)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// webhookDeliveryColumns lists the columns scanned by scanWebhookDelivery, in order.
+const webhookDeliveryColumns = `id, endpoint_id, event_id, event, payload, status, attempts, response_status, last_error, created_at, delivered_at`
+
+func scanWebhookDelivery(row interface{ Scan(...interface{}) error }) (*WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	var payload string
+	var responseStatus sql.NullInt64
+	var lastError sql.NullString
+	err := row.Scan(
+		&delivery.ID,
+		&delivery.EndpointID,
+		&delivery.EventID,
+		&delivery.Event,
+		&payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&responseStatus,
+		&lastError,
+		&delivery.CreatedAt,
+		&delivery.DeliveredAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	delivery.Payload = json.RawMessage(payload)
+	delivery.ResponseStatus = int(responseStatus.Int64)
+	delivery.LastError = lastError.String
+	return &delivery, nil
+}
+
+// GetDelivery returns a single delivery.
+func (m WebhookModel) GetDelivery(ctx context.Context, id int64) (*WebhookDelivery, error) {
+	query := `SELECT ` + webhookDeliveryColumns + ` FROM webhook_deliveries WHERE id = ?`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	delivery, err := scanWebhookDelivery(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return delivery, nil
+}
+
+// GetDeliveriesForEndpoint returns the most recent deliveries to an endpoint, newest
+// first.
+func (m WebhookModel) GetDeliveriesForEndpoint(ctx context.Context, endpointID int64, limit int) ([]*WebhookDelivery, error) {
+	query := `SELECT ` + webhookDeliveryColumns + ` FROM webhook_deliveries WHERE endpoint_id = ? ORDER BY id DESC LIMIT ?`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, endpointID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// RecordAttempt stores the outcome of one attempt to send a delivery.
+func (m WebhookModel) RecordAttempt(ctx context.Context, delivery *WebhookDelivery) error {
+	query := `
+	UPDATE webhook_deliveries
+	SET status = ?, attempts = attempts + 1, response_status = ?, last_error = ?,
+		delivered_at = CASE WHEN ? = 'delivered' THEN NOW() ELSE delivered_at END
+	WHERE id = ?`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, delivery.Status, delivery.ResponseStatus, delivery.LastError, delivery.Status, delivery.ID)
+	if err != nil {
+		return err
+	}
+	delivery.Attempts++
+	return nil
+}
+
+// ReplayDelivery puts a delivery back into the pending state and queues it to be sent
+// again with the same event ID and payload. It returns ErrEditConflict while the
+// outbox message from the last send or replay is still pending, which includes a
+// failed attempt waiting for its retry.
+func (m WebhookModel) ReplayDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var outboxID sql.NullInt64
+	err = tx.QueryRowContext(ctx, `SELECT outbox_id FROM webhook_deliveries WHERE id = ? FOR UPDATE`, delivery.ID).Scan(&outboxID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	if outboxID.Valid {
+		var status string
+		err = tx.QueryRowContext(ctx, `SELECT status FROM outbox WHERE id = ?`, outboxID.Int64).Scan(&status)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if status == OutboxPending {
+			return ErrEditConflict
+		}
+	}
+
+	msg, err := NewOutboxMessage(OutboxWebhookDelivery, WebhookDeliveryReference{DeliveryID: delivery.ID})
+	if err != nil {
+		return err
+	}
+	err = insertOutboxMessages(ctx, tx, msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE webhook_deliveries SET status = ?, outbox_id = ? WHERE id = ?`, Pending, msg.ID, delivery.ID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	delivery.Status = Pending
+	return nil
+}
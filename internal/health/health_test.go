@@ -0,0 +1,50 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestChecker(t *testing.T) {
+	c := New(50 * time.Millisecond)
+	c.Add("db", func(ctx context.Context) error { return nil })
+	c.Add("smtp", func(ctx context.Context) error { return errors.New("connection refused") })
+	c.Add("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	report := c.Run(context.Background())
+
+	if report.Ready() || report.Status != StatusDegraded {
+		t.Errorf("got status %q, want %q", report.Status, StatusDegraded)
+	}
+	want := map[string]string{"db": StatusUp, "smtp": StatusDown, "slow": StatusDown}
+	for name, status := range want {
+		if got := report.Components[name].Status; got != status {
+			t.Errorf("%s: got %q, want %q", name, got, status)
+		}
+	}
+	if report.Components["smtp"].Error != "connection refused" {
+		t.Errorf("smtp: got error %q", report.Components["smtp"].Error)
+	}
+}
+
+func TestCached(t *testing.T) {
+	calls := 0
+	check := Cached(func(ctx context.Context) error {
+		calls++
+		return nil
+	}, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		if err := check(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("check ran %d times, want 1", calls)
+	}
+}
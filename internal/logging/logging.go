@@ -0,0 +1,153 @@
+// Package logging builds the application's structured JSON logger. Every record
+// passes through a redaction layer which masks BVNs, PINs, account numbers, email
+// addresses and tokens, whether they appear as attributes or inside a message.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// New returns a JSON logger writing records at or above level to out.
+func New(out io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redactAttr,
+	}))
+}
+
+// ParseLevel converts "debug", "info", "warn" or "error" to a slog.Level.
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid log level %q", s)
+	}
+	return level, nil
+}
+
+// Masks for the different kinds of sensitive value.
+const (
+	redacted = "[REDACTED]"
+)
+
+// sensitiveKeys maps normalised attribute keys (see normaliseKey) to the function
+// that masks their values.
+var sensitiveKeys = map[string]func(string) string{
+	"bvn":            maskAll,
+	"pin":            maskAll,
+	"transactionpin": maskAll,
+	"newpin":         maskAll,
+	"otp":            maskAll,
+	"password":       maskAll,
+	"passwordhash":   maskAll,
+	"token":          func(string) string { return redacted },
+	"plaintext":      func(string) string { return redacted },
+	"authorization":  func(string) string { return redacted },
+	"secret":         func(string) string { return redacted },
+	"apikey":         func(string) string { return redacted },
+	"xapikey":        func(string) string { return redacted },
+	"clientsecret":   func(string) string { return redacted },
+	"xclientsecret":  func(string) string { return redacted },
+	"accountnumber":  MaskAccountNumber,
+	"accountno":      MaskAccountNumber,
+	"accountid":      MaskAccountNumber,
+	"email":          MaskEmail,
+}
+
+func normaliseKey(key string) string {
+	key = strings.ToLower(key)
+	key = strings.ReplaceAll(key, "_", "")
+	return strings.ReplaceAll(key, "-", "")
+}
+
+// redactAttr is the slog ReplaceAttr hook. Known sensitive keys are masked outright;
+// every other string, error or structured value is scanned with Redact.
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if mask, ok := sensitiveKeys[normaliseKey(a.Key)]; ok {
+		return slog.String(a.Key, mask(a.Value.Resolve().String()))
+	}
+
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, Redact(v.String()))
+	case slog.KindAny:
+		switch x := v.Any().(type) {
+		case error:
+			return slog.String(a.Key, Redact(x.Error()))
+		case fmt.Stringer:
+			return slog.String(a.Key, Redact(x.String()))
+		default:
+			js, err := json.Marshal(x)
+			if err != nil {
+				return slog.String(a.Key, Redact(fmt.Sprintf("%+v", x)))
+			}
+			return slog.Any(a.Key, json.RawMessage(Redact(string(js))))
+		}
+	}
+	return a
+}
+
+var (
+	// "key": "value" and "key": 123 pairs in JSON text, for the sensitive keys.
+	rxJSONPair = regexp.MustCompile(`(?i)"([a-z_\-]+)"\s*:\s*("(?:[^"\\]|\\.)*"|\d+)`)
+	// Bearer tokens in Authorization headers.
+	rxBearer = regexp.MustCompile(`(?i)(bearer\s+)[a-z0-9._\-]+`)
+	// Authentication tokens, which are 26 characters of base32.
+	rxToken = regexp.MustCompile(`\b[A-Z2-7]{26}\b`)
+	// Email addresses.
+	rxEmail = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	// 11 digits is a BVN (or phone number); 10 digits is a NUBAN account number.
+	rxDigits = regexp.MustCompile(`\b\d{10,11}\b`)
+)
+
+// Redact masks sensitive values in free-form text such as error messages, request
+// URIs and JSON bodies.
+func Redact(s string) string {
+	s = rxJSONPair.ReplaceAllStringFunc(s, func(pair string) string {
+		m := rxJSONPair.FindStringSubmatch(pair)
+		mask, ok := sensitiveKeys[normaliseKey(m[1])]
+		if !ok {
+			return pair
+		}
+		value := strings.Trim(m[2], `"`)
+		return fmt.Sprintf("%q:%q", m[1], mask(value))
+	})
+	s = rxBearer.ReplaceAllString(s, "${1}"+redacted)
+	s = rxToken.ReplaceAllString(s, redacted)
+	s = rxEmail.ReplaceAllStringFunc(s, MaskEmail)
+	s = rxDigits.ReplaceAllStringFunc(s, func(digits string) string {
+		if len(digits) == 10 {
+			return MaskAccountNumber(digits)
+		}
+		return maskAll(digits)
+	})
+	return s
+}
+
+// maskAll replaces every character with an asterisk.
+func maskAll(s string) string {
+	return strings.Repeat("*", len(s))
+}
+
+// MaskAccountNumber keeps only the last four digits of an account number.
+func MaskAccountNumber(s string) string {
+	if len(s) <= 4 {
+		return maskAll(s)
+	}
+	return strings.Repeat("*", len(s)-4) + s[len(s)-4:]
+}
+
+// MaskEmail keeps the first character of the local part and the domain.
+func MaskEmail(s string) string {
+	at := strings.LastIndex(s, "@")
+	if at < 1 {
+		return maskAll(s)
+	}
+	return s[:1] + "***" + s[at:]
+}
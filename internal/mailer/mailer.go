@@ -2,6 +2,7 @@ package mailer
 
 import (
 	"bytes"
+	"context"
 	"embed"
 	"html/template"
 	"time"
@@ -33,6 +34,26 @@ func New(host string, port int, username, password, sender string) Mailer {
 	}
 }
 
+// Ping connects and authenticates to the SMTP server, then hangs up. It is used by
+// the readiness check.
+func (m Mailer) Ping(ctx context.Context) error {
+	errc := make(chan error, 1)
+	go func() {
+		s, err := m.dialer.Dial()
+		if err == nil {
+			err = s.Close()
+		}
+		errc <- err
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // Define a Send() method on the Mailer type. This takes the recipient email address
 // as the first parameter, the name of the file containing the templates, and any
 // dynamic data for the templates as an interface{} parameter.
@@ -54,7 +75,6 @@ func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
 	// in the plainBody variable.
 	plainBody := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
-	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
 	if err != nil {
 		return err
 	}
@@ -93,5 +113,5 @@ func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
 		time.Sleep(500 * time.Millisecond)
 	}
 	//TODO 2:KEEP LOGS of failed sending do at the point of panic of defer or here that is todo 6
-	return nil
+	return err
 }
@@ -0,0 +1,148 @@
+// Package metrics defines the Prometheus metrics exported by the API, and the
+// helpers that record them.
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "fairmoney"
+
+// Registry holds every metric in this package, plus the Go runtime and process
+// collectors. It is separate from prometheus.DefaultRegisterer so that tests and
+// libraries can't register into it by accident.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// HTTPRequests counts requests served, by method, route pattern and status.
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests served, by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	// HTTPDuration observes request latency, by method and route pattern.
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency, by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	// ThirdPartyDuration observes calls to third-party APIs, by operation (the
+	// request URL path template) and outcome.
+	ThirdPartyDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "third_party_request_duration_seconds",
+		Help:      "Third-party API call latency, by operation and outcome.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"operation", "outcome"})
+
+	// Transactions counts recorded transactions, by type and status.
+	Transactions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transactions_total",
+		Help:      "Transactions recorded, by type and status.",
+	}, []string{"type", "status"})
+
+	// LimitRejections counts payments refused for exceeding a limit, by limit.
+	LimitRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "limit_rejections_total",
+		Help:      "Payments rejected for exceeding a limit, by limit.",
+	}, []string{"limit"})
+
+	// PINFailures counts transaction PIN checks that didn't match.
+	PINFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pin_failures_total",
+		Help:      "Failed transaction PIN verifications.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPDuration,
+		ThirdPartyDuration,
+		Transactions,
+		LimitRejections,
+		PINFailures,
+	)
+}
+
+// RegisterDB exports the connection pool statistics from db.Stats().
+func RegisterDB(db *sql.DB, name string) {
+	Registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// Handler serves the registry in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// ObserveHTTP records a served request.
+func ObserveHTTP(method, route string, status int, elapsed time.Duration) {
+	HTTPRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	HTTPDuration.WithLabelValues(method, route).Observe(elapsed.Seconds())
+}
+
+// operationKey is the request context key holding the URL template of a resty
+// request, captured before path parameters are substituted.
+type operationKey struct{}
+
+// InstrumentClient adds hooks to c which record the latency and outcome of every
+// request in ThirdPartyDuration. Requests are labelled with the path of the URL
+// they were made with, before path parameters are filled in, so that
+// "/payments/{reference}" is one operation rather than one per reference.
+func InstrumentClient(c *resty.Client) *resty.Client {
+	c.OnBeforeRequest(func(_ *resty.Client, r *resty.Request) error {
+		operation := r.URL
+		if u, err := url.Parse(r.URL); err == nil && u.Path != "" {
+			operation = u.Path
+		}
+		r.SetContext(context.WithValue(r.Context(), operationKey{}, operation))
+		return nil
+	})
+	c.OnAfterResponse(func(_ *resty.Client, resp *resty.Response) error {
+		observeThirdParty(resp.Request, outcome(resp.StatusCode()), resp.Time())
+		return nil
+	})
+	c.OnError(func(r *resty.Request, err error) {
+		if v, ok := err.(*resty.ResponseError); ok {
+			observeThirdParty(r, outcome(v.Response.StatusCode()), v.Response.Time())
+			return
+		}
+		observeThirdParty(r, "error", time.Since(r.Time))
+	})
+	return c
+}
+
+func observeThirdParty(r *resty.Request, outcome string, elapsed time.Duration) {
+	operation, _ := r.Context().Value(operationKey{}).(string)
+	ThirdPartyDuration.WithLabelValues(operation, outcome).Observe(elapsed.Seconds())
+}
+
+// outcome buckets a response status code.
+func outcome(status int) string {
+	switch {
+	case status >= 500:
+		return "server_error"
+	case status >= 400:
+		return "client_error"
+	default:
+		return "success"
+	}
+}
@@ -0,0 +1,32 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInstrumentClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/payments/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	client := InstrumentClient(resty.New())
+	for _, ref := range []string{"a", "b", "missing"} {
+		_, err := client.R().SetPathParam("reference", ref).Get(srv.URL + "/payments/{reference}")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := testutil.CollectAndCount(ThirdPartyDuration, "fairmoney_third_party_request_duration_seconds")
+	if got != 2 {
+		t.Errorf("got %d series, want 2 (one operation, two outcomes)", got)
+	}
+}
@@ -0,0 +1,261 @@
+// Package migrate applies the SQL migrations in a filesystem (normally one of the
+// embedded migrations.FS directories) to a MySQL or Postgres database. It keeps its state in the same
+// schema_migrations table as golang-migrate, so the two can be used interchangeably
+// on the same database.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ebitezion/backend-framework/internal/data"
+)
+
+// ErrDirty is returned when the last migration failed part-way through. MySQL can't
+// roll back DDL, so the schema has to be repaired by hand (and the version forced
+// with the migrate CLI) before any more migrations are run.
+var ErrDirty = errors.New("database schema is dirty")
+
+// The advisory lock held while migrating, so that several instances starting with
+// -migrate at once don't apply the same migration twice. MySQL locks are named and
+// Postgres locks are numbered.
+const (
+	lockName = "fairmoney_schema_migrations"
+	lockKey  = 7_315_062_811
+)
+
+var fileRX = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration is a single numbered schema change.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Load reads the migrations in the root of fsys, ordered by version. Every version
+// must have an up file; down files are only used by the migrate CLI.
+func Load(fsys fs.FS) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := fileRX.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migrate: %s: %w", entry.Name(), err)
+		}
+		body, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migrate: version %d has two names, %q and %q", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if strings.TrimSpace(m.Up) == "" {
+			return nil, fmt.Errorf("migrate: version %d has no up migration", m.Version)
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Latest returns the highest version in fsys, or zero if there are none.
+func Latest(fsys fs.FS) (int64, error) {
+	migrations, err := Load(fsys)
+	if err != nil || len(migrations) == 0 {
+		return 0, err
+	}
+	return migrations[len(migrations)-1].Version, nil
+}
+
+// Version returns the version recorded in the schema_migrations table, or ErrDirty
+// if the last migration didn't finish. A database that has never been migrated is
+// at version zero.
+func Version(ctx context.Context, db *sql.DB) (int64, error) {
+	var version int64
+	var dirty bool
+	err := db.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, nil
+		default:
+			return 0, err
+		}
+	}
+	if dirty {
+		return version, fmt.Errorf("%w at version %d", ErrDirty, version)
+	}
+	return version, nil
+}
+
+// Up applies every migration in fsys newer than the database's current version, in
+// order, and returns the version the database is left at. The dialect must match the
+// driver db was opened with.
+func Up(ctx context.Context, db *sql.DB, dialect data.Dialect, fsys fs.FS, logger *slog.Logger) (int64, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return 0, err
+	}
+
+	// The advisory lock belongs to a session, so everything below has to run on the
+	// same connection.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	unlock, err := lock(ctx, conn, dialect)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version bigint NOT NULL PRIMARY KEY, dirty boolean NOT NULL)`)
+	if err != nil {
+		return 0, err
+	}
+
+	var current int64
+	var dirty bool
+	err = conn.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&current, &dirty)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	if dirty {
+		return current, fmt.Errorf("%w at version %d", ErrDirty, current)
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+
+		start := time.Now()
+		err = setVersion(ctx, conn, dialect, m.Version, true)
+		if err != nil {
+			return current, err
+		}
+		for _, stmt := range Statements(m.Up) {
+			_, err = conn.ExecContext(ctx, stmt)
+			if err != nil {
+				return current, fmt.Errorf("migrate: %d_%s: %w", m.Version, m.Name, err)
+			}
+		}
+		err = setVersion(ctx, conn, dialect, m.Version, false)
+		if err != nil {
+			return current, err
+		}
+
+		current = m.Version
+		logger.Info("applied migration", "version", m.Version, "name", m.Name, "duration", time.Since(start).String())
+	}
+
+	return current, nil
+}
+
+// lock takes the migration lock on conn, waiting up to a minute for another
+// instance to finish, and returns a function which releases it.
+func lock(ctx context.Context, conn *sql.Conn, dialect data.Dialect) (func(), error) {
+	if dialect == data.Postgres {
+		ctx, cancel := context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+		_, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey)
+		if err != nil {
+			return nil, err
+		}
+		return func() { conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey) }, nil
+	}
+
+	var locked sql.NullBool
+	err := conn.QueryRowContext(ctx, `SELECT GET_LOCK(?, 60)`, lockName).Scan(&locked)
+	if err != nil {
+		return nil, err
+	}
+	if !locked.Bool {
+		return nil, errors.New("migrate: timed out waiting for the migration lock")
+	}
+	return func() { conn.ExecContext(context.Background(), `SELECT RELEASE_LOCK(?)`, lockName) }, nil
+}
+
+// setVersion replaces the single row of schema_migrations, the way golang-migrate
+// does.
+func setVersion(ctx context.Context, conn *sql.Conn, dialect data.Dialect, version int64, dirty bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, dialect.Rebind(`INSERT INTO schema_migrations (version, dirty) VALUES (?, ?)`), version, dirty)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Statements splits a migration into the statements it contains. The driver runs
+// one statement per Exec, so files are split on semicolons that end a line; a
+// semicolon anywhere else (inside a string, say) is left alone. Blank statements and
+// "--" comment lines are dropped.
+func Statements(script string) []string {
+	var stmts []string
+	var b strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		b.WriteString(line)
+		b.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			if stmt := strings.TrimSuffix(strings.TrimSpace(b.String()), ";"); stmt != "" {
+				stmts = append(stmts, stmt)
+			}
+			b.Reset()
+		}
+	}
+	if stmt := strings.TrimSpace(b.String()); stmt != "" {
+		stmts = append(stmts, stmt)
+	}
+	return stmts
+}
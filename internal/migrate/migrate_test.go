@@ -0,0 +1,107 @@
+package migrate
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/ebitezion/backend-framework/migrations"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"000002_add_index.up.sql":     {Data: []byte("CREATE INDEX i ON t (a);")},
+		"000002_add_index.down.sql":   {Data: []byte("DROP INDEX i ON t;")},
+		"000001_create_table.up.sql":  {Data: []byte("CREATE TABLE t (a int);")},
+		"000010_seed.up.sql":          {Data: []byte("INSERT INTO t VALUES (1);")},
+		"README.md":                   {Data: []byte("ignored")},
+		"000001_create_table.down.sq": {Data: []byte("ignored")},
+	}
+
+	got, err := Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var versions []int64
+	for _, m := range got {
+		versions = append(versions, m.Version)
+	}
+	if want := []int64{1, 2, 10}; !reflect.DeepEqual(versions, want) {
+		t.Errorf("got versions %v, want %v", versions, want)
+	}
+	if got[1].Name != "add_index" || got[1].Down == "" {
+		t.Errorf("got %+v", got[1])
+	}
+
+	latest, err := Latest(fsys)
+	if err != nil || latest != 10 {
+		t.Errorf("got latest %d, %v; want 10", latest, err)
+	}
+}
+
+func TestLoadRejectsMissingUp(t *testing.T) {
+	fsys := fstest.MapFS{
+		"000001_create_table.down.sql": {Data: []byte("DROP TABLE t;")},
+	}
+	if _, err := Load(fsys); err == nil {
+		t.Error("expected an error for a version with no up migration")
+	}
+}
+
+func TestStatements(t *testing.T) {
+	script := `-- a comment
+CREATE TABLE t (
+  a varchar(10) NOT NULL DEFAULT ';'
+);
+
+INSERT INTO t (a) VALUES ('x'), ('y');
+ALTER TABLE t ADD KEY a_idx (a)`
+
+	want := []string{
+		"CREATE TABLE t (\n  a varchar(10) NOT NULL DEFAULT ';'\n)",
+		"INSERT INTO t (a) VALUES ('x'), ('y')",
+		"ALTER TABLE t ADD KEY a_idx (a)",
+	}
+	if got := Statements(script); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// The embedded migrations must be numbered 1..n without gaps, and each must be
+// reversible with the migrate CLI. Every database gets the same migrations.
+func TestEmbeddedMigrations(t *testing.T) {
+	var names []string
+	for _, driver := range []string{"mysql", "postgres"} {
+		fsys, err := migrations.FS(driver)
+		if err != nil {
+			t.Fatal(err)
+		}
+		all, err := Load(fsys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) == 0 {
+			t.Fatalf("%s: no embedded migrations", driver)
+		}
+
+		var got []string
+		for i, m := range all {
+			if m.Version != int64(i+1) {
+				t.Errorf("%s: migration %d_%s: want version %d", driver, m.Version, m.Name, i+1)
+			}
+			if m.Down == "" {
+				t.Errorf("%s: migration %d_%s has no down migration", driver, m.Version, m.Name)
+			}
+			if len(Statements(m.Up)) == 0 {
+				t.Errorf("%s: migration %d_%s has no statements", driver, m.Version, m.Name)
+			}
+			got = append(got, m.Name)
+		}
+
+		if names == nil {
+			names = got
+		} else if !reflect.DeepEqual(got, names) {
+			t.Errorf("%s migrations %v don't match %v", driver, got, names)
+		}
+	}
+}
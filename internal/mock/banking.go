@@ -0,0 +1,179 @@
+package mock
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Bank is an entry in the interbank bank list.
+type Bank struct {
+	Code string `json:"bankCode"`
+	Name string `json:"bankName"`
+}
+
+// Transfer is an interbank transfer the mock NIP API carried out.
+type Transfer struct {
+	PaymentReference         string `json:"paymentReference"`
+	SessionID                string `json:"sessionID"`
+	DestinationBankCode      string `json:"destinationInstitutionCode"`
+	BeneficiaryAccountNumber string `json:"beneficiaryAccountNumber"`
+	Amount                   string `json:"amount"`
+}
+
+// Banks are the banks the mock NIP API knows about.
+var Banks = []Bank{
+	{Code: "000013", Name: "GTBank"},
+	{Code: "000014", Name: "Access Bank"},
+	{Code: "000015", Name: "Zenith Bank"},
+	{Code: "000016", Name: "First Bank of Nigeria"},
+	{Code: "000004", Name: "United Bank for Africa"},
+}
+
+// routeBanking adds the core banking (Orbit) and interbank (NIP) APIs, on the paths
+// that thirdparty.Config.WithMockDefaults points at. They answer every call
+// successfully, unless a fault says otherwise.
+func (p *Provider) routeBanking(router *mux.Router) {
+	post := func(path string, handler http.HandlerFunc) {
+		router.HandleFunc(path, p.withFaults(handler)).Methods(http.MethodPost)
+	}
+	post("/orbit/accounts", p.createAccount)
+	post("/orbit/accounts/history", p.respond(map[string]interface{}{"accountHistory": []interface{}{}}))
+	post("/orbit/accounts/balance", p.respond(map[string]interface{}{"availableBalance": "0.00", "ledgerBalance": "0.00"}))
+	post("/orbit/accounts/details", p.respond(map[string]interface{}{"accountName": "MOCK CUSTOMER"}))
+	post("/orbit/transfers/debit", p.transfer)
+	post("/orbit/transfers/credit", p.transfer)
+	post("/orbit/transfers/external", p.transfer)
+
+	router.HandleFunc("/nip/getBanks", p.withFaults(p.respond(map[string]interface{}{"banks": Banks}))).Methods(http.MethodGet)
+	post("/nip/nameEnquiry", p.nameEnquiry)
+	post("/nip/fundsTransferCredit", p.fundsTransferCredit)
+	post("/nip/transactionStatusQuery", p.transactionStatusQuery)
+}
+
+// Transfer returns the interbank transfer sent with the payment reference.
+func (p *Provider) Transfer(reference string) (Transfer, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	transfer, ok := p.transfers[reference]
+	return transfer, ok
+}
+
+// nextNumber returns a sequence number for generated account numbers and references.
+func (p *Provider) nextNumber() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.seq++
+	return p.seq
+}
+
+// respond answers with a successful response code and the given fields.
+func (p *Provider) respond(fields map[string]interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, success(fields))
+	}
+}
+
+func success(fields map[string]interface{}) map[string]interface{} {
+	body := map[string]interface{}{"responseCode": "00", "responseMessage": "Successful"}
+	for k, v := range fields {
+		body[k] = v
+	}
+	return body
+}
+
+func (p *Provider) createAccount(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, success(map[string]interface{}{
+		"accountNumber": fmt.Sprintf("30%08d", p.nextNumber()),
+	}))
+}
+
+func (p *Provider) transfer(w http.ResponseWriter, r *http.Request) {
+	n := p.nextNumber()
+	writeJSON(w, http.StatusOK, success(map[string]interface{}{
+		"transactionReference": fmt.Sprintf("MOCK%012d", n),
+		"sessionID":            fmt.Sprintf("999999%024d", n),
+	}))
+}
+
+func (p *Provider) nameEnquiry(w http.ResponseWriter, r *http.Request) {
+	var enquiry struct {
+		AccountNumber              string `json:"accountNumber"`
+		DestinationInstitutionCode string `json:"destinationInstitutionCode"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&enquiry)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	known := false
+	for _, bank := range Banks {
+		known = known || bank.Code == enquiry.DestinationInstitutionCode
+	}
+	if !known || len(enquiry.AccountNumber) != 10 {
+		writeJSON(w, http.StatusOK, map[string]string{"responseCode": "07", "responseMessage": "Invalid account"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, success(map[string]interface{}{
+		"sessionID":                  fmt.Sprintf("999999%024d", p.nextNumber()),
+		"accountNumber":              enquiry.AccountNumber,
+		"accountName":                "MOCK BENEFICIARY " + enquiry.AccountNumber[6:],
+		"bankVerificationNumber":     "22222222222",
+		"kycLevel":                   "1",
+		"destinationInstitutionCode": enquiry.DestinationInstitutionCode,
+	}))
+}
+
+func (p *Provider) fundsTransferCredit(w http.ResponseWriter, r *http.Request) {
+	var transfer Transfer
+	err := json.NewDecoder(r.Body).Decode(&transfer)
+	if err != nil || transfer.PaymentReference == "" {
+		writeJSON(w, http.StatusOK, map[string]string{"responseCode": "12", "responseMessage": "Invalid transaction"})
+		return
+	}
+
+	p.mu.Lock()
+	_, exists := p.transfers[transfer.PaymentReference]
+	if !exists {
+		p.seq++
+		transfer.SessionID = fmt.Sprintf("999999%024d", p.seq)
+		p.transfers[transfer.PaymentReference] = transfer
+	}
+	p.mu.Unlock()
+
+	if exists {
+		writeJSON(w, http.StatusOK, map[string]string{"responseCode": "26", "responseMessage": "Duplicate record"})
+		return
+	}
+	writeJSON(w, http.StatusOK, success(map[string]interface{}{
+		"sessionID":        transfer.SessionID,
+		"paymentReference": transfer.PaymentReference,
+	}))
+}
+
+// transactionStatusQuery reports a transfer that was carried out as successful, and
+// anything else as a record that can't be found.
+func (p *Provider) transactionStatusQuery(w http.ResponseWriter, r *http.Request) {
+	var query struct {
+		PaymentReference string `json:"paymentReference"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&query)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	transfer, ok := p.Transfer(query.PaymentReference)
+	if !ok {
+		writeJSON(w, http.StatusOK, map[string]string{"responseCode": "25", "responseMessage": "Unable to locate record"})
+		return
+	}
+	writeJSON(w, http.StatusOK, success(map[string]interface{}{
+		"sessionID":        transfer.SessionID,
+		"paymentReference": transfer.PaymentReference,
+	}))
+}
@@ -0,0 +1,172 @@
+package mock
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Biller is an entry in the bills API's biller list.
+type Biller struct {
+	ID            string          `json:"billerId"`
+	Name          string          `json:"billerName"`
+	Category      string          `json:"category"`
+	CustomerField string          `json:"customerField"`
+	Products      []BillerProduct `json:"products"`
+}
+
+// BillerProduct is something a biller sells, at a fixed price unless Amount is
+// empty.
+type BillerProduct struct {
+	Code   string `json:"productCode"`
+	Name   string `json:"productName"`
+	Amount string `json:"amount"`
+}
+
+// BillPayment is a bill payment the mock bills API accepted.
+type BillPayment struct {
+	PaymentReference string `json:"paymentReference"`
+	BillerReference  string `json:"billerReference"`
+	BillerID         string `json:"billerId"`
+	ProductCode      string `json:"productCode"`
+	CustomerID       string `json:"customerId"`
+	Amount           string `json:"amount"`
+	Token            string `json:"token"`
+}
+
+// Billers are the billers the mock bills API knows about, one or two in each
+// category.
+var Billers = []Biller{
+	{ID: "mtn-airtime", Name: "MTN Airtime", Category: "airtime", CustomerField: "Phone number", Products: []BillerProduct{
+		{Code: "airtime", Name: "Airtime top-up"},
+	}},
+	{ID: "mtn-data", Name: "MTN Data", Category: "data", CustomerField: "Phone number", Products: []BillerProduct{
+		{Code: "1gb-30d", Name: "1GB, 30 days", Amount: "1000.00"},
+		{Code: "5gb-30d", Name: "5GB, 30 days", Amount: "3500.00"},
+	}},
+	{ID: "ikedc-prepaid", Name: "Ikeja Electric Prepaid", Category: "electricity", CustomerField: "Meter number", Products: []BillerProduct{
+		{Code: "prepaid", Name: "Prepaid units"},
+	}},
+	{ID: "dstv", Name: "DStv", Category: "tv", CustomerField: "Smartcard number", Products: []BillerProduct{
+		{Code: "compact", Name: "DStv Compact", Amount: "12500.00"},
+		{Code: "premium", Name: "DStv Premium", Amount: "29500.00"},
+	}},
+}
+
+// routeBills adds the bills API, on the paths that thirdparty.Config.WithMockDefaults
+// points at.
+func (p *Provider) routeBills(router *mux.Router) {
+	router.HandleFunc("/bills/billers", p.withFaults(p.respond(map[string]interface{}{"billers": Billers}))).Methods(http.MethodGet)
+	router.HandleFunc("/bills/validateCustomer", p.withFaults(p.validateBillCustomer)).Methods(http.MethodPost)
+	router.HandleFunc("/bills/pay", p.withFaults(p.payBill)).Methods(http.MethodPost)
+	router.HandleFunc("/bills/paymentStatus", p.withFaults(p.billPaymentStatus)).Methods(http.MethodPost)
+}
+
+// BillPayment returns the bill payment made with the payment reference.
+func (p *Provider) BillPayment(reference string) (BillPayment, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	payment, ok := p.billPayments[reference]
+	return payment, ok
+}
+
+// billCustomer reports whether customerID is a customer of the biller. Any ID of 10
+// to 13 digits is, except for one of all zeros.
+func billCustomer(billerID, customerID string) bool {
+	known := false
+	for _, biller := range Billers {
+		known = known || biller.ID == billerID
+	}
+	if !known || len(customerID) < 10 || len(customerID) > 13 || strings.Trim(customerID, "0") == "" {
+		return false
+	}
+	for _, c := range customerID {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Provider) validateBillCustomer(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		BillerID   string `json:"billerId"`
+		CustomerID string `json:"customerId"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if !billCustomer(input.BillerID, input.CustomerID) {
+		writeJSON(w, http.StatusOK, map[string]string{"responseCode": "07", "responseMessage": "Invalid customer"})
+		return
+	}
+	writeJSON(w, http.StatusOK, success(map[string]interface{}{
+		"customerId":   input.CustomerID,
+		"customerName": "MOCK CUSTOMER " + input.CustomerID[len(input.CustomerID)-4:],
+	}))
+}
+
+// payBill accepts a payment to a known customer, with a token for electricity.
+func (p *Provider) payBill(w http.ResponseWriter, r *http.Request) {
+	var payment BillPayment
+	err := json.NewDecoder(r.Body).Decode(&payment)
+	if err != nil || payment.PaymentReference == "" {
+		writeJSON(w, http.StatusOK, map[string]string{"responseCode": "12", "responseMessage": "Invalid transaction"})
+		return
+	}
+	if !billCustomer(payment.BillerID, payment.CustomerID) {
+		writeJSON(w, http.StatusOK, map[string]string{"responseCode": "07", "responseMessage": "Invalid customer"})
+		return
+	}
+
+	p.mu.Lock()
+	_, exists := p.billPayments[payment.PaymentReference]
+	if !exists {
+		p.seq++
+		payment.BillerReference = fmt.Sprintf("BILL%012d", p.seq)
+		if strings.HasSuffix(payment.BillerID, "-prepaid") {
+			payment.Token = fmt.Sprintf("%04d-%04d-%04d-%04d-%04d", p.seq, p.seq*7%10000, p.seq*13%10000, p.seq*17%10000, p.seq*19%10000)
+		}
+		p.billPayments[payment.PaymentReference] = payment
+	}
+	p.mu.Unlock()
+
+	if exists {
+		writeJSON(w, http.StatusOK, map[string]string{"responseCode": "26", "responseMessage": "Duplicate record"})
+		return
+	}
+	writeJSON(w, http.StatusOK, success(map[string]interface{}{
+		"paymentReference": payment.PaymentReference,
+		"billerReference":  payment.BillerReference,
+		"token":            payment.Token,
+	}))
+}
+
+// billPaymentStatus reports a payment that was made as successful, and anything else
+// as a record that can't be found.
+func (p *Provider) billPaymentStatus(w http.ResponseWriter, r *http.Request) {
+	var query struct {
+		PaymentReference string `json:"paymentReference"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&query)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	payment, ok := p.BillPayment(query.PaymentReference)
+	if !ok {
+		writeJSON(w, http.StatusOK, map[string]string{"responseCode": "25", "responseMessage": "Unable to locate record"})
+		return
+	}
+	writeJSON(w, http.StatusOK, success(map[string]interface{}{
+		"paymentReference": payment.PaymentReference,
+		"billerReference":  payment.BillerReference,
+		"token":            payment.Token,
+	}))
+}
@@ -1,55 +1,244 @@
+// Package mock is a stand-in for the third-party payment provider, and for the core
+// banking, interbank and bills APIs behind it. It keeps the payments, interbank
+// transfers and bill payments it is sent, so they can be read back by reference, and
+// it can be scripted to misbehave on upcoming calls: slow responses, server errors,
+// requests that never get an answer, bodies that aren't valid JSON and declines.
 package mock
 
 import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
-// StartMockServer starts a mock server with the provided router and returns the base URL
-func StartMockServer() string {
+// Payment is the provider's payment resource.
+type Payment struct {
+	AccountID string  `json:"account_id"`
+	Reference string  `json:"reference"`
+	Amount    float64 `json:"amount"`
+}
+
+// Fault describes how the provider misbehaves on a single call. The zero value is a
+// normal call. Latency is applied before anything else, so it can be combined with
+// any of the other faults.
+type Fault struct {
+	// Latency delays the response.
+	Latency time.Duration `json:"latency"`
+	// Status, if set, is returned instead of handling the call. Nothing is stored.
+	Status int `json:"status"`
+	// Timeout holds the call open until the client gives up.
+	Timeout bool `json:"timeout"`
+	// Malformed answers as normal but with a truncated JSON body. The call is still
+	// handled, so a payment sent this way is stored.
+	Malformed bool `json:"malformed"`
+	// ResponseCode, if set, is returned as the response code of a 200 answer instead
+	// of handling the call, the way a bank turns a transfer down. Nothing is stored.
+	ResponseCode string `json:"response_code"`
+}
+
+// FaultsPath is where faults can be queued over HTTP, for the standalone server.
+// POST a JSON array of faults to queue them and DELETE to clear the queue.
+const FaultsPath = "/mock/faults"
+
+// Provider is an http.Handler that behaves like the third-party payment provider.
+type Provider struct {
+	router http.Handler
+
+	mu           sync.Mutex
+	payments     map[string]Payment
+	transfers    map[string]Transfer
+	billPayments map[string]BillPayment
+	faults       []Fault
+	seq          int
+	// closed is closed by Close, to release calls held open by a Timeout fault.
+	closed chan struct{}
+	once   sync.Once
+}
+
+// NewProvider returns a provider with no payments and no faults queued.
+func NewProvider() *Provider {
+	p := &Provider{
+		payments:     map[string]Payment{},
+		transfers:    map[string]Transfer{},
+		billPayments: map[string]BillPayment{},
+		closed:       make(chan struct{}),
+	}
+
 	router := mux.NewRouter()
+	router.HandleFunc("/third-party/payments", p.withFaults(p.createPayment)).Methods(http.MethodPost)
+	router.HandleFunc("/third-party/payments/{reference}", p.withFaults(p.showPayment)).Methods(http.MethodGet)
+	p.routeBanking(router)
+	p.routeBills(router)
+	router.HandleFunc(FaultsPath, p.queueFaults).Methods(http.MethodPost)
+	router.HandleFunc(FaultsPath, p.clearFaults).Methods(http.MethodDelete)
+	p.router = router
+	return p
+}
 
-	// Mock handler for POST /third-party/payments
-	router.HandleFunc("/third-party/payments", func(w http.ResponseWriter, r *http.Request) {
-		var payment struct {
-			AccountID string  `json:"account_id"`
-			Reference string  `json:"reference"`
-			Amount    float64 `json:"amount"`
-		}
-		err := json.NewDecoder(r.Body).Decode(&payment)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
+func (p *Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.router.ServeHTTP(w, r)
+}
+
+// Script queues faults for the next calls to the API endpoints, one per call, in
+// order. Calls after the queue has run out behave normally.
+func (p *Provider) Script(faults ...Fault) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.faults = append(p.faults, faults...)
+}
+
+// Payment returns the payment stored under reference.
+func (p *Provider) Payment(reference string) (Payment, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	payment, ok := p.payments[reference]
+	return payment, ok
+}
+
+// Payments returns the number of payments stored.
+func (p *Provider) Payments() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.payments)
+}
+
+// Close releases any calls being held open by a Timeout fault. Later Timeout faults
+// return straight away.
+func (p *Provider) Close() {
+	p.once.Do(func() { close(p.closed) })
+}
+
+// nextFault takes the fault for this call off the queue.
+func (p *Provider) nextFault() Fault {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.faults) == 0 {
+		return Fault{}
+	}
+	fault := p.faults[0]
+	p.faults = p.faults[1:]
+	return fault
+}
+
+// withFaults applies the next queued fault to a call. A malformed response is made
+// by letting next write its response and then cutting the body short.
+func (p *Provider) withFaults(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fault := p.nextFault()
+
+		if fault.Latency > 0 {
+			select {
+			case <-time.After(fault.Latency):
+			case <-r.Context().Done():
+				return
+			case <-p.closed:
+			}
 		}
-		// Respond with the same payment details
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(payment)
-	}).Methods("POST")
-
-	// Mock handler for GET /third-party/payments/:reference
-	router.HandleFunc("/third-party/payments/{reference}", func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		reference := vars["reference"]
-
-		// Mock response based on the reference ID
-		payment := struct {
-			AccountID string  `json:"account_id"`
-			Reference string  `json:"reference"`
-			Amount    float64 `json:"amount"`
-		}{
-			AccountID: "1234567890",
-			Reference: reference,
-			Amount:    100.50,
+
+		switch {
+		case fault.Timeout:
+			select {
+			case <-r.Context().Done():
+			case <-p.closed:
+			}
+		case fault.Status != 0:
+			writeJSON(w, fault.Status, map[string]string{"error": http.StatusText(fault.Status)})
+		case fault.ResponseCode != "":
+			writeJSON(w, http.StatusOK, map[string]string{"responseCode": fault.ResponseCode, "responseMessage": "Declined"})
+		case fault.Malformed:
+			rec := httptest.NewRecorder()
+			next(rec, r)
+			body := rec.Body.Bytes()
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(rec.Code)
+			w.Write(body[:len(body)/2])
+		default:
+			next(w, r)
 		}
-		// Respond with the mock payment details
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(payment)
-	}).Methods("GET")
-
-	// Start a test server using the router
-	ts := httptest.NewServer(router)
-	return ts.URL
+	}
+}
+
+func (p *Provider) createPayment(w http.ResponseWriter, r *http.Request) {
+	var payment Payment
+	err := json.NewDecoder(r.Body).Decode(&payment)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if payment.Reference == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "reference must be provided"})
+		return
+	}
+
+	p.mu.Lock()
+	_, exists := p.payments[payment.Reference]
+	if !exists {
+		p.payments[payment.Reference] = payment
+	}
+	p.mu.Unlock()
+
+	if exists {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "a payment with this reference already exists"})
+		return
+	}
+	writeJSON(w, http.StatusCreated, payment)
+}
+
+func (p *Provider) showPayment(w http.ResponseWriter, r *http.Request) {
+	payment, ok := p.Payment(mux.Vars(r)["reference"])
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "payment not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, payment)
+}
+
+func (p *Provider) queueFaults(w http.ResponseWriter, r *http.Request) {
+	var faults []Fault
+	err := json.NewDecoder(r.Body).Decode(&faults)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	p.Script(faults...)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (p *Provider) clearFaults(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	p.faults = nil
+	p.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Server is a provider listening on a local port.
+type Server struct {
+	*Provider
+	// URL is the base URL of the provider, with no trailing slash.
+	URL string
+
+	server *httptest.Server
+}
+
+// Start starts a provider on a local port. The caller must Close it.
+func Start() *Server {
+	p := NewProvider()
+	ts := httptest.NewServer(p)
+	return &Server{Provider: p, URL: ts.URL, server: ts}
+}
+
+// Close releases any held calls and shuts the server down.
+func (s *Server) Close() {
+	s.Provider.Close()
+	s.server.Close()
 }
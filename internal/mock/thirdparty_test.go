@@ -3,33 +3,23 @@ package mock
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"testing"
+	"time"
 )
 
 func TestMockServer(t *testing.T) {
 	// Start the mock server
-	baseURL := StartMockServer()
+	server := Start()
+	defer server.Close()
 
-	// Test GET request to /third-party/payments/payment123
-	t.Run("GET /third-party/payments/payment123", func(t *testing.T) {
-		resp, err := http.Get(baseURL + "/third-party/payments/payment123")
-		if err != nil {
-			t.Fatalf("GET request failed: %v", err)
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			t.Errorf("Unexpected status code: got %d, want %d", resp.StatusCode, http.StatusOK)
-		}
-	})
-
-	// Test POST request to /third-party/payments
-	t.Run("POST /third-party/payments", func(t *testing.T) {
+	post := func(t *testing.T, reference string) *http.Response {
+		t.Helper()
 		// Define the request body
 		requestBody := map[string]interface{}{
 			"account_id": "1234567890",
-			"reference":  "payment123",
+			"reference":  reference,
 			"amount":     100.50,
 		}
 
@@ -39,24 +29,110 @@ func TestMockServer(t *testing.T) {
 			t.Fatalf("Failed to marshal request body: %v", err)
 		}
 
-		// Create a POST request with the JSON request body
-		req, err := http.NewRequest(http.MethodPost, baseURL+"/third-party/payments", bytes.NewBuffer(reqBody))
+		resp, err := http.Post(server.URL+"/third-party/payments", "application/json", bytes.NewBuffer(reqBody))
 		if err != nil {
-			t.Fatalf("Failed to create POST request: %v", err)
+			t.Fatalf("POST request failed: %v", err)
 		}
+		resp.Body.Close()
+		return resp
+	}
 
-		// Set content type header
-		req.Header.Set("Content-Type", "application/json")
+	// Test POST request to /third-party/payments
+	t.Run("POST /third-party/payments", func(t *testing.T) {
+		if resp := post(t, "payment123"); resp.StatusCode != http.StatusCreated {
+			t.Errorf("Unexpected status code: got %d, want %d", resp.StatusCode, http.StatusCreated)
+		}
+		if resp := post(t, "payment123"); resp.StatusCode != http.StatusConflict {
+			t.Errorf("Duplicate reference: got %d, want %d", resp.StatusCode, http.StatusConflict)
+		}
+	})
 
-		// Perform the POST request
-		resp, err := http.DefaultClient.Do(req)
+	// Test GET request to /third-party/payments/payment123
+	t.Run("GET /third-party/payments/payment123", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "/third-party/payments/payment123")
 		if err != nil {
-			t.Errorf("POST request failed: %v", err)
+			t.Fatalf("GET request failed: %v", err)
 		}
 		defer resp.Body.Close()
 
 		if resp.StatusCode != http.StatusOK {
-			t.Errorf("Unexpected status code: got %d, want %d", resp.StatusCode, http.StatusOK)
+			t.Fatalf("Unexpected status code: got %d, want %d", resp.StatusCode, http.StatusOK)
+		}
+		var payment Payment
+		if err := json.NewDecoder(resp.Body).Decode(&payment); err != nil {
+			t.Fatal(err)
+		}
+		if payment.Reference != "payment123" || payment.Amount != 100.50 {
+			t.Errorf("Unexpected payment: %+v", payment)
+		}
+	})
+
+	t.Run("GET /third-party/payments/unknown", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "/third-party/payments/unknown")
+		if err != nil {
+			t.Fatalf("GET request failed: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Unexpected status code: got %d, want %d", resp.StatusCode, http.StatusNotFound)
 		}
 	})
 }
+
+func TestFaults(t *testing.T) {
+	server := Start()
+	defer server.Close()
+	client := &http.Client{Timeout: 200 * time.Millisecond}
+
+	get := func() (*http.Response, error) {
+		return client.Get(server.URL + "/third-party/payments/payment123")
+	}
+	server.Script(Fault{}, Fault{Status: http.StatusBadGateway}, Fault{Malformed: true})
+
+	// The first call goes through as normal and creates the payment.
+	resp, err := client.Post(server.URL+"/third-party/payments", "application/json", bytes.NewBufferString(`{"reference":"payment123","amount":1}`))
+	if err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create: got %v, %v", resp, err)
+	}
+	resp.Body.Close()
+
+	resp, err = get()
+	if err != nil || resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("5xx: got %v, %v", resp, err)
+	}
+	resp.Body.Close()
+
+	resp, err = get()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("malformed: got %v, %v", resp, err)
+	}
+	var payment Payment
+	if err := json.NewDecoder(resp.Body).Decode(&payment); err == nil {
+		t.Error("malformed: body decoded")
+	}
+	resp.Body.Close()
+
+	// Faults can also be queued over HTTP.
+	resp, err = client.Post(server.URL+FaultsPath, "application/json", bytes.NewBufferString(`[{"timeout":true},{"latency":50000000}]`))
+	if err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("queue faults: got %v, %v", resp, err)
+	}
+	resp.Body.Close()
+
+	_, err = get()
+	var timeout interface{ Timeout() bool }
+	if !errors.As(err, &timeout) || !timeout.Timeout() {
+		t.Fatalf("timeout: got %v", err)
+	}
+
+	start := time.Now()
+	resp, err = get()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("latency: got %v, %v", resp, err)
+	}
+	resp.Body.Close()
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("latency: answered after %v", elapsed)
+	}
+}
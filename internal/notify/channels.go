@@ -0,0 +1,182 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ebitezion/backend-framework/internal/mailer"
+	"github.com/go-resty/resty/v2"
+)
+
+// Channel names, also used as the keys of a user's Preferences.
+const (
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+	ChannelPush  = "push"
+	ChannelLog   = "log"
+)
+
+// EmailChannel delivers notifications through the existing SMTP mailer. Email
+// templates live with the mailer, as "<name>.tmpl".
+type EmailChannel struct {
+	mailer mailer.Mailer
+}
+
+// NewEmailChannel returns a Channel which sends through m.
+func NewEmailChannel(m mailer.Mailer) *EmailChannel {
+	return &EmailChannel{mailer: m}
+}
+
+func (c *EmailChannel) Name() string { return ChannelEmail }
+
+func (c *EmailChannel) Reaches(recipient Recipient) bool { return recipient.Email != "" }
+
+func (c *EmailChannel) Send(recipient Recipient, templateName string, data interface{}) error {
+	if recipient.Email == "" {
+		return ErrNoAddress
+	}
+	return c.mailer.Send(recipient.Email, templateName+".tmpl", data)
+}
+
+// SMSChannel posts notifications to an HTTP SMS gateway. The gateway is expected to
+// accept a JSON body of the form {"to": ..., "from": ..., "message": ...} and to
+// authenticate requests with an API key header.
+type SMSChannel struct {
+	client *resty.Client
+	url    string
+	apiKey string
+	sender string
+}
+
+// NewSMSChannel returns a Channel which sends through the SMS gateway at url.
+func NewSMSChannel(url, apiKey, sender string) *SMSChannel {
+	return &SMSChannel{
+		client: resty.New().SetTimeout(10 * time.Second),
+		url:    url,
+		apiKey: apiKey,
+		sender: sender,
+	}
+}
+
+func (c *SMSChannel) Name() string { return ChannelSMS }
+
+func (c *SMSChannel) Reaches(recipient Recipient) bool { return recipient.PhoneNumber != "" }
+
+func (c *SMSChannel) Send(recipient Recipient, templateName string, data interface{}) error {
+	if recipient.PhoneNumber == "" {
+		return ErrNoAddress
+	}
+	_, body, err := render(templateName, data)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.R().
+		SetHeader("Content-Type", "application/json").
+		SetHeader("Accept", "application/json").
+		SetHeader("X-API-Key", c.apiKey).
+		SetBody(map[string]string{
+			"to":      recipient.PhoneNumber,
+			"from":    c.sender,
+			"message": body,
+		}).
+		Post(c.url)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() >= http.StatusMultipleChoices {
+		return fmt.Errorf("sms gateway returned status %d", resp.StatusCode())
+	}
+	return nil
+}
+
+// PushChannel posts notifications to an HTTP push gateway (FCM style), addressed to
+// the device token the user registered.
+type PushChannel struct {
+	client    *resty.Client
+	url       string
+	serverKey string
+}
+
+// NewPushChannel returns a Channel which sends through the push gateway at url.
+func NewPushChannel(url, serverKey string) *PushChannel {
+	return &PushChannel{
+		client:    resty.New().SetTimeout(10 * time.Second),
+		url:       url,
+		serverKey: serverKey,
+	}
+}
+
+func (c *PushChannel) Name() string { return ChannelPush }
+
+func (c *PushChannel) Reaches(recipient Recipient) bool { return recipient.PushToken != "" }
+
+func (c *PushChannel) Send(recipient Recipient, templateName string, data interface{}) error {
+	if recipient.PushToken == "" {
+		return ErrNoAddress
+	}
+	subject, body, err := render(templateName, data)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.R().
+		SetHeader("Content-Type", "application/json").
+		SetHeader("Authorization", "key="+c.serverKey).
+		SetBody(map[string]interface{}{
+			"to": recipient.PushToken,
+			"notification": map[string]string{
+				"title": subject,
+				"body":  body,
+			},
+		}).
+		Post(c.url)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() >= http.StatusMultipleChoices {
+		return fmt.Errorf("push gateway returned status %d", resp.StatusCode())
+	}
+	return nil
+}
+
+// LogChannel writes each rendered notification as a JSON line to an io.Writer. It is
+// meant for development and tests, where no real gateway is available.
+type LogChannel struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// NewLogChannel returns a Channel which writes notifications to out.
+func NewLogChannel(out io.Writer) *LogChannel {
+	return &LogChannel{out: out}
+}
+
+func (c *LogChannel) Name() string { return ChannelLog }
+
+func (c *LogChannel) Send(recipient Recipient, templateName string, data interface{}) error {
+	subject, body, err := render(templateName, data)
+	if err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(map[string]interface{}{
+		"time":     time.Now().UTC().Format(time.RFC3339),
+		"user_id":  recipient.UserID,
+		"template": templateName,
+		"subject":  subject,
+		"body":     body,
+	})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err = c.out.Write(append(line, '\n'))
+	return err
+}
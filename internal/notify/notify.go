@@ -0,0 +1,179 @@
+package notify
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+//go:embed "templates"
+var templateFS embed.FS
+
+// Template names for the notifications we send. Each name has a matching
+// "<name>.tmpl" file in the notify templates (used by SMS, push and the log sink)
+// and in the mailer templates (used for email).
+const (
+	DebitAlert  = "debit_alert"
+	CreditAlert = "credit_alert"
+)
+
+// ErrNoAddress is returned by a Channel when the recipient has no address for it,
+// e.g. an SMS to a user without a phone number. The Notifier skips these quietly.
+var ErrNoAddress = errors.New("notify: recipient has no address for this channel")
+
+// Recipient holds everything a channel might need to reach a user.
+type Recipient struct {
+	UserID      int64
+	Name        string
+	Email       string
+	PhoneNumber string
+	PushToken   string
+}
+
+// Channel is implemented by every delivery medium (email, SMS, push, log sink).
+// Send renders the named template with data and delivers it to the recipient.
+type Channel interface {
+	Name() string
+	Send(recipient Recipient, templateName string, data interface{}) error
+}
+
+// Preferences maps a channel name to whether the user wants notifications on it. A
+// channel missing from the map is treated as enabled.
+type Preferences map[string]bool
+
+// Enabled reports whether the channel with the given name is switched on.
+func (p Preferences) Enabled(channel string) bool {
+	enabled, ok := p[channel]
+	return !ok || enabled
+}
+
+// Notifier fans a notification out to the channels a user has enabled.
+type Notifier struct {
+	channels []Channel
+	fallback string
+}
+
+// New returns a Notifier delivering over the given channels. The fallback channel is
+// used for mandatory notifications when the user has switched every channel off.
+func New(fallback string, channels ...Channel) *Notifier {
+	return &Notifier{
+		channels: channels,
+		fallback: fallback,
+	}
+}
+
+// Reacher is implemented by channels that can tell up front whether a recipient has
+// an address for them. Channels that don't implement it are assumed to reach
+// everyone.
+type Reacher interface {
+	Reaches(recipient Recipient) bool
+}
+
+// Targets returns the names of the channels a notification for recipient should go
+// out on: every channel enabled in prefs that can reach the recipient. Mandatory
+// notifications (transaction alerts) fall back to the fallback channel when nothing
+// else is left.
+func (n *Notifier) Targets(recipient Recipient, prefs Preferences, mandatory bool) []string {
+	targets := []string{}
+	for _, channel := range n.channels {
+		if !prefs.Enabled(channel.Name()) {
+			continue
+		}
+		if reacher, ok := channel.(Reacher); ok && !reacher.Reaches(recipient) {
+			continue
+		}
+		targets = append(targets, channel.Name())
+	}
+
+	if mandatory && len(targets) == 0 {
+		for _, channel := range n.channels {
+			if channel.Name() == n.fallback {
+				targets = append(targets, channel.Name())
+			}
+		}
+	}
+	return targets
+}
+
+// SendVia delivers the named template to the recipient over a single channel. It is
+// used by the outbox worker so that each channel is retried on its own.
+func (n *Notifier) SendVia(channelName string, recipient Recipient, templateName string, data interface{}) error {
+	for _, channel := range n.channels {
+		if channel.Name() == channelName {
+			return channel.Send(recipient, templateName, data)
+		}
+	}
+	return fmt.Errorf("notify: unknown channel %q", channelName)
+}
+
+// Notify sends the named template to the recipient over every channel returned by
+// Targets. Errors from individual channels are joined together so one failing
+// gateway doesn't stop delivery on the others.
+func (n *Notifier) Notify(recipient Recipient, prefs Preferences, mandatory bool, templateName string, data interface{}) error {
+	var errs []error
+	for _, name := range n.Targets(recipient, prefs, mandatory) {
+		err := n.SendVia(name, recipient, templateName, data)
+		if err != nil && !errors.Is(err, ErrNoAddress) {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// TransactionAlert is the template data for debit and credit alerts.
+type TransactionAlert struct {
+	Name          string
+	AccountNumber string
+	Type          string
+	Amount        string
+	Balance       string
+	Narration     string
+	Reference     string
+	Date          string
+}
+
+// MaskAccountNumber hides all but the first three and last three digits of an
+// account number, which is what banks print on alerts.
+func MaskAccountNumber(accountNumber string) string {
+	if len(accountNumber) <= 6 {
+		return accountNumber
+	}
+	return accountNumber[:3] + strings.Repeat("*", len(accountNumber)-6) + accountNumber[len(accountNumber)-3:]
+}
+
+// MaskName keeps the first name and the initials of the rest: "Ada Lovelace" becomes
+// "Ada L*******". It goes with MaskAccountNumber wherever an account holder is shown
+// to someone other than themselves.
+func MaskName(name string) string {
+	words := strings.Fields(name)
+	for i := 1; i < len(words); i++ {
+		runes := []rune(words[i])
+		words[i] = string(runes[0]) + strings.Repeat("*", len(runes)-1)
+	}
+	return strings.Join(words, " ")
+}
+
+// render executes the "subject" and "body" templates from the named template file.
+func render(templateName string, data interface{}) (subject, body string, err error) {
+	tmpl, err := template.New("notification").ParseFS(templateFS, "templates/"+templateName+".tmpl")
+	if err != nil {
+		return "", "", err
+	}
+
+	subjectBuf := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(subjectBuf, "subject", data)
+	if err != nil {
+		return "", "", err
+	}
+
+	bodyBuf := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(bodyBuf, "body", data)
+	if err != nil {
+		return "", "", err
+	}
+
+	return strings.TrimSpace(subjectBuf.String()), strings.TrimSpace(bodyBuf.String()), nil
+}
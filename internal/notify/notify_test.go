@@ -0,0 +1,92 @@
+package notify
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// stubChannel records the templates it was asked to send.
+type stubChannel struct {
+	name        string
+	err         error
+	unreachable bool
+	sent        []string
+}
+
+func (c *stubChannel) Name() string { return c.name }
+
+func (c *stubChannel) Reaches(recipient Recipient) bool { return !c.unreachable }
+
+func (c *stubChannel) Send(recipient Recipient, templateName string, data interface{}) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.sent = append(c.sent, templateName)
+	return nil
+}
+
+func TestNotifierPreferences(t *testing.T) {
+	email := &stubChannel{name: ChannelEmail}
+	sms := &stubChannel{name: ChannelSMS}
+	n := New(ChannelEmail, email, sms)
+
+	err := n.Notify(Recipient{UserID: 1}, Preferences{ChannelEmail: false}, false, DebitAlert, nil)
+	if err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	if len(email.sent) != 0 || len(sms.sent) != 1 {
+		t.Errorf("Unexpected deliveries: email=%d sms=%d", len(email.sent), len(sms.sent))
+	}
+}
+
+func TestNotifierMandatoryFallback(t *testing.T) {
+	email := &stubChannel{name: ChannelEmail}
+	sms := &stubChannel{name: ChannelSMS, unreachable: true}
+	n := New(ChannelEmail, email, sms)
+
+	err := n.Notify(Recipient{UserID: 1}, Preferences{ChannelEmail: false}, true, CreditAlert, nil)
+	if err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	if len(email.sent) != 1 {
+		t.Errorf("Mandatory alert was not sent on the fallback channel")
+	}
+}
+
+func TestNotifierJoinsErrors(t *testing.T) {
+	boom := errors.New("gateway down")
+	n := New(ChannelEmail, &stubChannel{name: ChannelSMS, err: boom})
+
+	err := n.Notify(Recipient{}, nil, false, DebitAlert, nil)
+	if !errors.Is(err, boom) {
+		t.Errorf("Unexpected error: got %v, want %v", err, boom)
+	}
+}
+
+func TestLogChannelRendersTemplate(t *testing.T) {
+	var buf bytes.Buffer
+	c := NewLogChannel(&buf)
+
+	err := c.Send(Recipient{UserID: 7}, DebitAlert, TransactionAlert{Amount: "1500.00", AccountNumber: MaskAccountNumber("0123456789")})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "DR Amt: NGN 1500.00") || !strings.Contains(buf.String(), "012****789") {
+		t.Errorf("Unexpected log line: %s", buf.String())
+	}
+}
+
+func TestMaskName(t *testing.T) {
+	tests := map[string]string{
+		"Ada":                  "Ada",
+		"Ada Lovelace":         "Ada L*******",
+		"  Ada  King Lovelace": "Ada K*** L*******",
+	}
+	for name, want := range tests {
+		if got := MaskName(name); got != want {
+			t.Errorf("MaskName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ebitezion/backend-framework/internal/data"
+)
+
+// Store is the subset of data.OutboxModel the worker needs.
+type Store interface {
+	ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]*data.OutboxMessage, error)
+	MarkDelivered(ctx context.Context, id int64) error
+	MarkFailed(ctx context.Context, id int64, lastError string, nextAttempt time.Time) error
+	MarkDead(ctx context.Context, id int64, lastError string) error
+}
+
+// Handler delivers a single outbox message. Returning an error schedules a retry. The
+// context expires when the message's lease does.
+type Handler func(ctx context.Context, msg *data.OutboxMessage) error
+
+// Config holds the tuning knobs for a Worker.
+type Config struct {
+	// Workers is the number of messages delivered concurrently.
+	Workers int
+	// PollInterval is how long the worker sleeps when the outbox is empty.
+	PollInterval time.Duration
+	// Lease is how long a claimed message is hidden from other workers. It must be
+	// longer than the slowest handler.
+	Lease time.Duration
+	// MaxAttempts is the number of attempts before a message is dead-lettered.
+	MaxAttempts int
+	// BaseBackoff and MaxBackoff bound the exponential backoff between attempts.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+}
+
+// Worker polls the outbox and hands due messages to the handler registered for their
+// kind, retrying failures with exponential backoff.
+type Worker struct {
+	store    Store
+	cfg      Config
+	logger   *slog.Logger
+	handlers map[string]Handler
+}
+
+// New returns a Worker reading from store.
+func New(store Store, cfg Config, logger *slog.Logger) *Worker {
+	return &Worker{
+		store:    store,
+		cfg:      cfg,
+		logger:   logger,
+		handlers: make(map[string]Handler),
+	}
+}
+
+// Handle registers the handler for messages of the given kind. It must be called
+// before Run.
+func (w *Worker) Handle(kind string, h Handler) {
+	w.handlers[kind] = h
+}
+
+// Run polls the outbox until ctx is cancelled, with up to Workers messages being
+// delivered at a time. Each message takes a slot, and a slot that frees up is
+// filled with the next due message straight away, so a slow handler holds up only
+// its own slot. On shutdown Run stops claiming and waits for the messages it has
+// claimed, so every one of them is either delivered or rescheduled before Run
+// returns.
+func (w *Worker) Run(ctx context.Context) {
+	slots := make(chan struct{}, w.cfg.Workers)
+	freed := make(chan struct{}, 1)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		var messages []*data.OutboxMessage
+		free := w.cfg.Workers - len(slots)
+		if free > 0 {
+			var err error
+			messages, err = w.store.ClaimDue(ctx, free, w.cfg.Lease)
+			if err != nil && ctx.Err() == nil {
+				w.logger.Error("outbox: claim failed", "error", err)
+			}
+		}
+
+		for _, msg := range messages {
+			slots <- struct{}{}
+			wg.Add(1)
+			go func(msg *data.OutboxMessage) {
+				defer wg.Done()
+				w.process(msg)
+				<-slots
+				select {
+				case freed <- struct{}{}:
+				default:
+				}
+			}(msg)
+		}
+
+		// While there is a backlog every slot is taken, so claim again as soon as one
+		// frees up; otherwise wait for the next poll or for shutdown.
+		if len(messages) == free {
+			select {
+			case <-ctx.Done():
+				return
+			case <-freed:
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(w.cfg.PollInterval):
+		}
+	}
+}
+
+// process delivers a single message and records the outcome. It deliberately doesn't
+// take the Run context: a message that has been claimed is seen through even if a
+// shutdown starts, and the lease bounds how long that can take.
+func (w *Worker) process(msg *data.OutboxMessage) {
+	ctx, cancel := context.WithTimeout(context.Background(), w.cfg.Lease)
+	defer cancel()
+
+	// A panicking handler must not take the whole worker down; treat it as a failed
+	// attempt.
+	err := func() (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("panic: %v", p)
+			}
+		}()
+
+		handler, ok := w.handlers[msg.Kind]
+		if !ok {
+			return fmt.Errorf("no handler for kind %q", msg.Kind)
+		}
+		return handler(ctx, msg)
+	}()
+
+	switch {
+	case err == nil:
+		err = w.store.MarkDelivered(context.Background(), msg.ID)
+	case msg.Attempts >= w.cfg.MaxAttempts:
+		w.logger.Error("outbox: message dead-lettered", "id", msg.ID, "kind", msg.Kind, "attempts", msg.Attempts, "error", err)
+		err = w.store.MarkDead(context.Background(), msg.ID, err.Error())
+	default:
+		next := time.Now().Add(Backoff(msg.Attempts, w.cfg.BaseBackoff, w.cfg.MaxBackoff))
+		err = w.store.MarkFailed(context.Background(), msg.ID, err.Error(), next)
+	}
+	if err != nil {
+		w.logger.Error("outbox: recording outcome failed", "id", msg.ID, "error", err)
+	}
+}
+
+// Backoff returns the delay before the next attempt: base doubled for every attempt
+// already made, capped at max, with up to 20% jitter so that a burst of failures
+// doesn't retry in lockstep.
+func Backoff(attempt int, base, max time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	jitter := time.Duration(rand.Int63n(int64(delay)/5 + 1))
+	return delay + jitter
+}
@@ -0,0 +1,188 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ebitezion/backend-framework/internal/data"
+)
+
+// memStore is an in-memory Store which hands out every pending message that is due.
+type memStore struct {
+	mu       sync.Mutex
+	messages map[int64]*data.OutboxMessage
+	due      map[int64]time.Time
+}
+
+func newMemStore(messages ...*data.OutboxMessage) *memStore {
+	s := &memStore{messages: map[int64]*data.OutboxMessage{}, due: map[int64]time.Time{}}
+	for _, msg := range messages {
+		msg.Status = data.OutboxPending
+		s.messages[msg.ID] = msg
+	}
+	return s
+}
+
+func (s *memStore) ClaimDue(_ context.Context, limit int, lease time.Duration) ([]*data.OutboxMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var claimed []*data.OutboxMessage
+	for id, msg := range s.messages {
+		if len(claimed) == limit {
+			break
+		}
+		if msg.Status == data.OutboxPending && !s.due[id].After(time.Now()) {
+			msg.Attempts++
+			s.due[id] = time.Now().Add(lease)
+			claimed = append(claimed, msg)
+		}
+	}
+	return claimed, nil
+}
+
+func (s *memStore) MarkDelivered(_ context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages[id].Status = data.OutboxDelivered
+	return nil
+}
+
+func (s *memStore) MarkFailed(_ context.Context, id int64, lastError string, nextAttempt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages[id].LastError = lastError
+	s.due[id] = time.Now()
+	return nil
+}
+
+func (s *memStore) MarkDead(_ context.Context, id int64, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages[id].Status = data.OutboxDead
+	s.messages[id].LastError = lastError
+	return nil
+}
+
+func (s *memStore) status(id int64) (string, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.messages[id].Status, s.messages[id].Attempts
+}
+
+func TestWorkerRetriesAndDeadLetters(t *testing.T) {
+	store := newMemStore(
+		&data.OutboxMessage{ID: 1, Kind: "flaky"},
+		&data.OutboxMessage{ID: 2, Kind: "broken"},
+	)
+
+	w := New(store, Config{
+		Workers:      2,
+		PollInterval: time.Millisecond,
+		Lease:        time.Minute,
+		MaxAttempts:  3,
+		BaseBackoff:  time.Millisecond,
+		MaxBackoff:   time.Millisecond,
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	calls := 0
+	w.Handle("flaky", func(_ context.Context, msg *data.OutboxMessage) error {
+		calls++
+		if calls < 2 {
+			return errors.New("temporary failure")
+		}
+		return nil
+	})
+	w.Handle("broken", func(_ context.Context, msg *data.OutboxMessage) error {
+		panic("handler bug")
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	w.Run(ctx)
+
+	if status, attempts := store.status(1); status != data.OutboxDelivered || attempts != 2 {
+		t.Errorf("flaky message: got %s after %d attempts, want %s after 2", status, attempts, data.OutboxDelivered)
+	}
+	if status, attempts := store.status(2); status != data.OutboxDead || attempts != 3 {
+		t.Errorf("broken message: got %s after %d attempts, want %s after 3", status, attempts, data.OutboxDead)
+	}
+}
+
+func TestWorkerSlowHandler(t *testing.T) {
+	store := newMemStore(&data.OutboxMessage{ID: 1, Kind: "slow"})
+	for id := int64(2); id <= 6; id++ {
+		store.messages[id] = &data.OutboxMessage{ID: id, Kind: "fast", Status: data.OutboxPending}
+	}
+
+	w := New(store, Config{
+		Workers:      2,
+		PollInterval: time.Hour,
+		Lease:        time.Minute,
+		MaxAttempts:  3,
+		BaseBackoff:  time.Millisecond,
+		MaxBackoff:   time.Millisecond,
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	release := make(chan struct{})
+	w.Handle("slow", func(ctx context.Context, msg *data.OutboxMessage) error {
+		<-release
+		return nil
+	})
+	w.Handle("fast", func(_ context.Context, msg *data.OutboxMessage) error {
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+
+	// The fast messages go through the other slot while the slow one holds its own.
+	deadline := time.Now().Add(5 * time.Second)
+	for id := int64(2); id <= 6; id++ {
+		for {
+			if status, _ := store.status(id); status == data.OutboxDelivered {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("message %d wasn't delivered while the slow handler ran", id)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// Shutdown waits for the message in flight.
+	cancel()
+	close(release)
+	<-done
+	if status, _ := store.status(1); status != data.OutboxDelivered {
+		t.Errorf("slow message: got %s, want %s", status, data.OutboxDelivered)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	base, max := time.Second, 10*time.Second
+
+	tests := []struct {
+		attempt int
+		min     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{10, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		got := Backoff(tt.attempt, base, max)
+		if got < tt.min || got > tt.min+tt.min/5 {
+			t.Errorf("Backoff(%d) = %v, want between %v and %v", tt.attempt, got, tt.min, tt.min+tt.min/5)
+		}
+	}
+}
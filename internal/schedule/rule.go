@@ -0,0 +1,232 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ebitezion/backend-framework/internal/data"
+)
+
+// Rule says when a schedule's payments fall. Daily, weekly and monthly rules repeat
+// from the schedule's start; a monthly rule keeps the start's day of the month, or
+// the last day of a shorter month. Times are in UTC.
+type Rule struct {
+	frequency string
+	cron      *cronRule
+}
+
+// ParseRule returns the rule for a schedule frequency. expr is the cron rule of a
+// cron schedule, and is ignored otherwise.
+func ParseRule(frequency, expr string) (*Rule, error) {
+	switch frequency {
+	case data.FrequencyOnce, data.FrequencyDaily, data.FrequencyWeekly, data.FrequencyMonthly:
+		return &Rule{frequency: frequency}, nil
+	case data.FrequencyCron:
+		cron, err := parseCron(expr)
+		if err != nil {
+			return nil, err
+		}
+		return &Rule{frequency: frequency, cron: cron}, nil
+	default:
+		return nil, fmt.Errorf("unknown frequency %q", frequency)
+	}
+}
+
+// Next returns the first occurrence of a schedule starting at start that falls
+// after previous, or the first occurrence of all if previous is zero. It reports
+// false if there are no more.
+func (r *Rule) Next(start, previous time.Time) (time.Time, bool) {
+	start = start.UTC()
+	previous = previous.UTC()
+	if r.frequency == data.FrequencyCron {
+		from := start
+		if !previous.IsZero() && !previous.Before(start) {
+			from = previous.Add(time.Nanosecond)
+		}
+		return r.cron.next(from)
+	}
+
+	if previous.IsZero() || previous.Before(start) {
+		return start, true
+	}
+	var n int
+	switch r.frequency {
+	case data.FrequencyOnce:
+		return time.Time{}, false
+	case data.FrequencyDaily:
+		n = int(previous.Sub(start) / (24 * time.Hour))
+	case data.FrequencyWeekly:
+		n = int(previous.Sub(start) / (7 * 24 * time.Hour))
+	case data.FrequencyMonthly:
+		n = (previous.Year()-start.Year())*12 + int(previous.Month()-start.Month()) - 1
+	}
+	if n < 0 {
+		n = 0
+	}
+	for {
+		t := r.occurrence(start, n)
+		if t.After(previous) {
+			return t, true
+		}
+		n++
+	}
+}
+
+// occurrence returns the nth occurrence of an interval rule, counting the start as
+// the 0th.
+func (r *Rule) occurrence(start time.Time, n int) time.Time {
+	switch r.frequency {
+	case data.FrequencyDaily:
+		return start.AddDate(0, 0, n)
+	case data.FrequencyWeekly:
+		return start.AddDate(0, 0, 7*n)
+	default:
+		// AddDate would roll 31 January on to 3 March, so the day is clamped to
+		// the end of the month instead.
+		month := time.Date(start.Year(), start.Month()+time.Month(n), 1, start.Hour(), start.Minute(), start.Second(), start.Nanosecond(), time.UTC)
+		day := start.Day()
+		if last := month.AddDate(0, 1, -1).Day(); day > last {
+			day = last
+		}
+		return month.AddDate(0, 0, day-1)
+	}
+}
+
+// cronRule is a five-field cron rule: minute, hour, day of the month, month and day
+// of the week. Each field is *, a value, a range a-b, or a list of them separated
+// by commas, and any of these but a single value can take a step /n. Day of the week
+// runs from 0 (Sunday) to 6, and 7 is Sunday too. As in cron, a rule that restricts
+// both days matches a day that satisfies either.
+type cronRule struct {
+	minute, hour, dom, month, dow []bool
+	// anyDOM and anyDOW record a day field that was *.
+	anyDOM, anyDOW bool
+}
+
+// cronFields gives the range of each field of a cron rule.
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+func parseCron(expr string) (*cronRule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, errors.New("must have 5 fields: minute, hour, day of month, month and day of week")
+	}
+	sets := make([][]bool, len(fields))
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", cronFields[i].name, err)
+		}
+		sets[i] = set
+	}
+	// 7 is another name for Sunday.
+	sets[4][0] = sets[4][0] || sets[4][7]
+
+	return &cronRule{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4][:7],
+		anyDOM: strings.HasPrefix(fields[2], "*"),
+		anyDOW: strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+// parseCronField returns the values from min to max that a field matches.
+func parseCronField(field string, min, max int) ([]bool, error) {
+	set := make([]bool, max+1)
+	for _, item := range strings.Split(field, ",") {
+		rng, step := item, 1
+		if i := strings.IndexByte(item, '/'); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(item[i+1:])
+			if err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step in %q", item)
+			}
+			rng = item[:i]
+		}
+
+		lo, hi := min, max
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			bounds := strings.SplitN(rng, "-", 2)
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(bounds[0])
+			hi, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil || lo > hi {
+				return nil, fmt.Errorf("invalid range %q", rng)
+			}
+		default:
+			v, err := strconv.Atoi(rng)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q", rng)
+			}
+			if step != 1 {
+				return nil, fmt.Errorf("step given for a single value in %q", item)
+			}
+			lo, hi = v, v
+		}
+		if lo < min || hi > max {
+			return nil, fmt.Errorf("%q is out of range %d-%d", rng, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+// dayMatches reports whether the rule's day fields match t's day.
+func (c *cronRule) dayMatches(t time.Time) bool {
+	dom, dow := c.dom[t.Day()], c.dow[t.Weekday()]
+	switch {
+	case c.anyDOM && c.anyDOW:
+		return true
+	case c.anyDOM:
+		return dow
+	case c.anyDOW:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// next returns the first minute at or after from that the rule matches. A rule
+// that matches nothing in the next five years, such as one for 30 February, is
+// taken to match nothing at all.
+func (c *cronRule) next(from time.Time) (time.Time, bool) {
+	t := from.Truncate(time.Minute)
+	if t.Before(from) {
+		t = t.Add(time.Minute)
+	}
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case !c.month[t.Month()]:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case !c.hour[t.Hour()]:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, time.UTC)
+		case !c.minute[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,91 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ebitezion/backend-framework/internal/data"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestRuleNext(t *testing.T) {
+	tests := []struct {
+		name      string
+		frequency string
+		cron      string
+		start     string
+		previous  string
+		want      string
+	}{
+		{"once first", data.FrequencyOnce, "", "2026-01-31 09:00", "", "2026-01-31 09:00"},
+		{"once done", data.FrequencyOnce, "", "2026-01-31 09:00", "2026-01-31 09:00", ""},
+		{"daily", data.FrequencyDaily, "", "2026-01-31 09:00", "2026-02-03 09:00", "2026-02-04 09:00"},
+		{"daily between runs", data.FrequencyDaily, "", "2026-01-31 09:00", "2026-02-03 12:30", "2026-02-04 09:00"},
+		{"daily before start", data.FrequencyDaily, "", "2026-01-31 09:00", "2026-01-01 09:00", "2026-01-31 09:00"},
+		{"weekly", data.FrequencyWeekly, "", "2026-01-05 09:00", "2026-01-12 09:00", "2026-01-19 09:00"},
+		{"monthly", data.FrequencyMonthly, "", "2026-01-15 09:00", "2026-01-15 09:00", "2026-02-15 09:00"},
+		{"monthly to a shorter month", data.FrequencyMonthly, "", "2026-01-31 09:00", "2026-01-31 09:00", "2026-02-28 09:00"},
+		{"monthly back to the anchor", data.FrequencyMonthly, "", "2026-01-31 09:00", "2026-02-28 09:00", "2026-03-31 09:00"},
+		{"monthly leap year", data.FrequencyMonthly, "", "2028-01-30 09:00", "2028-01-30 09:00", "2028-02-29 09:00"},
+		{"monthly across a year", data.FrequencyMonthly, "", "2026-11-30 09:00", "2027-01-30 10:00", "2027-02-28 09:00"},
+		{"cron first", data.FrequencyCron, "0 9 * * *", "2026-01-31 09:00", "", "2026-01-31 09:00"},
+		{"cron first after start", data.FrequencyCron, "0 9 * * *", "2026-01-31 09:01", "", "2026-02-01 09:00"},
+		{"cron every 15 minutes", data.FrequencyCron, "*/15 * * * *", "2026-01-31 09:00", "2026-01-31 09:15", "2026-01-31 09:30"},
+		{"cron weekdays", data.FrequencyCron, "30 8 * * 1-5", "2026-01-01 00:00", "2026-01-02 08:30", "2026-01-05 08:30"},
+		{"cron sunday as 7", data.FrequencyCron, "0 0 * * 7", "2026-01-01 00:00", "", "2026-01-04 00:00"},
+		{"cron last of list", data.FrequencyCron, "0 9 1,15 * *", "2026-01-01 00:00", "2026-01-15 09:00", "2026-02-01 09:00"},
+		{"cron day of month or week", data.FrequencyCron, "0 0 13 * 5", "2026-02-01 00:00", "", "2026-02-06 00:00"},
+		{"cron month", data.FrequencyCron, "0 0 1 6 *", "2026-07-01 00:00", "", "2027-06-01 00:00"},
+		{"cron never", data.FrequencyCron, "0 0 30 2 *", "2026-01-01 00:00", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := ParseRule(tt.frequency, tt.cron)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var previous time.Time
+			if tt.previous != "" {
+				previous = date(tt.previous)
+			}
+			got, ok := rule.Next(date(tt.start), previous)
+			switch {
+			case tt.want == "" && ok:
+				t.Errorf("got %s, want none", got)
+			case tt.want != "" && !got.Equal(date(tt.want)):
+				t.Errorf("got %s (%v), want %s", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRuleErrors(t *testing.T) {
+	tests := []struct {
+		frequency string
+		cron      string
+	}{
+		{"hourly", ""},
+		{data.FrequencyCron, ""},
+		{data.FrequencyCron, "0 9 * *"},
+		{data.FrequencyCron, "60 9 * * *"},
+		{data.FrequencyCron, "0 9 0 * *"},
+		{data.FrequencyCron, "0 9 * 13 *"},
+		{data.FrequencyCron, "0 9 * * 8"},
+		{data.FrequencyCron, "0 17-9 * * *"},
+		{data.FrequencyCron, "*/0 9 * * *"},
+		{data.FrequencyCron, "5/10 9 * * *"},
+		{data.FrequencyCron, "0 9 L * *"},
+	}
+	for _, tt := range tests {
+		if _, err := ParseRule(tt.frequency, tt.cron); err == nil {
+			t.Errorf("%s %q: got no error", tt.frequency, tt.cron)
+		}
+	}
+}
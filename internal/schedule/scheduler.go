@@ -0,0 +1,194 @@
+// Package schedule runs scheduled payments: one-off payments on a future date and
+// standing orders on a recurring rule.
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/ebitezion/backend-framework/internal/data"
+)
+
+// Store is the subset of data.ScheduleModel the scheduler needs.
+type Store interface {
+	ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]*data.Schedule, error)
+	RecordRun(ctx context.Context, schedule *data.Schedule, run *data.ScheduleRun) error
+}
+
+// Handler makes a schedule's payment, using reference as the request reference of
+// the transfer, and returns the transfer's own reference. If a payment has already
+// been made under reference, by a run whose lease ran out before it was recorded, the
+// handler returns the outcome of that payment rather than making another. The
+// context expires after Config.RunTimeout.
+type Handler func(ctx context.Context, schedule *data.Schedule, reference string) (string, error)
+
+// Config holds the tuning knobs for a Scheduler.
+type Config struct {
+	// BatchSize is the number of due schedules claimed at a time. They are run one
+	// after another, so that two payments from the same account never race.
+	BatchSize int
+	// PollInterval is how long the scheduler sleeps when nothing is due.
+	PollInterval time.Duration
+	// Lease is how long a claimed schedule is hidden from other replicas. The whole
+	// batch is claimed at once, so it must be at least BatchSize times RunTimeout,
+	// the most a single run is given.
+	Lease      time.Duration
+	RunTimeout time.Duration
+	// A run that fails with data.ErrInsufficientFunds is retried MaxRetries times,
+	// RetryDelay apart, as long as the retry comes before the next occurrence.
+	RetryDelay time.Duration
+	MaxRetries int
+}
+
+// Scheduler polls for schedules that are due, runs their payments through the
+// handler and records each run.
+type Scheduler struct {
+	store   Store
+	handler Handler
+	cfg     Config
+	logger  *slog.Logger
+}
+
+// New returns a Scheduler reading from store.
+func New(store Store, handler Handler, cfg Config, logger *slog.Logger) *Scheduler {
+	return &Scheduler{
+		store:   store,
+		handler: handler,
+		cfg:     cfg,
+		logger:  logger,
+	}
+}
+
+// Run polls for due schedules until ctx is cancelled. A batch that has been claimed
+// is run to completion before Run looks at ctx again.
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		schedules, err := s.store.ClaimDue(ctx, s.cfg.BatchSize, s.cfg.Lease)
+		if err != nil && ctx.Err() == nil {
+			s.logger.Error("schedule: claim failed", "error", err)
+		}
+
+		for _, schedule := range schedules {
+			s.process(schedule)
+		}
+
+		if len(schedules) == s.cfg.BatchSize && ctx.Err() == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.cfg.PollInterval):
+		}
+	}
+}
+
+// Reference returns the request reference for the current attempt at a schedule's
+// current occurrence. It is the same every time the attempt is made, so that a run
+// repeated after a lease ran out is turned down as a duplicate instead of paying
+// twice.
+func Reference(schedule *data.Schedule) string {
+	return fmt.Sprintf("SCH%d-%d-%d", schedule.ID, schedule.Occurrence+1, schedule.Retries+1)
+}
+
+// process runs a schedule's payment, records the run and moves the schedule on to
+// its next attempt or occurrence. Like the outbox worker it doesn't take the Run
+// context, so that a payment that has started is seen through on shutdown.
+func (s *Scheduler) process(schedule *data.Schedule) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.RunTimeout)
+	defer cancel()
+
+	run := &data.ScheduleRun{
+		ScheduleID: schedule.ID,
+		Occurrence: schedule.Occurrence + 1,
+		Attempt:    schedule.Retries + 1,
+		Reference:  Reference(schedule),
+	}
+
+	// A panicking handler must not take the scheduler down; treat it as a failed
+	// run.
+	transferReference, err := func() (reference string, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("panic: %v", p)
+			}
+		}()
+		return s.handler(ctx, schedule, run.Reference)
+	}()
+
+	// The occurrence just run is the one due at the claimed next run, which has
+	// normally passed by now.
+	now := time.Now()
+	if schedule.NextRunAt != nil && schedule.NextRunAt.After(now) {
+		now = *schedule.NextRunAt
+	}
+	switch {
+	case err == nil:
+		run.Status = data.RunPaid
+		run.TransferReference = transferReference
+		s.advance(schedule, now)
+	case errors.Is(err, data.ErrInsufficientFunds) && s.retry(schedule, now):
+		run.Status = data.RunRetrying
+		run.Error = err.Error()
+	default:
+		run.Status = data.RunFailed
+		run.Error = err.Error()
+		s.advance(schedule, now)
+	}
+	if len(run.Error) > 255 {
+		run.Error = run.Error[:255]
+	}
+
+	err = s.store.RecordRun(context.Background(), schedule, run)
+	if err != nil {
+		s.logger.Error("schedule: recording run failed", "id", schedule.ID, "reference", run.Reference, "error", err)
+	}
+}
+
+// retry moves the schedule on to another attempt at its current occurrence, if it
+// has retries left and the retry would come before the next occurrence.
+func (s *Scheduler) retry(schedule *data.Schedule, now time.Time) bool {
+	if schedule.Retries >= s.cfg.MaxRetries {
+		return false
+	}
+	retryAt := now.Add(s.cfg.RetryDelay)
+	if next, ok := s.next(schedule, now); ok && !retryAt.Before(next) {
+		return false
+	}
+	schedule.Retries++
+	schedule.NextRunAt = &retryAt
+	return true
+}
+
+// advance moves the schedule on to its next occurrence after now, completing it if
+// there are no more. Occurrences missed while no scheduler was running are skipped
+// rather than paid all at once.
+func (s *Scheduler) advance(schedule *data.Schedule, now time.Time) {
+	schedule.Occurrence++
+	schedule.Retries = 0
+	next, ok := s.next(schedule, now)
+	if !ok {
+		schedule.NextRunAt = nil
+		schedule.Status = data.ScheduleCompleted
+		return
+	}
+	schedule.NextRunAt = &next
+}
+
+// next returns the schedule's next occurrence after now, if it has one before its
+// end.
+func (s *Scheduler) next(schedule *data.Schedule, now time.Time) (time.Time, bool) {
+	rule, err := ParseRule(schedule.Frequency, schedule.Cron)
+	if err != nil {
+		s.logger.Error("schedule: invalid rule", "id", schedule.ID, "error", err)
+		return time.Time{}, false
+	}
+	next, ok := rule.Next(schedule.StartAt, now)
+	if !ok || (schedule.EndAt != nil && next.After(*schedule.EndAt)) {
+		return time.Time{}, false
+	}
+	return next, true
+}
@@ -0,0 +1,167 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/ebitezion/backend-framework/internal/data"
+)
+
+// memStore is an in-memory Store which hands out every active schedule that is due.
+type memStore struct {
+	schedules []*data.Schedule
+	runs      []*data.ScheduleRun
+}
+
+func (s *memStore) ClaimDue(_ context.Context, limit int, lease time.Duration) ([]*data.Schedule, error) {
+	var claimed []*data.Schedule
+	for _, schedule := range s.schedules {
+		if len(claimed) == limit {
+			break
+		}
+		if schedule.Status == data.ScheduleActive && schedule.NextRunAt != nil && !schedule.NextRunAt.After(time.Now()) {
+			found := *schedule
+			claimed = append(claimed, &found)
+			leased := time.Now().Add(lease)
+			schedule.NextRunAt = &leased
+		}
+	}
+	return claimed, nil
+}
+
+func (s *memStore) RecordRun(_ context.Context, schedule *data.Schedule, run *data.ScheduleRun) error {
+	for i, stored := range s.schedules {
+		if stored.ID == schedule.ID {
+			found := *schedule
+			s.schedules[i] = &found
+		}
+	}
+	s.runs = append(s.runs, run)
+	return nil
+}
+
+// runOnce runs a single batch of the scheduler.
+func runOnce(store *memStore, handler Handler, cfg Config) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	New(store, handler, cfg, slog.New(slog.NewTextHandler(io.Discard, nil))).Run(ctx)
+}
+
+func TestSchedulerRunsAndRetries(t *testing.T) {
+	start := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+	due := start
+	store := &memStore{schedules: []*data.Schedule{
+		{ID: 1, Frequency: data.FrequencyDaily, StartAt: start, NextRunAt: &due, Status: data.ScheduleActive},
+		{ID: 2, Frequency: data.FrequencyOnce, StartAt: start, NextRunAt: &due, Status: data.ScheduleActive},
+		{ID: 3, Frequency: data.FrequencyMonthly, StartAt: start, NextRunAt: &due, Status: data.ScheduleActive},
+		{ID: 4, Frequency: data.FrequencyWeekly, StartAt: start, NextRunAt: &due, Status: data.ScheduleActive},
+	}}
+	cfg := Config{BatchSize: 10, PollInterval: time.Second, Lease: time.Minute, RunTimeout: time.Second, RetryDelay: time.Hour, MaxRetries: 1}
+
+	var references []string
+	handler := func(ctx context.Context, schedule *data.Schedule, reference string) (string, error) {
+		references = append(references, reference)
+		switch schedule.ID {
+		case 3:
+			return "", data.ErrInsufficientFunds
+		case 4:
+			return "", errors.New("limit exceeded")
+		}
+		return "T" + reference, nil
+	}
+	runOnce(store, handler, cfg)
+
+	if len(references) != 4 || references[0] != "SCH1-1-1" {
+		t.Fatalf("got references %v", references)
+	}
+
+	daily, once, monthly, weekly := store.schedules[0], store.schedules[1], store.schedules[2], store.schedules[3]
+	if daily.Occurrence != 1 || !daily.NextRunAt.Equal(start.AddDate(0, 0, 1)) || daily.Status != data.ScheduleActive {
+		t.Errorf("daily: got %+v, next %s", daily, daily.NextRunAt)
+	}
+	if once.Occurrence != 1 || once.NextRunAt != nil || once.Status != data.ScheduleCompleted {
+		t.Errorf("once: got %+v", once)
+	}
+	if monthly.Occurrence != 0 || monthly.Retries != 1 || monthly.NextRunAt.Sub(time.Now()) < 59*time.Minute {
+		t.Errorf("monthly: got %+v, next %s", monthly, monthly.NextRunAt)
+	}
+	if weekly.Occurrence != 1 || weekly.Retries != 0 || !weekly.NextRunAt.Equal(start.AddDate(0, 0, 7)) {
+		t.Errorf("weekly: got %+v, next %s", weekly, weekly.NextRunAt)
+	}
+
+	wantRuns := []struct {
+		status, transfer, err string
+	}{
+		{data.RunPaid, "TSCH1-1-1", ""},
+		{data.RunPaid, "TSCH2-1-1", ""},
+		{data.RunRetrying, "", data.ErrInsufficientFunds.Error()},
+		{data.RunFailed, "", "limit exceeded"},
+	}
+	for i, want := range wantRuns {
+		run := store.runs[i]
+		if run.Status != want.status || run.TransferReference != want.transfer || run.Error != want.err || run.Occurrence != 1 || run.Attempt != 1 {
+			t.Errorf("run %d: got %+v", i, run)
+		}
+	}
+
+	// The retry uses a new reference, and once the retries are used up the
+	// occurrence is given up.
+	now := time.Now()
+	monthly.NextRunAt = &now
+	references = nil
+	runOnce(store, handler, cfg)
+	monthly = store.schedules[2]
+	if len(references) != 1 || references[0] != "SCH3-1-2" {
+		t.Fatalf("got references %v", references)
+	}
+	if run := store.runs[4]; run.Status != data.RunFailed || run.Attempt != 2 {
+		t.Errorf("retry: got %+v", run)
+	}
+	if monthly.Occurrence != 1 || monthly.Retries != 0 || !monthly.NextRunAt.Equal(start.AddDate(0, 1, 0)) {
+		t.Errorf("monthly after retry: got %+v, next %s", monthly, monthly.NextRunAt)
+	}
+}
+
+func TestSchedulerEndsAndSkipsMissedOccurrences(t *testing.T) {
+	// Three days of occurrences were missed; only one is paid, and the schedule ends
+	// before the next.
+	start := time.Now().Add(-72*time.Hour - time.Minute).UTC().Truncate(time.Second)
+	end := time.Now().Add(time.Hour)
+	due := start
+	store := &memStore{schedules: []*data.Schedule{
+		{ID: 1, Frequency: data.FrequencyDaily, StartAt: start, EndAt: &end, NextRunAt: &due, Status: data.ScheduleActive},
+	}}
+	paid := 0
+	handler := func(ctx context.Context, schedule *data.Schedule, reference string) (string, error) {
+		paid++
+		return reference, nil
+	}
+	runOnce(store, handler, Config{BatchSize: 10, PollInterval: time.Hour, Lease: time.Minute, RunTimeout: time.Second})
+
+	if schedule := store.schedules[0]; paid != 1 || schedule.Status != data.ScheduleCompleted || schedule.NextRunAt != nil {
+		t.Errorf("got %d payments, %+v", paid, schedule)
+	}
+}
+
+func TestSchedulerDoesNotRetryPastNextOccurrence(t *testing.T) {
+	start := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+	due := start
+	store := &memStore{schedules: []*data.Schedule{
+		{ID: 1, Frequency: data.FrequencyCron, Cron: "*/30 * * * *", StartAt: start, NextRunAt: &due, Status: data.ScheduleActive},
+	}}
+	handler := func(ctx context.Context, schedule *data.Schedule, reference string) (string, error) {
+		return "", data.ErrInsufficientFunds
+	}
+	runOnce(store, handler, Config{BatchSize: 10, PollInterval: time.Hour, Lease: time.Minute, RunTimeout: time.Second, RetryDelay: time.Hour, MaxRetries: 3})
+
+	if run := store.runs[0]; run.Status != data.RunFailed {
+		t.Errorf("got %+v", run)
+	}
+	if schedule := store.schedules[0]; schedule.Retries != 0 || schedule.Occurrence != 1 || schedule.NextRunAt.Sub(time.Now()) > 30*time.Minute {
+		t.Errorf("got %+v, next %s", schedule, schedule.NextRunAt)
+	}
+}
@@ -1,9 +1,9 @@
 package thirdparty
 
 import (
-	"encoding/json"
-	"errors"
+	"context"
 	"net/http"
+	"time"
 
 	"github.com/ebitezion/backend-framework/internal/data"
 	"github.com/go-resty/resty/v2"
@@ -18,12 +18,11 @@ type Account struct {
 	BVN         string
 }
 
-const (
-	ACCOUNT_CREATION_URL                = ""
-	ACCOUNT_HISTORY_WITH_PAGINATION_URL = ""
-	BALANCE_ENQUIRY_URL                 = ""
-
-	ACCOUNT_DETAILS_URL = ""
+// Account endpoints. Opening an account isn't retried; the enquiries are.
+var (
+	createAccountEndpoint  = endpoint{name: "create account", timeout: 15 * time.Second}
+	balanceEnquiryEndpoint = endpoint{name: "balance enquiry", timeout: 10 * time.Second, idempotent: true}
+	accountDetailsEndpoint = endpoint{name: "account details", timeout: 10 * time.Second, idempotent: true}
 )
 
 // initiatize and populate the Account_creation struct
@@ -39,7 +38,7 @@ func New(Surname, FirstName, HomeAddress, City, PhoneNumber, BVN string) *Accoun
 }
 
 // CreateBankAccount creates an account for app users using orbit API
-func CreateBankAccount(account *Account) (*resty.Response, error) {
+func CreateBankAccount(ctx context.Context, account *Account) (*resty.Response, error) {
 	requestData := map[string]interface{}{
 		"surname":     account.Surname,
 		"firstName":   account.FirstName,
@@ -49,14 +48,12 @@ func CreateBankAccount(account *Account) (*resty.Response, error) {
 		"bvn":         account.BVN,
 	}
 
-	// Making HTTP request using resty library
-	response, err := resty.New().R().
-		SetHeader("Content-Type", "application/json").
-		SetHeader("Accept", "application/json").
-		SetBody(requestData).
-		Post(ACCOUNT_CREATION_URL)
-
-	return response, err
+	return httpClient.do(ctx, createAccountEndpoint, request{
+		method:      http.MethodPost,
+		url:         httpClient.config.AccountCreationURL,
+		body:        requestData,
+		credentials: httpClient.config.Orbit,
+	})
 }
 
 // Transaction represents a single transaction in the account history
@@ -77,57 +74,51 @@ type Response struct {
 	AccountHistory  []Transaction `json:"accountHistory"`
 }
 
-func BalanceEnquiryApi(data *data.AccountNumber) (*resty.Response, error) {
+func BalanceEnquiryApi(ctx context.Context, data *data.AccountNumber) (*resty.Response, error) {
 	requestData := map[string]interface{}{
 		"accountNumber": data.AccountNumber,
 	}
 
-	// Making HTTP request using resty library
-	response, err := resty.New().R().
-		SetHeader("Content-Type", "application/json").
-		SetHeader("Accept", "application/json").
-		SetBody(requestData).
-		Post(BALANCE_ENQUIRY_URL)
-
-	return response, err
+	return httpClient.do(ctx, balanceEnquiryEndpoint, request{
+		method:      http.MethodPost,
+		url:         httpClient.config.BalanceEnquiryURL,
+		body:        requestData,
+		credentials: httpClient.config.Orbit,
+	})
 
 }
 
-func AccountDetailsApi(data *data.AccountNumber) (*resty.Response, error) {
+func AccountDetailsApi(ctx context.Context, data *data.AccountNumber) (*resty.Response, error) {
 
 	requestData := map[string]interface{}{
 		"accountNumber": data.AccountNumber,
 	}
 
-	// Making HTTP request using resty library
-	response, err := resty.New().R().
-		SetHeader("Content-Type", "application/json").
-		SetHeader("Accept", "application/json").
-		SetBody(requestData).
-		Post(ACCOUNT_DETAILS_URL)
-
-	return response, err
+	return httpClient.do(ctx, accountDetailsEndpoint, request{
+		method:      http.MethodPost,
+		url:         httpClient.config.AccountDetailsURL,
+		body:        requestData,
+		credentials: httpClient.config.Orbit,
+	})
 
 }
-func AccountDetails2Api(account string) (*resty.Response, error) {
+func AccountDetails2Api(ctx context.Context, account string) (*resty.Response, error) {
 
 	requestData := map[string]interface{}{
 		"accountNumber": account,
 	}
 
-	// Making HTTP request using resty library
-	response, err := resty.New().R().
-		SetHeader("Content-Type", "application/json").
-		SetHeader("Accept", "application/json").
-		SetBody(requestData).
-		Post(ACCOUNT_DETAILS_URL)
-
-	return response, err
+	return httpClient.do(ctx, accountDetailsEndpoint, request{
+		method:      http.MethodPost,
+		url:         httpClient.config.AccountDetailsURL,
+		body:        requestData,
+		credentials: httpClient.config.Orbit,
+	})
 
 }
 
 
-func CreateBankAccount2(account *Account) ([]byte, error) {
+func CreateBankAccount2(ctx context.Context, account *Account) ([]byte, error) {
 
 	requestData := map[string]string{
 		"surname":     account.Surname,
@@ -137,28 +128,5 @@ func CreateBankAccount2(account *Account) ([]byte, error) {
 		"phoneNumber": account.PhoneNumber,
 		"bvn":         account.BVN,
 	}
-	// Convert request data to JSON
-	reqBody, err := json.Marshal(requestData)
-	if err != nil {
-		return nil, err
-	}
-
-	// Perform HTTP POST request using the global HTTP client
-	resp, err := httpClient.R().
-		SetHeader("Content-Type", "application/json").
-		SetHeader("Accept", "application/json").
-		SetBody(reqBody).
-		Post(ACCOUNT_CREATION_URL)
-	if err != nil {
-		return nil, err
-	}
-
-	// Check for errors in the response status code
-	if resp.StatusCode() != http.StatusOK {
-		return nil, errors.New("Unexpected status code")
-	}
-
-	// Return the response body
-	return resp.Body(), nil
-
+	return post(ctx, createAccountEndpoint, httpClient.config.AccountCreationURL, httpClient.config.Orbit, requestData)
 }
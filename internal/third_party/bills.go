@@ -0,0 +1,145 @@
+package thirdparty
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Biller is a biller on the bills API, with the products it sells.
+type Biller struct {
+	ID       string `json:"billerId"`
+	Name     string `json:"billerName"`
+	Category string `json:"category"`
+	// CustomerField names what the biller knows its customers by, such as a phone
+	// number or meter number.
+	CustomerField string          `json:"customerField"`
+	Products      []BillerProduct `json:"products"`
+}
+
+// BillerProduct is something a biller sells. Amount is its fixed price, or empty if
+// the customer chooses how much to pay.
+type BillerProduct struct {
+	Code   string `json:"productCode"`
+	Name   string `json:"productName"`
+	Amount string `json:"amount"`
+}
+
+type BillCustomerRequest struct {
+	BillerID   string `json:"billerId"`
+	CustomerID string `json:"customerId"`
+}
+
+// BillCustomerResponse is the customer a biller has under the customer ID.
+type BillCustomerResponse struct {
+	ResponseCode string `json:"responseCode"`
+	CustomerID   string `json:"customerId"`
+	CustomerName string `json:"customerName"`
+}
+
+type BillPaymentRequest struct {
+	BillerID         string `json:"billerId"`
+	ProductCode      string `json:"productCode"`
+	CustomerID       string `json:"customerId"`
+	Amount           string `json:"amount"`
+	PaymentReference string `json:"paymentReference"`
+}
+
+// BillPaymentResponse is a bill payment the biller accepted. Token is what the
+// customer needs to use what they paid for, such as a prepaid electricity token;
+// most payments have none.
+type BillPaymentResponse struct {
+	ResponseCode     string `json:"responseCode"`
+	PaymentReference string `json:"paymentReference"`
+	BillerReference  string `json:"billerReference"`
+	Token            string `json:"token"`
+}
+
+// Bills API paths, relative to the bills base URL.
+const (
+	GetBillersPath           = "/billers"
+	ValidateBillCustomerPath = "/validateCustomer"
+	PayBillPath              = "/pay"
+	BillPaymentStatusPath    = "/paymentStatus"
+)
+
+// Bills endpoints. Like a transfer, a payment is never retried; the lookups are.
+var (
+	getBillersEndpoint        = endpoint{name: "get billers", timeout: 10 * time.Second, idempotent: true}
+	validateCustomerEndpoint  = endpoint{name: "validate bill customer", timeout: 10 * time.Second, idempotent: true}
+	payBillEndpoint           = endpoint{name: "pay bill", timeout: 30 * time.Second}
+	billPaymentStatusEndpoint = endpoint{name: "bill payment status", timeout: 10 * time.Second, idempotent: true}
+)
+
+// GetBillers returns the billers that can be paid through the bills API.
+func GetBillers(ctx context.Context) ([]Biller, error) {
+	resp, err := httpClient.do(ctx, getBillersEndpoint, request{
+		method:      http.MethodGet,
+		url:         httpClient.config.BillsBaseURL + GetBillersPath,
+		credentials: httpClient.config.Bills,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var list struct {
+		ResponseCode string   `json:"responseCode"`
+		Billers      []Biller `json:"billers"`
+	}
+	err = decodeResponse(getBillersEndpoint.name, resp.StatusCode(), resp.Body(), &list, &list.ResponseCode)
+	if err != nil {
+		return nil, err
+	}
+	return list.Billers, nil
+}
+
+// ValidateBillCustomer looks up a customer of a biller. A customer the biller doesn't
+// know comes back as an APIError that is Declined.
+func ValidateBillCustomer(ctx context.Context, requestData *BillCustomerRequest) (*BillCustomerResponse, error) {
+	body, err := post(ctx, validateCustomerEndpoint, httpClient.config.BillsBaseURL+ValidateBillCustomerPath, httpClient.config.Bills, requestData)
+	if err != nil {
+		return nil, err
+	}
+
+	var customer BillCustomerResponse
+	err = decodeResponse(validateCustomerEndpoint.name, http.StatusOK, body, &customer, &customer.ResponseCode)
+	if err != nil {
+		return nil, err
+	}
+	return &customer, nil
+}
+
+// PayBill pays a biller. As with FundsTransferCredit, a payment the biller turns down
+// comes back as an APIError that is Declined, and one whose outcome isn't known as
+// an APIError that is Indeterminate, to be settled with BillPaymentStatus.
+func PayBill(ctx context.Context, requestData *BillPaymentRequest) (*BillPaymentResponse, error) {
+	body, err := post(ctx, payBillEndpoint, httpClient.config.BillsBaseURL+PayBillPath, httpClient.config.Bills, requestData)
+	if err != nil {
+		return nil, err
+	}
+
+	var payment BillPaymentResponse
+	err = decodeResponse(payBillEndpoint.name, http.StatusOK, body, &payment, &payment.ResponseCode)
+	if err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// BillPaymentStatus asks for the outcome of the bill payment made with the given
+// payment reference.
+func BillPaymentStatus(ctx context.Context, paymentReference string) (*BillPaymentResponse, error) {
+	body, err := post(ctx, billPaymentStatusEndpoint, httpClient.config.BillsBaseURL+BillPaymentStatusPath, httpClient.config.Bills, map[string]string{
+		"paymentReference": paymentReference,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var payment BillPaymentResponse
+	err = decodeResponse(billPaymentStatusEndpoint.name, http.StatusOK, body, &payment, &payment.ResponseCode)
+	if err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
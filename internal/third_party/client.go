@@ -0,0 +1,213 @@
+package thirdparty
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ebitezion/backend-framework/internal/metrics"
+	"github.com/go-resty/resty/v2"
+)
+
+// RetryPolicy controls how idempotent calls are retried. Each retry waits for an
+// exponentially growing delay, with jitter, capped at MaxDelay.
+type RetryPolicy struct {
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// BreakerSettings controls the circuit breaker kept for each upstream. After
+// Threshold consecutive failures the circuit opens and calls fail straight away for
+// Cooldown; then a single call is let through to probe whether the upstream has
+// recovered.
+type BreakerSettings struct {
+	Threshold int
+	Cooldown  time.Duration
+}
+
+// Client makes the calls to third-party APIs. Every call has its own timeout, and a
+// call either gets a 2xx response or returns an *APIError.
+type Client struct {
+	Retry   RetryPolicy
+	Breaker BreakerSettings
+
+	http   *resty.Client
+	config Config
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+// NewClient returns a client with the default retry policy and breaker settings.
+func NewClient() *Client {
+	return &Client{
+		Retry:   RetryPolicy{Attempts: 3, BaseDelay: 100 * time.Millisecond, MaxDelay: 2 * time.Second},
+		Breaker: BreakerSettings{Threshold: 5, Cooldown: 30 * time.Second},
+		// The client timeout is only a backstop; each endpoint sets its own.
+		http:     metrics.InstrumentClient(resty.New().SetTimeout(time.Minute)),
+		breakers: map[string]*breaker{},
+	}
+}
+
+var httpClient = NewClient() // Global HTTP client
+
+// endpoint describes a third-party call. Only idempotent calls are retried, since a
+// call that timed out may still have been carried out.
+type endpoint struct {
+	name       string
+	timeout    time.Duration
+	idempotent bool
+}
+
+// request is a single call to an endpoint. The URL can contain {placeholders} for
+// pathParams, which keeps it usable as a metrics label.
+type request struct {
+	method      string
+	url         string
+	body        interface{}
+	pathParams  map[string]string
+	credentials Credentials
+}
+
+// do makes the call, retrying it if the endpoint allows. The response is returned
+// whenever the upstream answered, even with an error status.
+func (c *Client) do(ctx context.Context, ep endpoint, req request) (*resty.Response, error) {
+	u, err := url.Parse(req.url)
+	if err != nil || u.Host == "" {
+		return nil, &APIError{Op: ep.name, Err: ErrNotConfigured}
+	}
+
+	b := c.breaker(u.Host)
+	for attempt := 1; ; attempt++ {
+		if !b.allow(time.Now(), c.Breaker) {
+			return nil, &APIError{Op: ep.name, Err: ErrCircuitOpen}
+		}
+
+		resp, err := c.attempt(ctx, ep, req)
+		b.record(time.Now(), c.Breaker, !isFailure(err))
+		if err == nil || !ep.idempotent || attempt >= c.Retry.Attempts || !c.retryable(ctx, err) {
+			return resp, err
+		}
+
+		select {
+		case <-time.After(c.Retry.backoff(attempt)):
+		case <-ctx.Done():
+			return resp, err
+		}
+	}
+}
+
+func (c *Client) attempt(ctx context.Context, ep endpoint, req request) (*resty.Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, ep.timeout)
+	defer cancel()
+
+	r := c.http.R().
+		SetContext(ctx).
+		SetHeader("Accept", "application/json").
+		SetHeaders(req.credentials.headers()).
+		SetPathParams(req.pathParams)
+	if req.body != nil {
+		r.SetHeader("Content-Type", "application/json").SetBody(req.body)
+	}
+
+	resp, err := r.Execute(req.method, req.url)
+	if err != nil {
+		return nil, &APIError{Op: ep.name, Err: err}
+	}
+	if !resp.IsSuccess() {
+		return resp, newStatusError(ep.name, resp.StatusCode(), resp.Body())
+	}
+	return resp, nil
+}
+
+// retryable reports whether a failed attempt is worth repeating: the upstream didn't
+// answer (but the caller is still waiting), or it answered that it is busy or down.
+func (c *Client) retryable(ctx context.Context, err error) bool {
+	var apiErr *APIError
+	if ctx.Err() != nil || !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.StatusCode {
+	case 0:
+		return !errors.Is(apiErr.Err, ErrCircuitOpen)
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// isFailure reports whether err counts against the upstream's circuit. Errors the
+// upstream answered with below 500 are the caller's problem, not the upstream's.
+func isFailure(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return err != nil
+	}
+	return apiErr.StatusCode == 0 || apiErr.StatusCode >= http.StatusInternalServerError
+}
+
+// backoff returns the delay before the retry that follows attempt.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay << (attempt - 1)
+	if delay > p.MaxDelay || delay <= 0 {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// breaker returns the circuit breaker for the upstream host.
+func (c *Client) breaker(upstream string) *breaker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.breakers[upstream]
+	if !ok {
+		b = &breaker{}
+		c.breakers[upstream] = b
+	}
+	return b
+}
+
+// breaker is the circuit state for one upstream.
+type breaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// allow reports whether a call may go ahead. Once an open circuit has cooled down,
+// one probe is let through at a time until a call succeeds.
+func (b *breaker) allow(now time.Time, s BreakerSettings) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if s.Threshold <= 0 || b.failures < s.Threshold {
+		return true
+	}
+	if now.Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+func (b *breaker) record(now time.Time, s BreakerSettings, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if ok {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if s.Threshold > 0 && b.failures >= s.Threshold {
+		b.openUntil = now.Add(s.Cooldown)
+	}
+}
@@ -0,0 +1,125 @@
+package thirdparty
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ebitezion/backend-framework/internal/mock"
+)
+
+func TestPayments(t *testing.T) {
+	provider := mock.Start()
+	defer provider.Close()
+	ctx := context.Background()
+
+	payment := &Payment{AccountID: "0123456789", Reference: "ref-1", Amount: 100}
+	if _, err := CreatePayment(ctx, provider.URL, payment); err != nil {
+		t.Fatal(err)
+	}
+
+	// A duplicate comes back as a typed error with the upstream's status and body.
+	_, err := CreatePayment(ctx, provider.URL, payment)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusConflict || len(apiErr.Body) == 0 {
+		t.Fatalf("duplicate: got %v", err)
+	}
+	if apiErr.Timeout() || apiErr.Unreachable() {
+		t.Errorf("duplicate: got timeout %v, unreachable %v", apiErr.Timeout(), apiErr.Unreachable())
+	}
+
+	// Reads are retried through transient failures.
+	provider.Script(mock.Fault{Status: http.StatusServiceUnavailable}, mock.Fault{Status: http.StatusBadGateway})
+	got, err := GetPayment(ctx, provider.URL, "ref-1")
+	if err != nil || got.Amount != 100 {
+		t.Fatalf("get with retries: got %+v, %v", got, err)
+	}
+
+	// Payments aren't, since the first attempt may have gone through.
+	provider.Script(mock.Fault{Status: http.StatusServiceUnavailable})
+	_, err = CreatePayment(ctx, provider.URL, &Payment{Reference: "ref-2", Amount: 1})
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("create: got %v", err)
+	}
+	if provider.Payments() != 1 {
+		t.Errorf("provider has %d payments, want 1", provider.Payments())
+	}
+
+	provider.Script(mock.Fault{Timeout: true})
+	ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	_, err = GetPayment(ctx, provider.URL, "ref-1")
+	if !errors.As(err, &apiErr) || !apiErr.Timeout() || !apiErr.Unreachable() {
+		t.Errorf("timeout: got %v", err)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	provider := mock.Start()
+	defer provider.Close()
+	ctx := context.Background()
+
+	c := NewClient()
+	c.Breaker = BreakerSettings{Threshold: 2, Cooldown: 50 * time.Millisecond}
+	req := request{method: http.MethodGet, url: provider.URL + PaymentsPath + "/missing"}
+
+	// Answers below 500 don't count as failures.
+	for i := 0; i < 3; i++ {
+		_, err := c.do(ctx, pingEndpoint, req)
+		if err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("not found: got %v", err)
+		}
+	}
+
+	provider.Script(mock.Fault{Status: 500}, mock.Fault{Status: 500})
+	c.do(ctx, pingEndpoint, req)
+	c.do(ctx, pingEndpoint, req)
+	_, err := c.do(ctx, pingEndpoint, req)
+	var apiErr *APIError
+	if !errors.Is(err, ErrCircuitOpen) || !errors.As(err, &apiErr) || !apiErr.Unreachable() {
+		t.Fatalf("open circuit: got %v", err)
+	}
+
+	// Other upstreams are unaffected.
+	other := mock.Start()
+	defer other.Close()
+	if _, err := c.do(ctx, pingEndpoint, request{method: http.MethodPost, url: other.URL + PaymentsPath, body: Payment{Reference: "ref"}}); err != nil {
+		t.Errorf("other upstream: got %v", err)
+	}
+
+	// After the cooldown a probe goes through, and its success closes the circuit.
+	time.Sleep(60 * time.Millisecond)
+	provider.Script(mock.Fault{Status: 500})
+	if _, err := c.do(ctx, pingEndpoint, req); errors.Is(err, ErrCircuitOpen) {
+		t.Fatal("probe wasn't let through")
+	}
+	if _, err := c.do(ctx, pingEndpoint, req); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("failed probe: got %v, want the circuit to reopen", err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if _, err := c.do(ctx, pingEndpoint, req); errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("call %d after recovery: circuit still open", i)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{Attempts: 5, BaseDelay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond}
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{2, 100 * time.Millisecond, 200 * time.Millisecond},
+		{3, 150 * time.Millisecond, 300 * time.Millisecond},
+		{40, 150 * time.Millisecond, 300 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := p.backoff(tt.attempt); got < tt.min || got > tt.max {
+			t.Errorf("backoff(%d) = %v, want between %v and %v", tt.attempt, got, tt.min, tt.max)
+		}
+	}
+}
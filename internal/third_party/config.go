@@ -0,0 +1,256 @@
+package thirdparty
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+)
+
+// Credentials authenticate calls to an upstream. Whichever are set are sent as
+// headers on every call.
+type Credentials struct {
+	APIKey       string `yaml:"api_key" toml:"api_key"`
+	ClientID     string `yaml:"client_id" toml:"client_id"`
+	ClientSecret string `yaml:"client_secret" toml:"client_secret"`
+}
+
+func (c Credentials) headers() map[string]string {
+	headers := map[string]string{}
+	if c.APIKey != "" {
+		headers["X-Api-Key"] = c.APIKey
+	}
+	if c.ClientID != "" {
+		headers["X-Client-Id"] = c.ClientID
+	}
+	if c.ClientSecret != "" {
+		headers["X-Client-Secret"] = c.ClientSecret
+	}
+	return headers
+}
+
+// LogValue keeps the secrets out of logs, whichever handler they go through.
+func (c Credentials) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("api_key", redact(c.APIKey)),
+		slog.String("client_id", c.ClientID),
+		slog.String("client_secret", redact(c.ClientSecret)),
+	)
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "[REDACTED]"
+}
+
+// Config holds where the core banking (Orbit), interbank (NIP) and bills APIs live
+// and how to authenticate with them.
+type Config struct {
+	// Core banking endpoints, each a full URL.
+	AccountCreationURL string      `yaml:"account_creation_url" toml:"account_creation_url"`
+	AccountHistoryURL  string      `yaml:"account_history_url" toml:"account_history_url"`
+	BalanceEnquiryURL  string      `yaml:"balance_enquiry_url" toml:"balance_enquiry_url"`
+	AccountDetailsURL  string      `yaml:"account_details_url" toml:"account_details_url"`
+	InternalDebitURL   string      `yaml:"internal_debit_url" toml:"internal_debit_url"`
+	InternalCreditURL  string      `yaml:"internal_credit_url" toml:"internal_credit_url"`
+	ExternalDebitURL   string      `yaml:"external_debit_url" toml:"external_debit_url"`
+	Orbit              Credentials `yaml:"orbit" toml:"orbit"`
+
+	// NIPBaseURL is the interbank API, which the NIP paths (FundsTransferCreditEndpoint,
+	// GetBanksPath and NameEnquiryPath) are relative to.
+	NIPBaseURL string      `yaml:"nip_base_url" toml:"nip_base_url"`
+	NIP        Credentials `yaml:"nip" toml:"nip"`
+
+	// BillsBaseURL is the bills API, which the bills paths (GetBillersPath and so on)
+	// are relative to.
+	BillsBaseURL string      `yaml:"bills_base_url" toml:"bills_base_url"`
+	Bills        Credentials `yaml:"bills" toml:"bills"`
+
+	// TransactionLocation is the "latitude, longitude" reported with interbank
+	// transfers.
+	TransactionLocation string `yaml:"transaction_location" toml:"transaction_location"`
+}
+
+// ConfigFromEnv reads the configuration from environment variables, using getenv
+// (normally os.Getenv) to look them up.
+func ConfigFromEnv(getenv func(string) string) Config {
+	return Config{
+		AccountCreationURL: getenv("ORBIT_ACCOUNT_CREATION_URL"),
+		AccountHistoryURL:  getenv("ORBIT_ACCOUNT_HISTORY_URL"),
+		BalanceEnquiryURL:  getenv("ORBIT_BALANCE_ENQUIRY_URL"),
+		AccountDetailsURL:  getenv("ORBIT_ACCOUNT_DETAILS_URL"),
+		InternalDebitURL:   getenv("ORBIT_INTERNAL_DEBIT_URL"),
+		InternalCreditURL:  getenv("ORBIT_INTERNAL_CREDIT_URL"),
+		ExternalDebitURL:   getenv("ORBIT_EXTERNAL_DEBIT_URL"),
+		Orbit: Credentials{
+			APIKey:       getenv("ORBIT_API_KEY"),
+			ClientID:     getenv("ORBIT_CLIENT_ID"),
+			ClientSecret: getenv("ORBIT_CLIENT_SECRET"),
+		},
+		NIPBaseURL: getenv("NIP_BASE_URL"),
+		NIP: Credentials{
+			APIKey:       getenv("NIP_API_KEY"),
+			ClientID:     getenv("NIP_CLIENT_ID"),
+			ClientSecret: getenv("NIP_CLIENT_SECRET"),
+		},
+		BillsBaseURL: getenv("BILLS_BASE_URL"),
+		Bills: Credentials{
+			APIKey:       getenv("BILLS_API_KEY"),
+			ClientID:     getenv("BILLS_CLIENT_ID"),
+			ClientSecret: getenv("BILLS_CLIENT_SECRET"),
+		},
+		TransactionLocation: getenv("TRANSACTION_LOCATION"),
+	}
+}
+
+// Merge returns c with every setting that is set in other taking its place.
+func (c Config) Merge(other Config) Config {
+	set := func(field *string, value string) {
+		if value != "" {
+			*field = value
+		}
+	}
+	set(&c.AccountCreationURL, other.AccountCreationURL)
+	set(&c.AccountHistoryURL, other.AccountHistoryURL)
+	set(&c.BalanceEnquiryURL, other.BalanceEnquiryURL)
+	set(&c.AccountDetailsURL, other.AccountDetailsURL)
+	set(&c.InternalDebitURL, other.InternalDebitURL)
+	set(&c.InternalCreditURL, other.InternalCreditURL)
+	set(&c.ExternalDebitURL, other.ExternalDebitURL)
+	set(&c.Orbit.APIKey, other.Orbit.APIKey)
+	set(&c.Orbit.ClientID, other.Orbit.ClientID)
+	set(&c.Orbit.ClientSecret, other.Orbit.ClientSecret)
+	set(&c.NIPBaseURL, other.NIPBaseURL)
+	set(&c.NIP.APIKey, other.NIP.APIKey)
+	set(&c.NIP.ClientID, other.NIP.ClientID)
+	set(&c.NIP.ClientSecret, other.NIP.ClientSecret)
+	set(&c.BillsBaseURL, other.BillsBaseURL)
+	set(&c.Bills.APIKey, other.Bills.APIKey)
+	set(&c.Bills.ClientID, other.Bills.ClientID)
+	set(&c.Bills.ClientSecret, other.Bills.ClientSecret)
+	set(&c.TransactionLocation, other.TransactionLocation)
+	return c
+}
+
+// Paths that the mock provider serves the core banking, interbank and bills APIs on.
+const (
+	MockAccountCreationPath = "/orbit/accounts"
+	MockAccountHistoryPath  = "/orbit/accounts/history"
+	MockBalanceEnquiryPath  = "/orbit/accounts/balance"
+	MockAccountDetailsPath  = "/orbit/accounts/details"
+	MockInternalDebitPath   = "/orbit/transfers/debit"
+	MockInternalCreditPath  = "/orbit/transfers/credit"
+	MockExternalDebitPath   = "/orbit/transfers/external"
+	MockNIPPath             = "/nip"
+	MockBillsPath           = "/bills"
+)
+
+// WithMockDefaults points every endpoint that isn't set at the mock provider at
+// mockURL, and fills in a transaction location if there isn't one.
+func (c Config) WithMockDefaults(mockURL string) Config {
+	setDefault := func(field *string, value string) {
+		if *field == "" {
+			*field = value
+		}
+	}
+	setDefault(&c.AccountCreationURL, mockURL+MockAccountCreationPath)
+	setDefault(&c.AccountHistoryURL, mockURL+MockAccountHistoryPath)
+	setDefault(&c.BalanceEnquiryURL, mockURL+MockBalanceEnquiryPath)
+	setDefault(&c.AccountDetailsURL, mockURL+MockAccountDetailsPath)
+	setDefault(&c.InternalDebitURL, mockURL+MockInternalDebitPath)
+	setDefault(&c.InternalCreditURL, mockURL+MockInternalCreditPath)
+	setDefault(&c.ExternalDebitURL, mockURL+MockExternalDebitPath)
+	setDefault(&c.NIPBaseURL, mockURL+MockNIPPath)
+	setDefault(&c.BillsBaseURL, mockURL+MockBillsPath)
+	setDefault(&c.TransactionLocation, "6.625800, 3.334580")
+	return c
+}
+
+// Validate checks the configuration. Any URL that is set must be an absolute http
+// or https URL. In production every endpoint, a transaction location and
+// credentials for every upstream are required as well.
+func (c Config) Validate(env string) error {
+	var problems []string
+	check := func(name, value string) {
+		if value == "" {
+			if env == "production" {
+				problems = append(problems, name+" must be provided")
+			}
+			return
+		}
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, name+" must be an absolute http(s) URL")
+		}
+	}
+	check("ORBIT_ACCOUNT_CREATION_URL", c.AccountCreationURL)
+	check("ORBIT_ACCOUNT_HISTORY_URL", c.AccountHistoryURL)
+	check("ORBIT_BALANCE_ENQUIRY_URL", c.BalanceEnquiryURL)
+	check("ORBIT_ACCOUNT_DETAILS_URL", c.AccountDetailsURL)
+	check("ORBIT_INTERNAL_DEBIT_URL", c.InternalDebitURL)
+	check("ORBIT_INTERNAL_CREDIT_URL", c.InternalCreditURL)
+	check("ORBIT_EXTERNAL_DEBIT_URL", c.ExternalDebitURL)
+	check("NIP_BASE_URL", c.NIPBaseURL)
+	check("BILLS_BASE_URL", c.BillsBaseURL)
+
+	if env == "production" {
+		if c.TransactionLocation == "" {
+			problems = append(problems, "TRANSACTION_LOCATION must be provided")
+		}
+		if c.Orbit.APIKey == "" && c.Orbit.ClientSecret == "" {
+			problems = append(problems, "ORBIT_API_KEY or ORBIT_CLIENT_SECRET must be provided")
+		}
+		if c.NIP.APIKey == "" && c.NIP.ClientSecret == "" {
+			problems = append(problems, "NIP_API_KEY or NIP_CLIENT_SECRET must be provided")
+		}
+		if c.Bills.APIKey == "" && c.Bills.ClientSecret == "" {
+			problems = append(problems, "BILLS_API_KEY or BILLS_CLIENT_SECRET must be provided")
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid third-party configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Redacted returns a copy of the configuration with the API keys and client secrets
+// replaced, for printing.
+func (c Config) Redacted() Config {
+	for _, creds := range []*Credentials{&c.Orbit, &c.NIP, &c.Bills} {
+		creds.APIKey = redact(creds.APIKey)
+		creds.ClientSecret = redact(creds.ClientSecret)
+	}
+	return c
+}
+
+// LogValue logs where each endpoint points, with the credentials redacted.
+func (c Config) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("account_creation_url", c.AccountCreationURL),
+		slog.String("account_history_url", c.AccountHistoryURL),
+		slog.String("balance_enquiry_url", c.BalanceEnquiryURL),
+		slog.String("account_details_url", c.AccountDetailsURL),
+		slog.String("internal_debit_url", c.InternalDebitURL),
+		slog.String("internal_credit_url", c.InternalCreditURL),
+		slog.String("external_debit_url", c.ExternalDebitURL),
+		slog.Any("orbit", c.Orbit),
+		slog.String("nip_base_url", c.NIPBaseURL),
+		slog.Any("nip", c.NIP),
+		slog.String("bills_base_url", c.BillsBaseURL),
+		slog.Any("bills", c.Bills),
+	)
+}
+
+// Configure sets the endpoints and credentials used by the package's calls. It is
+// meant to be called once at startup, before any call is made.
+func Configure(cfg Config) {
+	httpClient.config = cfg
+}
+
+// ErrNotConfigured is the cause of an APIError for a call to an endpoint whose URL
+// hasn't been configured.
+var ErrNotConfigured = errors.New("endpoint not configured")
@@ -0,0 +1,117 @@
+package thirdparty
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ebitezion/backend-framework/internal/mock"
+)
+
+func TestConfigValidate(t *testing.T) {
+	env := map[string]string{
+		"ORBIT_ACCOUNT_CREATION_URL": "https://orbit.example.com/accounts",
+		"ORBIT_ACCOUNT_HISTORY_URL":  "https://orbit.example.com/history",
+		"ORBIT_BALANCE_ENQUIRY_URL":  "https://orbit.example.com/balance",
+		"ORBIT_ACCOUNT_DETAILS_URL":  "https://orbit.example.com/details",
+		"ORBIT_INTERNAL_DEBIT_URL":   "https://orbit.example.com/debit",
+		"ORBIT_INTERNAL_CREDIT_URL":  "https://orbit.example.com/credit",
+		"ORBIT_EXTERNAL_DEBIT_URL":   "https://orbit.example.com/external",
+		"ORBIT_API_KEY":              "orbit-key",
+		"NIP_BASE_URL":               "https://nip.example.com",
+		"NIP_CLIENT_ID":              "client",
+		"NIP_CLIENT_SECRET":          "nip-secret",
+		"BILLS_BASE_URL":             "https://bills.example.com",
+		"BILLS_API_KEY":              "bills-key",
+		"TRANSACTION_LOCATION":       "6.5, 3.3",
+	}
+	complete := ConfigFromEnv(func(key string) string { return env[key] })
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		env     string
+		problem string
+	}{
+		{"complete", complete, "production", ""},
+		{"empty in development", Config{}, "development", ""},
+		{"empty in production", Config{}, "production", "NIP_BASE_URL must be provided"},
+		{"no credentials", func() Config { c := complete; c.NIP = Credentials{ClientID: "client"}; return c }(), "production", "NIP_API_KEY or NIP_CLIENT_SECRET"},
+		{"relative URL", Config{NIPBaseURL: "/nip"}, "development", "NIP_BASE_URL must be an absolute"},
+		{"bad scheme", Config{AccountCreationURL: "ftp://orbit.example.com"}, "staging", "ORBIT_ACCOUNT_CREATION_URL must be an absolute"},
+		{"mock defaults", Config{}.WithMockDefaults("http://127.0.0.1:4100"), "production", "ORBIT_API_KEY"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate(tt.env)
+			switch {
+			case tt.problem == "" && err != nil:
+				t.Errorf("got %v", err)
+			case tt.problem != "" && (err == nil || !strings.Contains(err.Error(), tt.problem)):
+				t.Errorf("got %v, want it to mention %q", err, tt.problem)
+			}
+		})
+	}
+}
+
+func TestConfigLogValue(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	cfg := Config{Orbit: Credentials{APIKey: "orbit-key"}, NIP: Credentials{ClientID: "client", ClientSecret: "nip-secret"}}
+	logger.Info("configured", "upstreams", cfg)
+
+	for _, secret := range []string{"orbit-key", "nip-secret"} {
+		if strings.Contains(buf.String(), secret) {
+			t.Errorf("log contains %q: %s", secret, buf.String())
+		}
+	}
+	if !strings.Contains(buf.String(), `"client_id":"client"`) {
+		t.Errorf("log is missing the client ID: %s", buf.String())
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	defer Configure(httpClient.config)
+	ctx := context.Background()
+
+	// Calls to endpoints that aren't configured fail without going anywhere.
+	Configure(Config{})
+	_, err := GetBanks(ctx)
+	if !errors.Is(err, ErrNotConfigured) {
+		t.Fatalf("unconfigured: got %v", err)
+	}
+
+	// Credentials go out as headers.
+	var headers http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+		w.Write([]byte(`{"responseCode":"00","banks":[]}`))
+	}))
+	defer srv.Close()
+	Configure(Config{NIPBaseURL: srv.URL, NIP: Credentials{APIKey: "key", ClientID: "client", ClientSecret: "secret"}})
+	if _, err := GetBanks(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if headers.Get("X-Api-Key") != "key" || headers.Get("X-Client-Id") != "client" || headers.Get("X-Client-Secret") != "secret" {
+		t.Errorf("got headers %v", headers)
+	}
+
+	// The mock defaults cover every endpoint.
+	provider := mock.Start()
+	defer provider.Close()
+	Configure(Config{}.WithMockDefaults(provider.URL))
+	if _, err := NameEnquiry(ctx, &NameEnquiryRequest{AccountNumber: "0123456789", DestinationInstitutionCode: mock.Banks[0].Code}); err != nil {
+		t.Errorf("name enquiry: %v", err)
+	}
+	if _, err := OutwardTransfer(ctx, &FundsTransferCreditRequest{PaymentReference: "ref"}); err != nil {
+		t.Errorf("outward transfer: %v", err)
+	}
+	if _, err := SpectrumTransfer(ctx, NewTransfer("0123456789", "9876543210", "100", "0", "0", "rent")); err != nil {
+		t.Errorf("internal transfer: %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package thirdparty
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// ErrCircuitOpen is the cause of an APIError for a call that wasn't attempted because
+// the upstream has been failing.
+var ErrCircuitOpen = errors.New("circuit open")
+
+// ErrDeclined is the cause of an APIError for a call that the upstream answered
+// successfully at the HTTP level, but with a response code other than
+// ResponseCodeSuccess in the body.
+var ErrDeclined = errors.New("declined")
+
+// ResponseCodeSuccess is the response code of a call the upstream carried out.
+const ResponseCodeSuccess = "00"
+
+// APIError is returned for a call that failed, whether the upstream answered with
+// an unexpected status or never answered at all.
+type APIError struct {
+	// Op names the call, such as "create payment".
+	Op string
+	// StatusCode is the HTTP status the upstream answered with, or 0 if it didn't
+	// answer.
+	StatusCode int
+	// Code is the upstream's own response code from the body, if it sent one.
+	Code string
+	// Body is the response body. It can hold customer data, so it is for logs and
+	// debugging rather than for clients.
+	Body []byte
+	// Err is why there was no answer, when StatusCode is 0.
+	Err error
+}
+
+func (e *APIError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("thirdparty: %s: %v", e.Op, e.Err)
+	}
+	if errors.Is(e.Err, ErrDeclined) {
+		return fmt.Sprintf("thirdparty: %s: declined with response code %s", e.Op, e.Code)
+	}
+	if e.Err != nil {
+		return fmt.Sprintf("thirdparty: %s: %v", e.Op, e.Err)
+	}
+	if e.Code != "" {
+		return fmt.Sprintf("thirdparty: %s: unexpected status code %d (response code %s)", e.Op, e.StatusCode, e.Code)
+	}
+	return fmt.Sprintf("thirdparty: %s: unexpected status code %d", e.Op, e.StatusCode)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout reports whether the upstream, or a gateway in front of it, took too long
+// to answer.
+func (e *APIError) Timeout() bool {
+	if e.StatusCode == http.StatusGatewayTimeout || errors.Is(e.Err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(e.Err, &netErr) && netErr.Timeout()
+}
+
+// Unreachable reports whether the call got no answer from the upstream: the
+// connection failed, or the circuit was open and the call wasn't made.
+func (e *APIError) Unreachable() bool {
+	return e.StatusCode == 0
+}
+
+// Declined reports whether the upstream answered but turned the call down, for
+// example because the account it was about doesn't exist.
+func (e *APIError) Declined() bool {
+	return errors.Is(e.Err, ErrDeclined)
+}
+
+// pendingCodes are the interbank response codes that leave the outcome of a
+// transfer open: it is still being processed, or the upstream can't say.
+var pendingCodes = map[string]bool{
+	"01": true, // status unknown
+	"09": true, // request processing in progress
+	"96": true, // system malfunction
+	"97": true, // timeout waiting for response from destination
+}
+
+// Indeterminate reports whether a call that moves money may have been carried out
+// even though it didn't succeed: it timed out, the connection broke, the upstream
+// failed with a 5xx status, the answer couldn't be read, or the response code says
+// the outcome isn't known yet. The outcome of such a call has to be requeried
+// before it is treated as failed. A call that wasn't made because the circuit was
+// open is not indeterminate.
+func (e *APIError) Indeterminate() bool {
+	switch {
+	case errors.Is(e.Err, ErrCircuitOpen):
+		return false
+	case e.StatusCode == 0 || e.StatusCode >= 500:
+		return true
+	case e.Declined():
+		return pendingCodes[e.Code]
+	default:
+		// An answer with a success status that couldn't be decoded.
+		return e.StatusCode < 300 && e.Err != nil
+	}
+}
+
+// decodeResponse unmarshals a successful response body into v, and then checks the
+// response code that unmarshalling left in code.
+func decodeResponse(op string, status int, body []byte, v interface{}, code *string) error {
+	err := json.Unmarshal(body, v)
+	if err != nil {
+		return &APIError{Op: op, StatusCode: status, Body: body, Err: fmt.Errorf("decoding response: %w", err)}
+	}
+	if *code != ResponseCodeSuccess {
+		return &APIError{Op: op, StatusCode: status, Code: *code, Body: body, Err: ErrDeclined}
+	}
+	return nil
+}
+
+// newStatusError builds the error for an unexpected status, picking out the
+// upstream's response code from the body if it is JSON.
+func newStatusError(op string, status int, body []byte) *APIError {
+	var envelope struct {
+		ResponseCode string `json:"responseCode"`
+		Code         string `json:"code"`
+	}
+	_ = json.Unmarshal(body, &envelope)
+	code := envelope.ResponseCode
+	if code == "" {
+		code = envelope.Code
+	}
+	return &APIError{Op: op, StatusCode: status, Code: code, Body: body}
+}
@@ -0,0 +1,84 @@
+package thirdparty
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Payment mirrors the third-party provider's payment resource, see
+// POST /third-party/payments and GET /third-party/payments/:reference.
+type Payment struct {
+	AccountID string  `json:"account_id"`
+	Reference string  `json:"reference"`
+	Amount    float64 `json:"amount"`
+}
+
+// Payment endpoints, relative to the provider base URL.
+const (
+	PaymentsPath = "/third-party/payments"
+)
+
+// Payment endpoints. Creating a payment isn't retried: the provider rejects a
+// repeated reference, so a retry after a lost response would look like a failure.
+var (
+	createPaymentEndpoint = endpoint{name: "create payment", timeout: 10 * time.Second}
+	getPaymentEndpoint    = endpoint{name: "get payment", timeout: 5 * time.Second, idempotent: true}
+	pingEndpoint          = endpoint{name: "ping", timeout: 2 * time.Second}
+)
+
+// CreatePayment submits a payment to the third-party provider and returns the payment
+// as recorded by the provider.
+func CreatePayment(ctx context.Context, baseURL string, payment *Payment) (*Payment, error) {
+	resp, err := httpClient.do(ctx, createPaymentEndpoint, request{
+		method: http.MethodPost,
+		url:    baseURL + PaymentsPath,
+		body:   payment,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var created Payment
+	err = json.Unmarshal(resp.Body(), &created)
+	if err != nil {
+		return nil, &APIError{Op: createPaymentEndpoint.name, StatusCode: resp.StatusCode(), Body: resp.Body(), Err: err}
+	}
+	return &created, nil
+}
+
+// GetPayment retrieves a payment from the third-party provider by its reference.
+func GetPayment(ctx context.Context, baseURL, reference string) (*Payment, error) {
+	resp, err := httpClient.do(ctx, getPaymentEndpoint, request{
+		method:     http.MethodGet,
+		url:        baseURL + PaymentsPath + "/{reference}",
+		pathParams: map[string]string{"reference": reference},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var payment Payment
+	err = json.Unmarshal(resp.Body(), &payment)
+	if err != nil {
+		return nil, &APIError{Op: getPaymentEndpoint.name, StatusCode: resp.StatusCode(), Body: resp.Body(), Err: err}
+	}
+	return &payment, nil
+}
+
+// Ping checks that the provider at baseURL is reachable and not failing. Any
+// response below 500 counts as healthy, since the provider has no dedicated health
+// endpoint.
+func Ping(ctx context.Context, baseURL string) error {
+	_, err := httpClient.do(ctx, pingEndpoint, request{
+		method: http.MethodGet,
+		url:    baseURL + PaymentsPath,
+	})
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode != 0 && apiErr.StatusCode < http.StatusInternalServerError {
+		return nil
+	}
+	return err
+}
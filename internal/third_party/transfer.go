@@ -1,12 +1,9 @@
 package thirdparty
 
 import (
-	"encoding/json"
-	"errors"
-	"fmt"
+	"context"
 	"net/http"
-
-	"github.com/go-resty/resty/v2"
+	"time"
 	// "github.com/shopspring/decimal"
 )
 
@@ -19,6 +16,13 @@ type InternalTransaction struct {
 	Narration         string
 }
 
+// InternalTransferResponse is a transfer between two of our own accounts that core
+// banking carried out.
+type InternalTransferResponse struct {
+	ResponseCode         string `json:"responseCode"`
+	TransactionReference string `json:"transactionReference"`
+}
+
 type EasyPayTransfer struct {
 }
 
@@ -40,25 +44,59 @@ type FundsTransferCreditRequest struct {
 	Amount                            string `json:"amount"`
 }
 
+// FundsTransferCreditResponse is an interbank transfer the destination bank accepted.
+type FundsTransferCreditResponse struct {
+	ResponseCode     string `json:"responseCode"`
+	SessionID        string `json:"sessionID"`
+	PaymentReference string `json:"paymentReference"`
+}
+
+// TransactionStatus is the outcome of an earlier interbank transfer, as reported by a
+// transaction status query. A transfer that failed, or that never arrived, comes
+// back as an APIError that is Declined.
+type TransactionStatus struct {
+	ResponseCode     string `json:"responseCode"`
+	SessionID        string `json:"sessionID"`
+	PaymentReference string `json:"paymentReference"`
+}
+
 type NameEnquiryRequest struct {
 	AccountNumber              string `json:"accountNumber"`
 	DestinationInstitutionCode string `json:"destinationInstitutionCode"`
 	ChannelCode                string `json:"channelCode"`
 }
 
-// Internal Transfer
+// NameEnquiryResponse is the account a name enquiry resolved to. SessionID must be
+// sent back as the NameEnquiryRef of a transfer to the account.
+type NameEnquiryResponse struct {
+	ResponseCode               string `json:"responseCode"`
+	SessionID                  string `json:"sessionID"`
+	AccountNumber              string `json:"accountNumber"`
+	AccountName                string `json:"accountName"`
+	BankVerificationNumber     string `json:"bankVerificationNumber"`
+	KYCLevel                   string `json:"kycLevel"`
+	DestinationInstitutionCode string `json:"destinationInstitutionCode"`
+}
+
+// Bank is an institution on the interbank network.
+type Bank struct {
+	Code string `json:"bankCode"`
+	Name string `json:"bankName"`
+}
+
+// NIP channel codes, identifying where a transfer was initiated.
 const (
-	ORBIT_INTERNAL_DEBIT_TRANSFER  = ""
-	ORBIT_EXTERNAL_DEBIT_TRANSFER  = ""
-	ORBIT_INTERNAL_CREDIT_TRANSFER = ""
+	ChannelInternetBanking = "2"
+	ChannelMobile          = "3"
+	ChannelUSSD            = "8"
 )
 
-// External Transfer
+// External Transfer, relative to the NIP base URL.
 const (
-	BaseURL                     = ""
 	FundsTransferCreditEndpoint = "/fundsTransferCredit"
 	GetBanksPath                = "/getBanks"
 	NameEnquiryPath             = "/nameEnquiry"
+	TransactionStatusQueryPath  = "/transactionStatusQuery"
 )
 
 // initiatize and populate the Account_creation struct
@@ -73,10 +111,9 @@ func NewTransfer(SendersAccountNo, ReceiverAccountNo, Amount, Charge, Tax, Narra
 	}
 }
 
-var httpClient = resty.New() // Global HTTP client
-
-// Internal Transfer
-func SpectrumTransfer(tnx *InternalTransaction) ([]byte, error) {
+// SpectrumTransfer moves money between two of our own accounts on core banking. A
+// transfer core banking turns down comes back as an APIError that is Declined.
+func SpectrumTransfer(ctx context.Context, tnx *InternalTransaction) (*InternalTransferResponse, error) {
 	requestData := map[string]string{
 		"fromAccountNumber":      tnx.SendersAccountNo,
 		"toAccountNumber":        tnx.ReceiverAccountNo,
@@ -86,16 +123,21 @@ func SpectrumTransfer(tnx *InternalTransaction) ([]byte, error) {
 		"taxAmount":              tnx.Tax,
 	}
 
-	body, err := postRequest(requestData)
+	body, err := postRequest(ctx, requestData)
 	if err != nil {
 		return nil, err
 	}
 
-	return body, nil
+	var transfer InternalTransferResponse
+	err = decodeResponse(internalDebitEndpoint.name, http.StatusOK, body, &transfer, &transfer.ResponseCode)
+	if err != nil {
+		return nil, err
+	}
+	return &transfer, nil
 }
 
 // Internal Transfer To Ledger, credit ledger, debit user
-func SpectrumTransferToLedger(Amount, SendersAccountNo, Narration string) ([]byte, error) {
+func SpectrumTransferToLedger(ctx context.Context, Amount, SendersAccountNo, Narration string) ([]byte, error) {
 	requestData := map[string]string{
 		"fromAccountNumber":      SendersAccountNo,
 		"toAccountNumber":        "",
@@ -104,7 +146,7 @@ func SpectrumTransferToLedger(Amount, SendersAccountNo, Narration string) ([]byt
 		"chargeAmount":           "",
 		"taxAmount":              "",
 	}
-	body, err := postRequest(requestData)
+	body, err := postRequest(ctx, requestData)
 	if err != nil {
 		return nil, err
 	}
@@ -112,7 +154,7 @@ func SpectrumTransferToLedger(Amount, SendersAccountNo, Narration string) ([]byt
 }
 
 // Internal Transfer To Ledger, credit ledger, debit user
-func SpectrumTransferFromLedgerToAccountNo(Amount string, AccountNo, Narration string) ([]byte, error) {
+func SpectrumTransferFromLedgerToAccountNo(ctx context.Context, Amount string, AccountNo, Narration string) ([]byte, error) {
 
 	requestData := map[string]interface{}{
 		"accountNumber": AccountNo,
@@ -129,13 +171,13 @@ func SpectrumTransferFromLedgerToAccountNo(Amount string, AccountNo, Narration s
 	// 	"chargeAmount":           "",
 	// 	"taxAmount":              "",
 	// }
-	body, err := postCreditRequest(requestData)
+	body, err := postCreditRequest(ctx, requestData)
 	if err != nil {
 		return nil, err
 	}
 	return body, nil
 }
-func OutwardTransfer(tnx *FundsTransferCreditRequest) ([]byte, error) {
+func OutwardTransfer(ctx context.Context, tnx *FundsTransferCreditRequest) ([]byte, error) {
 	requestData := map[string]string{
 
 		"nameEnquiryRef":                    tnx.NameEnquiryRef,
@@ -149,13 +191,13 @@ func OutwardTransfer(tnx *FundsTransferCreditRequest) ([]byte, error) {
 		"originatorAccountNumber":           tnx.OriginatorAccountNumber,
 		"originatorBankVerificationNumber":  tnx.OriginatorBankVerificationNumber,
 		"originatorKYCLevel":                tnx.OriginatorKYCLevel,
-		"transactionLocation":               "6.625800, 3.334580",
+		"transactionLocation":               httpClient.config.TransactionLocation,
 		"narration":                         tnx.Narration,
 		"paymentReference":                  tnx.PaymentReference,
 		"amount":                            tnx.Amount,
 	}
 
-	body, err := postExternalRequest(requestData)
+	body, err := postExternalRequest(ctx, requestData)
 	if err != nil {
 		return nil, err
 	}
@@ -163,155 +205,117 @@ func OutwardTransfer(tnx *FundsTransferCreditRequest) ([]byte, error) {
 	return body, nil
 }
 
-// External Transfer
-func FundsTransferCredit(requestData *FundsTransferCreditRequest) ([]byte, error) {
-	// Convert request data to JSON
-	reqBody, err := json.Marshal(requestData)
-	if err != nil {
-		return nil, err
+// Transfer endpoints. Moving money is never retried; the lookups are.
+var (
+	internalDebitEndpoint       = endpoint{name: "internal transfer", timeout: 30 * time.Second}
+	externalDebitEndpoint       = endpoint{name: "outward transfer", timeout: 30 * time.Second}
+	internalCreditEndpoint      = endpoint{name: "ledger credit", timeout: 30 * time.Second}
+	fundsTransferCreditEndpoint = endpoint{name: "funds transfer credit", timeout: 30 * time.Second}
+	getBanksEndpoint            = endpoint{name: "get banks", timeout: 10 * time.Second, idempotent: true}
+	nameEnquiryEndpoint         = endpoint{name: "name enquiry", timeout: 10 * time.Second, idempotent: true}
+	statusQueryEndpoint         = endpoint{name: "transaction status query", timeout: 10 * time.Second, idempotent: true}
+)
+
+// FundsTransferCredit sends money to an account at another bank. A transfer the
+// destination bank turns down comes back as an APIError that is Declined; one whose
+// outcome isn't known comes back as an APIError that is Indeterminate, and has to be
+// settled with TransactionStatusQuery.
+func FundsTransferCredit(ctx context.Context, requestData *FundsTransferCreditRequest) (*FundsTransferCreditResponse, error) {
+	if requestData.TransactionLocation == "" {
+		requestData.TransactionLocation = httpClient.config.TransactionLocation
 	}
-	fmt.Println("Request Body:", requestData)
-
-	// Perform HTTP POST request using the global HTTP client
-	resp, err := httpClient.R().
-		SetHeader("Content-Type", "application/json").
-		SetHeader("Accept", "application/json").
-		SetBody(reqBody).
-		Post(BaseURL + FundsTransferCreditEndpoint)
+	body, err := post(ctx, fundsTransferCreditEndpoint, httpClient.config.NIPBaseURL+FundsTransferCreditEndpoint, httpClient.config.NIP, requestData)
 	if err != nil {
-		fmt.Println("Logging error from ext. tnx", err)
 		return nil, err
 	}
 
-	fmt.Println("Logging response from ext. tnx", resp)
-	// Check for errors in the response status code
-	if resp.StatusCode() != http.StatusOK {
-		return nil, errors.New("Unexpected status code")
-	}
-
-	// Return the response body
-	return resp.Body(), nil
-}
-
-// Get List of Banks and code
-func GetBanks() ([]byte, error) {
-	// Perform HTTP GET request using the global HTTP client
-	resp, err := httpClient.R().
-		SetHeader("Accept", "application/json").
-		Get(BaseURL + GetBanksPath)
+	var transfer FundsTransferCreditResponse
+	err = decodeResponse(fundsTransferCreditEndpoint.name, http.StatusOK, body, &transfer, &transfer.ResponseCode)
 	if err != nil {
 		return nil, err
 	}
-
-	// Check for errors in the response status code
-	if resp.StatusCode() != http.StatusOK {
-		return nil, errors.New("Unexpected status code")
-	}
-
-	// Return the response body
-	return resp.Body(), nil
+	return &transfer, nil
 }
 
-func NameEnquiry(requestData *NameEnquiryRequest) ([]byte, error) {
-	// Convert request data to JSON
-	reqBody, err := json.Marshal(requestData)
+// TransactionStatusQuery asks for the outcome of the interbank transfer sent with
+// the given payment reference.
+func TransactionStatusQuery(ctx context.Context, paymentReference string) (*TransactionStatus, error) {
+	body, err := post(ctx, statusQueryEndpoint, httpClient.config.NIPBaseURL+TransactionStatusQueryPath, httpClient.config.NIP, map[string]string{
+		"paymentReference": paymentReference,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	// Perform HTTP POST request using the global HTTP client
-	resp, err := httpClient.R().
-		SetHeader("Content-Type", "application/json").
-		SetHeader("Accept", "application/json").
-		SetBody(reqBody).
-		Post(BaseURL + NameEnquiryPath)
+	var status TransactionStatus
+	err = decodeResponse(statusQueryEndpoint.name, http.StatusOK, body, &status, &status.ResponseCode)
 	if err != nil {
 		return nil, err
 	}
-
-	// Check for errors in the response status code
-	if resp.StatusCode() != http.StatusOK {
-		return nil, errors.New("Unexpected status code")
-	}
-
-	// Return the response body
-	return resp.Body(), nil
+	return &status, nil
 }
 
-// Helpers
-func postRequest(data map[string]string) ([]byte, error) {
-	// Convert request data to JSON
-	reqBody, err := json.Marshal(data)
+// GetBanks returns the banks that can be sent money over the interbank network.
+func GetBanks(ctx context.Context) ([]Bank, error) {
+	resp, err := httpClient.do(ctx, getBanksEndpoint, request{
+		method:      http.MethodGet,
+		url:         httpClient.config.NIPBaseURL + GetBanksPath,
+		credentials: httpClient.config.NIP,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	// Perform HTTP POST request using the global HTTP client
-	resp, err := httpClient.R().
-		SetHeader("Content-Type", "application/json").
-		SetHeader("Accept", "application/json").
-		SetBody(reqBody).
-		Post(ORBIT_INTERNAL_DEBIT_TRANSFER)
+	var list struct {
+		ResponseCode string `json:"responseCode"`
+		Banks        []Bank `json:"banks"`
+	}
+	err = decodeResponse(getBanksEndpoint.name, resp.StatusCode(), resp.Body(), &list, &list.ResponseCode)
 	if err != nil {
 		return nil, err
 	}
-
-	// Check for errors in the response status code
-	if resp.StatusCode() != http.StatusOK {
-		return nil, errors.New("Unexpected status code")
-	}
-
-	// Return the response body
-	return resp.Body(), nil
+	return list.Banks, nil
 }
 
-func postExternalRequest(data map[string]string) ([]byte, error) {
-	// Convert request data to JSON
-	reqBody, err := json.Marshal(data)
+// NameEnquiry looks up the name on an account at another bank. An account that
+// doesn't exist comes back as an APIError that is Declined.
+func NameEnquiry(ctx context.Context, requestData *NameEnquiryRequest) (*NameEnquiryResponse, error) {
+	body, err := post(ctx, nameEnquiryEndpoint, httpClient.config.NIPBaseURL+NameEnquiryPath, httpClient.config.NIP, requestData)
 	if err != nil {
 		return nil, err
 	}
 
-	// Perform HTTP POST request using the global HTTP client
-	resp, err := httpClient.R().
-		SetHeader("Content-Type", "application/json").
-		SetHeader("Accept", "application/json").
-		SetBody(reqBody).
-		Post(ORBIT_EXTERNAL_DEBIT_TRANSFER)
+	var enquiry NameEnquiryResponse
+	err = decodeResponse(nameEnquiryEndpoint.name, http.StatusOK, body, &enquiry, &enquiry.ResponseCode)
 	if err != nil {
 		return nil, err
 	}
+	return &enquiry, nil
+}
 
-	// Check for errors in the response status code
-	if resp.StatusCode() != http.StatusOK {
-		return nil, errors.New("Unexpected status code")
-	}
+// Helpers
+func postRequest(ctx context.Context, data map[string]string) ([]byte, error) {
+	return post(ctx, internalDebitEndpoint, httpClient.config.InternalDebitURL, httpClient.config.Orbit, data)
+}
 
-	// Return the response body
-	return resp.Body(), nil
+func postExternalRequest(ctx context.Context, data map[string]string) ([]byte, error) {
+	return post(ctx, externalDebitEndpoint, httpClient.config.ExternalDebitURL, httpClient.config.Orbit, data)
+}
+
+func postCreditRequest(ctx context.Context, data map[string]interface{}) ([]byte, error) {
+	return post(ctx, internalCreditEndpoint, httpClient.config.InternalCreditURL, httpClient.config.Orbit, data)
 }
-func postCreditRequest(data map[string]interface{}) ([]byte, error) {
-	// Convert request data to JSON
-	reqBody, err := json.Marshal(data)
-	if err != nil {
-		return nil, err
-	}
 
-	// Perform HTTP POST request using the global HTTP client
-	resp, err := httpClient.R().
-		SetHeader("Content-Type", "application/json").
-		SetHeader("Accept", "application/json").
-		SetBody(reqBody).
-		Post(ORBIT_INTERNAL_CREDIT_TRANSFER)
+// post sends body as JSON and returns the response body.
+func post(ctx context.Context, ep endpoint, url string, credentials Credentials, body interface{}) ([]byte, error) {
+	resp, err := httpClient.do(ctx, ep, request{
+		method:      http.MethodPost,
+		url:         url,
+		body:        body,
+		credentials: credentials,
+	})
 	if err != nil {
 		return nil, err
 	}
-
-	// Check for errors in the response status code
-	if resp.StatusCode() != http.StatusOK {
-		return nil, errors.New("Unexpected status code")
-	}
-
-	// Return the response body
 	return resp.Body(), nil
 }
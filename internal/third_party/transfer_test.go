@@ -0,0 +1,109 @@
+package thirdparty
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ebitezion/backend-framework/internal/mock"
+)
+
+func TestBanksAndNameEnquiry(t *testing.T) {
+	defer Configure(httpClient.config)
+	provider := mock.Start()
+	defer provider.Close()
+	Configure(Config{}.WithMockDefaults(provider.URL))
+	ctx := context.Background()
+
+	banks, err := GetBanks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(banks) != len(mock.Banks) || banks[0].Code != mock.Banks[0].Code || banks[0].Name != mock.Banks[0].Name {
+		t.Errorf("got banks %+v", banks)
+	}
+
+	enquiry, err := NameEnquiry(ctx, &NameEnquiryRequest{AccountNumber: "0123456789", DestinationInstitutionCode: banks[0].Code, ChannelCode: ChannelMobile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enquiry.AccountName != "MOCK BENEFICIARY 6789" || enquiry.SessionID == "" || enquiry.KYCLevel != "1" {
+		t.Errorf("got %+v", enquiry)
+	}
+
+	// An account the bank doesn't know is declined rather than failing outright.
+	_, err = NameEnquiry(ctx, &NameEnquiryRequest{AccountNumber: "0123", DestinationInstitutionCode: banks[0].Code})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || !apiErr.Declined() || apiErr.Code != "07" {
+		t.Errorf("unknown account: got %v", err)
+	}
+
+	// A body that can't be decoded is an error too, but not a decline.
+	provider.Script(mock.Fault{Malformed: true})
+	_, err = GetBanks(ctx)
+	if !errors.As(err, &apiErr) || apiErr.Declined() || apiErr.Unreachable() {
+		t.Errorf("malformed: got %v", err)
+	}
+}
+
+func TestFundsTransferCredit(t *testing.T) {
+	defer Configure(httpClient.config)
+	provider := mock.Start()
+	defer provider.Close()
+	Configure(Config{}.WithMockDefaults(provider.URL))
+	ctx := context.Background()
+
+	request := &FundsTransferCreditRequest{
+		DestinationInstitutionCode: mock.Banks[0].Code,
+		BeneficiaryAccountNumber:   "0123456789",
+		PaymentReference:           "IB0001",
+		Amount:                     "100.00",
+	}
+	sent, err := FundsTransferCredit(ctx, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent.SessionID == "" || sent.PaymentReference != "IB0001" {
+		t.Errorf("got %+v", sent)
+	}
+
+	status, err := TransactionStatusQuery(ctx, "IB0001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.SessionID != sent.SessionID {
+		t.Errorf("status: got %+v, want session %s", status, sent.SessionID)
+	}
+
+	// A transfer the network has no record of is a settled failure.
+	_, err = TransactionStatusQuery(ctx, "IB0002")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || !apiErr.Declined() || apiErr.Indeterminate() {
+		t.Errorf("unknown transfer: got %v", err)
+	}
+
+	// Whether a transfer went through can't be told from a lost answer, a server
+	// error or a pending code, but it can from an outright decline.
+	tests := []struct {
+		name          string
+		reference     string
+		fault         mock.Fault
+		indeterminate bool
+	}{
+		{"malformed", "IB0003", mock.Fault{Malformed: true}, true},
+		{"server error", "IB0004", mock.Fault{Status: 500}, true},
+		{"pending", "IB0005", mock.Fault{ResponseCode: "09"}, true},
+		{"declined", "IB0006", mock.Fault{ResponseCode: "51"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider.Script(tt.fault)
+			request.PaymentReference = tt.reference
+			_, err := FundsTransferCredit(ctx, request)
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.Indeterminate() != tt.indeterminate {
+				t.Errorf("got %v, want indeterminate %v", err, tt.indeterminate)
+			}
+		})
+	}
+}
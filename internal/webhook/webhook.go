@@ -0,0 +1,190 @@
+// Package webhook signs and delivers webhook payloads to partner endpoints, and
+// verifies the signatures on webhooks we receive.
+//
+// A signature is the hex HMAC-SHA256 of "<timestamp>.<body>" keyed with the shared
+// secret, sent as "sha256=<hex>". Including the timestamp in the signed content lets
+// the receiver reject replays of an old request.
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// Headers set on every outgoing delivery.
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderID        = "X-Webhook-Id"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+var (
+	ErrInvalidSignature = errors.New("webhook: invalid signature")
+	ErrStaleTimestamp   = errors.New("webhook: timestamp outside tolerance")
+	ErrPrivateAddress   = errors.New("webhook: endpoint resolves to a private address")
+)
+
+// reservedNets are the ranges, besides those net.IP classifies itself, that are not
+// reachable on the public internet.
+var reservedNets = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),
+	mustParseCIDR("100.64.0.0/10"),
+	mustParseCIDR("198.18.0.0/15"),
+	mustParseCIDR("240.0.0.0/4"),
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// publicIP reports whether ip is a public unicast address. Loopback, RFC 1918 and
+// unique local, link-local, multicast and reserved addresses are not.
+func publicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, n := range reservedNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewSecret returns a random signing secret for a new endpoint.
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// Sign returns the signature header value for body sent at timestamp.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks that signature is a valid signature of body at timestamp, and that
+// timestamp is within tolerance of now.
+func Verify(secret, signature, timestamp string, body []byte, tolerance time.Duration, now time.Time) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrStaleTimestamp
+	}
+	age := now.Sub(time.Unix(ts, 0))
+	if age > tolerance || age < -tolerance {
+		return ErrStaleTimestamp
+	}
+
+	if !strings.HasPrefix(signature, "sha256=") {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(signature), []byte(Sign(secret, ts, body))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Sender posts signed payloads to partner endpoints. Unless it allows private
+// addresses, it refuses to connect to anything but a public address, checked when
+// each connection is dialled so that a host re-pointed after registration, or a
+// redirect, can't reach internal services.
+type Sender struct {
+	client       *resty.Client
+	allowPrivate bool
+}
+
+// NewSender returns a Sender whose requests time out after timeout. allowPrivate lets
+// it deliver to loopback and private addresses, for development.
+func NewSender(timeout time.Duration, allowPrivate bool) *Sender {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return ErrPrivateAddress
+			}
+			return nil
+		}
+	}
+	transport := &http.Transport{
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: timeout,
+		MaxIdleConns:        100,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	return &Sender{
+		client:       resty.New().SetTimeout(timeout).SetTransport(transport),
+		allowPrivate: allowPrivate,
+	}
+}
+
+// CheckHost resolves host and returns ErrPrivateAddress if any of its addresses is
+// one the Sender won't deliver to.
+func (s *Sender) CheckHost(ctx context.Context, host string) error {
+	if s.allowPrivate {
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if !publicIP(addr.IP) {
+			return ErrPrivateAddress
+		}
+	}
+	return nil
+}
+
+// Send posts body to url, signed with secret. It returns the response status code,
+// which is zero if no response was received, and an error unless the endpoint
+// answered with a 2xx status.
+func (s *Sender) Send(ctx context.Context, url, secret, event, id string, body []byte) (int, error) {
+	timestamp := time.Now().Unix()
+
+	resp, err := s.client.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetHeader(HeaderEvent, event).
+		SetHeader(HeaderID, id).
+		SetHeader(HeaderTimestamp, strconv.FormatInt(timestamp, 10)).
+		SetHeader(HeaderSignature, Sign(secret, timestamp, body)).
+		SetBody(body).
+		Post(url)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode() < http.StatusOK || resp.StatusCode() >= http.StatusMultipleChoices {
+		return resp.StatusCode(), fmt.Errorf("webhook endpoint returned status %d", resp.StatusCode())
+	}
+	return resp.StatusCode(), nil
+}
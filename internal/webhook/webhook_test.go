@@ -0,0 +1,113 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"type":"transaction.completed"}`)
+	now := time.Unix(1700000000, 0)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	sig := Sign("secret", now.Unix(), body)
+
+	tests := []struct {
+		name      string
+		secret    string
+		signature string
+		timestamp string
+		body      []byte
+		want      error
+	}{
+		{"valid", "secret", sig, ts, body, nil},
+		{"wrong secret", "other", sig, ts, body, ErrInvalidSignature},
+		{"tampered body", "secret", sig, ts, []byte(`{}`), ErrInvalidSignature},
+		{"missing prefix", "secret", sig[len("sha256="):], ts, body, ErrInvalidSignature},
+		{"stale", "secret", sig, strconv.FormatInt(now.Add(-10*time.Minute).Unix(), 10), body, ErrStaleTimestamp},
+		{"not a number", "secret", sig, "yesterday", body, ErrStaleTimestamp},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.secret, tt.signature, tt.timestamp, tt.body, 5*time.Minute, now)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSenderSignsRequests(t *testing.T) {
+	body := []byte(`{"id":"evt_1"}`)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ := io.ReadAll(r.Body)
+		err := Verify("secret", r.Header.Get(HeaderSignature), r.Header.Get(HeaderTimestamp), got, time.Minute, time.Now())
+		if err != nil || r.Header.Get(HeaderEvent) != "transaction.completed" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	sender := NewSender(time.Second, true)
+
+	status, err := sender.Send(context.Background(), srv.URL, "secret", "transaction.completed", "evt_1", body)
+	if err != nil || status != http.StatusNoContent {
+		t.Errorf("Send: got status %d and error %v, want %d", status, err, http.StatusNoContent)
+	}
+
+	status, err = sender.Send(context.Background(), srv.URL, "wrong", "transaction.completed", "evt_1", body)
+	if err == nil || status != http.StatusUnauthorized {
+		t.Errorf("Send with wrong secret: got status %d and error %v, want %d and an error", status, err, http.StatusUnauthorized)
+	}
+}
+
+func TestSenderRefusesPrivateAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request reached a loopback endpoint")
+	}))
+	defer srv.Close()
+
+	sender := NewSender(time.Second, false)
+	_, err := sender.Send(context.Background(), srv.URL, "secret", "transaction.completed", "evt_1", []byte(`{}`))
+	if !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("Send to loopback: got %v, want ErrPrivateAddress", err)
+	}
+	if err := sender.CheckHost(context.Background(), "localhost"); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("CheckHost(localhost): got %v, want ErrPrivateAddress", err)
+	}
+}
+
+func TestPublicIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"100.64.0.1", false},
+		{"::ffff:127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := publicIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("publicIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
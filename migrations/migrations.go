@@ -0,0 +1,25 @@
+// Package migrations embeds the SQL migrations, so that the API binary can apply
+// them itself (see the -migrate flag) and knows which schema version it expects.
+// Each supported database has its own directory of migrations, numbered in step.
+// The files follow golang-migrate's naming, so the migrate CLI works on them too.
+package migrations
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+)
+
+//go:embed mysql/*.sql postgres/*.sql
+var files embed.FS
+
+// FS returns the NNNNNN_name.up.sql and NNNNNN_name.down.sql files for the named
+// database driver, "mysql" or "postgres".
+func FS(driver string) (fs.FS, error) {
+	switch driver {
+	case "mysql", "postgres":
+		return fs.Sub(files, driver)
+	default:
+		return nil, fmt.Errorf("no migrations for database driver %q", driver)
+	}
+}
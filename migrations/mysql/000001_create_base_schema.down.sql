@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS mailing_list;
+DROP TABLE IF EXISTS transfer_tag;
+DROP TABLE IF EXISTS account_upgrade;
+DROP TABLE IF EXISTS limit_upgrade_requests;
+DROP TABLE IF EXISTS transactions;
+DROP TABLE IF EXISTS user_details;
+DROP TABLE IF EXISTS tokens;
+DROP TABLE IF EXISTS users_permissions;
+DROP TABLE IF EXISTS permissions;
+DROP TABLE IF EXISTS users;
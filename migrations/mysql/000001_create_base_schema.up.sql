@@ -0,0 +1,150 @@
+CREATE TABLE IF NOT EXISTS users (
+  id bigint(20) NOT NULL AUTO_INCREMENT,
+  name varchar(255) NOT NULL,
+  username varchar(255) NOT NULL,
+  email varchar(200) NOT NULL,
+  phone_number varchar(20) NOT NULL DEFAULT '',
+  password varchar(255) NOT NULL,
+  status varchar(20) NOT NULL DEFAULT 'pending',
+  activated tinyint(1) NOT NULL DEFAULT 0,
+  email_verified tinyint(1) NOT NULL DEFAULT 0,
+  phone_number_verified tinyint(1) NOT NULL DEFAULT 0,
+  bvn_verified tinyint(1) NOT NULL DEFAULT 0,
+  address_verified tinyint(1) NOT NULL DEFAULT 0,
+  account_upgraded tinyint(1) NOT NULL DEFAULT 0,
+  kyc_level int(11) NOT NULL DEFAULT 0,
+  is_spectrum_extra tinyint(1) NOT NULL DEFAULT 0,
+  source varchar(50) NOT NULL DEFAULT '',
+  device_id varchar(255) NOT NULL DEFAULT '',
+  device_os varchar(100) NOT NULL DEFAULT '',
+  device_name varchar(255) NOT NULL DEFAULT '',
+  created_at timestamp NOT NULL DEFAULT current_timestamp(),
+  version int(11) NOT NULL DEFAULT 1,
+  PRIMARY KEY (id),
+  UNIQUE KEY users_username_key (username),
+  UNIQUE KEY users_email_key (email),
+  KEY users_phone_number_idx (phone_number)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+CREATE TABLE IF NOT EXISTS permissions (
+  id bigint(20) NOT NULL AUTO_INCREMENT,
+  code varchar(100) NOT NULL,
+  PRIMARY KEY (id),
+  UNIQUE KEY permissions_code_key (code)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+CREATE TABLE IF NOT EXISTS users_permissions (
+  user_id bigint(20) NOT NULL,
+  permission_id bigint(20) NOT NULL,
+  PRIMARY KEY (user_id, permission_id),
+  KEY users_permissions_permission_id_idx (permission_id),
+  CONSTRAINT users_permissions_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+  CONSTRAINT users_permissions_permission_id_fk FOREIGN KEY (permission_id) REFERENCES permissions (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+CREATE TABLE IF NOT EXISTS tokens (
+  hash varbinary(32) NOT NULL,
+  user_id bigint(20) NOT NULL,
+  expiry datetime NOT NULL,
+  scope varchar(50) NOT NULL,
+  PRIMARY KEY (hash),
+  KEY tokens_user_id_scope_idx (user_id, scope),
+  CONSTRAINT tokens_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+CREATE TABLE IF NOT EXISTS user_details (
+  user_id bigint(20) NOT NULL,
+  account_number varchar(20) NOT NULL,
+  limits longtext NOT NULL CHECK (json_valid(limits)),
+  counter longtext NOT NULL CHECK (json_valid(counter)),
+  transaction_pin varchar(255) NOT NULL DEFAULT '',
+  ussd_pin_failures int(11) NOT NULL DEFAULT 0,
+  balance decimal(20,2) NOT NULL DEFAULT 0.00,
+  created_at timestamp NOT NULL DEFAULT current_timestamp(),
+  updated_at timestamp NOT NULL DEFAULT current_timestamp(),
+  PRIMARY KEY (user_id),
+  UNIQUE KEY user_details_account_number_key (account_number),
+  CONSTRAINT user_details_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+CREATE TABLE IF NOT EXISTS transactions (
+  id bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  user_id bigint(20) NOT NULL,
+  type varchar(50) NOT NULL,
+  source varchar(50) NOT NULL DEFAULT '',
+  narration varchar(255) NOT NULL DEFAULT '',
+  account_number varchar(20) NOT NULL,
+  request_id varchar(100) NOT NULL DEFAULT '',
+  internal_reference varchar(100) NOT NULL,
+  external_reference varchar(100) NULL,
+  amount decimal(20,2) NOT NULL,
+  commission decimal(20,2) NULL,
+  balance_after decimal(20,2) NULL,
+  status varchar(20) NOT NULL DEFAULT 'pending',
+  limit_channel varchar(20) NOT NULL DEFAULT '',
+  beneficiary_id bigint(20) NULL,
+  created_at timestamp NOT NULL DEFAULT current_timestamp(),
+  updated_at timestamp NOT NULL DEFAULT current_timestamp(),
+  debit_request_id varchar(100) GENERATED ALWAYS AS (CASE WHEN type = 'debit' AND request_id <> '' THEN request_id END) STORED,
+  PRIMARY KEY (id),
+  UNIQUE KEY transactions_internal_reference_key (internal_reference),
+  UNIQUE KEY transactions_account_number_request_id_key (account_number, debit_request_id),
+  KEY transactions_account_number_created_at_idx (account_number, created_at),
+  KEY transactions_user_id_idx (user_id),
+  KEY transactions_beneficiary_id_idx (beneficiary_id),
+  CONSTRAINT transactions_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+CREATE TABLE IF NOT EXISTS limit_upgrade_requests (
+  id bigint(20) NOT NULL AUTO_INCREMENT,
+  user_id bigint(20) NOT NULL,
+  type varchar(20) NOT NULL,
+  amount bigint(20) NOT NULL,
+  daily bigint(20) NOT NULL,
+  status varchar(20) NOT NULL DEFAULT 'pending',
+  created_at timestamp NOT NULL DEFAULT current_timestamp(),
+  PRIMARY KEY (id),
+  KEY limit_upgrade_requests_user_id_idx (user_id),
+  CONSTRAINT limit_upgrade_requests_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+CREATE TABLE IF NOT EXISTS account_upgrade (
+  id bigint(20) NOT NULL AUTO_INCREMENT,
+  title varchar(20) NOT NULL DEFAULT '',
+  first_name varchar(100) NOT NULL,
+  last_name varchar(100) NOT NULL,
+  middle_name varchar(100) NOT NULL DEFAULT '',
+  account_number varchar(20) NOT NULL,
+  bvn varchar(11) NOT NULL,
+  dob varchar(20) NOT NULL,
+  phone_number varchar(20) NOT NULL,
+  email varchar(200) NOT NULL,
+  maiden_name varchar(100) NOT NULL DEFAULT '',
+  nationality varchar(100) NOT NULL DEFAULT '',
+  country varchar(100) NOT NULL DEFAULT '',
+  address varchar(255) NOT NULL DEFAULT '',
+  id_type varchar(50) NOT NULL DEFAULT '',
+  document varchar(255) NOT NULL DEFAULT '',
+  created_at timestamp NOT NULL DEFAULT current_timestamp(),
+  PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+CREATE TABLE IF NOT EXISTS transfer_tag (
+  user_id bigint(20) NOT NULL,
+  transfer_tag varchar(50) NOT NULL,
+  account_number varchar(20) NOT NULL,
+  created_at timestamp NOT NULL DEFAULT current_timestamp(),
+  PRIMARY KEY (user_id),
+  UNIQUE KEY transfer_tag_transfer_tag_key (transfer_tag),
+  CONSTRAINT transfer_tag_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+CREATE TABLE IF NOT EXISTS mailing_list (
+  id bigint(20) NOT NULL AUTO_INCREMENT,
+  email varchar(200) NOT NULL,
+  created_at timestamp NOT NULL DEFAULT current_timestamp(),
+  PRIMARY KEY (id),
+  UNIQUE KEY mailing_list_email_key (email)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+INSERT INTO permissions (code) VALUES ('account:read'), ('account:write');
@@ -0,0 +1,15 @@
+DELETE users_permissions FROM users_permissions
+  INNER JOIN permissions ON permissions.id = users_permissions.permission_id
+  WHERE permissions.code = 'kyc:review';
+DELETE FROM permissions WHERE code = 'kyc:review';
+
+ALTER TABLE account_upgrade
+  DROP FOREIGN KEY account_upgrade_user_id_fk,
+  DROP KEY account_upgrade_user_id_idx,
+  DROP KEY account_upgrade_status_idx,
+  DROP COLUMN reviewed_at,
+  DROP COLUMN review_note,
+  DROP COLUMN reviewed_by,
+  DROP COLUMN status,
+  DROP COLUMN utility_bill,
+  DROP COLUMN user_id;
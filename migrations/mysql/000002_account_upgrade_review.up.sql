@@ -0,0 +1,12 @@
+ALTER TABLE account_upgrade
+  ADD COLUMN user_id bigint(20) NOT NULL AFTER id,
+  ADD COLUMN utility_bill varchar(255) NOT NULL DEFAULT '' AFTER document,
+  ADD COLUMN status varchar(20) NOT NULL DEFAULT 'pending',
+  ADD COLUMN reviewed_by bigint(20) NULL,
+  ADD COLUMN review_note text NULL,
+  ADD COLUMN reviewed_at timestamp NULL,
+  ADD KEY account_upgrade_status_idx (status),
+  ADD KEY account_upgrade_user_id_idx (user_id),
+  ADD CONSTRAINT account_upgrade_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+
+INSERT INTO permissions (code) VALUES ('kyc:review');
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS notification_preferences (
+  user_id bigint(20) NOT NULL,
+  email tinyint(1) NOT NULL DEFAULT 1,
+  sms tinyint(1) NOT NULL DEFAULT 1,
+  push tinyint(1) NOT NULL DEFAULT 1,
+  push_token varchar(255) NULL,
+  updated_at timestamp NOT NULL DEFAULT current_timestamp(),
+  PRIMARY KEY (user_id),
+  CONSTRAINT notification_preferences_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS outbox (
+  id bigint(20) NOT NULL AUTO_INCREMENT,
+  kind varchar(50) NOT NULL,
+  payload longtext NOT NULL,
+  status varchar(20) NOT NULL DEFAULT 'pending',
+  attempts int(11) NOT NULL DEFAULT 0,
+  next_attempt_at datetime NOT NULL,
+  last_error text NULL,
+  created_at timestamp NOT NULL DEFAULT current_timestamp(),
+  delivered_at datetime NULL,
+  PRIMARY KEY (id),
+  KEY outbox_status_next_attempt_at (status, next_attempt_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
@@ -0,0 +1,7 @@
+DELETE users_permissions FROM users_permissions
+  INNER JOIN permissions ON permissions.id = users_permissions.permission_id
+  WHERE permissions.code = 'webhooks:manage';
+DELETE FROM permissions WHERE code = 'webhooks:manage';
+
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhook_endpoints;
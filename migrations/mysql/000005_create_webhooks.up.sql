@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS webhook_endpoints (
+  id bigint(20) NOT NULL AUTO_INCREMENT,
+  user_id bigint(20) NOT NULL,
+  url varchar(2048) NOT NULL,
+  secret varchar(100) NOT NULL,
+  events varchar(255) NOT NULL,
+  created_at timestamp NOT NULL DEFAULT current_timestamp(),
+  PRIMARY KEY (id),
+  KEY webhook_endpoints_user_id_idx (user_id),
+  CONSTRAINT webhook_endpoints_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+  id bigint(20) NOT NULL AUTO_INCREMENT,
+  endpoint_id bigint(20) NOT NULL,
+  event_id varchar(50) NOT NULL,
+  event varchar(50) NOT NULL,
+  payload longtext NOT NULL,
+  status varchar(20) NOT NULL DEFAULT 'pending',
+  attempts int(11) NOT NULL DEFAULT 0,
+  response_status int(11) NULL,
+  last_error text NULL,
+  created_at timestamp NOT NULL DEFAULT current_timestamp(),
+  delivered_at timestamp NULL,
+  outbox_id bigint(20) NULL,
+  PRIMARY KEY (id),
+  KEY webhook_deliveries_endpoint_id_idx (endpoint_id),
+  CONSTRAINT webhook_deliveries_endpoint_id_fk FOREIGN KEY (endpoint_id) REFERENCES webhook_endpoints (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+INSERT INTO permissions (code) VALUES ('webhooks:manage');
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS provider_callbacks (
+  id bigint(20) NOT NULL AUTO_INCREMENT,
+  nonce varchar(100) NOT NULL DEFAULT '',
+  reference varchar(255) NOT NULL DEFAULT '',
+  status varchar(20) NOT NULL DEFAULT '',
+  signature varchar(255) NOT NULL DEFAULT '',
+  timestamp varchar(20) NOT NULL DEFAULT '',
+  payload longtext NOT NULL,
+  outcome varchar(20) NOT NULL,
+  error text NULL,
+  received_at timestamp NOT NULL DEFAULT current_timestamp(),
+  PRIMARY KEY (id),
+  KEY provider_callbacks_reference_idx (reference)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+CREATE TABLE IF NOT EXISTS provider_callback_nonces (
+  nonce varchar(100) NOT NULL,
+  created_at timestamp NOT NULL DEFAULT current_timestamp(),
+  PRIMARY KEY (nonce),
+  KEY provider_callback_nonces_created_at_idx (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS name_enquiries (
+  ref varchar(50) NOT NULL,
+  user_id bigint(20) NOT NULL,
+  account_number varchar(20) NOT NULL,
+  bank_code varchar(10) NOT NULL DEFAULT '',
+  bank_name varchar(255) NOT NULL DEFAULT '',
+  account_name varchar(255) NOT NULL,
+  internal tinyint(1) NOT NULL DEFAULT 0,
+  bvn varchar(11) NOT NULL DEFAULT '',
+  kyc_level varchar(5) NOT NULL DEFAULT '',
+  session_id varchar(100) NOT NULL DEFAULT '',
+  expires_at datetime NOT NULL,
+  created_at timestamp NOT NULL DEFAULT current_timestamp(),
+  PRIMARY KEY (ref),
+  KEY name_enquiries_expires_at_idx (expires_at),
+  CONSTRAINT name_enquiries_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
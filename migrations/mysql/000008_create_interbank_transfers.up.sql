@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS interbank_transfers (
+  reference varchar(100) NOT NULL,
+  user_id bigint(20) NOT NULL,
+  name_enquiry_ref varchar(50) NOT NULL,
+  session_id varchar(100) NOT NULL DEFAULT '',
+  bank_code varchar(10) NOT NULL,
+  bank_name varchar(255) NOT NULL DEFAULT '',
+  beneficiary_account_number varchar(20) NOT NULL,
+  beneficiary_account_name varchar(255) NOT NULL,
+  fee decimal(20,2) NOT NULL DEFAULT 0.00,
+  created_at timestamp NOT NULL DEFAULT current_timestamp(),
+  PRIMARY KEY (reference),
+  KEY interbank_transfers_user_id_idx (user_id),
+  CONSTRAINT interbank_transfers_reference_fk FOREIGN KEY (reference) REFERENCES transactions (internal_reference),
+  CONSTRAINT interbank_transfers_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS internal_transfers (
+  reference varchar(100) NOT NULL,
+  user_id bigint(20) NOT NULL,
+  recipient_user_id bigint(20) NOT NULL,
+  debit_reference varchar(100) NOT NULL,
+  credit_reference varchar(100) NOT NULL,
+  created_at timestamp NOT NULL DEFAULT current_timestamp(),
+  submitted_at timestamp NULL,
+  PRIMARY KEY (reference),
+  KEY internal_transfers_user_id_idx (user_id),
+  KEY internal_transfers_recipient_user_id_idx (recipient_user_id),
+  CONSTRAINT internal_transfers_debit_reference_fk FOREIGN KEY (debit_reference) REFERENCES transactions (internal_reference),
+  CONSTRAINT internal_transfers_credit_reference_fk FOREIGN KEY (credit_reference) REFERENCES transactions (internal_reference),
+  CONSTRAINT internal_transfers_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id),
+  CONSTRAINT internal_transfers_recipient_user_id_fk FOREIGN KEY (recipient_user_id) REFERENCES users (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
@@ -0,0 +1,2 @@
+ALTER TABLE transfer_tag
+  DROP COLUMN updated_at;
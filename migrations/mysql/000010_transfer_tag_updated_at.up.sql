@@ -0,0 +1,2 @@
+ALTER TABLE transfer_tag
+  ADD COLUMN updated_at timestamp NOT NULL DEFAULT current_timestamp() AFTER created_at;
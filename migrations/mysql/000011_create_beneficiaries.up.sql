@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS beneficiaries (
+  id bigint(20) NOT NULL AUTO_INCREMENT,
+  user_id bigint(20) NOT NULL,
+  type varchar(20) NOT NULL,
+  nickname varchar(50) NOT NULL DEFAULT '',
+  account_number varchar(20) NOT NULL DEFAULT '',
+  account_name varchar(255) NOT NULL,
+  bank_code varchar(20) NOT NULL,
+  bank_name varchar(255) NOT NULL,
+  tag varchar(50) NOT NULL DEFAULT '',
+  tag_account_number varchar(20) NOT NULL DEFAULT '',
+  usage_count int(11) NOT NULL DEFAULT 0,
+  last_used_at timestamp NULL,
+  cooling_off_until timestamp NULL,
+  created_at timestamp NOT NULL DEFAULT current_timestamp(),
+  PRIMARY KEY (id),
+  UNIQUE KEY beneficiaries_user_id_destination_key (user_id, type, bank_code, account_number, tag),
+  CONSTRAINT beneficiaries_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
@@ -0,0 +1,36 @@
+CREATE TABLE IF NOT EXISTS schedules (
+  id bigint(20) NOT NULL AUTO_INCREMENT,
+  user_id bigint(20) NOT NULL,
+  beneficiary_id bigint(20) NOT NULL,
+  type varchar(20) NOT NULL,
+  amount bigint(20) NOT NULL,
+  narration varchar(100) NOT NULL DEFAULT '',
+  frequency varchar(20) NOT NULL,
+  cron varchar(100) NOT NULL DEFAULT '',
+  start_at datetime NOT NULL,
+  end_at datetime NULL,
+  next_run_at datetime NULL,
+  occurrence int(11) NOT NULL DEFAULT 0,
+  retries int(11) NOT NULL DEFAULT 0,
+  status varchar(20) NOT NULL DEFAULT 'active',
+  created_at timestamp NOT NULL DEFAULT current_timestamp(),
+  PRIMARY KEY (id),
+  KEY schedules_user_id (user_id),
+  KEY schedules_status_next_run_at (status, next_run_at),
+  CONSTRAINT schedules_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+CREATE TABLE IF NOT EXISTS schedule_runs (
+  id bigint(20) NOT NULL AUTO_INCREMENT,
+  schedule_id bigint(20) NOT NULL,
+  occurrence int(11) NOT NULL,
+  attempt int(11) NOT NULL,
+  reference varchar(50) NOT NULL,
+  status varchar(20) NOT NULL,
+  transfer_reference varchar(50) NOT NULL DEFAULT '',
+  error varchar(255) NOT NULL DEFAULT '',
+  created_at timestamp NOT NULL DEFAULT current_timestamp(),
+  PRIMARY KEY (id),
+  KEY schedule_runs_schedule_id (schedule_id),
+  CONSTRAINT schedule_runs_schedule_id_fk FOREIGN KEY (schedule_id) REFERENCES schedules (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS bill_payments (
+  reference varchar(100) NOT NULL,
+  user_id bigint(20) NOT NULL,
+  provider varchar(50) NOT NULL,
+  biller_id varchar(100) NOT NULL,
+  biller_name varchar(255) NOT NULL DEFAULT '',
+  category varchar(20) NOT NULL,
+  product_code varchar(100) NOT NULL,
+  customer_id varchar(50) NOT NULL,
+  customer_name varchar(255) NOT NULL DEFAULT '',
+  biller_reference varchar(100) NOT NULL DEFAULT '',
+  token varchar(255) NOT NULL DEFAULT '',
+  created_at timestamp NOT NULL DEFAULT current_timestamp(),
+  PRIMARY KEY (reference),
+  KEY bill_payments_user_id_idx (user_id),
+  CONSTRAINT bill_payments_reference_fk FOREIGN KEY (reference) REFERENCES transactions (internal_reference),
+  CONSTRAINT bill_payments_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
@@ -0,0 +1,3 @@
+DROP VIEW IF EXISTS ledger_balances;
+DROP TABLE IF EXISTS ledger_entries;
+DROP TABLE IF EXISTS ledger_accounts;
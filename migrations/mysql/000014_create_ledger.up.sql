@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS ledger_accounts (
+  code varchar(50) NOT NULL,
+  name varchar(100) NOT NULL,
+  PRIMARY KEY (code)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+CREATE TABLE IF NOT EXISTS ledger_entries (
+  id bigint(20) NOT NULL AUTO_INCREMENT,
+  ledger_code varchar(50) NOT NULL,
+  reference varchar(100) NOT NULL,
+  type varchar(10) NOT NULL,
+  amount decimal(20,2) NOT NULL,
+  created_at timestamp NOT NULL DEFAULT current_timestamp(),
+  PRIMARY KEY (id),
+  KEY ledger_entries_reference_idx (reference),
+  KEY ledger_entries_ledger_code_idx (ledger_code),
+  CONSTRAINT ledger_entries_ledger_code_fk FOREIGN KEY (ledger_code) REFERENCES ledger_accounts (code)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+INSERT INTO ledger_accounts (code, name) VALUES
+  ('interbank_suspense', 'Interbank transfers awaiting settlement'),
+  ('interbank_settlement', 'Interbank settlement'),
+  ('bills_suspense', 'Bill payments awaiting settlement'),
+  ('bills_settlement', 'Biller settlement'),
+  ('fee_income', 'Fee income');
+
+CREATE OR REPLACE VIEW ledger_balances AS
+SELECT a.code, COALESCE(SUM(CASE WHEN e.type = 'credit' THEN e.amount ELSE -e.amount END), 0) AS balance
+FROM ledger_accounts a
+LEFT JOIN ledger_entries e ON e.ledger_code = a.code
+GROUP BY a.code;
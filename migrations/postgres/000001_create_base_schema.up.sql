@@ -0,0 +1,134 @@
+CREATE TABLE IF NOT EXISTS users (
+  id bigserial PRIMARY KEY,
+  name varchar(255) NOT NULL,
+  username varchar(255) NOT NULL,
+  email varchar(200) NOT NULL,
+  phone_number varchar(20) NOT NULL DEFAULT '',
+  password bytea NOT NULL,
+  status varchar(20) NOT NULL DEFAULT 'pending',
+  activated boolean NOT NULL DEFAULT false,
+  email_verified boolean NOT NULL DEFAULT false,
+  phone_number_verified boolean NOT NULL DEFAULT false,
+  bvn_verified boolean NOT NULL DEFAULT false,
+  address_verified boolean NOT NULL DEFAULT false,
+  account_upgraded boolean NOT NULL DEFAULT false,
+  kyc_level integer NOT NULL DEFAULT 0,
+  is_spectrum_extra boolean NOT NULL DEFAULT false,
+  source varchar(50) NOT NULL DEFAULT '',
+  device_id varchar(255) NOT NULL DEFAULT '',
+  device_os varchar(100) NOT NULL DEFAULT '',
+  device_name varchar(255) NOT NULL DEFAULT '',
+  created_at timestamptz NOT NULL DEFAULT now(),
+  version integer NOT NULL DEFAULT 1,
+  CONSTRAINT users_username_key UNIQUE (username),
+  CONSTRAINT users_email_key UNIQUE (email)
+);
+CREATE INDEX IF NOT EXISTS users_phone_number_idx ON users (phone_number);
+
+CREATE TABLE IF NOT EXISTS permissions (
+  id bigserial PRIMARY KEY,
+  code varchar(100) NOT NULL,
+  CONSTRAINT permissions_code_key UNIQUE (code)
+);
+
+CREATE TABLE IF NOT EXISTS users_permissions (
+  user_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  permission_id bigint NOT NULL REFERENCES permissions (id) ON DELETE CASCADE,
+  PRIMARY KEY (user_id, permission_id)
+);
+CREATE INDEX IF NOT EXISTS users_permissions_permission_id_idx ON users_permissions (permission_id);
+
+CREATE TABLE IF NOT EXISTS tokens (
+  hash bytea PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  expiry timestamptz NOT NULL,
+  scope varchar(50) NOT NULL
+);
+CREATE INDEX IF NOT EXISTS tokens_user_id_scope_idx ON tokens (user_id, scope);
+
+CREATE TABLE IF NOT EXISTS user_details (
+  user_id bigint PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+  account_number varchar(20) NOT NULL,
+  limits jsonb NOT NULL,
+  counter jsonb NOT NULL,
+  transaction_pin varchar(255) NOT NULL DEFAULT '',
+  ussd_pin_failures integer NOT NULL DEFAULT 0,
+  balance numeric(20,2) NOT NULL DEFAULT 0,
+  created_at timestamptz NOT NULL DEFAULT now(),
+  updated_at timestamptz NOT NULL DEFAULT now(),
+  CONSTRAINT user_details_account_number_key UNIQUE (account_number)
+);
+
+CREATE TABLE IF NOT EXISTS transactions (
+  id bigserial PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users (id),
+  type varchar(50) NOT NULL,
+  source varchar(50) NOT NULL DEFAULT '',
+  narration varchar(255) NOT NULL DEFAULT '',
+  account_number varchar(20) NOT NULL,
+  request_id varchar(100) NOT NULL DEFAULT '',
+  internal_reference varchar(100) NOT NULL,
+  external_reference varchar(100) NULL,
+  amount numeric(20,2) NOT NULL,
+  commission numeric(20,2) NULL,
+  balance_after numeric(20,2) NULL,
+  status varchar(20) NOT NULL DEFAULT 'pending',
+  limit_channel varchar(20) NOT NULL DEFAULT '',
+  beneficiary_id bigint NULL,
+  created_at timestamptz NOT NULL DEFAULT now(),
+  updated_at timestamptz NOT NULL DEFAULT now(),
+  CONSTRAINT transactions_internal_reference_key UNIQUE (internal_reference)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS transactions_account_number_request_id_key ON transactions (account_number, request_id)
+  WHERE type = 'debit' AND request_id <> '';
+CREATE INDEX IF NOT EXISTS transactions_account_number_created_at_idx ON transactions (account_number, created_at);
+CREATE INDEX IF NOT EXISTS transactions_user_id_idx ON transactions (user_id);
+CREATE INDEX IF NOT EXISTS transactions_beneficiary_id_idx ON transactions (beneficiary_id);
+
+CREATE TABLE IF NOT EXISTS limit_upgrade_requests (
+  id bigserial PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  type varchar(20) NOT NULL,
+  amount bigint NOT NULL,
+  daily bigint NOT NULL,
+  status varchar(20) NOT NULL DEFAULT 'pending',
+  created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS limit_upgrade_requests_user_id_idx ON limit_upgrade_requests (user_id);
+
+CREATE TABLE IF NOT EXISTS account_upgrade (
+  id bigserial PRIMARY KEY,
+  title varchar(20) NOT NULL DEFAULT '',
+  first_name varchar(100) NOT NULL,
+  last_name varchar(100) NOT NULL,
+  middle_name varchar(100) NOT NULL DEFAULT '',
+  account_number varchar(20) NOT NULL,
+  bvn varchar(11) NOT NULL,
+  dob varchar(20) NOT NULL,
+  phone_number varchar(20) NOT NULL,
+  email varchar(200) NOT NULL,
+  maiden_name varchar(100) NOT NULL DEFAULT '',
+  nationality varchar(100) NOT NULL DEFAULT '',
+  country varchar(100) NOT NULL DEFAULT '',
+  address varchar(255) NOT NULL DEFAULT '',
+  id_type varchar(50) NOT NULL DEFAULT '',
+  document varchar(255) NOT NULL DEFAULT '',
+  created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS transfer_tag (
+  user_id bigint PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+  transfer_tag varchar(50) NOT NULL,
+  account_number varchar(20) NOT NULL,
+  created_at timestamptz NOT NULL DEFAULT now(),
+  CONSTRAINT transfer_tag_transfer_tag_key UNIQUE (transfer_tag)
+);
+
+CREATE TABLE IF NOT EXISTS mailing_list (
+  id bigserial PRIMARY KEY,
+  email varchar(200) NOT NULL,
+  created_at timestamptz NOT NULL DEFAULT now(),
+  CONSTRAINT mailing_list_email_key UNIQUE (email)
+);
+
+INSERT INTO permissions (code) VALUES ('account:read'), ('account:write');
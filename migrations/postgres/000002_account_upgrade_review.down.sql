@@ -0,0 +1,12 @@
+DELETE FROM users_permissions USING permissions
+  WHERE permissions.id = users_permissions.permission_id
+  AND permissions.code = 'kyc:review';
+DELETE FROM permissions WHERE code = 'kyc:review';
+
+ALTER TABLE account_upgrade
+  DROP COLUMN reviewed_at,
+  DROP COLUMN review_note,
+  DROP COLUMN reviewed_by,
+  DROP COLUMN status,
+  DROP COLUMN utility_bill,
+  DROP COLUMN user_id;
@@ -0,0 +1,11 @@
+ALTER TABLE account_upgrade
+  ADD COLUMN user_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  ADD COLUMN utility_bill varchar(255) NOT NULL DEFAULT '',
+  ADD COLUMN status varchar(20) NOT NULL DEFAULT 'pending',
+  ADD COLUMN reviewed_by bigint NULL,
+  ADD COLUMN review_note text NULL,
+  ADD COLUMN reviewed_at timestamptz NULL;
+CREATE INDEX IF NOT EXISTS account_upgrade_status_idx ON account_upgrade (status);
+CREATE INDEX IF NOT EXISTS account_upgrade_user_id_idx ON account_upgrade (user_id);
+
+INSERT INTO permissions (code) VALUES ('kyc:review');
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS notification_preferences (
+  user_id bigint PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+  email boolean NOT NULL DEFAULT true,
+  sms boolean NOT NULL DEFAULT true,
+  push boolean NOT NULL DEFAULT true,
+  push_token varchar(255) NULL,
+  updated_at timestamptz NOT NULL DEFAULT now()
+);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS outbox (
+  id bigserial PRIMARY KEY,
+  kind varchar(50) NOT NULL,
+  payload text NOT NULL,
+  status varchar(20) NOT NULL DEFAULT 'pending',
+  attempts integer NOT NULL DEFAULT 0,
+  next_attempt_at timestamptz NOT NULL,
+  last_error text NULL,
+  created_at timestamptz NOT NULL DEFAULT now(),
+  delivered_at timestamptz NULL
+);
+CREATE INDEX IF NOT EXISTS outbox_status_next_attempt_at ON outbox (status, next_attempt_at);
@@ -0,0 +1,7 @@
+DELETE FROM users_permissions USING permissions
+  WHERE permissions.id = users_permissions.permission_id
+  AND permissions.code = 'webhooks:manage';
+DELETE FROM permissions WHERE code = 'webhooks:manage';
+
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhook_endpoints;
@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS webhook_endpoints (
+  id bigserial PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  url varchar(2048) NOT NULL,
+  secret varchar(100) NOT NULL,
+  events varchar(255) NOT NULL,
+  created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS webhook_endpoints_user_id_idx ON webhook_endpoints (user_id);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+  id bigserial PRIMARY KEY,
+  endpoint_id bigint NOT NULL REFERENCES webhook_endpoints (id) ON DELETE CASCADE,
+  event_id varchar(50) NOT NULL,
+  event varchar(50) NOT NULL,
+  payload text NOT NULL,
+  status varchar(20) NOT NULL DEFAULT 'pending',
+  attempts integer NOT NULL DEFAULT 0,
+  response_status integer NULL,
+  last_error text NULL,
+  created_at timestamptz NOT NULL DEFAULT now(),
+  delivered_at timestamptz NULL,
+  outbox_id bigint NULL
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_endpoint_id_idx ON webhook_deliveries (endpoint_id);
+
+INSERT INTO permissions (code) VALUES ('webhooks:manage');
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS provider_callback_nonces;
+DROP TABLE IF EXISTS provider_callbacks;
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS provider_callbacks (
+  id bigserial PRIMARY KEY,
+  nonce varchar(100) NOT NULL DEFAULT '',
+  reference varchar(255) NOT NULL DEFAULT '',
+  status varchar(20) NOT NULL DEFAULT '',
+  signature varchar(255) NOT NULL DEFAULT '',
+  timestamp varchar(20) NOT NULL DEFAULT '',
+  payload text NOT NULL,
+  outcome varchar(20) NOT NULL,
+  error text NULL,
+  received_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS provider_callbacks_reference_idx ON provider_callbacks (reference);
+
+CREATE TABLE IF NOT EXISTS provider_callback_nonces (
+  nonce varchar(100) PRIMARY KEY,
+  created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS provider_callback_nonces_created_at_idx ON provider_callback_nonces (created_at);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS name_enquiries (
+  ref varchar(50) PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  account_number varchar(20) NOT NULL,
+  bank_code varchar(10) NOT NULL DEFAULT '',
+  bank_name varchar(255) NOT NULL DEFAULT '',
+  account_name varchar(255) NOT NULL,
+  internal boolean NOT NULL DEFAULT false,
+  bvn varchar(11) NOT NULL DEFAULT '',
+  kyc_level varchar(5) NOT NULL DEFAULT '',
+  session_id varchar(100) NOT NULL DEFAULT '',
+  expires_at timestamptz NOT NULL,
+  created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS name_enquiries_expires_at_idx ON name_enquiries (expires_at);
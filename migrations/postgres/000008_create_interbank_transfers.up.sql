@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS interbank_transfers (
+  reference varchar(100) PRIMARY KEY REFERENCES transactions (internal_reference),
+  user_id bigint NOT NULL REFERENCES users (id),
+  name_enquiry_ref varchar(50) NOT NULL,
+  session_id varchar(100) NOT NULL DEFAULT '',
+  bank_code varchar(10) NOT NULL,
+  bank_name varchar(255) NOT NULL DEFAULT '',
+  beneficiary_account_number varchar(20) NOT NULL,
+  beneficiary_account_name varchar(255) NOT NULL,
+  fee numeric(20,2) NOT NULL DEFAULT 0.00,
+  created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS interbank_transfers_user_id_idx ON interbank_transfers (user_id);
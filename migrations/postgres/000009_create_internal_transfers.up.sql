@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS internal_transfers (
+  reference varchar(100) PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users (id),
+  recipient_user_id bigint NOT NULL REFERENCES users (id),
+  debit_reference varchar(100) NOT NULL REFERENCES transactions (internal_reference),
+  credit_reference varchar(100) NOT NULL REFERENCES transactions (internal_reference),
+  created_at timestamptz NOT NULL DEFAULT now(),
+  submitted_at timestamptz NULL
+);
+
+CREATE INDEX IF NOT EXISTS internal_transfers_user_id_idx ON internal_transfers (user_id);
+CREATE INDEX IF NOT EXISTS internal_transfers_recipient_user_id_idx ON internal_transfers (recipient_user_id);
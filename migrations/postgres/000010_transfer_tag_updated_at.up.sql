@@ -0,0 +1,2 @@
+ALTER TABLE transfer_tag
+  ADD COLUMN updated_at timestamptz NOT NULL DEFAULT now();
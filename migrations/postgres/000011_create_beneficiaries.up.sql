@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS beneficiaries (
+  id bigserial PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  type varchar(20) NOT NULL,
+  nickname varchar(50) NOT NULL DEFAULT '',
+  account_number varchar(20) NOT NULL DEFAULT '',
+  account_name varchar(255) NOT NULL,
+  bank_code varchar(20) NOT NULL,
+  bank_name varchar(255) NOT NULL,
+  tag varchar(50) NOT NULL DEFAULT '',
+  tag_account_number varchar(20) NOT NULL DEFAULT '',
+  usage_count integer NOT NULL DEFAULT 0,
+  last_used_at timestamptz NULL,
+  cooling_off_until timestamptz NULL,
+  created_at timestamptz NOT NULL DEFAULT now(),
+  CONSTRAINT beneficiaries_user_id_destination_key UNIQUE (user_id, type, bank_code, account_number, tag)
+);
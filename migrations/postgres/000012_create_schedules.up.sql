@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS schedules (
+  id bigserial PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  beneficiary_id bigint NOT NULL,
+  type varchar(20) NOT NULL,
+  amount bigint NOT NULL,
+  narration varchar(100) NOT NULL DEFAULT '',
+  frequency varchar(20) NOT NULL,
+  cron varchar(100) NOT NULL DEFAULT '',
+  start_at timestamptz NOT NULL,
+  end_at timestamptz NULL,
+  next_run_at timestamptz NULL,
+  occurrence integer NOT NULL DEFAULT 0,
+  retries integer NOT NULL DEFAULT 0,
+  status varchar(20) NOT NULL DEFAULT 'active',
+  created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS schedules_user_id ON schedules (user_id);
+CREATE INDEX IF NOT EXISTS schedules_status_next_run_at ON schedules (status, next_run_at);
+
+CREATE TABLE IF NOT EXISTS schedule_runs (
+  id bigserial PRIMARY KEY,
+  schedule_id bigint NOT NULL REFERENCES schedules (id) ON DELETE CASCADE,
+  occurrence integer NOT NULL,
+  attempt integer NOT NULL,
+  reference varchar(50) NOT NULL,
+  status varchar(20) NOT NULL,
+  transfer_reference varchar(50) NOT NULL DEFAULT '',
+  error varchar(255) NOT NULL DEFAULT '',
+  created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS schedule_runs_schedule_id ON schedule_runs (schedule_id);
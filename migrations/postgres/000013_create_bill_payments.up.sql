@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS bill_payments (
+  reference varchar(100) PRIMARY KEY REFERENCES transactions (internal_reference),
+  user_id bigint NOT NULL REFERENCES users (id),
+  provider varchar(50) NOT NULL,
+  biller_id varchar(100) NOT NULL,
+  biller_name varchar(255) NOT NULL DEFAULT '',
+  category varchar(20) NOT NULL,
+  product_code varchar(100) NOT NULL,
+  customer_id varchar(50) NOT NULL,
+  customer_name varchar(255) NOT NULL DEFAULT '',
+  biller_reference varchar(100) NOT NULL DEFAULT '',
+  token varchar(255) NOT NULL DEFAULT '',
+  created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS bill_payments_user_id_idx ON bill_payments (user_id);
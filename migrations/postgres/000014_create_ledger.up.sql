@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS ledger_accounts (
+  code varchar(50) PRIMARY KEY,
+  name varchar(100) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS ledger_entries (
+  id bigserial PRIMARY KEY,
+  ledger_code varchar(50) NOT NULL REFERENCES ledger_accounts (code),
+  reference varchar(100) NOT NULL,
+  type varchar(10) NOT NULL,
+  amount numeric(20,2) NOT NULL,
+  created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS ledger_entries_reference_idx ON ledger_entries (reference);
+CREATE INDEX IF NOT EXISTS ledger_entries_ledger_code_idx ON ledger_entries (ledger_code);
+
+INSERT INTO ledger_accounts (code, name) VALUES
+  ('interbank_suspense', 'Interbank transfers awaiting settlement'),
+  ('interbank_settlement', 'Interbank settlement'),
+  ('bills_suspense', 'Bill payments awaiting settlement'),
+  ('bills_settlement', 'Biller settlement'),
+  ('fee_income', 'Fee income');
+
+CREATE OR REPLACE VIEW ledger_balances AS
+SELECT a.code, COALESCE(SUM(CASE WHEN e.type = 'credit' THEN e.amount ELSE -e.amount END), 0) AS balance
+FROM ledger_accounts a
+LEFT JOIN ledger_entries e ON e.ledger_code = a.code
+GROUP BY a.code;